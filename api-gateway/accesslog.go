@@ -0,0 +1,239 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Access log в формате Combined Log Format с ротацией
+// ─────────────────────────────────────────────────────────────
+
+// Суффикс архивов: access.log.20250701-150405.000.gz
+const accessLogTimeSuffix = "20060102-150405.000"
+
+// rotatingFile файл, который ротируется по размеру и возрасту.
+// Старые файлы сжимаются gzip в фоне, лишние архивы удаляются.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+var accessLog *rotatingFile
+
+// newRotatingFileFromEnv читает ACCESS_LOG_PATH, ACCESS_LOG_MAX_SIZE_MB,
+// ACCESS_LOG_ROTATE_HOURS и ACCESS_LOG_MAX_BACKUPS. Без ACCESS_LOG_PATH
+// access log выключен.
+func newRotatingFileFromEnv() (*rotatingFile, error) {
+	path := os.Getenv("ACCESS_LOG_PATH")
+	if path == "" {
+		return nil, nil
+	}
+	envInt := func(name string, def int) int {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			return v
+		}
+		return def
+	}
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    int64(envInt("ACCESS_LOG_MAX_SIZE_MB", 100)) << 20,
+		maxAge:     time.Duration(envInt("ACCESS_LOG_ROTATE_HOURS", 24)) * time.Hour,
+		maxBackups: envInt("ACCESS_LOG_MAX_BACKUPS", 7),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть access log %s: %v", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size+int64(len(p)) > f.maxSize || time.Since(f.openedAt) >= f.maxAge {
+		if err := f.rotate(); err != nil {
+			log.Printf("Ошибка ротации access log: %v", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate переименовывает текущий файл и открывает новый. Вызывать под mu.
+func (f *rotatingFile) rotate() error {
+	if f.size == 0 {
+		f.openedAt = time.Now()
+		return nil
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	rotated := f.path + "." + time.Now().Format(accessLogTimeSuffix)
+	if err := os.Rename(f.path, rotated); err != nil {
+		// продолжаем писать в прежний файл
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go f.compressAndPrune(rotated)
+	return nil
+}
+
+func (f *rotatingFile) compressAndPrune(rotated string) {
+	if err := gzipFile(rotated); err != nil {
+		log.Printf("Ошибка сжатия %s: %v", rotated, err)
+	}
+
+	backups, _ := filepath.Glob(f.path + ".*.gz")
+	if len(backups) <= f.maxBackups {
+		return
+	}
+	// имена содержат время ротации, лексикографический порядок = хронологический
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(old); err != nil {
+			log.Printf("Ошибка удаления старого access log %s: %v", old, err)
+		}
+	}
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// accessLogWriter запоминает статус и размер ответа
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// accessLogMiddleware пишет строку Combined Log Format на каждый запрос:
+// host ident user [time] "request" status bytes "referer" "user-agent"
+func accessLogMiddleware(out io.Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, tag := withRequestTag(r)
+		aw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(aw, r)
+
+		host := strings.TrimSpace(getClientIP(r))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		user := tag.username
+		if user == "" {
+			user = "-"
+		}
+		size := "-"
+		if aw.bytes > 0 {
+			size = strconv.Itoa(aw.bytes)
+		}
+		fmt.Fprintf(out, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+			host,
+			user,
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method,
+			escapeLogField(r.URL.RequestURI()),
+			r.Proto,
+			aw.status,
+			size,
+			escapeLogField(r.Referer()),
+			escapeLogField(r.UserAgent()),
+		)
+	})
+}
+
+// escapeLogField экранирует кавычки и управляющие символы, чтобы
+// значение из запроса не ломало строку лога
+func escapeLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Аналитика использования API
+// ─────────────────────────────────────────────────────────────
+
+// Максимальное число замеров латентности, хранимых на один агрегат
+// (reservoir sampling), чтобы память не росла при большом трафике.
+const analyticsLatencySamples = 1000
+
+// unmatchedRoute маршрут запросов, не совпавших ни с одним шаблоном
+// (404/405 маршрутизатора): сканеры и опечатки в пути не создают агрегатов
+const unmatchedRoute = "unmatched"
+
+// Файл выгрузки ротируется, когда превышает exportMaxSize: прежний
+// переименовывается в <path>.1 (предыдущий .1 удаляется), так что на
+// диске не больше двух файлов, а /admin/analytics/usage читает оба.
+const (
+	usageRotatedSuffix          = ".1"
+	defaultAnalyticsExportMaxMB = 50
+)
+
+// UsageRecord агрегат использования одного маршрута одним ключом за окно
+type UsageRecord struct {
+	WindowStart  time.Time `json:"window_start"`
+	WindowEnd    time.Time `json:"window_end"`
+	Route        string    `json:"route"`
+	Method       string    `json:"method"`
+	Key          string    `json:"key"`
+	Requests     int       `json:"requests"`
+	Errors       int       `json:"errors"`
+	P95LatencyMs float64   `json:"p95_latency_ms"`
+}
+
+type usageKey struct {
+	route  string
+	method string
+	key    string
+}
+
+type usageBucket struct {
+	requests  int
+	errors    int
+	latencies []float64
+}
+
+// usageAggregator накапливает статистику в памяти и периодически
+// выгружает её в файл (JSON Lines) и/или во внешний сервис аналитики.
+type usageAggregator struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	buckets     map[usageKey]*usageBucket
+
+	exportPath    string
+	exportMaxSize int64
+	exportURL     string
+	client        *http.Client
+
+	// exportMu упорядочивает дозапись и ротацию файла с его открытием
+	// для чтения, чтобы запрос не прочитал одну выгрузку дважды
+	exportMu sync.Mutex
+}
+
+var analytics *usageAggregator
+
+func newUsageAggregator(exportPath string, exportMaxSize int64, exportURL string) *usageAggregator {
+	return &usageAggregator{
+		windowStart:   time.Now(),
+		buckets:       make(map[usageKey]*usageBucket),
+		exportPath:    exportPath,
+		exportMaxSize: exportMaxSize,
+		exportURL:     exportURL,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *usageAggregator) record(route, method, key string, status int, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k := usageKey{route: route, method: method, key: key}
+	b, ok := a.buckets[k]
+	if !ok {
+		b = &usageBucket{}
+		a.buckets[k] = b
+	}
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	if len(b.latencies) < analyticsLatencySamples {
+		b.latencies = append(b.latencies, ms)
+	} else if i := rand.Intn(b.requests); i < analyticsLatencySamples {
+		b.latencies[i] = ms
+	}
+}
+
+// snapshot забирает накопленные агрегаты и начинает новое окно
+func (a *usageAggregator) snapshot() []UsageRecord {
+	a.mu.Lock()
+	buckets := a.buckets
+	start := a.windowStart
+	a.buckets = make(map[usageKey]*usageBucket)
+	a.windowStart = time.Now()
+	a.mu.Unlock()
+
+	end := time.Now()
+	records := make([]UsageRecord, 0, len(buckets))
+	for k, b := range buckets {
+		records = append(records, UsageRecord{
+			WindowStart:  start,
+			WindowEnd:    end,
+			Route:        k.route,
+			Method:       k.method,
+			Key:          k.key,
+			Requests:     b.requests,
+			Errors:       b.errors,
+			P95LatencyMs: percentile(b.latencies, 0.95),
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Route != records[j].Route {
+			return records[i].Route < records[j].Route
+		}
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
+
+func (a *usageAggregator) flush() {
+	records := a.snapshot()
+	if len(records) == 0 {
+		return
+	}
+
+	if a.exportPath != "" {
+		if err := a.appendRecords(records); err != nil {
+			log.Printf("Ошибка выгрузки аналитики в %s: %v", a.exportPath, err)
+		}
+	}
+
+	if a.exportURL != "" {
+		body, _ := json.Marshal(records)
+		resp, err := a.client.Post(a.exportURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Сервис аналитики недоступен: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Сервис аналитики вернул статус %d", resp.StatusCode)
+		}
+	}
+}
+
+func (a *usageAggregator) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.flush()
+	}
+}
+
+// appendRecords дописывает агрегаты в файл выгрузки, предварительно
+// ротируя его по размеру
+func (a *usageAggregator) appendRecords(records []UsageRecord) error {
+	a.exportMu.Lock()
+	defer a.exportMu.Unlock()
+
+	if info, err := os.Stat(a.exportPath); err == nil && a.exportMaxSize > 0 && info.Size() >= a.exportMaxSize {
+		if err := os.Rename(a.exportPath, a.exportPath+usageRotatedSuffix); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(a.exportPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openExport открывает файлы выгрузки от старого к новому; отсутствующие
+// пропускаются
+func (a *usageAggregator) openExport() ([]*os.File, error) {
+	a.exportMu.Lock()
+	defer a.exportMu.Unlock()
+
+	var files []*os.File
+	for _, path := range []string{a.exportPath + usageRotatedSuffix, a.exportPath} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// scanUsageRecords читает агрегаты построчно и передаёт их в fn, не
+// загружая файл в память; битые строки пропускаются
+func scanUsageRecords(r io.Reader, fn func(UsageRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rec UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+const contextKeyRequestTag contextKey = "request_tag"
+
+// requestTag сведения о запросе, которые узнают маршрутизатор (шаблон
+// маршрута) и auth-middleware (пользователь), а нужны внешним middleware:
+// значения, добавленные в контекст внутри цепочки, снаружи не видны.
+type requestTag struct {
+	route    string
+	username string
+}
+
+// withRequestTag добавляет в контекст запроса requestTag, если его ещё нет
+func withRequestTag(r *http.Request) (*http.Request, *requestTag) {
+	if tag := requestTagFrom(r); tag != nil {
+		return r, tag
+	}
+	tag := &requestTag{}
+	return r.WithContext(context.WithValue(r.Context(), contextKeyRequestTag, tag)), tag
+}
+
+func requestTagFrom(r *http.Request) *requestTag {
+	tag, _ := r.Context().Value(contextKeyRequestTag).(*requestTag)
+	return tag
+}
+
+// withUsername запоминает пользователя с проверенным JWT в контексте и в
+// requestTag
+func withUsername(r *http.Request, username string) *http.Request {
+	if tag := requestTagFrom(r); tag != nil {
+		tag.username = username
+	}
+	return r.WithContext(context.WithValue(r.Context(), contextKeyUsername, username))
+}
+
+// usageKey ключ клиента: пользователь, которого определил auth-middleware
+// маршрута, или "anonymous"
+func (t *requestTag) usageKey() string {
+	if t.username != "" {
+		return t.username
+	}
+	return "anonymous"
+}
+
+// usageRoute шаблон совпавшего маршрута или unmatchedRoute
+func (t *requestTag) usageRoute() string {
+	if t.route != "" {
+		return t.route
+	}
+	return unmatchedRoute
+}
+
+// matchedRoute шаблон маршрута, совпавшего с запросом, или unmatchedRoute
+func matchedRoute(r *http.Request) string {
+	if tag := requestTagFrom(r); tag != nil {
+		return tag.usageRoute()
+	}
+	return unmatchedRoute
+}
+
+func analyticsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, tag := withRequestTag(r)
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		analytics.record(tag.usageRoute(), r.Method, tag.usageKey(), rw.statusCode, time.Since(start))
+	})
+}
+
+// usageQueryHandler возвращает выгруженные агрегаты с фильтрами
+// route, key, from и to (RFC 3339).
+func usageQueryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var from, to time.Time
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Неверный формат from")
+			return
+		}
+		from = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Неверный формат to")
+			return
+		}
+		to = t
+	}
+
+	var files []*os.File
+	if analytics.exportPath != "" {
+		var err error
+		if files, err = analytics.openExport(); err != nil {
+			log.Printf("Ошибка чтения аналитики: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Ошибка чтения аналитики")
+			return
+		}
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	// ответ пишется по мере чтения файлов, без промежуточного среза
+	route, key := q.Get("route"), q.Get("key")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	io.WriteString(w, "[")
+	first := true
+	write := func(rec UsageRecord) error {
+		if route != "" && rec.Route != route {
+			return nil
+		}
+		if key != "" && rec.Key != key {
+			return nil
+		}
+		if !from.IsZero() && rec.WindowEnd.Before(from) {
+			return nil
+		}
+		if !to.IsZero() && rec.WindowStart.After(to) {
+			return nil
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	}
+	for _, f := range files {
+		if err := scanUsageRecords(f, write); err != nil {
+			// статус уже отправлен: ответ обрывается, клиент получит невалидный JSON
+			log.Printf("Ошибка чтения аналитики из %s: %v", f.Name(), err)
+			return
+		}
+	}
+	io.WriteString(w, "]\n")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Аномалии размера и времени ответов апстримов
+// ─────────────────────────────────────────────────────────────
+
+// Для каждого маршрута апстрима (news-service /news/{id} и т.п.)
+// ведётся скользящая базовая линия размера и времени успешных
+// GET-ответов — экспоненциальное среднее. Ответ, который в
+// ANOMALY_SIZE_RATIO раз больше или меньше обычного или дольше
+// обычного в ANOMALY_LATENCY_RATIO раз, считается аномалией: она
+// пишется в журнал, учитывается в gateway_upstream_anomalies_total
+// и видна в /admin/upstreams/baselines. Так регрессия апстрима
+// (новость внезапно в 50 раз больше) замечается раньше пользователей.
+
+const (
+	// anomalyAlpha вес нового ответа в базовой линии
+	anomalyAlpha = 0.05
+	// anomalyMaxRoutes ограничивает число базовых линий
+	anomalyMaxRoutes = 200
+	// anomalyRecent сколько последних аномалий хранится для /admin
+	anomalyRecent = 50
+)
+
+// Виды аномалий (метка kind)
+const (
+	anomalySizeHigh = "size_high"
+	anomalySizeLow  = "size_low"
+	anomalyLatency  = "latency"
+)
+
+// AnomalyEvent отклонение ответа от базовой линии
+type AnomalyEvent struct {
+	Time     time.Time `json:"time"`
+	Upstream string    `json:"upstream"`
+	Route    string    `json:"route"`
+	Kind     string    `json:"kind"`
+	Value    float64   `json:"value"`
+	Baseline float64   `json:"baseline"`
+	URL      string    `json:"url"`
+}
+
+// ResponseBaseline базовая линия маршрута апстрима
+type ResponseBaseline struct {
+	Upstream     string  `json:"upstream"`
+	Route        string  `json:"route"`
+	Samples      int     `json:"samples"`
+	AvgSizeBytes float64 `json:"avg_size_bytes"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	Anomalies    int     `json:"anomalies"`
+}
+
+type anomalyDetector struct {
+	sizeRatio    float64
+	latencyRatio float64
+	minSamples   int
+	// отклонения меньше этих порогов не считаются аномалиями даже
+	// при большом отношении (ответ 100 байт вместо 5 или 20мс вместо 2мс)
+	minSizeDelta float64
+	minLatencyMs float64
+
+	mu        sync.Mutex
+	baselines map[string]*ResponseBaseline
+	recent    []AnomalyEvent
+}
+
+var anomalies *anomalyDetector
+
+// newAnomalyDetectorFromEnv читает ANOMALY_SIZE_RATIO, ANOMALY_LATENCY_RATIO,
+// ANOMALY_MIN_SAMPLES, ANOMALY_MIN_SIZE_DELTA_BYTES и ANOMALY_MIN_LATENCY_MS
+func newAnomalyDetectorFromEnv() *anomalyDetector {
+	envFloat := func(name string, def float64) float64 {
+		if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && v > 1 {
+			return v
+		}
+		return def
+	}
+	envInt := func(name string, def int) int {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			return v
+		}
+		return def
+	}
+	return &anomalyDetector{
+		sizeRatio:    envFloat("ANOMALY_SIZE_RATIO", 10),
+		latencyRatio: envFloat("ANOMALY_LATENCY_RATIO", 5),
+		minSamples:   envInt("ANOMALY_MIN_SAMPLES", 50),
+		minSizeDelta: float64(envInt("ANOMALY_MIN_SIZE_DELTA_BYTES", 4096)),
+		minLatencyMs: float64(envInt("ANOMALY_MIN_LATENCY_MS", 200)),
+		baselines:    make(map[string]*ResponseBaseline),
+	}
+}
+
+// upstreamRoutes пути апстримов, которые вызывает gateway
+var upstreamRoutes = []string{
+	"/news/latest", "/news/filter", "/news/authors", "/news/categories", "/news/top",
+	"/news/popular", "/news/{id}", "/sources", "/comments", "/comments/{id}",
+}
+
+// upstreamRoute шаблон из upstreamRoutes, совпавший с путём, или
+// unmatchedRoute: число базовых линий и рядов метрики не зависит от путей
+func upstreamRoute(path string) string {
+	segments := splitPath(path)
+	label, best := unmatchedRoute, -1
+	for _, pattern := range upstreamRoutes {
+		rte := route{pattern: pattern, segments: splitPath(pattern)}
+		if _, score, ok := rte.match(segments); ok && score > best {
+			label, best = pattern, score
+		}
+	}
+	return label
+}
+
+// track подменяет тело успешного ответа: после его полного прочтения
+// размер и время ответа сравниваются с базовой линией маршрута
+func (d *anomalyDetector) track(upstream, rawURL string, latency time.Duration, resp *http.Response) {
+	if d == nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	route := unmatchedRoute
+	if u, err := url.Parse(rawURL); err == nil {
+		route = upstreamRoute(u.Path)
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, done: func(size int64) {
+		d.observe(upstream, route, rawURL, float64(size), float64(latency)/float64(time.Millisecond))
+	}}
+}
+
+// countingBody считает прочитанные байты и сообщает размер, если
+// тело дочитано до конца (частично прочитанный ответ не показателен)
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	eof  bool
+	done func(size int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF && !b.eof {
+		b.eof = true
+		b.done(b.n)
+	}
+	return n, err
+}
+
+func (d *anomalyDetector) observe(upstream, route, rawURL string, size, latencyMs float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := upstream + " " + route
+	b, ok := d.baselines[key]
+	if !ok {
+		if len(d.baselines) >= anomalyMaxRoutes {
+			return
+		}
+		b = &ResponseBaseline{Upstream: upstream, Route: route}
+		d.baselines[key] = b
+	}
+
+	if b.Samples >= d.minSamples {
+		var events []AnomalyEvent
+		event := func(kind string, value, baseline float64) {
+			events = append(events, AnomalyEvent{
+				Time: time.Now(), Upstream: upstream, Route: route,
+				Kind: kind, Value: value, Baseline: baseline, URL: rawURL,
+			})
+		}
+		switch {
+		case size > b.AvgSizeBytes*d.sizeRatio && size-b.AvgSizeBytes >= d.minSizeDelta:
+			event(anomalySizeHigh, size, b.AvgSizeBytes)
+		case size*d.sizeRatio < b.AvgSizeBytes && b.AvgSizeBytes-size >= d.minSizeDelta:
+			event(anomalySizeLow, size, b.AvgSizeBytes)
+		}
+		if latencyMs > b.AvgLatencyMs*d.latencyRatio && latencyMs >= d.minLatencyMs {
+			event(anomalyLatency, latencyMs, b.AvgLatencyMs)
+		}
+		for _, e := range events {
+			b.Anomalies++
+			metrics.upstreamAnomalies.inc(upstream, route, e.Kind)
+			log.Printf("[WARN] Аномалия ответа апстрима: upstream=%s route=%s kind=%s value=%.0f baseline=%.0f url=%s",
+				upstream, route, e.Kind, e.Value, e.Baseline, rawURL)
+			d.recent = append(d.recent, e)
+		}
+		if len(d.recent) > anomalyRecent {
+			d.recent = d.recent[len(d.recent)-anomalyRecent:]
+		}
+		// выброс входит в базовую линию с ограничением, чтобы единичный
+		// ответ её не сдвинул, а устойчивое изменение постепенно стало нормой
+		size = clampRatio(size, b.AvgSizeBytes, d.sizeRatio)
+		latencyMs = clampRatio(latencyMs, b.AvgLatencyMs, d.latencyRatio)
+	}
+
+	// пока выборка мала — обычное среднее, затем экспоненциальное
+	b.Samples++
+	alpha := anomalyAlpha
+	if a := 1 / float64(b.Samples); a > alpha {
+		alpha = a
+	}
+	b.AvgSizeBytes += alpha * (size - b.AvgSizeBytes)
+	b.AvgLatencyMs += alpha * (latencyMs - b.AvgLatencyMs)
+}
+
+// clampRatio ограничивает v диапазоном [mean/ratio, mean*ratio]
+func clampRatio(v, mean, ratio float64) float64 {
+	if mean <= 0 {
+		return v
+	}
+	if v > mean*ratio {
+		return mean * ratio
+	}
+	if v < mean/ratio {
+		return mean / ratio
+	}
+	return v
+}
+
+// anomalyBaselinesHandler базовые линии маршрутов и последние аномалии
+func anomalyBaselinesHandler(w http.ResponseWriter, r *http.Request) {
+	anomalies.mu.Lock()
+	baselines := make([]ResponseBaseline, 0, len(anomalies.baselines))
+	for _, b := range anomalies.baselines {
+		baselines = append(baselines, *b)
+	}
+	recent := append([]AnomalyEvent{}, anomalies.recent...)
+	anomalies.mu.Unlock()
+
+	sort.Slice(baselines, func(i, j int) bool {
+		if baselines[i].Upstream != baselines[j].Upstream {
+			return baselines[i].Upstream < baselines[j].Upstream
+		}
+		return baselines[i].Route < baselines[j].Route
+	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"min_samples":   anomalies.minSamples,
+		"size_ratio":    anomalies.sizeRatio,
+		"latency_ratio": anomalies.latencyRatio,
+		"baselines":     baselines,
+		"recent":        recent,
+	})
+}
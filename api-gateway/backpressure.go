@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Здоровье апстримов, бюджет ретраев и сигналы backpressure
+// ─────────────────────────────────────────────────────────────
+
+// Окно наблюдения делится на корзины, устаревшие корзины
+// отбрасываются целиком
+const healthBuckets = 10
+
+// Настройки (BACKPRESSURE_WINDOW_SEC, BACKPRESSURE_ERROR_THRESHOLD,
+// BACKPRESSURE_MIN_REQUESTS, RETRY_BUDGET_RATIO, RETRY_MAX_ATTEMPTS)
+var (
+	healthWindow         = 30 * time.Second
+	healthErrorThreshold = 0.5
+	healthMinRequests    = 20
+	retryBudgetRatio     = 0.1
+	retryMaxAttempts     = 2
+)
+
+// Минимум ретраев в окне, чтобы единичные сбои при малом трафике
+// всё же повторялись
+const retryBudgetFloor = 3
+
+type healthBucket struct {
+	start    time.Time
+	requests int
+	failures int
+	retries  int
+}
+
+// upstreamHealth считает запросы и ошибки апстрима в скользящем окне.
+// Ретраи расходуют общий бюджет (доля от числа запросов) и полностью
+// отключаются, пока доля ошибок выше порога, — так частичный сбой
+// не превращается в шторм повторных запросов.
+type upstreamHealth struct {
+	name string
+
+	mu      sync.Mutex
+	buckets [healthBuckets]healthBucket
+}
+
+var (
+	newsHealth       = &upstreamHealth{name: "news-service"}
+	commentsHealth   = &upstreamHealth{name: "comments-service"}
+	censorshipHealth = &upstreamHealth{name: "censorship-service"}
+
+	upstreams = []*upstreamHealth{newsHealth, commentsHealth, censorshipHealth}
+)
+
+// HealthSnapshot состояние апстрима за текущее окно
+type HealthSnapshot struct {
+	Upstream   string  `json:"upstream"`
+	Requests   int     `json:"requests"`
+	Failures   int     `json:"failures"`
+	Retries    int     `json:"retries"`
+	ErrorRate  float64 `json:"error_rate"`
+	Degraded   bool    `json:"degraded"`
+	RetryAfter int     `json:"retry_after_sec,omitempty"`
+}
+
+func loadBackpressureConfig() {
+	if v, err := strconv.Atoi(os.Getenv("BACKPRESSURE_WINDOW_SEC")); err == nil && v > 0 {
+		healthWindow = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("BACKPRESSURE_ERROR_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		healthErrorThreshold = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BACKPRESSURE_MIN_REQUESTS")); err == nil && v > 0 {
+		healthMinRequests = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("RETRY_BUDGET_RATIO"), 64); err == nil && v >= 0 {
+		retryBudgetRatio = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("RETRY_MAX_ATTEMPTS")); err == nil && v >= 0 {
+		retryMaxAttempts = v
+	}
+}
+
+func bucketSpan() time.Duration {
+	return healthWindow / healthBuckets
+}
+
+// current возвращает корзину для момента now, обнуляя её, если она
+// осталась от предыдущего оборота окна. Вызывать под mu.
+func (h *upstreamHealth) current(now time.Time) *healthBucket {
+	span := bucketSpan()
+	start := now.Truncate(span)
+	b := &h.buckets[(start.UnixNano()/int64(span))%healthBuckets]
+	if !b.start.Equal(start) {
+		*b = healthBucket{start: start}
+	}
+	return b
+}
+
+// totals суммирует корзины, попадающие в окно. Вызывать под mu.
+func (h *upstreamHealth) totals(now time.Time) (requests, failures, retries int) {
+	cutoff := now.Add(-healthWindow)
+	for _, b := range h.buckets {
+		if b.start.After(cutoff) {
+			requests += b.requests
+			failures += b.failures
+			retries += b.retries
+		}
+	}
+	return
+}
+
+func (h *upstreamHealth) record(failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := h.current(time.Now())
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+func degradedAt(requests, failures int) bool {
+	return requests >= healthMinRequests &&
+		float64(failures)/float64(requests) >= healthErrorThreshold
+}
+
+// allowRetry списывает ретрай из бюджета, если он ещё не исчерпан
+// и апстрим не признан деградировавшим
+func (h *upstreamHealth) allowRetry() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	requests, failures, retries := h.totals(now)
+	if degradedAt(requests, failures) {
+		return false
+	}
+	budget := int(float64(requests)*retryBudgetRatio) + retryBudgetFloor
+	if retries >= budget {
+		return false
+	}
+	h.current(now).retries++
+	return true
+}
+
+func (h *upstreamHealth) snapshot() HealthSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	requests, failures, retries := h.totals(time.Now())
+	s := HealthSnapshot{
+		Upstream: h.name,
+		Requests: requests,
+		Failures: failures,
+		Retries:  retries,
+		Degraded: degradedAt(requests, failures),
+	}
+	if requests > 0 {
+		s.ErrorRate = float64(failures) / float64(requests)
+	}
+	if s.Degraded {
+		s.RetryAfter = retryAfterSeconds(s.ErrorRate)
+	}
+	return s
+}
+
+// retryAfterSeconds растёт с долей ошибок: от одной корзины окна
+// на пороге до всего окна при полном отказе
+func retryAfterSeconds(errorRate float64) int {
+	excess := 0.0
+	if healthErrorThreshold < 1 {
+		excess = (errorRate - healthErrorThreshold) / (1 - healthErrorThreshold)
+	}
+	d := bucketSpan() + time.Duration(excess*float64(healthWindow-bucketSpan()))
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
+
+// isRetryableStatus статусы, при которых апстрим считается сбойным
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout ||
+		status == http.StatusInternalServerError
+}
+
+// get выполняет идемпотентный GET с учётом здоровья апстрима и
+// повторяет его при сбое, пока позволяет бюджет ретраев
+func (h *upstreamHealth) get(url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		// news-service верит стране клиента только от gateway
+		setServiceToken(req)
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		failed := err != nil || isRetryableStatus(resp.StatusCode)
+		h.record(failed)
+		recordUpstreamCall(h.name, http.MethodGet, url, start, resp, err)
+		if !failed || attempt >= retryMaxAttempts || !h.allowRetry() {
+			if err == nil {
+				anomalies.track(h.name, url, time.Since(start), resp)
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		// экспоненциальная задержка с джиттером: 50мс, 100мс, ...
+		backoff := time.Duration(50<<attempt) * time.Millisecond
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("Повтор запроса к %s через %v (попытка %d)", h.name, backoff, attempt+2)
+		time.Sleep(backoff)
+	}
+}
+
+// do выполняет неидемпотентный запрос без ретраев, только учитывая исход
+func (h *upstreamHealth) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	h.record(err != nil || isRetryableStatus(resp.StatusCode))
+	recordUpstreamCall(h.name, req.Method, req.URL.String(), start, resp, err)
+	return resp, err
+}
+
+// upstreamForPath определяет апстрим, от которого зависит маршрут
+func upstreamForPath(path string) *upstreamHealth {
+	switch {
+	case strings.HasPrefix(path, "/comments"), strings.HasSuffix(path, "/comments"):
+		return commentsHealth
+	case strings.HasPrefix(path, "/news"):
+		return newsHealth
+	}
+	return nil
+}
+
+// backpressureMiddleware, пока апстрим маршрута деградировал, сообщает
+// клиентам, когда повторять запрос (Retry-After), и что доступная
+// ёмкость исчерпана (RateLimit-Limit/Remaining/Reset).
+func backpressureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := upstreamForPath(r.URL.Path); h != nil {
+			if s := h.snapshot(); s.Degraded {
+				retryAfter := strconv.Itoa(s.RetryAfter)
+				w.Header().Set("Retry-After", retryAfter)
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(s.Requests-s.Failures))
+				w.Header().Set("RateLimit-Remaining", "0")
+				w.Header().Set("RateLimit-Reset", retryAfter)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// upstreamHealthHandler состояние апстримов для внутреннего листенера
+func upstreamHealthHandler(w http.ResponseWriter, r *http.Request) {
+	snapshots := make([]HealthSnapshot, 0, len(upstreams))
+	for _, h := range upstreams {
+		snapshots = append(snapshots, h.snapshot())
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(snapshots)
+}
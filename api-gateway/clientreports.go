@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Журнал запросов и отчёты клиентов об ошибках
+// ─────────────────────────────────────────────────────────────
+
+// Каждый ответ содержит заголовок X-Request-ID. Журнал хранит
+// последние REQUEST_LOG_SIZE запросов (по умолчанию 10000): маршрут,
+// статус, длительность и все вызовы апстримов с этим request_id.
+// Фронтенд, показав пользователю ошибку, отправляет POST /client-reports
+// с request_id неудачного ответа; отчёт сохраняется рядом с записью
+// запроса, и поддержка по GET /admin/requests/{id} видит, какой вызов
+// апстрима к ней привёл. Отчётов хранится не больше CLIENT_REPORTS_MAX
+// (по умолчанию 1000).
+
+const (
+	maxReportMessage = 2000
+	maxReportStack   = 16 << 10
+	maxReportBody    = 64 << 10
+)
+
+// UpstreamCall вызов апстрима в рамках запроса
+type UpstreamCall struct {
+	Upstream   string    `json:"upstream"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	At         time.Time `json:"at"`
+}
+
+// ClientReport отчёт фронтенда об ошибке
+type ClientReport struct {
+	ID         string          `json:"id"`
+	RequestID  string          `json:"request_id"`
+	Message    string          `json:"message"`
+	Kind       string          `json:"kind,omitempty"`
+	Page       string          `json:"page,omitempty"`
+	AppVersion string          `json:"app_version,omitempty"`
+	Stack      string          `json:"stack,omitempty"`
+	Context    json.RawMessage `json:"context,omitempty"`
+	OccurredAt *time.Time      `json:"occurred_at,omitempty"`
+	Username   string          `json:"username,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+	// Matched найден ли запрос в журнале на момент получения отчёта
+	Matched bool `json:"matched"`
+}
+
+// RequestRecord запись журнала запросов
+type RequestRecord struct {
+	RequestID  string         `json:"request_id"`
+	Method     string         `json:"method"`
+	Path       string         `json:"path"`
+	Status     int            `json:"status,omitempty"`
+	DurationMs int64          `json:"duration_ms"`
+	ClientIP   string         `json:"client_ip"`
+	Username   string         `json:"username,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	Finished   bool           `json:"finished"`
+	Upstream   []UpstreamCall `json:"upstream_calls"`
+}
+
+// RequestLookup ответ GET /admin/requests/{id}
+type RequestLookup struct {
+	Request *RequestRecord `json:"request"`
+	Reports []ClientReport `json:"reports"`
+}
+
+type requestJournal struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxReports int
+	entries    map[string]*RequestRecord
+	order      []string // порядок добавления для вытеснения старых
+	reports    []ClientReport
+}
+
+var requestLog = newRequestJournalFromEnv()
+
+func newRequestJournalFromEnv() *requestJournal {
+	envInt := func(name string, def int) int {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			return v
+		}
+		return def
+	}
+	return &requestJournal{
+		maxEntries: envInt("REQUEST_LOG_SIZE", 10000),
+		maxReports: envInt("CLIENT_REPORTS_MAX", 1000),
+		entries:    make(map[string]*RequestRecord),
+	}
+}
+
+// begin заводит запись запроса; повтор request_id, переданного
+// клиентом, продолжает существующую запись
+func (j *requestJournal) begin(requestID string, r *http.Request) *RequestRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec, ok := j.entries[requestID]; ok {
+		return rec
+	}
+	rec := &RequestRecord{
+		RequestID: requestID,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		ClientIP:  getClientIP(r),
+		StartedAt: time.Now(),
+		Upstream:  []UpstreamCall{},
+	}
+	j.entries[requestID] = rec
+	j.order = append(j.order, requestID)
+	if len(j.order) > j.maxEntries {
+		delete(j.entries, j.order[0])
+		j.order = j.order[1:]
+	}
+	return rec
+}
+
+func (j *requestJournal) finish(rec *RequestRecord, status int, username string, d time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec.Status = status
+	rec.Username = username
+	rec.DurationMs = d.Milliseconds()
+	rec.Finished = true
+}
+
+// upstream добавляет вызов апстрима к записи запроса; вызовы без
+// request_id или по вытесненному запросу не сохраняются
+func (j *requestJournal) upstream(requestID string, call UpstreamCall) {
+	if requestID == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rec, ok := j.entries[requestID]; ok {
+		rec.Upstream = append(rec.Upstream, call)
+	}
+}
+
+func (j *requestJournal) addReport(report ClientReport) ClientReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, report.Matched = j.entries[report.RequestID]
+	j.reports = append(j.reports, report)
+	if len(j.reports) > j.maxReports {
+		j.reports = j.reports[len(j.reports)-j.maxReports:]
+	}
+	return report
+}
+
+// lookup запись запроса и отчёты по нему
+func (j *requestJournal) lookup(requestID string) (RequestLookup, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := RequestLookup{Reports: []ClientReport{}}
+	if rec, ok := j.entries[requestID]; ok {
+		c := *rec
+		c.Upstream = append([]UpstreamCall{}, rec.Upstream...)
+		res.Request = &c
+	}
+	for _, report := range j.reports {
+		if report.RequestID == requestID {
+			res.Reports = append(res.Reports, report)
+		}
+	}
+	return res, res.Request != nil || len(res.Reports) > 0
+}
+
+// recentReports последние отчёты, новые первыми
+func (j *requestJournal) recentReports(limit int) []ClientReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := []ClientReport{}
+	for i := len(j.reports) - 1; i >= 0 && len(res) < limit; i-- {
+		res = append(res, j.reports[i])
+	}
+	return res
+}
+
+// recordUpstreamCall сохраняет исход вызова апстрима в журнал запросов.
+// Запрос определяется по параметру request_id, который gateway передаёт
+// апстримам
+func recordUpstreamCall(name, method, rawURL string, start time.Time, resp *http.Response, err error) {
+	u, perr := url.Parse(rawURL)
+	if perr != nil {
+		return
+	}
+	call := UpstreamCall{
+		Upstream:   name,
+		Method:     method,
+		Path:       u.Path,
+		DurationMs: time.Since(start).Milliseconds(),
+		At:         start,
+	}
+	if err != nil {
+		call.Error = err.Error()
+	} else {
+		call.Status = resp.StatusCode
+	}
+	requestLog.upstream(u.Query().Get("request_id"), call)
+}
+
+// requestLogMiddleware ведёт запись журнала на каждый запрос; ставится
+// внутри requestIDMiddleware
+func requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+		rec := requestLog.begin(requestID, r)
+		start := time.Now()
+		r, tag := withRequestTag(r)
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		requestLog.finish(rec, rw.statusCode, tag.username, time.Since(start))
+	})
+}
+
+// clientReportsHandler POST /client-reports — отчёт фронтенда об ошибке
+func clientReportsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequestID  string          `json:"request_id"`
+		Message    string          `json:"message"`
+		Kind       string          `json:"kind"`
+		Page       string          `json:"page"`
+		AppVersion string          `json:"app_version"`
+		Stack      string          `json:"stack"`
+		Context    json.RawMessage `json:"context"`
+		OccurredAt *time.Time      `json:"occurred_at"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportBody)).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Некорректный JSON")
+		return
+	}
+	req.RequestID = strings.TrimSpace(req.RequestID)
+	req.Message = strings.TrimSpace(req.Message)
+	if req.RequestID == "" || len(req.RequestID) > 100 {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "request_id обязателен и не длиннее 100 символов")
+		return
+	}
+	if req.Message == "" {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "message обязателен")
+		return
+	}
+	if len(req.Message) > maxReportMessage || len(req.Stack) > maxReportStack {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "Слишком длинные message или stack")
+		return
+	}
+
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	report := requestLog.addReport(ClientReport{
+		ID:         generateRequestID(),
+		RequestID:  req.RequestID,
+		Message:    req.Message,
+		Kind:       req.Kind,
+		Page:       req.Page,
+		AppVersion: req.AppVersion,
+		Stack:      req.Stack,
+		Context:    req.Context,
+		OccurredAt: req.OccurredAt,
+		Username:   username,
+		ReceivedAt: time.Now(),
+	})
+	log.Printf("[CLIENT] Отчёт об ошибке %s по запросу %s (найден: %t): %s", report.ID, report.RequestID, report.Matched, report.Message)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": report.ID, "matched": report.Matched})
+}
+
+// requestLookupHandler GET /admin/requests/{id} — запись журнала,
+// вызовы апстримов и отчёты клиентов по request_id
+func requestLookupHandler(w http.ResponseWriter, r *http.Request) {
+	res, ok := requestLog.lookup(pathParam(r, "id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "Запрос не найден в журнале")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+// clientReportsListHandler GET /admin/client-reports?limit= — последние отчёты
+func clientReportsListHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeProblem(w, r, http.StatusBadRequest, "limit должен быть от 1 до 1000")
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(requestLog.recentReports(limit))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Объединение одинаковых запросов к апстриму (singleflight)
+// ─────────────────────────────────────────────────────────────
+
+type flightCall struct {
+	wg     sync.WaitGroup
+	body   []byte
+	status int
+	err    error
+	dups   int
+}
+
+// flightGroup гарантирует, что одновременные вызовы с одним ключом
+// выполняют функцию один раз, а остальные ждут и получают её результат.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+var newsFlights = &flightGroup{calls: make(map[string]*flightCall)}
+
+// do выполняет fn для key; shared == true, если результат получен
+// от чужого запроса. Возвращаемое тело нельзя изменять. Если fn
+// паникует, ожидающие получают ошибку, ключ освобождается, а паника
+// продолжается в вызвавшей fn горутине.
+func (g *flightGroup) do(key string, fn func() ([]byte, int, error)) (body []byte, status int, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.body, c.status, true, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		var p interface{}
+		if !returned {
+			p = recover()
+			c.err = fmt.Errorf("запрос к апстриму завершился паникой: %v", p)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		shared = c.dups > 0
+		g.mu.Unlock()
+		c.wg.Done()
+		if !returned {
+			panic(p)
+		}
+	}()
+
+	c.body, c.status, c.err = fn()
+	returned = true
+	return c.body, c.status, shared, c.err
+}
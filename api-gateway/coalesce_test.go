@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupPanicReleasesKey(t *testing.T) {
+	g := &flightGroup{calls: make(map[string]*flightCall)}
+	started := make(chan struct{})
+
+	// ожидающий вызов должен получить ошибку, а не зависнуть
+	var wg sync.WaitGroup
+	var waitErr error
+	var waitShared bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-started
+		_, _, waitShared, waitErr = g.do("news", func() ([]byte, int, error) {
+			t.Error("fn не должна выполняться для ожидающего вызова")
+			return nil, 0, nil
+		})
+	}()
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("паника не дошла до вызвавшего fn: %v", p)
+			}
+		}()
+		g.do("news", func() ([]byte, int, error) {
+			close(started)
+			// ждём, пока второй вызов встанет в очередь за первым
+			for {
+				g.mu.Lock()
+				dups := g.calls["news"].dups
+				g.mu.Unlock()
+				if dups > 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			panic("boom")
+		})
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ожидающий вызов завис после паники")
+	}
+	if waitErr == nil || !waitShared {
+		t.Fatalf("ожидающий вызов: shared=%t err=%v", waitShared, waitErr)
+	}
+
+	// ключ освобождён: следующий вызов выполняет fn заново
+	body, status, shared, err := g.do("news", func() ([]byte, int, error) {
+		return []byte("ok"), 200, nil
+	})
+	if err != nil || shared || status != 200 || string(body) != "ok" {
+		t.Fatalf("вызов после паники: %q %d %t %v", body, status, shared, err)
+	}
+}
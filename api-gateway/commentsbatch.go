@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Пакетная загрузка комментариев для списков новостей
+// ─────────────────────────────────────────────────────────────
+
+// Список новостей с ?include=comments дополняется комментариями одним
+// запросом к comments-service (GET /comments?news_ids=...). Ошибка
+// пакета или отдельных ID не ломает выдачу: такие новости остаются без
+// комментариев и перечисляются в comments_unavailable.
+
+const (
+	defaultListCommentsLimit = 20
+	maxListCommentsLimit     = 100
+)
+
+// batchNewsComments ответ comments-service для одной новости
+type batchNewsComments struct {
+	Comments     []Comment `json:"comments"`
+	Total        int       `json:"total"`
+	Continuation string    `json:"continuation,omitempty"`
+}
+
+type batchCommentsResponse struct {
+	Results map[string]batchNewsComments `json:"results"`
+	Errors  map[string]string            `json:"errors,omitempty"`
+}
+
+// wantsComments проверяет ?include=comments
+func wantsComments(r *http.Request) bool {
+	for _, v := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(v) == "comments" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchCommentsBatch загружает комментарии новостей одним запросом.
+// Возвращает найденные результаты и ID, для которых их получить не удалось.
+func fetchCommentsBatch(ids []int, maxNodes int, requestID string) (map[int]batchNewsComments, []int) {
+	results := make(map[int]batchNewsComments, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
+	metrics.commentsBatchSize.observe(float64(len(ids)))
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	params := url.Values{}
+	params.Set("news_ids", strings.Join(parts, ","))
+	params.Set("max_nodes", strconv.Itoa(maxNodes))
+	params.Set("request_id", requestID)
+
+	var batch batchCommentsResponse
+	resp, err := commentsHealth.get(commentsServiceURL + "/comments?" + params.Encode())
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&batch)
+		}
+	}
+	if err != nil {
+		log.Printf("Пакетная загрузка комментариев для %d новостей не удалась: %v, request_id: %s", len(ids), err, requestID)
+		metrics.commentsBatchRequests.inc("failed")
+		return results, ids
+	}
+
+	var failed []int
+	for _, id := range ids {
+		res, ok := batch.Results[strconv.Itoa(id)]
+		if !ok {
+			failed = append(failed, id)
+			continue
+		}
+		results[id] = res
+	}
+	if len(failed) > 0 {
+		metrics.commentsBatchRequests.inc("partial")
+	} else {
+		metrics.commentsBatchRequests.inc("ok")
+	}
+	return results, failed
+}
+
+// attachListComments дополняет новости списка комментариями,
+// если клиент запросил ?include=comments[&comments_limit=N]
+func attachListComments(r *http.Request, list *NewsListResponse) {
+	if !wantsComments(r) || len(list.News) == 0 {
+		return
+	}
+	limit := defaultListCommentsLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("comments_limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > maxListCommentsLimit {
+		limit = maxListCommentsLimit
+	}
+
+	ids := make([]int, len(list.News))
+	for i, n := range list.News {
+		ids[i] = n.ID
+	}
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	results, failed := fetchCommentsBatch(ids, limit, requestID)
+
+	for i := range list.News {
+		res, ok := results[list.News[i].ID]
+		if !ok {
+			continue
+		}
+		addCommentLinks(res.Comments)
+		total := res.Total
+		list.News[i].Comments = res.Comments
+		list.News[i].CommentsTotal = &total
+		list.News[i].CommentsContinuation = res.Continuation
+	}
+	list.CommentsUnavailable = failed
+}
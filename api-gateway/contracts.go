@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Проверка контрактов upstream-сервисов
+// ─────────────────────────────────────────────────────────────
+
+// Отдельных файлов protobuf/OpenAPI у сервисов нет: контрактом служат
+// модели gateway, в которые декодируются ответы upstream. Проверка
+// запрашивает ответ сервиса и сравнивает его с моделью: поля, которых
+// модель не знает (unknown), обязательные поля без omitempty, которых
+// нет в ответе (missing), и несовпадение типов (type).
+
+const contractMaxIssues = 100
+
+// upstreamContract ожидаемая форма ответа эндпоинта upstream
+type upstreamContract struct {
+	Upstream string
+	// Path путь с query; {id} заменяется на ID новости
+	Path  string
+	Model reflect.Type
+	// gatewayFields поля модели, которые заполняет сам gateway;
+	// в ответе upstream их быть не должно, и они не считаются missing
+	gatewayFields map[string]bool
+}
+
+var newsGatewayFields = map[string]bool{
+	"links":                 true,
+	"served_stale":          true,
+	"comments":              true,
+	"comments_continuation": true,
+	"comments_total":        true,
+	"comments_unavailable":  true,
+	"next_cursor":           true,
+}
+
+var upstreamContracts = map[string]upstreamContract{
+	"news.latest": {
+		Upstream:      "news-service",
+		Path:          "/news/latest?page=1",
+		Model:         reflect.TypeOf(NewsListResponse{}),
+		gatewayFields: newsGatewayFields,
+	},
+	"news.filter": {
+		Upstream:      "news-service",
+		Path:          "/news/filter?page=1",
+		Model:         reflect.TypeOf(NewsListResponse{}),
+		gatewayFields: newsGatewayFields,
+	},
+	"news.detail": {
+		Upstream:      "news-service",
+		Path:          "/news/{id}",
+		Model:         reflect.TypeOf(NewsFullDetailed{}),
+		gatewayFields: newsGatewayFields,
+	},
+	"comments.list": {
+		Upstream:      "comments-service",
+		Path:          "/comments/{id}",
+		Model:         reflect.TypeOf([]Comment{}),
+		gatewayFields: map[string]bool{"links": true},
+	},
+}
+
+var upstreamBaseURLs = map[string]string{
+	"news-service":     newsServiceURL,
+	"comments-service": commentsServiceURL,
+}
+
+var contractClient = &http.Client{Timeout: 10 * time.Second}
+
+// ContractIssue расхождение ответа с моделью. Индексы массивов в пути
+// заменены на [], чтобы одно расхождение не повторялось для каждого элемента.
+type ContractIssue struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ContractReport результат проверки одного контракта
+type ContractReport struct {
+	Contract string          `json:"contract"`
+	URL      string          `json:"url"`
+	Status   int             `json:"status,omitempty"`
+	OK       bool            `json:"ok"`
+	Error    string          `json:"error,omitempty"`
+	Issues   []ContractIssue `json:"issues"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// contractChecker обходит JSON-значение параллельно с типом модели
+type contractChecker struct {
+	ignore map[string]bool
+	seen   map[string]bool
+	issues []ContractIssue
+}
+
+func (c *contractChecker) report(path, kind, detail string) {
+	key := kind + " " + path
+	if c.seen[key] || len(c.issues) >= contractMaxIssues {
+		return
+	}
+	c.seen[key] = true
+	c.issues = append(c.issues, ContractIssue{Path: path, Kind: kind, Detail: detail})
+}
+
+// jsonFields поля структуры по json-тегам
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func hasOmitEmpty(f reflect.StructField) bool {
+	_, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "omitempty" {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (c *contractChecker) check(path string, v interface{}, t reflect.Type) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	if v == nil {
+		// null допустим для указателей, срезов и map; для остальных
+		// типов json молча оставит нулевое значение
+		if !nullable && t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+			c.report(path, "type", "ожидалось значение, получен null")
+		}
+		return
+	}
+
+	expected := ""
+	switch {
+	case t == timeType:
+		s, ok := v.(string)
+		if !ok {
+			expected = "string (RFC 3339)"
+		} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			c.report(path, "type", "ожидалась дата RFC 3339, получено "+strconv.Quote(s))
+		}
+	case t.Kind() == reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			expected = "object"
+			break
+		}
+		fields := jsonFields(t)
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f, known := fields[name]
+			switch {
+			case !known:
+				c.report(path+"."+name, "unknown", "поле отсутствует в модели gateway")
+			case c.ignore[name]:
+				c.report(path+"."+name, "unexpected", "поле заполняет gateway, upstream его передавать не должен")
+			default:
+				c.check(path+"."+name, obj[name], f.Type)
+			}
+		}
+		fieldNames := make([]string, 0, len(fields))
+		for name := range fields {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+		for _, name := range fieldNames {
+			if _, present := obj[name]; !present && !c.ignore[name] && !hasOmitEmpty(fields[name]) {
+				c.report(path+"."+name, "missing", "обязательное поле модели не пришло")
+			}
+		}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		items, ok := v.([]interface{})
+		if !ok {
+			expected = "array"
+			break
+		}
+		for _, item := range items {
+			c.check(path+"[]", item, t.Elem())
+		}
+	case t.Kind() == reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			expected = "object"
+			break
+		}
+		for name, item := range obj {
+			c.check(path+"."+name, item, t.Elem())
+		}
+	case t.Kind() == reflect.String:
+		if _, ok := v.(string); !ok {
+			expected = "string"
+		}
+	case t.Kind() == reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			expected = "boolean"
+		}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		n, ok := v.(float64)
+		if !ok {
+			expected = "number"
+		} else if t.Kind() < reflect.Float32 && n != float64(int64(n)) {
+			c.report(path, "type", "ожидалось целое число")
+		}
+	}
+	if expected != "" {
+		c.report(path, "type", fmt.Sprintf("ожидался %s, получен %s", expected, jsonTypeName(v)))
+	}
+}
+
+// validateContract сверяет JSON-ответ с моделью контракта
+func validateContract(body []byte, contract upstreamContract) ([]ContractIssue, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("ответ не JSON: %v", err)
+	}
+	c := &contractChecker{ignore: contract.gatewayFields, seen: make(map[string]bool)}
+	c.check("$", doc, contract.Model)
+	if c.issues == nil {
+		c.issues = []ContractIssue{}
+	}
+	return c.issues, nil
+}
+
+func checkContract(name string, contract upstreamContract, newsID int) ContractReport {
+	path := contract.Path
+	report := ContractReport{Contract: name, Issues: []ContractIssue{}}
+	if strings.Contains(path, "{id}") {
+		if newsID == 0 {
+			report.Error = "не задан news_id, а в /news/latest нет новостей"
+			return report
+		}
+		path = strings.Replace(path, "{id}", strconv.Itoa(newsID), 1)
+	}
+	report.URL = upstreamBaseURLs[contract.Upstream] + path
+
+	resp, err := contractClient.Get(report.URL)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer resp.Body.Close()
+	report.Status = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		report.Error = fmt.Sprintf("upstream ответил %d", resp.StatusCode)
+		return report
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	issues, err := validateContract(body, contract)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Issues = issues
+	report.OK = len(issues) == 0
+	return report
+}
+
+// sampleNewsID ID первой новости из /news/latest для контрактов с {id}
+func sampleNewsID() int {
+	resp, err := contractClient.Get(newsServiceURL + "/news/latest?page=1")
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	var list NewsListResponse
+	if json.NewDecoder(resp.Body).Decode(&list) != nil || len(list.News) == 0 {
+		return 0
+	}
+	return list.News[0].ID
+}
+
+// contractsCheckHandler GET /admin/contracts/check[?contract=news.detail&news_id=42]
+// проверяет один или все контракты и возвращает отчёты
+func contractsCheckHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	names := []string{}
+	if name := query.Get("contract"); name != "" {
+		if _, ok := upstreamContracts[name]; !ok {
+			writeProblem(w, r, http.StatusBadRequest, "Неизвестный контракт: "+name)
+			return
+		}
+		names = append(names, name)
+	} else {
+		for name := range upstreamContracts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	newsID := 0
+	if v := query.Get("news_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, "Неверный news_id")
+			return
+		}
+		newsID = id
+	}
+	for _, name := range names {
+		if newsID == 0 && strings.Contains(upstreamContracts[name].Path, "{id}") {
+			newsID = sampleNewsID()
+			break
+		}
+	}
+
+	reports := make([]ContractReport, 0, len(names))
+	for _, name := range names {
+		reports = append(reports, checkContract(name, upstreamContracts[name], newsID))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Профилирование и отладочные эндпоинты (внутренний листенер)
+// ─────────────────────────────────────────────────────────────
+
+// Эндпоинты регистрируются только на внутреннем листенере и, как
+// и /admin/*, требуют X-Admin-Token.
+
+var startedAt = time.Now()
+
+func init() {
+	expvar.Publish("runtime", expvar.Func(func() interface{} { return runtimeStats() }))
+}
+
+// RuntimeStats сводка состояния рантайма
+type RuntimeStats struct {
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	GoVersion     string  `json:"go_version"`
+	NumCPU        int     `json:"num_cpu"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	Goroutines    int     `json:"goroutines"`
+	HeapAlloc     uint64  `json:"heap_alloc_bytes"`
+	HeapInuse     uint64  `json:"heap_inuse_bytes"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	Sys           uint64  `json:"sys_bytes"`
+	NumGC         uint32  `json:"num_gc"`
+	LastGCPauseMs float64 `json:"last_gc_pause_ms"`
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+func runtimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := RuntimeStats{
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		GoVersion:     runtime.Version(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapObjects:   m.HeapObjects,
+		Sys:           m.Sys,
+		NumGC:         m.NumGC,
+		GCCPUFraction: m.GCCPUFraction,
+	}
+	if m.NumGC > 0 {
+		stats.LastGCPauseMs = float64(m.PauseNs[(m.NumGC+255)%256]) / 1e6
+	}
+	return stats
+}
+
+// runtimeStatsHandler GET /debug/runtime
+func runtimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(runtimeStats())
+}
+
+// goroutineDumpHandler GET /debug/goroutines — стеки всех горутин
+func goroutineDumpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			w.Write(buf[:n])
+			return
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// freeOSMemoryHandler POST /debug/gc — принудительная сборка мусора
+func freeOSMemoryHandler(w http.ResponseWriter, r *http.Request) {
+	debug.FreeOSMemory()
+	runtimeStatsHandler(w, r)
+}
+
+// registerDebugRoutes добавляет pprof, expvar и отладочные эндпоинты
+func registerDebugRoutes(rt *router) {
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/", pprof.Index)
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/cmdline", pprof.Cmdline)
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/profile", pprof.Profile)
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/symbol", pprof.Symbol)
+	rt.HandleFunc(http.MethodPost, "/debug/pprof/symbol", pprof.Symbol)
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/trace", pprof.Trace)
+	// heap, goroutine, block, mutex, allocs, threadcreate
+	rt.HandleFunc(http.MethodGet, "/debug/pprof/{profile}", pprof.Index)
+	rt.Handle(http.MethodGet, "/debug/vars", expvar.Handler())
+	rt.HandleFunc(http.MethodGet, "/debug/runtime", runtimeStatsHandler)
+	rt.HandleFunc(http.MethodGet, "/debug/goroutines", goroutineDumpHandler)
+	rt.HandleFunc(http.MethodPost, "/debug/gc", freeOSMemoryHandler)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Выгрузка данных пользователя (запрос на доступ, GDPR)
+// ─────────────────────────────────────────────────────────────
+
+// GET /my/data собирает всё, что сервисы хранят о пользователе, в один
+// JSON-архив: комментарии (постранично из /export/comments) и настройки
+// уведомлений comments-service. Выгрузка идёт в фоне; если она успевает
+// за dataExportSyncWait, архив отдаётся сразу, иначе — 202 с Location
+// задания, ход которого виден в GET /my/data/exports/{id}. У пользователя
+// одновременно идёт не больше одной выгрузки; готовый архив хранится
+// dataExportTTL.
+
+const (
+	dataExportSyncWait = 5 * time.Second
+	dataExportTTL      = time.Hour
+	dataExportPageSize = 500
+)
+
+// Статусы выгрузки
+const (
+	exportRunning = "running"
+	exportDone    = "done"
+	exportFailed  = "failed"
+)
+
+// ExportSectionProgress ход выгрузки одного раздела
+type ExportSectionProgress struct {
+	Fetched int  `json:"fetched"`
+	Total   int  `json:"total"`
+	Done    bool `json:"done"`
+}
+
+// DataExportStatus состояние выгрузки для клиента
+type DataExportStatus struct {
+	ID         string                           `json:"id"`
+	Status     string                           `json:"status"`
+	Sections   map[string]ExportSectionProgress `json:"sections"`
+	Error      string                           `json:"error,omitempty"`
+	StartedAt  time.Time                        `json:"started_at"`
+	FinishedAt *time.Time                       `json:"finished_at,omitempty"`
+}
+
+// UserDataArchive архив данных пользователя
+type UserDataArchive struct {
+	Username                string          `json:"username"`
+	GeneratedAt             time.Time       `json:"generated_at"`
+	Comments                []interface{}   `json:"comments"`
+	NotificationPreferences json.RawMessage `json:"notification_preferences"`
+}
+
+type dataExportJob struct {
+	username string
+	done     chan struct{}
+
+	mu      sync.Mutex
+	status  DataExportStatus
+	archive []byte
+}
+
+func (j *dataExportJob) snapshot() DataExportStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Sections = make(map[string]ExportSectionProgress, len(j.status.Sections))
+	for k, v := range j.status.Sections {
+		s.Sections[k] = v
+	}
+	return s
+}
+
+func (j *dataExportJob) progress(section string, p ExportSectionProgress) {
+	j.mu.Lock()
+	j.status.Sections[section] = p
+	j.mu.Unlock()
+}
+
+type dataExportStore struct {
+	mu     sync.Mutex
+	jobs   map[string]*dataExportJob
+	active map[string]*dataExportJob // идущая выгрузка пользователя
+}
+
+var dataExports = &dataExportStore{
+	jobs:   make(map[string]*dataExportJob),
+	active: make(map[string]*dataExportJob),
+}
+
+// start возвращает идущую выгрузку пользователя или запускает новую
+func (s *dataExportStore) start(username, requestID string) *dataExportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	if job, ok := s.active[username]; ok {
+		return job
+	}
+	job := &dataExportJob{
+		username: username,
+		done:     make(chan struct{}),
+		status: DataExportStatus{
+			ID:        generateRequestID(),
+			Status:    exportRunning,
+			Sections:  map[string]ExportSectionProgress{},
+			StartedAt: time.Now(),
+		},
+	}
+	s.jobs[job.status.ID] = job
+	s.active[username] = job
+	go s.run(job, requestID)
+	return job
+}
+
+// get выгрузка по ID, только для её владельца
+func (s *dataExportStore) get(id, username string) (*dataExportJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	job, ok := s.jobs[id]
+	if !ok || job.username != username {
+		return nil, false
+	}
+	return job, true
+}
+
+// expireLocked удаляет завершённые выгрузки старше dataExportTTL
+func (s *dataExportStore) expireLocked() {
+	for id, job := range s.jobs {
+		st := job.snapshot()
+		if st.FinishedAt != nil && time.Since(*st.FinishedAt) > dataExportTTL {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *dataExportStore) run(job *dataExportJob, requestID string) {
+	archive, err := collectUserData(job, requestID)
+	now := time.Now()
+	job.mu.Lock()
+	job.status.FinishedAt = &now
+	if err != nil {
+		job.status.Status = exportFailed
+		job.status.Error = err.Error()
+	} else {
+		job.status.Status = exportDone
+		job.archive = archive
+	}
+	job.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.active, job.username)
+	s.mu.Unlock()
+	close(job.done)
+
+	if err != nil {
+		log.Printf("[ERROR] Выгрузка данных %s пользователя %s не удалась: %v", job.status.ID, job.username, err)
+	} else {
+		log.Printf("Выгрузка данных %s пользователя %s готова: %d байт", job.status.ID, job.username, len(archive))
+	}
+}
+
+// collectUserData обходит экспортные API сервисов и собирает архив
+func collectUserData(job *dataExportJob, requestID string) ([]byte, error) {
+	archive := UserDataArchive{Username: job.username, Comments: []interface{}{}}
+
+	cursor := ""
+	for {
+		params := url.Values{"request_id": {requestID}, "limit": {strconv.Itoa(dataExportPageSize)}}
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+		var page struct {
+			Total      int           `json:"total"`
+			Comments   []interface{} `json:"comments"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := fetchUserExport("/export/comments?"+params.Encode(), job.username, &page); err != nil {
+			return nil, fmt.Errorf("комментарии: %v", err)
+		}
+		archive.Comments = append(archive.Comments, page.Comments...)
+		job.progress("comments", ExportSectionProgress{
+			Fetched: len(archive.Comments), Total: page.Total, Done: page.NextCursor == "",
+		})
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	params := url.Values{"request_id": {requestID}}
+	if err := fetchUserExport("/preferences/notifications?"+params.Encode(), job.username, &archive.NotificationPreferences); err != nil {
+		return nil, fmt.Errorf("настройки уведомлений: %v", err)
+	}
+	job.progress("notification_preferences", ExportSectionProgress{Fetched: 1, Total: 1, Done: true})
+
+	archive.GeneratedAt = time.Now()
+	return json.MarshalIndent(archive, "", "  ")
+}
+
+// fetchUserExport GET к comments-service от имени пользователя
+func fetchUserExport(path, username string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, commentsServiceURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-User", username)
+	setServiceToken(req)
+	resp, err := commentsHealth.do(moderationClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("сервис комментариев вернул статус %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// myDataHandler GET /my/data — запускает выгрузку и отдаёт архив, если
+// она успела завершиться, иначе 202 с адресом задания
+func myDataHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+
+	job := dataExports.start(username, requestID)
+	select {
+	case <-job.done:
+	case <-time.After(dataExportSyncWait):
+	case <-r.Context().Done():
+		return
+	}
+	writeDataExport(w, r, job, http.StatusAccepted)
+}
+
+// myDataExportHandler GET /my/data/exports/{id} — ход выгрузки или архив
+func myDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	job, ok := dataExports.get(pathParam(r, "id"), username)
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, "Выгрузка не найдена или устарела")
+		return
+	}
+	writeDataExport(w, r, job, http.StatusOK)
+}
+
+// writeDataExport отдаёт архив готовой выгрузки или её состояние
+// со статусом runningStatus
+func writeDataExport(w http.ResponseWriter, r *http.Request, job *dataExportJob, runningStatus int) {
+	st := job.snapshot()
+	switch st.Status {
+	case exportDone:
+		job.mu.Lock()
+		archive := job.archive
+		job.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="my-data-%s.json"`, st.ID))
+		w.Write(archive)
+	case exportFailed:
+		writeProblem(w, r, http.StatusBadGateway, "Не удалось собрать данные: "+st.Error)
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Location", "/my/data/exports/"+st.ID)
+		w.WriteHeader(runningStatus)
+		json.NewEncoder(w).Encode(st)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Фича-флаги
+// ─────────────────────────────────────────────────────────────
+
+const (
+	flagUseNewSearch     = "use_new_search"
+	flagCommentsReadonly = "comments_readonly"
+)
+
+// FeatureFlag описание флага. Флаг включён для пользователя, если
+// Enabled == true и пользователь попал в Users или в процент раскатки.
+// Пустой Users и нулевой Percent означают «включён для всех».
+type FeatureFlag struct {
+	Name        string   `json:"name"`
+	Enabled     bool     `json:"enabled"`
+	Percent     int      `json:"percent,omitempty"`
+	Users       []string `json:"users,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// flagStore хранит флаги в памяти и сохраняет их в JSON-файл
+type flagStore struct {
+	mu    sync.RWMutex
+	path  string
+	flags map[string]FeatureFlag
+
+	// saveMu упорядочивает записи файла: снимок берётся под ним, поэтому
+	// последним на диск попадает последнее изменение
+	saveMu sync.Mutex
+}
+
+var flags *flagStore
+
+func newFlagStore(path string) (*flagStore, error) {
+	s := &flagStore{path: path, flags: make(map[string]FeatureFlag)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("не удалось прочитать флаги из %s: %w", path, err)
+	}
+
+	var list []FeatureFlag
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("не удалось распарсить флаги из %s: %w", path, err)
+	}
+	for _, f := range list {
+		s.flags[f.Name] = f
+	}
+	return s, nil
+}
+
+// Enabled вычисляет флаг для запроса с учётом пользователя из контекста
+func (s *flagStore) Enabled(name string, r *http.Request) bool {
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	return s.EnabledFor(name, username)
+}
+
+// EnabledFor вычисляет флаг для конкретного пользователя
+func (s *flagStore) EnabledFor(name, username string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	f, ok := s.flags[name]
+	s.mu.RUnlock()
+	if !ok || !f.Enabled {
+		return false
+	}
+	if len(f.Users) == 0 && f.Percent == 0 {
+		return true
+	}
+	for _, u := range f.Users {
+		if u == username {
+			return true
+		}
+	}
+	if f.Percent > 0 && username != "" {
+		h := fnv.New32a()
+		h.Write([]byte(name + ":" + username))
+		return int(h.Sum32()%100) < f.Percent
+	}
+	return false
+}
+
+func (s *flagStore) list() []FeatureFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]FeatureFlag, 0, len(s.flags))
+	for _, f := range s.flags {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *flagStore) set(f FeatureFlag) error {
+	s.mu.Lock()
+	s.flags[f.Name] = f
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *flagStore) delete(name string) error {
+	s.mu.Lock()
+	delete(s.flags, name)
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *flagStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// flagsAdminHandler управление флагами:
+// GET — список, PUT — создать/изменить, DELETE ?name= — удалить.
+func flagsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(flags.list())
+	case http.MethodPut, http.MethodPost:
+		var f FeatureFlag
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Неверный JSON")
+			return
+		}
+		f.Name = strings.TrimSpace(f.Name)
+		if f.Name == "" {
+			writeProblem(w, r, http.StatusBadRequest, "Требуется имя флага")
+			return
+		}
+		if f.Percent < 0 || f.Percent > 100 {
+			writeProblem(w, r, http.StatusBadRequest, "percent должен быть от 0 до 100")
+			return
+		}
+		if err := flags.set(f); err != nil {
+			log.Printf("Ошибка сохранения флагов: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Ошибка сохранения флагов")
+			return
+		}
+		log.Printf("Флаг %s изменён: enabled=%v percent=%d", f.Name, f.Enabled, f.Percent)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(f)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeProblem(w, r, http.StatusBadRequest, "Требуется имя флага")
+			return
+		}
+		if err := flags.delete(name); err != nil {
+			log.Printf("Ошибка сохранения флагов: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, "Ошибка сохранения флагов")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Метод не поддерживается")
+	}
+}
+
+// flagsEvaluateHandler возвращает значения всех флагов для текущего пользователя
+func flagsEvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]bool)
+	for _, f := range flags.list() {
+		result[f.Name] = flags.Enabled(f.Name, r)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
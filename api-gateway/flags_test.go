@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFlagStoreEnabledFor(t *testing.T) {
+	s, err := newFlagStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(FeatureFlag{Name: "all", Enabled: true})
+	s.set(FeatureFlag{Name: "off", Enabled: false, Users: []string{"alice"}})
+	s.set(FeatureFlag{Name: "listed", Enabled: true, Users: []string{"alice"}})
+	s.set(FeatureFlag{Name: "half", Enabled: true, Percent: 50})
+
+	if !s.EnabledFor("all", "") || !s.EnabledFor("all", "bob") {
+		t.Error("флаг без аудитории должен быть включён для всех")
+	}
+	if s.EnabledFor("off", "alice") {
+		t.Error("выключенный флаг включён")
+	}
+	if !s.EnabledFor("listed", "alice") || s.EnabledFor("listed", "bob") {
+		t.Error("флаг по списку пользователей")
+	}
+	if s.EnabledFor("missing", "alice") {
+		t.Error("неизвестный флаг включён")
+	}
+	// анонимный пользователь в процент раскатки не попадает
+	if s.EnabledFor("half", "") {
+		t.Error("процентная раскатка включена для анонима")
+	}
+
+	// решение для пользователя стабильно, а доля включённых близка к проценту
+	on := 0
+	for i := 0; i < 1000; i++ {
+		user := "user" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		first := s.EnabledFor("half", user)
+		if first != s.EnabledFor("half", user) {
+			t.Fatalf("решение для %s меняется между вызовами", user)
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 400 || on > 600 {
+		t.Errorf("включено у %d из 1000 при percent=50", on)
+	}
+
+	var nilStore *flagStore
+	if nilStore.EnabledFor("all", "alice") {
+		t.Error("без хранилища флаги должны быть выключены")
+	}
+}
+
+// Изменения, сделанные через админку, переживают перезапуск
+func TestFlagStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	s, err := newFlagStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.set(FeatureFlag{Name: flagUseNewSearch, Enabled: true, Percent: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.set(FeatureFlag{Name: flagCommentsReadonly, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.delete(flagCommentsReadonly); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newFlagStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reloaded.list()
+	if len(got) != 1 || got[0].Name != flagUseNewSearch || got[0].Percent != 10 {
+		t.Fatalf("после перезапуска: %+v", got)
+	}
+}
+
+// Параллельные изменения не теряются: файл после них совпадает с памятью
+func TestFlagStoreConcurrentSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flags.json")
+	s, err := newFlagStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("flag_%02d", i)
+			if err := s.set(FeatureFlag{Name: name, Enabled: true}); err != nil {
+				t.Error(err)
+			}
+			if i%2 == 0 {
+				if err := s.delete(name); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	reloaded, err := newFlagStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(reloaded.list()), fmt.Sprint(s.list()); got != want || len(s.list()) != 10 {
+		t.Fatalf("на диске %s, в памяти %s", got, want)
+	}
+	// временные файлы не остаются
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("файлы в каталоге: %v", entries)
+	}
+}
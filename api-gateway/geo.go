@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Геоограничения
+// ─────────────────────────────────────────────────────────────
+
+type geoRange struct {
+	network *net.IPNet
+	country string
+}
+
+// geoResolver определяет страну клиента по IP. Источники: заголовок,
+// выставляемый CDN/балансировщиком (GEO_COUNTRY_HEADER), и CSV-файл
+// с диапазонами вида "cidr,country" (GEOIP_CSV_PATH). Заголовку страны и
+// X-Forwarded-For верим, только если запрос пришёл с адреса из
+// GEO_TRUSTED_PROXIES (CIDR через запятую): иначе клиент подставил бы
+// любую страну сам. Без доверенных прокси страна определяется по адресу
+// соединения.
+type geoResolver struct {
+	header  string
+	ranges  []geoRange
+	trusted []*net.IPNet
+}
+
+var geo *geoResolver
+
+func newGeoResolver(header, csvPath, trustedProxies string) (*geoResolver, error) {
+	g := &geoResolver{header: header}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный GEO_TRUSTED_PROXIES: %w", err)
+		}
+		g.trusted = append(g.trusted, network)
+	}
+	if csvPath == "" {
+		return g, nil
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть GeoIP-базу %s: %w", csvPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ",", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(parts[0]))
+		if err != nil {
+			continue
+		}
+		g.ranges = append(g.ranges, geoRange{
+			network: network,
+			country: strings.ToUpper(strings.TrimSpace(parts[1])),
+		})
+	}
+	return g, scanner.Err()
+}
+
+func (g *geoResolver) isTrusted(ip net.IP) bool {
+	for _, n := range g.trusted {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP адрес клиента: адрес соединения, а если оно от доверенного
+// прокси — первый справа недоверенный адрес X-Forwarded-For
+func (g *geoResolver) clientIP(r *http.Request) (ip net.IP, viaTrusted bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip = net.ParseIP(host)
+	if !g.isTrusted(ip) {
+		return ip, false
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !g.isTrusted(hop) {
+			break
+		}
+	}
+	return ip, true
+}
+
+// country возвращает код страны клиента или пустую строку, если она неизвестна
+func (g *geoResolver) country(r *http.Request) string {
+	ip, viaTrusted := g.clientIP(r)
+	if g.header != "" && viaTrusted {
+		if c := strings.TrimSpace(r.Header.Get(g.header)); c != "" {
+			return strings.ToUpper(c)
+		}
+	}
+	if ip == nil {
+		return ""
+	}
+	for _, rng := range g.ranges {
+		if rng.network.Contains(ip) {
+			return rng.country
+		}
+	}
+	return ""
+}
+
+// geoAllowed проверяет, можно ли показывать новость клиенту из страны country.
+// Если страна не определена, новости с ограничениями не показываются.
+func geoAllowed(restriction []string, country string) bool {
+	if len(restriction) == 0 {
+		return true
+	}
+	for _, c := range restriction {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterNewsByGeo убирает из списка новости, недоступные в стране клиента
+func filterNewsByGeo(news []NewsShortDetailed, country string) []NewsShortDetailed {
+	filtered := make([]NewsShortDetailed, 0, len(news))
+	for _, n := range news {
+		if geoAllowed(n.GeoRestriction, country) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
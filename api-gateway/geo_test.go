@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoResolverCountry(t *testing.T) {
+	csv := filepath.Join(t.TempDir(), "geoip.csv")
+	data := "# cidr,country\n203.0.113.0/24,de\n198.51.100.0/24,FR\nмусор\n"
+	if err := os.WriteFile(csv, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := newGeoResolver("X-Country", csv, "10.0.0.1, 10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		header string
+		want   string
+	}{
+		{"адрес соединения", "203.0.113.7:5000", "", "", "DE"},
+		{"недоверенный клиент подставил страну", "203.0.113.7:5000", "198.51.100.1", "US", "DE"},
+		{"заголовок от доверенного прокси", "10.0.0.1:5000", "", "us", "US"},
+		{"XFF от доверенного прокси", "10.0.0.1:5000", "198.51.100.9, 10.1.2.3", "", "FR"},
+		{"неизвестный адрес", "192.0.2.1:5000", "", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/news", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.header != "" {
+			r.Header.Set("X-Country", tt.header)
+		}
+		if got := g.country(r); got != tt.want {
+			t.Errorf("%s: страна %q, ожидалась %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGeoAllowed(t *testing.T) {
+	if !geoAllowed(nil, "") {
+		t.Error("новость без ограничений должна быть видна всем")
+	}
+	if !geoAllowed([]string{"RU", "by"}, "BY") {
+		t.Error("страна из списка должна видеть новость")
+	}
+	// страна не определена — ограниченная новость скрыта
+	if geoAllowed([]string{"RU"}, "") || geoAllowed([]string{"RU"}, "DE") {
+		t.Error("ограниченная новость видна вне списка стран")
+	}
+
+	news := []NewsShortDetailed{{ID: 1}, {ID: 2, GeoRestriction: []string{"DE"}}}
+	if got := filterNewsByGeo(news, "FR"); len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("фильтр для FR: %+v", got)
+	}
+}
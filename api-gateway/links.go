@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Навигационные ссылки (HATEOAS)
+// ─────────────────────────────────────────────────────────────
+
+// publicBaseURL внешний адрес gateway для построения ссылок (PUBLIC_BASE_URL)
+var publicBaseURL = "http://localhost:8080"
+
+// Links набор ссылок по отношению: self, next, prev, comments, news
+type Links map[string]string
+
+func apiURL(path string, query url.Values) string {
+	u := publicBaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// clientQuery параметры запроса клиента без служебного request_id
+func clientQuery(r *http.Request) url.Values {
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		if k != "request_id" {
+			q[k] = v
+		}
+	}
+	return q
+}
+
+// pageURL ссылка на ту же выборку с другой страницей
+func pageURL(r *http.Request, page int) string {
+	q := clientQuery(r)
+	q.Set("page", strconv.Itoa(page))
+	return apiURL(r.URL.Path, q)
+}
+
+// listLinks ссылки для постраничного списка новостей
+func listLinks(r *http.Request, p Pagination) Links {
+	links := Links{"self": apiURL(r.URL.Path, clientQuery(r))}
+	if p.Page < p.TotalPages {
+		links["next"] = pageURL(r, p.Page+1)
+	}
+	if p.Page > 1 {
+		links["prev"] = pageURL(r, p.Page-1)
+	}
+	return links
+}
+
+// setPaginationHeaders дублирует пагинацию списка в заголовках: Link
+// (RFC 8288) с rel="first", "prev", "next" и "last" и X-Total-Count.
+// prev и next берутся из links, поэтому при листании курсором next
+// в заголовке совпадает с next в теле ответа.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, p Pagination, links Links) {
+	last := p.TotalPages
+	if last < 1 {
+		last = 1
+	}
+	parts := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(r, 1))}
+	if prev, ok := links["prev"]; ok {
+		parts = append(parts, fmt.Sprintf(`<%s>; rel="prev"`, prev))
+	}
+	if next, ok := links["next"]; ok {
+		parts = append(parts, fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	parts = append(parts, fmt.Sprintf(`<%s>; rel="last"`, pageURL(r, last)))
+	w.Header().Set("Link", strings.Join(parts, ", "))
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.Total))
+}
+
+func newsLinks(newsID int) Links {
+	return Links{
+		"self":     apiURL(fmt.Sprintf("/news/%d", newsID), nil),
+		"comments": apiURL(fmt.Sprintf("/news/%d/comments", newsID), nil),
+	}
+}
+
+// addCommentLinks проставляет ссылку на новость во всём дереве
+func addCommentLinks(comments []Comment) {
+	for i := range comments {
+		c := &comments[i]
+		c.Links = Links{"news": apiURL(fmt.Sprintf("/news/%d", c.NewsID), nil)}
+		addCommentLinks(c.Children)
+	}
+}
+
+// commentsContinuationURL ссылка на догрузку дерева комментариев
+func commentsContinuationURL(newsID int, token string) string {
+	return apiURL(fmt.Sprintf("/news/%d/comments", newsID), url.Values{"continuation": {token}})
+}
+
+func setPublicBaseURL(value string) {
+	if value = strings.TrimRight(strings.TrimSpace(value), "/"); value != "" {
+		publicBaseURL = value
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -23,22 +25,76 @@ import (
 // Модели
 // ─────────────────────────────────────────────────────────────
 
-type NewsShortDetailed struct {
+// RelatedNews публикация той же истории в другом источнике
+type RelatedNews struct {
 	ID          int       `json:"id"`
 	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	PubDate     time.Time `json:"pub_date"`
 	Link        string    `json:"link"`
+	SourceID    string    `json:"source_id,omitempty"`
+	SourceTitle string    `json:"source_title,omitempty"`
+	PubDate     time.Time `json:"pub_date"`
+}
+
+type NewsShortDetailed struct {
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	PubDate        time.Time `json:"pub_date"`
+	Link           string    `json:"link"`
+	GeoRestriction []string  `json:"geo_restriction,omitempty"`
+	Author         string    `json:"author,omitempty"`
+	SourceID       string    `json:"source_id,omitempty"`
+	SourceTitle    string    `json:"source_title,omitempty"`
+	// ImageURL иллюстрация новости для превью
+	ImageURL string `json:"image_url,omitempty"`
+	// Language язык новости (ISO 639-1)
+	Language string `json:"language,omitempty"`
+	// UpdatedAt время последней правки новости в ленте источника
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Links     Links      `json:"links,omitempty"`
+	// Pick и Coverage только в /news/top: происхождение новости
+	// (curated, trending, latest) и число перепечатавших её источников
+	Pick     string `json:"pick,omitempty"`
+	Coverage int    `json:"coverage,omitempty"`
+	// Комментарии при ?include=comments; comments_total есть только
+	// у новостей, для которых их удалось загрузить
+	Comments             []Comment `json:"comments,omitempty"`
+	CommentsTotal        *int      `json:"comments_total,omitempty"`
+	CommentsContinuation string    `json:"comments_continuation,omitempty"`
 }
 
 type NewsFullDetailed struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Content     string    `json:"content"`
-	Description string    `json:"description"`
-	PubDate     time.Time `json:"pub_date"`
-	Link        string    `json:"link"`
-	Comments    []Comment `json:"comments"`
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	Description    string    `json:"description"`
+	PubDate        time.Time `json:"pub_date"`
+	Link           string    `json:"link"`
+	GeoRestriction []string  `json:"geo_restriction,omitempty"`
+	Author         string    `json:"author,omitempty"`
+	SourceID       string    `json:"source_id,omitempty"`
+	SourceTitle    string    `json:"source_title,omitempty"`
+	ImageURL       string    `json:"image_url,omitempty"`
+	ContentText    string    `json:"content_text,omitempty"`
+	Language       string    `json:"language,omitempty"`
+	// Revision номер редакции новости, UpdatedAt — время последней правки
+	Revision  int        `json:"revision,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// DuplicateOf и Related та же история в других источниках
+	DuplicateOf *int          `json:"duplicate_of,omitempty"`
+	Related     []RelatedNews `json:"related,omitempty"`
+	Comments    []Comment     `json:"comments"`
+	// CommentsContinuation токен для догрузки остатка большого дерева
+	// через /comments/{id}?continuation=
+	CommentsContinuation string `json:"comments_continuation,omitempty"`
+	ServedStale          bool   `json:"served_stale,omitempty"`
+	Links                Links  `json:"links,omitempty"`
+}
+
+// commentsPage часть дерева комментариев и токен продолжения
+type commentsPage struct {
+	Comments     []Comment
+	Continuation string
 }
 
 type Comment struct {
@@ -46,19 +102,37 @@ type Comment struct {
 	NewsID    int       `json:"news_id"`
 	ParentID  *int      `json:"parent_id,omitempty"`
 	Text      string    `json:"text"`
+	Status    string    `json:"status,omitempty"`
+	Language  string    `json:"language,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	Children  []Comment `json:"children,omitempty"`
+	Links     Links     `json:"links,omitempty"`
 }
 
 type CommentRequest struct {
 	NewsID   int    `json:"news_id"`
 	ParentID *int   `json:"parent_id,omitempty"`
 	Text     string `json:"text"`
+	Status   string `json:"status,omitempty"`
 }
 
 type NewsListResponse struct {
-	News       []NewsShortDetailed `json:"news"`
-	Pagination Pagination          `json:"pagination"`
+	News        []NewsShortDetailed `json:"news"`
+	Pagination  Pagination          `json:"pagination"`
+	ServedStale bool                `json:"served_stale,omitempty"`
+	Links       Links               `json:"links,omitempty"`
+	// CommentsUnavailable новости, комментарии которых не загрузились
+	CommentsUnavailable []int `json:"comments_unavailable,omitempty"`
+	// NextCursor курсор следующей страницы, null на последней
+	NextCursor *string `json:"next_cursor"`
+	// Facets разрезы выдачи news-service (/news/filter с facets), как есть
+	Facets json.RawMessage `json:"facets,omitempty"`
+}
+
+// TopNewsResponse ответ /news/top
+type TopNewsResponse struct {
+	News        []NewsShortDetailed `json:"news"`
+	ServedStale bool                `json:"served_stale,omitempty"`
 }
 
 type Pagination struct {
@@ -71,6 +145,8 @@ type Pagination struct {
 type RequestResult struct {
 	Data interface{}
 	Err  error
+	// Status код ответа апстрима; 0 — ответа не было (ошибка транспорта)
+	Status int
 }
 
 type CensorshipRequest struct {
@@ -94,6 +170,20 @@ const (
 
 var jwtSecret []byte
 
+// adminToken токен для служебных /admin/* маршрутов (ADMIN_TOKEN)
+var adminToken string
+
+// serviceToken общий секрет gateway и сервисов (SERVICE_TOKEN): сервисы
+// верят X-User и X-Moderator и открывают /admin/* только с ним
+var serviceToken string
+
+// setServiceToken подписывает запрос к сервису от имени gateway
+func setServiceToken(req *http.Request) {
+	if serviceToken != "" {
+		req.Header.Set("X-Service-Token", serviceToken)
+	}
+}
+
 func validateJWT(tokenString string) (string, error) {
 	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -127,8 +217,7 @@ func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if tokenStr := extractBearerToken(r); tokenStr != "" {
 			if username, err := validateJWT(tokenStr); err == nil && username != "" {
-				ctx := context.WithValue(r.Context(), contextKeyUsername, username)
-				r = r.WithContext(ctx)
+				r = withUsername(r, username)
 			}
 		}
 		next.ServeHTTP(w, r)
@@ -139,25 +228,44 @@ func requireAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := extractBearerToken(r)
 		if tokenStr == "" {
-			http.Error(w, "Необходима авторизация", http.StatusUnauthorized)
+			writeProblem(w, r, http.StatusUnauthorized, "Необходима авторизация")
 			return
 		}
 		username, err := validateJWT(tokenStr)
 		if err != nil || username == "" {
-			http.Error(w, "Токен недействителен или истёк", http.StatusUnauthorized)
+			writeProblem(w, r, http.StatusUnauthorized, "Токен недействителен или истёк")
 			return
 		}
-		ctx := context.WithValue(r.Context(), contextKeyUsername, username)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withUsername(r, username))
 	}
 }
 
+// adminAuthMiddleware пропускает на внутренний листенер только запросы
+// с валидным X-Admin-Token; /health и /metrics доступны без токена
+// для проб и сборщика метрик.
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// сравнение за постоянное время: по времени ответа токен не подобрать
+		got := r.Header.Get("X-Admin-Token")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+			writeProblem(w, r, http.StatusForbidden, "Доступ запрещён")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func requestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestID := r.URL.Query().Get("request_id")
 		if requestID == "" {
 			requestID = generateRequestID()
 		}
+		w.Header().Set("X-Request-ID", requestID)
 		ctx := context.WithValue(r.Context(), contextKeyRequestID, requestID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -186,9 +294,10 @@ func corsMiddleware(next http.Handler) http.Handler {
 			origin = "http://localhost:5173"
 		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count, X-Continuation-Token, Idempotent-Replayed, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, X-Request-ID")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -224,6 +333,12 @@ func generateRequestID() string {
 }
 
 func main() {
+	validate := flag.Bool("validate", false, "проверить конфигурацию и апстримы, вывести отчёт и выйти")
+	flag.Parse()
+	if *validate {
+		runValidateCommand()
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
 	secret := os.Getenv("JWT_SECRET")
@@ -231,54 +346,183 @@ func main() {
 		log.Fatal("JWT_SECRET не задан — запуск невозможен")
 	}
 	jwtSecret = []byte(secret)
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	serviceToken = os.Getenv("SERVICE_TOKEN")
+	setPublicBaseURL(os.Getenv("PUBLIC_BASE_URL"))
+
+	analyticsInterval := time.Minute
+	if v, err := strconv.Atoi(os.Getenv("ANALYTICS_EXPORT_INTERVAL_SEC")); err == nil && v > 0 {
+		analyticsInterval = time.Duration(v) * time.Second
+	}
+	analyticsMaxMB := defaultAnalyticsExportMaxMB
+	if v, err := strconv.Atoi(os.Getenv("ANALYTICS_EXPORT_MAX_SIZE_MB")); err == nil && v > 0 {
+		analyticsMaxMB = v
+	}
+	analytics = newUsageAggregator(os.Getenv("ANALYTICS_EXPORT_PATH"), int64(analyticsMaxMB)<<20, os.Getenv("ANALYTICS_URL"))
+	go analytics.run(analyticsInterval)
+
+	var err error
+	flags, err = newFlagStore(os.Getenv("FEATURE_FLAGS_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	geo, err = newGeoResolver(os.Getenv("GEO_COUNTRY_HEADER"), os.Getenv("GEOIP_CSV_PATH"), os.Getenv("GEO_TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shadowPercent, _ := strconv.ParseFloat(os.Getenv("SHADOW_PERCENT"), 64)
+	shadow = newShadowMirror(strings.TrimRight(os.Getenv("SHADOW_UPSTREAM_URL"), "/"), shadowPercent)
+	if shadow.enabled() {
+		log.Printf("Зеркалирование %.1f%% GET-запросов к новостям на %s", shadow.percent, shadow.upstream)
+	}
+
+	loadBackpressureConfig()
+
+	prober = newStatusProberFromEnv()
+	go prober.run()
+
+	limiter = newConcurrencyLimiterFromEnv()
+	metrics = newGatewayMetricsFromEnv()
+	anomalies = newAnomalyDetectorFromEnv()
+
+	pipe, err := loadPipeline(os.Getenv("ROUTES_CONFIG_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mux := http.NewServeMux()
+	mux := newRouter()
+	route := func(method, pattern, group string, h http.HandlerFunc) {
+		mux.Handle(method, pattern, pipe.wrap(group, pattern, h))
+	}
 
 	// ── Публичные маршруты (новости и чтение комментариев) ──────────────────
-	mux.Handle("/news/latest", authMiddleware(http.HandlerFunc(latestNewsHandler)))
-	mux.Handle("/news/filter", authMiddleware(http.HandlerFunc(filterNewsHandler)))
-	mux.Handle("/news/", authMiddleware(http.HandlerFunc(newsDetailHandler)))
-	mux.HandleFunc("/comments/", getCommentsHandler)
-
-	// ── Защищённый маршрут — создание комментария ───────────────────────────
-	mux.HandleFunc("/comments", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			requireAuthMiddleware(addCommentHandler)(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	// Набор middleware для каждой группы задаётся в ROUTES_CONFIG_PATH
+	route(http.MethodGet, "/news/latest", groupNews, latestNewsHandler)
+	route(http.MethodGet, "/news/filter", groupNews, filterNewsHandler)
+	route(http.MethodGet, "/news/authors", groupNews, newsAuthorsHandler)
+	route(http.MethodGet, "/news/categories", groupNews, newsCategoriesHandler)
+	route(http.MethodGet, "/news/top", groupNews, topNewsHandler)
+	route(http.MethodGet, "/news/popular", groupNews, popularNewsHandler)
+	route(http.MethodGet, "/sources", groupNews, sourcesHandler)
+	route(http.MethodGet, "/news/{id}", groupNews, newsDetailHandler)
+	route(http.MethodPost, "/news/{id}/view", groupNews, newsViewHandler)
+	route(http.MethodGet, "/news/{newsID}/comments", groupCommentsRead, getCommentsHandler)
+	route(http.MethodGet, "/comments/{newsID}", groupCommentsRead, getCommentsHandler)
+	route(http.MethodGet, "/flags", groupFlags, flagsEvaluateHandler)
+	route(http.MethodPost, "/client-reports", groupClientReports, clientReportsHandler)
+
+	// ── Создание комментария ────────────────────────────────────────────────
+	route(http.MethodPost, "/comments", groupCommentsWrite, addCommentHandler)
+
+	// ── Модерация (только назначенные новости и категории) ──────────────────
+	route(http.MethodGet, "/moderation/queue", groupModeration, moderationQueueHandler)
+	route(http.MethodPost, "/moderation/comments/{id}", groupModeration, moderateCommentHandler)
+	route(http.MethodGet, "/moderation/comments/{id}/translation", groupModeration, commentTranslationHandler)
+	route(http.MethodPost, "/moderation/bulk", groupModeration, bulkModerationHandler)
+	route(http.MethodGet, "/moderation/bulk/{id}", groupModeration, bulkBatchHandler)
+	route(http.MethodDelete, "/moderation/bulk/{id}", groupModeration, bulkBatchHandler)
+	route(http.MethodGet, "/me/notifications", groupAccount, notificationPreferencesHandler)
+	route(http.MethodPut, "/me/notifications", groupAccount, notificationPreferencesHandler)
+	route(http.MethodGet, "/my/data", groupAccount, myDataHandler)
+	route(http.MethodGet, "/my/data/exports/{id}", groupAccount, myDataExportHandler)
 
 	// Прокси к SystemAAA
 	// /auth/*, /oauth2/* и /login/oauth2/* пробрасываются в Java-сервис.
-	mux.HandleFunc("/auth/", authProxyHandler)
-	mux.HandleFunc("/oauth2/", authProxyHandler)
-	mux.HandleFunc("/login/oauth2/", authProxyHandler)
-
-	handler := requestIDMiddleware(mux)
+	route(methodAny, "/auth/*", groupAuthProxy, authProxyHandler)
+	route(methodAny, "/oauth2/*", groupAuthProxy, authProxyHandler)
+	route(methodAny, "/login/oauth2/*", groupAuthProxy, authProxyHandler)
+
+	handler := backpressureMiddleware(mux)
+	handler = analyticsMiddleware(handler)
+	handler = loadSheddingMiddleware(handler)
+	handler = metricsMiddleware(handler)
+	handler = requestLogMiddleware(handler)
+	handler = requestIDMiddleware(handler)
 	handler = loggingMiddleware(handler)
 	handler = corsMiddleware(handler)
 
-	log.Println("API Gateway запущен на порту 8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	accessLog, err = newRotatingFileFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if accessLog != nil {
+		handler = accessLogMiddleware(accessLog, handler)
+		log.Printf("Access log пишется в %s", accessLog.path)
+	}
+
+	// ── Служебные маршруты — отдельный внутренний листенер ──────────────────
+	internalMux := newRouter()
+	internalMux.HandleFunc(http.MethodGet, "/health", healthCheckHandler)
+	internalMux.HandleFunc(http.MethodGet, "/metrics", metricsHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/analytics/usage", usageQueryHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/shadow/diffs", shadowDiffsHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/upstreams", upstreamHealthHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/upstreams/baselines", anomalyBaselinesHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/load", loadHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/contracts/check", contractsCheckHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/status", statusHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/requests/{id}", requestLookupHandler)
+	internalMux.HandleFunc(http.MethodGet, "/admin/client-reports", clientReportsListHandler)
+	registerDebugRoutes(internalMux)
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete} {
+		internalMux.HandleFunc(method, "/admin/flags", flagsAdminHandler)
+	}
+
+	internalHandler := adminAuthMiddleware(internalMux)
+	internalHandler = requestIDMiddleware(internalHandler)
+	internalHandler = loggingMiddleware(internalHandler)
+
+	internalAddr := os.Getenv("INTERNAL_LISTEN_ADDR")
+	if internalAddr == "" {
+		internalAddr = "127.0.0.1:9090"
+	}
+	go func() {
+		log.Printf("Внутренний листенер API Gateway запущен на %s", internalAddr)
+		log.Fatal(http.ListenAndServe(internalAddr, internalHandler))
+	}()
+
+	publicAddr := os.Getenv("PUBLIC_LISTEN_ADDR")
+	if publicAddr == "" {
+		publicAddr = ":8080"
+	}
+	// TLS на публичном листенере включается парой TLS_CERT_FILE/TLS_KEY_FILE
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		log.Printf("API Gateway запущен на %s (TLS)", publicAddr)
+		log.Fatal(http.ListenAndServeTLS(publicAddr, certFile, keyFile, handler))
+	}
+	log.Printf("API Gateway запущен на %s", publicAddr)
+	log.Fatal(http.ListenAndServe(publicAddr, handler))
+}
+
+// healthCheckHandler проверка состояния gateway
+func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"timestamp": time.Now(),
+		"service":   "api-gateway",
+	})
 }
 
 // Прокси к SystemAAA
 
+const authServiceURL = "http://system-aaa:8080"
+
 func authProxyHandler(w http.ResponseWriter, r *http.Request) {
-	targetURL := "http://system-aaa:8080" + r.URL.RequestURI()
+	targetURL := authServiceURL + r.URL.RequestURI()
 
 	// Читаем тело один раз, чтобы передать в новый запрос
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Ошибка чтения тела запроса", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка чтения тела запроса")
 		return
 	}
 
 	proxyReq, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(bodyBytes))
 	if err != nil {
-		http.Error(w, "Ошибка создания запроса к auth-сервису", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка создания запроса к auth-сервису")
 		return
 	}
 
@@ -297,14 +541,14 @@ func authProxyHandler(w http.ResponseWriter, r *http.Request) {
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		log.Printf("Ошибка при обращении к system-aaa: %v", err)
-		http.Error(w, "Auth-сервис недоступен", http.StatusServiceUnavailable)
+		writeProblem(w, r, http.StatusServiceUnavailable, "Auth-сервис недоступен")
 		return
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		http.Error(w, "Ошибка чтения ответа auth-сервиса", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка чтения ответа auth-сервиса")
 		return
 	}
 
@@ -320,96 +564,215 @@ func authProxyHandler(w http.ResponseWriter, r *http.Request) {
 // Обработчики новостей
 
 func latestNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	paging, err := resolveListPaging(r, []string{"page", "per_page", "s", "author", "source", "lang"})
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := paging.upstreamParams(requestID)
+	// news-service отбирает доступные в стране новости до пагинации
+	params.Set("country", geo.country(r))
+
+	upstreamPath := "/news/latest?" + params.Encode()
+	// Новый поиск: полнотекстовый /news/filter вместо ILIKE по заголовку
+	if params.Get("s") != "" && flags.Enabled(flagUseNewSearch, r) {
+		upstreamPath = "/news/filter?" + params.Encode()
+	}
+	body, status, stale, err := fetchNewsUpstream(upstreamPath)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить новости")
+		return
+	}
+	if status != http.StatusOK {
+		writeListUpstreamError(w, r, status, body)
 		return
 	}
+	if !stale {
+		shadow.mirror(requestID, upstreamPath, status, body)
+	}
 
-	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
-	params := url.Values{}
-	q := r.URL.Query()
-	if page := q.Get("page"); page != "" {
-		params.Add("page", page)
+	var newsList NewsListResponse
+	if err = json.Unmarshal(body, &newsList); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования новостей")
+		return
 	}
-	if s := q.Get("s"); s != "" {
-		params.Add("s", s)
+	newsList.News = filterNewsByGeo(newsList.News, geo.country(r))
+	newsList.ServedStale = stale
+	for i := range newsList.News {
+		newsList.News[i].Links = newsLinks(newsList.News[i].ID)
 	}
-	params.Add("request_id", requestID)
+	newsList.NextCursor = paging.nextCursor(newsList.Pagination, newsList.NextCursor)
+	linkReq := withListQuery(r, paging)
+	newsList.Links = listLinks(linkReq, newsList.Pagination)
+	if paging.AsOf != nil && newsList.NextCursor != nil {
+		// при листании курсором следующая страница — из того же снимка
+		newsList.Links["next"] = apiURL(r.URL.Path, url.Values{"cursor": {*newsList.NextCursor}})
+	}
+	attachListComments(r, &newsList)
 
-	resp, err := http.Get("http://news-service:8082/news/latest?" + params.Encode())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	setPaginationHeaders(w, linkReq, newsList.Pagination, newsList.Links)
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	json.NewEncoder(w).Encode(newsList)
+}
+
+func filterNewsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	paging, err := resolveListPaging(r, []string{"page", "per_page", "q", "s", "author", "category", "source", "lang", "exclude", "date_from", "date_to", "sort_by", "facets", "facet_interval"})
 	if err != nil {
-		http.Error(w, "Не удалось получить новости", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer resp.Body.Close()
+	params := paging.upstreamParams(requestID)
+	params.Set("country", geo.country(r))
 
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Ошибка сервиса новостей", resp.StatusCode)
+	upstreamPath := "/news/filter?" + params.Encode()
+	body, status, stale, err := fetchNewsUpstream(upstreamPath)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить новости")
+		return
+	}
+	if status != http.StatusOK {
+		writeListUpstreamError(w, r, status, body)
 		return
 	}
+	if !stale {
+		shadow.mirror(requestID, upstreamPath, status, body)
+	}
 
 	var newsList NewsListResponse
-	if err = json.NewDecoder(resp.Body).Decode(&newsList); err != nil {
-		http.Error(w, "Ошибка декодирования новостей", http.StatusInternalServerError)
+	if err = json.Unmarshal(body, &newsList); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования новостей")
 		return
 	}
+	newsList.News = filterNewsByGeo(newsList.News, geo.country(r))
+	newsList.ServedStale = stale
+	for i := range newsList.News {
+		newsList.News[i].Links = newsLinks(newsList.News[i].ID)
+	}
+	newsList.NextCursor = paging.nextCursor(newsList.Pagination, newsList.NextCursor)
+	linkReq := withListQuery(r, paging)
+	newsList.Links = listLinks(linkReq, newsList.Pagination)
+	if paging.AsOf != nil && newsList.NextCursor != nil {
+		// при листании курсором следующая страница — из того же снимка
+		newsList.Links["next"] = apiURL(r.URL.Path, url.Values{"cursor": {*newsList.NextCursor}})
+	}
+	attachListComments(r, &newsList)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	setPaginationHeaders(w, linkReq, newsList.Pagination, newsList.Links)
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
 	json.NewEncoder(w).Encode(newsList)
 }
 
-func filterNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// newsAuthorsHandler проксирует список авторов с количеством новостей
+func newsAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	body, status, stale, err := fetchNewsUpstream("/news/authors?request_id=" + url.QueryEscape(requestID))
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить авторов")
+		return
+	}
+	if status != http.StatusOK {
+		writeProblem(w, r, status, "Ошибка сервиса новостей")
 		return
 	}
 
-	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
-	params := url.Values{}
-	q := r.URL.Query()
-	for _, key := range []string{"page", "q", "s", "date_from", "date_to", "sort_by"} {
-		if v := q.Get(key); v != "" {
-			params.Add(key, v)
-		}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if stale {
+		w.Header().Set("Warning", staleWarning)
 	}
-	params.Add("request_id", requestID)
+	w.Write(body)
+}
 
-	resp, err := http.Get("http://news-service:8082/news/filter?" + params.Encode())
+// newsCategoriesHandler проксирует список рубрик с количеством новостей
+func newsCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	body, status, stale, err := fetchNewsUpstream("/news/categories?request_id=" + url.QueryEscape(requestID))
 	if err != nil {
-		http.Error(w, "Не удалось получить новости", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить рубрики")
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Ошибка сервиса новостей", resp.StatusCode)
+	if status != http.StatusOK {
+		writeProblem(w, r, status, "Ошибка сервиса новостей")
 		return
 	}
 
-	var newsList NewsListResponse
-	if err = json.NewDecoder(resp.Body).Decode(&newsList); err != nil {
-		http.Error(w, "Ошибка декодирования новостей", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	w.Write(body)
+}
+
+// sourcesHandler проксирует список источников новостей
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	body, status, stale, err := fetchNewsUpstream("/sources?request_id=" + url.QueryEscape(requestID))
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить источники")
+		return
+	}
+	if status != http.StatusOK {
+		writeProblem(w, r, status, "Ошибка сервиса новостей")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(newsList)
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	w.Write(body)
 }
 
-func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// topNewsHandler проксирует главные новости: закреплённые редакцией,
+// затем трендовые и последние
+func topNewsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	params := url.Values{"request_id": {requestID}}
+	for _, key := range []string{"limit", "window_hours"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+
+	body, status, stale, err := fetchNewsUpstream("/news/top?" + params.Encode())
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить главные новости")
+		return
+	}
+	if status != http.StatusOK {
+		writeListUpstreamError(w, r, status, body)
 		return
 	}
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/news/")
-	if idStr == "" {
-		http.Error(w, "Требуется ID новости", http.StatusBadRequest)
+	var top TopNewsResponse
+	if err = json.Unmarshal(body, &top); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования новостей")
 		return
 	}
-	newsID, err := strconv.Atoi(idStr)
+	top.News = filterNewsByGeo(top.News, geo.country(r))
+	top.ServedStale = stale
+	for i := range top.News {
+		top.News[i].Links = newsLinks(top.News[i].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	json.NewEncoder(w).Encode(top)
+}
+
+func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
+	newsID, err := strconv.Atoi(pathParam(r, "id"))
 	if err != nil {
-		http.Error(w, "Неверный ID новости", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID новости")
 		return
 	}
 
@@ -421,34 +784,36 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		newsURL := fmt.Sprintf("http://news-service:8082/news/%d?request_id=%s", newsID, requestID)
-		resp, err := http.Get(newsURL)
+		// страна нужна news-service, чтобы не показать в related
+		// публикации, недоступные клиенту
+		params := url.Values{"request_id": {requestID}, "country": {geo.country(r)}}
+		upstreamPath := fmt.Sprintf("/news/%d?%s", newsID, params.Encode())
+		body, status, stale, err := fetchNewsUpstream(upstreamPath)
 		if err != nil {
 			resultChan <- RequestResult{Err: fmt.Errorf("ошибка получения новости: %v", err)}
 			return
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode == http.StatusNotFound {
-			resultChan <- RequestResult{Err: fmt.Errorf("новость не найдена")}
+		if status != http.StatusOK {
+			resultChan <- RequestResult{Err: fmt.Errorf("сервис новостей ответил %d", status), Status: status}
 			return
 		}
-		if resp.StatusCode != http.StatusOK {
-			resultChan <- RequestResult{Err: fmt.Errorf("ошибка сервиса новостей: %d", resp.StatusCode)}
-			return
+		if !stale {
+			shadow.mirror(requestID, upstreamPath, status, body)
 		}
 		var news NewsFullDetailed
-		if err = json.NewDecoder(resp.Body).Decode(&news); err != nil {
-			resultChan <- RequestResult{Err: fmt.Errorf("ошибка декодирования новости: %v", err)}
+		if err = json.Unmarshal(body, &news); err != nil {
+			resultChan <- RequestResult{Err: fmt.Errorf("ошибка декодирования новости: %v", err), Status: status}
 			return
 		}
+		news.ServedStale = stale
 		resultChan <- RequestResult{Data: news}
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		commentsURL := fmt.Sprintf("http://comments-service:8081/comments/%d?request_id=%s", newsID, requestID)
-		resp, err := http.Get(commentsURL)
+		commentsURL := fmt.Sprintf(commentsServiceURL+"/comments/%d?%s", newsID, url.Values{"request_id": {requestID}}.Encode())
+		resp, err := commentsHealth.get(commentsURL)
 		if err != nil {
 			resultChan <- RequestResult{Data: []Comment{}}
 			return
@@ -463,7 +828,10 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 			resultChan <- RequestResult{Data: []Comment{}}
 			return
 		}
-		resultChan <- RequestResult{Data: comments}
+		resultChan <- RequestResult{Data: commentsPage{
+			Comments:     comments,
+			Continuation: resp.Header.Get("X-Continuation-Token"),
+		}}
 	}()
 
 	go func() {
@@ -473,10 +841,17 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	var news NewsFullDetailed
 	var comments []Comment
+	var continuation string
 
 	for result := range resultChan {
 		if result.Err != nil {
-			http.Error(w, result.Err.Error(), http.StatusInternalServerError)
+			// подробности апстрима остаются в логе, клиент видит только итог
+			if result.Status == http.StatusNotFound {
+				writeProblem(w, r, http.StatusNotFound, "Новость не найдена")
+				return
+			}
+			log.Printf("Новость %d, request_id %s: %v", newsID, requestID, result.Err)
+			writeProblem(w, r, http.StatusBadGateway, "Сервис новостей недоступен")
 			return
 		}
 		switch data := result.Data.(type) {
@@ -484,11 +859,27 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 			news = data
 		case []Comment:
 			comments = data
+		case commentsPage:
+			comments, continuation = data.Comments, data.Continuation
 		}
 	}
 
+	if !geoAllowed(news.GeoRestriction, geo.country(r)) {
+		writeProblem(w, r, http.StatusUnavailableForLegalReasons, "Новость недоступна в вашем регионе")
+		return
+	}
+
+	addCommentLinks(comments)
 	news.Comments = comments
+	news.CommentsContinuation = continuation
+	news.Links = newsLinks(news.ID)
+	if continuation != "" {
+		news.Links["comments_next"] = commentsContinuationURL(news.ID, continuation)
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if news.ServedStale {
+		w.Header().Set("Warning", staleWarning)
+	}
 	json.NewEncoder(w).Encode(news)
 }
 
@@ -497,59 +888,79 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 // ─────────────────────────────────────────────────────────────
 
 func getCommentsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	newsIDStr := strings.TrimPrefix(r.URL.Path, "/comments/")
-	if newsIDStr == "" {
-		http.Error(w, "Требуется ID новости", http.StatusBadRequest)
-		return
-	}
-	newsID, err := strconv.Atoi(newsIDStr)
+	newsID, err := strconv.Atoi(pathParam(r, "newsID"))
 	if err != nil {
-		http.Error(w, "Неверный ID новости", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID новости")
 		return
 	}
 
 	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
-	commentsURL := fmt.Sprintf("http://comments-service:8081/comments/%d?request_id=%s", newsID, requestID)
+	params := url.Values{}
+	params.Add("request_id", requestID)
+	if token := r.URL.Query().Get("continuation"); token != "" {
+		params.Add("continuation", token)
+	}
+	if language := r.URL.Query().Get("language"); language != "" {
+		params.Add("language", language)
+	}
+	commentsURL := fmt.Sprintf(commentsServiceURL+"/comments/%d?%s", newsID, params.Encode())
 
-	resp, err := http.Get(commentsURL)
+	resp, err := commentsHealth.get(commentsURL)
 	if err != nil {
-		http.Error(w, "Не удалось получить комментарии", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить комментарии")
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Ошибка сервиса комментариев", resp.StatusCode)
+		detail := "Ошибка сервиса комментариев"
+		if resp.StatusCode == http.StatusBadRequest {
+			if body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10)); len(strings.TrimSpace(string(body))) > 0 {
+				detail = strings.TrimSpace(string(body))
+			}
+		}
+		writeProblem(w, r, resp.StatusCode, detail)
 		return
 	}
 
 	var comments []Comment
 	if err = json.NewDecoder(resp.Body).Decode(&comments); err != nil {
-		http.Error(w, "Ошибка декодирования комментариев", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования комментариев")
 		return
 	}
 
+	addCommentLinks(comments)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if token := resp.Header.Get("X-Continuation-Token"); token != "" {
+		w.Header().Set("X-Continuation-Token", token)
+	}
 	json.NewEncoder(w).Encode(comments)
 }
 
+const censorshipServiceURL = "http://censorship-service:8083"
+
 func addCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if flags.Enabled(flagCommentsReadonly, r) {
+		writeProblem(w, r, http.StatusServiceUnavailable, "Комментирование временно недоступно")
+		return
+	}
+
 	var commentReq CommentRequest
 	if err := json.NewDecoder(r.Body).Decode(&commentReq); err != nil {
-		http.Error(w, "Неверный JSON", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Неверный JSON")
 		return
 	}
 	if commentReq.NewsID <= 0 {
-		http.Error(w, "Требуется ID новости", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Требуется ID новости")
 		return
 	}
 	if strings.TrimSpace(commentReq.Text) == "" {
-		http.Error(w, "Требуется текст комментария", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Требуется текст комментария")
+		return
+	}
+	idempotencyKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(idempotencyKey) > 255 {
+		writeProblem(w, r, http.StatusBadRequest, "Idempotency-Key слишком длинный")
 		return
 	}
 
@@ -557,60 +968,86 @@ func addCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверка цензуры
 	censorBody, _ := json.Marshal(CensorshipRequest{Text: commentReq.Text})
-	censorURL := fmt.Sprintf("http://censorship-service:8083/censor?request_id=%s", requestID)
+	censorURL := censorshipServiceURL + "/censor?" + url.Values{"request_id": {requestID}}.Encode()
 	censorReq, err := http.NewRequest(http.MethodPost, censorURL, bytes.NewReader(censorBody))
 	if err != nil {
-		http.Error(w, "Ошибка создания запроса цензуры", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка создания запроса цензуры")
 		return
 	}
 	censorReq.Header.Set("Content-Type", "application/json")
 
+	// Если цензура недоступна, комментарий сохраняется в статусе pending
+	// и будет перепроверен comments-service позже.
+	commentReq.Status = ""
 	client := &http.Client{}
-	censorResp, err := client.Do(censorReq)
+	censorResp, err := censorshipHealth.do(client, censorReq)
 	if err != nil {
-		http.Error(w, "Сервис цензурирования недоступен", http.StatusInternalServerError)
-		return
-	}
-	defer censorResp.Body.Close()
-
-	if censorResp.StatusCode == http.StatusBadRequest {
-		http.Error(w, "Комментарий содержит недопустимый контент", http.StatusBadRequest)
-		return
-	}
-	if censorResp.StatusCode != http.StatusOK {
-		http.Error(w, "Ошибка сервиса цензурирования", http.StatusInternalServerError)
-		return
+		log.Printf("Сервис цензурирования недоступен, комментарий уйдёт в pending: %v", err)
+		commentReq.Status = "pending"
+	} else {
+		defer censorResp.Body.Close()
+		switch censorResp.StatusCode {
+		case http.StatusOK:
+		case http.StatusBadRequest:
+			writeProblem(w, r, http.StatusBadRequest, "Комментарий содержит недопустимый контент")
+			return
+		case http.StatusAccepted:
+			// внешний API модерации недоступен, fallback-политика требует ручной проверки
+			commentReq.Status = "pending"
+		default:
+			log.Printf("Ошибка сервиса цензурирования (%d), комментарий уйдёт в pending", censorResp.StatusCode)
+			commentReq.Status = "pending"
+		}
 	}
 
 	// Отправка в comments-service
 	commentBody, _ := json.Marshal(commentReq)
-	commentsURL := fmt.Sprintf("http://comments-service:8081/comments?request_id=%s", requestID)
+	commentsURL := commentsServiceURL + "/comments?" + url.Values{"request_id": {requestID}}.Encode()
 	commentHTTPReq, err := http.NewRequest(http.MethodPost, commentsURL, bytes.NewReader(commentBody))
 	if err != nil {
-		http.Error(w, "Ошибка создания запроса комментария", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка создания запроса комментария")
 		return
 	}
 	commentHTTPReq.Header.Set("Content-Type", "application/json")
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	commentHTTPReq.Header.Set("X-User", username)
+	setServiceToken(commentHTTPReq)
+	if idempotencyKey != "" {
+		// Ключ уникален в пределах пользователя
+		commentHTTPReq.Header.Set("Idempotency-Key", username+":"+idempotencyKey)
+	}
 
-	commentResp, err := client.Do(commentHTTPReq)
+	commentResp, err := commentsHealth.do(client, commentHTTPReq)
 	if err != nil {
-		http.Error(w, "Не удалось добавить комментарий", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось добавить комментарий")
 		return
 	}
 	defer commentResp.Body.Close()
 
+	if commentResp.StatusCode == http.StatusUnprocessableEntity {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "Idempotency-Key уже использован для другого комментария")
+		return
+	}
 	if commentResp.StatusCode != http.StatusCreated {
-		http.Error(w, "Ошибка сервиса комментариев", commentResp.StatusCode)
+		writeProblem(w, r, commentResp.StatusCode, "Ошибка сервиса комментариев")
 		return
 	}
 
 	var newComment Comment
 	if err = json.NewDecoder(commentResp.Body).Decode(&newComment); err != nil {
-		http.Error(w, "Ошибка декодирования ответа", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования ответа")
 		return
 	}
 
+	newComment.Links = Links{"news": apiURL(fmt.Sprintf("/news/%d", newComment.NewsID), nil)}
+	status := http.StatusCreated
+	if newComment.Status == "pending" {
+		status = http.StatusAccepted
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusCreated)
+	if replayed := commentResp.Header.Get("Idempotent-Replayed"); replayed != "" {
+		w.Header().Set("Idempotent-Replayed", replayed)
+	}
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(newComment)
 }
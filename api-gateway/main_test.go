@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// roundTripFunc подменяет http.DefaultTransport, через который ходят апстримы
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// request_id клиента не должен добавлять параметры в запрос к news-service:
+// иначе "&country=RU" в нём обошёл бы геоограничение
+func TestNewsDetailRequestIDCannotSetCountry(t *testing.T) {
+	var mu sync.Mutex
+	var newsQueries []url.Values
+	prevTransport := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := "[]"
+		if r.URL.Host == "news-service:8082" {
+			mu.Lock()
+			newsQueries = append(newsQueries, r.URL.Query())
+			mu.Unlock()
+			body = `{"id":1,"title":"Только для RU","geo_restriction":["RU"]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	})
+	prevGeo := geo
+	geo = &geoResolver{}
+	defer func() {
+		http.DefaultTransport = prevTransport
+		geo = prevGeo
+	}()
+
+	rt := newRouter()
+	rt.HandleFunc(http.MethodGet, "/news/{id}", newsDetailHandler)
+	rec := httptest.NewRecorder()
+	requestIDMiddleware(rt).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/news/1?request_id=x%26country%3DRU", nil))
+
+	if rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("статус %d, ожидался 451: %s", rec.Code, rec.Body)
+	}
+	if len(newsQueries) != 1 {
+		t.Fatalf("запросов к news-service: %d", len(newsQueries))
+	}
+	q := newsQueries[0]
+	if got := q["country"]; len(got) != 1 || got[0] != "" {
+		t.Errorf("country в запросе к news-service: %q", got)
+	}
+	if got := q.Get("request_id"); got != "x&country=RU" {
+		t.Errorf("request_id %q", got)
+	}
+}
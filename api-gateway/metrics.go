@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Метрики в формате OpenMetrics с меткой арендатора
+// ─────────────────────────────────────────────────────────────
+
+// Служебные значения метки tenant
+const (
+	tenantNone    = "__none__"    // заголовок не передан
+	tenantInvalid = "__invalid__" // недопустимые символы или длина
+	tenantOther   = "__other__"   // превышен лимит различных арендаторов
+)
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tenantLabeler определяет арендатора запроса. Мультиарендность
+// включается переменной TENANT_HEADER; число различных значений метки
+// ограничено TENANT_METRICS_MAX, остальные попадают в __other__,
+// чтобы произвольные заголовки не раздували число временных рядов.
+type tenantLabeler struct {
+	header string
+	max    int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newTenantLabeler(header string, max int) *tenantLabeler {
+	return &tenantLabeler{header: header, max: max, seen: make(map[string]bool)}
+}
+
+func (t *tenantLabeler) enabled() bool {
+	return t.header != ""
+}
+
+func (t *tenantLabeler) label(r *http.Request) string {
+	value := strings.TrimSpace(r.Header.Get(t.header))
+	if value == "" {
+		return tenantNone
+	}
+	if !tenantPattern.MatchString(value) {
+		return tenantInvalid
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.seen[value] {
+		if len(t.seen) >= t.max {
+			return tenantOther
+		}
+		t.seen[value] = true
+	}
+	return value
+}
+
+// counterVec счётчик с набором меток
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = labelValues
+	}
+	c.values[key]++
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# TYPE %s counter\n# HELP %s %s\n", c.name, c.name, c.help)
+	keys := make([]string, 0, len(c.series))
+	for k := range c.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs := make([]string, len(c.labels))
+		for i, name := range c.labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(c.series[k][i]))
+		}
+		fmt.Fprintf(b, "%s_total{%s} %s\n", c.name, strings.Join(pairs, ","),
+			strconv.FormatFloat(c.values[k], 'f', -1, 64))
+	}
+}
+
+// histogram гистограмма без меток с фиксированными границами корзин
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // по корзинам, не накопительно
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets ...float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# TYPE %s histogram\n# HELP %s %s\n", h.name, h.name, h.help)
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(le, 'f', -1, 64), cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n%s_count %d\n", h.name, strconv.FormatFloat(h.sum, 'f', -1, 64), h.name, h.count)
+}
+
+// gatewayMetrics счётчики запросов, кэша и rate limit. Метка tenant
+// добавляется только при включённой мультиарендности.
+type gatewayMetrics struct {
+	tenants     *tenantLabeler
+	requests    *counterVec
+	cache       *counterVec
+	rateLimited *counterVec
+
+	commentsBatchSize     *histogram
+	commentsBatchRequests *counterVec
+	upstreamAnomalies     *counterVec
+}
+
+var metrics *gatewayMetrics
+
+func newGatewayMetrics(tenants *tenantLabeler) *gatewayMetrics {
+	withTenant := func(labels ...string) []string {
+		if tenants.enabled() {
+			labels = append(labels, "tenant")
+		}
+		return labels
+	}
+	return &gatewayMetrics{
+		tenants: tenants,
+		requests: newCounterVec("gateway_requests", "Запросы к публичному листенеру",
+			withTenant("route", "method", "code")...),
+		cache: newCounterVec("gateway_cache_requests", "Обращения к кэшу ответов",
+			withTenant("result")...),
+		rateLimited: newCounterVec("gateway_rate_limited", "Запросы, отклонённые rate limit",
+			withTenant("route")...),
+		commentsBatchSize: newHistogram("gateway_comments_batch_size",
+			"Число новостей в пакетном запросе комментариев", 1, 2, 5, 10, 20, 50, 100),
+		commentsBatchRequests: newCounterVec("gateway_comments_batch_requests",
+			"Пакетные запросы комментариев по результату", "result"),
+		upstreamAnomalies: newCounterVec("gateway_upstream_anomalies",
+			"Ответы апстримов, отклонившиеся от базовой линии размера или времени", "upstream", "route", "kind"),
+	}
+}
+
+func newGatewayMetricsFromEnv() *gatewayMetrics {
+	max := 50
+	if v, err := strconv.Atoi(os.Getenv("TENANT_METRICS_MAX")); err == nil && v > 0 {
+		max = v
+	}
+	return newGatewayMetrics(newTenantLabeler(strings.TrimSpace(os.Getenv("TENANT_HEADER")), max))
+}
+
+// labels дополняет значения меток арендатором запроса
+func (m *gatewayMetrics) labels(r *http.Request, values ...string) []string {
+	if m.tenants.enabled() {
+		values = append(values, m.tenants.label(r))
+	}
+	return values
+}
+
+// observeRequest учитывает запрос по шаблону совпавшего маршрута, а не
+// по пути: произвольные пути и методы клиентов не превращаются в
+// отдельные ряды
+func (m *gatewayMetrics) observeRequest(r *http.Request, status int) {
+	m.requests.inc(m.labels(r, matchedRoute(r), methodLabel(r.Method), strconv.Itoa(status))...)
+}
+
+// methodLabel оставляет стандартные методы HTTP, остальные — OTHER
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *gatewayMetrics) observeCache(r *http.Request, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.inc(m.labels(r, result)...)
+}
+
+func (m *gatewayMetrics) observeRateLimited(r *http.Request) {
+	m.rateLimited.inc(m.labels(r, matchedRoute(r))...)
+}
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// шаблон маршрута запишет маршрутизатор
+		r, _ = withRequestTag(r)
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		metrics.observeRequest(r, rw.statusCode)
+	})
+}
+
+// metricsHandler отдаёт метрики в формате OpenMetrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, c := range []*counterVec{metrics.requests, metrics.cache, metrics.rateLimited, metrics.commentsBatchRequests,
+		metrics.upstreamAnomalies} {
+		c.write(&b)
+	}
+	metrics.commentsBatchSize.write(&b)
+	b.WriteString("# EOF\n")
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
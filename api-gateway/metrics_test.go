@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenantLabelerBounded(t *testing.T) {
+	l := newTenantLabeler("X-Tenant", 2)
+
+	// порядок важен: первые два арендатора занимают лимит
+	steps := []struct{ value, want string }{
+		{"", tenantNone},
+		{"acme", "acme"},
+		{"globex", "globex"},
+		{" acme ", "acme"},
+		{"initech", tenantOther},
+		{"bad tenant", tenantInvalid},
+		{strings.Repeat("x", 65), tenantInvalid},
+	}
+	for _, s := range steps {
+		r := httptest.NewRequest("GET", "/news", nil)
+		r.Header.Set("X-Tenant", s.value)
+		if got := l.label(r); got != s.want {
+			t.Errorf("%q: метка %q, ожидалась %q", s.value, got, s.want)
+		}
+	}
+}
+
+func TestMetricsTenantLabel(t *testing.T) {
+	m := newGatewayMetrics(newTenantLabeler("X-Tenant", 10))
+	r := httptest.NewRequest("GET", "/news", nil)
+	r.Header.Set("X-Tenant", "acme")
+	m.observeCache(r, true)
+	m.observeCache(r, true)
+	m.observeCache(httptest.NewRequest("GET", "/news", nil), false)
+
+	var b strings.Builder
+	m.cache.write(&b)
+	for _, line := range []string{
+		"# TYPE gateway_cache_requests counter\n",
+		`gateway_cache_requests_total{result="hit",tenant="acme"} 2` + "\n",
+		`gateway_cache_requests_total{result="miss",tenant="__none__"} 1` + "\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("нет строки %q в\n%s", line, b.String())
+		}
+	}
+
+	// без TENANT_HEADER метки tenant нет
+	plain := newGatewayMetrics(newTenantLabeler("", 10))
+	plain.observeCache(r, false)
+	b.Reset()
+	plain.cache.write(&b)
+	if !strings.Contains(b.String(), `gateway_cache_requests_total{result="miss"} 1`) {
+		t.Errorf("без арендаторов:\n%s", b.String())
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if got := escapeLabelValue("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("экранирование %q", got)
+	}
+}
+
+// Метка route берётся из шаблона совпавшего маршрута: случайные пути
+// и методы не создают новых рядов
+func TestMetricsRouteLabelBounded(t *testing.T) {
+	prev := metrics
+	metrics = newGatewayMetrics(newTenantLabeler("", 10))
+	defer func() { metrics = prev }()
+
+	rt := newRouter()
+	rt.HandleFunc(http.MethodGet, "/news/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	rt.HandleFunc(methodAny, "/auth/*", func(w http.ResponseWriter, r *http.Request) {})
+	handler := metricsMiddleware(rt)
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/news/abc"},
+		{"GET", "/news/def"},
+		{"GET", "/random/1"},
+		{"GET", "/random/2"},
+		{"BREW", "/auth/login"},
+		{"DELETE", "/news/1"},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	var b strings.Builder
+	metrics.requests.write(&b)
+	got := strings.Count(b.String(), "gateway_requests_total{")
+	for _, line := range []string{
+		`gateway_requests_total{route="/news/{id}",method="GET",code="400"} 2`,
+		`gateway_requests_total{route="unmatched",method="GET",code="404"} 2`,
+		`gateway_requests_total{route="/auth/*",method="OTHER",code="200"} 1`,
+		`gateway_requests_total{route="unmatched",method="DELETE",code="405"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("нет строки %q", line)
+		}
+	}
+	if got != 4 {
+		t.Errorf("рядов %d, ожидалось 4:\n%s", got, b.String())
+	}
+}
+
+func TestUpstreamRoute(t *testing.T) {
+	for path, want := range map[string]string{
+		"/news/latest":    "/news/latest",
+		"/news/42":        "/news/{id}",
+		"/comments/42":    "/comments/{id}",
+		"/news/42/extra":  unmatchedRoute,
+		"/something/else": unmatchedRoute,
+	} {
+		if got := upstreamRoute(path); got != want {
+			t.Errorf("%s: %q, ожидался %q", path, got, want)
+		}
+	}
+}
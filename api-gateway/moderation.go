@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Модерация комментариев делегированными модераторами
+// ─────────────────────────────────────────────────────────────
+
+// Права модератора (какие новости и категории ему назначены) проверяет
+// comments-service; gateway передаёт имя пользователя из JWT в X-Moderator.
+
+const commentsServiceURL = "http://comments-service:8081"
+
+var moderationClient = &http.Client{Timeout: 10 * time.Second}
+
+// moderationQueueHandler очередь комментариев, назначенных модератору
+func moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	params := url.Values{}
+	for _, key := range []string{"after_id", "language"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+	proxyModeration(w, r, http.MethodGet, "/moderation/queue", params, nil)
+}
+
+// moderateCommentHandler решение модератора по комментарию
+func moderateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID комментария")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Ошибка чтения тела запроса")
+		return
+	}
+	proxyModeration(w, r, http.MethodPost, fmt.Sprintf("/moderation/comments/%d", commentID), url.Values{}, body)
+}
+
+// commentTranslationHandler перевод комментария для модератора
+// (?target=ru); сам перевод выполняет внешний сервис comments-service
+func commentTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID комментария")
+		return
+	}
+	params := url.Values{}
+	if target := r.URL.Query().Get("target"); target != "" {
+		params.Set("target", target)
+	}
+	proxyModeration(w, r, http.MethodGet, fmt.Sprintf("/moderation/comments/%d/translation", commentID), params, nil)
+}
+
+// bulkModerationHandler пакетное действие над комментариями с окном отмены
+func bulkModerationHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Ошибка чтения тела запроса")
+		return
+	}
+	proxyModeration(w, r, http.MethodPost, "/moderation/bulk", url.Values{}, body)
+}
+
+// bulkBatchHandler состояние (GET) или отмена (DELETE) пакетного действия
+func bulkBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID пакета")
+		return
+	}
+	proxyModeration(w, r, r.Method, fmt.Sprintf("/moderation/bulk/%d", batchID), url.Values{}, nil)
+}
+
+func proxyModeration(w http.ResponseWriter, r *http.Request, method, path string, params url.Values, body []byte) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	params.Set("request_id", requestID)
+
+	req, err := http.NewRequest(method, commentsServiceURL+path+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка создания запроса модерации")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Moderator", username)
+	setServiceToken(req)
+
+	resp, err := commentsHealth.do(moderationClient, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Сервис комментариев недоступен")
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+	case http.StatusForbidden:
+		writeProblem(w, r, http.StatusForbidden, "Комментарий не входит в ваши назначения")
+		return
+	case http.StatusNotFound:
+		if strings.HasPrefix(path, "/moderation/bulk/") {
+			writeProblem(w, r, http.StatusNotFound, "Пакет модерации не найден")
+		} else {
+			writeProblem(w, r, http.StatusNotFound, "Комментарий не найден")
+		}
+		return
+	case http.StatusConflict:
+		writeProblem(w, r, http.StatusConflict, "Пакет уже применён или отменён")
+		return
+	case http.StatusBadRequest:
+		writeProblem(w, r, http.StatusBadRequest, "Неверный запрос модерации")
+		return
+	case http.StatusNotImplemented:
+		writeProblem(w, r, http.StatusNotImplemented, "Перевод комментариев не настроен")
+		return
+	default:
+		writeProblem(w, r, resp.StatusCode, "Ошибка сервиса комментариев")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
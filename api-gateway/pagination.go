@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Единая модель пагинации: page/per_page или непрозрачный cursor
+// ─────────────────────────────────────────────────────────────
+
+// Курсор хранит номер страницы, размер страницы, момент снимка и
+// параметры выборки. news-service получает снимок как as_of и не
+// учитывает новости, появившиеся позже, поэтому листание курсором
+// не сдвигается при поступлении новых новостей. Верхнюю границу
+// per_page задаёт news-service (max_per_page в его config.json).
+// Если news-service вернул собственный keyset-курсор (next_cursor),
+// он сохраняется в After и передаётся дальше вместо номера страницы:
+// глубокие страницы выбираются без OFFSET. Курсор подписан HMAC с
+// ключом из JWT_SECRET: параметры выборки и момент снимка в нём нельзя
+// подменить, а курсор одного экземпляра шлюза принимают и остальные.
+
+type listCursor struct {
+	Page    int        `json:"p"`
+	PerPage int        `json:"n,omitempty"`
+	AsOf    time.Time  `json:"t"`
+	Params  url.Values `json:"f,omitempty"`
+	After   string     `json:"k,omitempty"`
+}
+
+// cursorSignatureSize байт HMAC в курсоре
+const cursorSignatureSize = 16
+
+// cursorSignature подпись тела курсора
+func cursorSignature(body string) []byte {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("list-cursor\x00"))
+	mac.Write([]byte(body))
+	return mac.Sum(nil)[:cursorSignatureSize]
+}
+
+func encodeCursor(c listCursor) string {
+	b, _ := json.Marshal(c)
+	body := base64.RawURLEncoding.EncodeToString(b)
+	return body + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(body))
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	body, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return c, fmt.Errorf("курсор без подписи")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cursorSignature(body)) {
+		return c, fmt.Errorf("неверная подпись курсора")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	if c.Page < 1 || c.PerPage < 0 || c.AsOf.IsZero() {
+		return c, fmt.Errorf("некорректный курсор")
+	}
+	return c, nil
+}
+
+// listPaging параметры выборки после разбора page/per_page или cursor
+type listPaging struct {
+	// Query параметры выборки из keys, включая page и per_page
+	Query url.Values
+	// AsOf момент снимка; nil для обычного запроса по номеру страницы
+	AsOf *time.Time
+	// After keyset-курсор news-service для следующей страницы
+	After string
+}
+
+// resolveListPaging разбирает параметры списка. С cursor параметры
+// выборки берутся из курсора, остальные параметры запроса игнорируются.
+func resolveListPaging(r *http.Request, keys []string) (listPaging, error) {
+	q := r.URL.Query()
+	var p listPaging
+	if raw := q.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil {
+			return p, fmt.Errorf("некорректный cursor")
+		}
+		q = c.Params
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("page", strconv.Itoa(c.Page))
+		if c.PerPage > 0 {
+			q.Set("per_page", strconv.Itoa(c.PerPage))
+		}
+		asOf := c.AsOf
+		p.AsOf = &asOf
+		p.After = c.After
+	}
+	if v := q.Get("per_page"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			return p, fmt.Errorf("per_page должен быть положительным числом")
+		}
+	}
+
+	p.Query = url.Values{}
+	for _, key := range keys {
+		if v := q.Get(key); v != "" {
+			p.Query.Set(key, v)
+		}
+	}
+	return p, nil
+}
+
+// upstreamParams параметры для news-service
+func (p listPaging) upstreamParams(requestID string) url.Values {
+	params := url.Values{}
+	for k, v := range p.Query {
+		params[k] = v
+	}
+	if p.AsOf != nil {
+		params.Set("as_of", p.AsOf.UTC().Format(time.RFC3339Nano))
+	}
+	if p.After != "" {
+		params.Set("cursor", p.After)
+	}
+	params.Set("request_id", requestID)
+	return params
+}
+
+// nextCursor курсор следующей страницы или nil на последней. Первый
+// запрос без курсора открывает снимок в момент ответа; upstream —
+// next_cursor из ответа news-service, если он есть.
+func (p listPaging) nextCursor(pg Pagination, upstream *string) *string {
+	if pg.Page >= pg.TotalPages {
+		return nil
+	}
+	asOf := time.Now().UTC()
+	if p.AsOf != nil {
+		asOf = *p.AsOf
+	}
+	params := url.Values{}
+	for k, v := range p.Query {
+		if k != "page" && k != "per_page" {
+			params[k] = v
+		}
+	}
+	if len(params) == 0 {
+		params = nil
+	}
+	c := listCursor{Page: pg.Page + 1, PerPage: pg.PerPage, AsOf: asOf, Params: params}
+	if upstream != nil {
+		c.After = *upstream
+	}
+	cursor := encodeCursor(c)
+	return &cursor
+}
+
+// withListQuery копия запроса с явными параметрами выборки вместо
+// cursor, чтобы ссылки на соседние страницы строились от них
+func withListQuery(r *http.Request, p listPaging) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.RawQuery = p.Query.Encode()
+	r2.URL = &u
+	return r2
+}
+
+// writeListUpstreamError ошибка news-service для списка; текст ошибки
+// валидации (400, например превышение max_per_page) передаётся клиенту
+func writeListUpstreamError(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	detail := "Ошибка сервиса новостей"
+	if status == http.StatusBadRequest {
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			detail = msg
+		}
+	}
+	writeProblem(w, r, status, detail)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Декларативная настройка middleware по группам маршрутов
+// ─────────────────────────────────────────────────────────────
+
+// Имена middleware, допустимые в конфигурации. Порядок в списке группы
+// — порядок выполнения: первый элемент оборачивает все остальные.
+// cache должен идти после auth/require_auth, так как ключ кэша
+// учитывает пользователя.
+const (
+	mwAuth        = "auth"         // необязательный JWT: имя пользователя в контекст
+	mwRequireAuth = "require_auth" // обязательный JWT, иначе 401
+	mwRateLimit   = "rate_limit"   // token bucket на пользователя или IP, иначе 429
+	mwCache       = "cache"        // кэш успешных GET-ответов в памяти
+	mwCompress    = "compress"     // gzip при Accept-Encoding: gzip
+)
+
+// Группы маршрутов публичного листенера
+const (
+	groupNews          = "news"
+	groupCommentsRead  = "comments_read"
+	groupCommentsWrite = "comments_write"
+	groupFlags         = "flags"
+	groupModeration    = "moderation"
+	groupAccount       = "account"
+	groupAuthProxy     = "auth_proxy"
+	groupClientReports = "client_reports"
+)
+
+// PipelineConfig файл ROUTES_CONFIG_PATH. Группы, не указанные в файле,
+// сохраняют значения по умолчанию. Transforms — правила преобразования
+// ответа по шаблону маршрута (например "/news/{id}").
+type PipelineConfig struct {
+	Groups     map[string][]string        `json:"groups"`
+	RateLimit  RateLimitConfig            `json:"rate_limit"`
+	Cache      CacheConfig                `json:"cache"`
+	Transforms map[string][]TransformRule `json:"transforms,omitempty"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+type CacheConfig struct {
+	TTLSec     int `json:"ttl_sec"`
+	MaxEntries int `json:"max_entries"`
+}
+
+func defaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Groups: map[string][]string{
+			groupNews:          {mwCompress, mwAuth, mwCache},
+			groupCommentsRead:  {mwCompress},
+			groupCommentsWrite: {mwRequireAuth, mwRateLimit},
+			groupFlags:         {mwAuth},
+			groupModeration:    {mwRequireAuth},
+			groupAccount:       {mwRequireAuth},
+			groupAuthProxy:     {},
+			groupClientReports: {mwAuth, mwRateLimit},
+		},
+		RateLimit: RateLimitConfig{RequestsPerMinute: 30, Burst: 10},
+		Cache:     CacheConfig{TTLSec: 10, MaxEntries: 1000},
+	}
+}
+
+// pipeline собирает цепочки middleware для групп маршрутов
+type pipeline struct {
+	config  PipelineConfig
+	limiter *rateLimiter
+	cache   *responseCache
+}
+
+func loadPipeline(path string) (*pipeline, error) {
+	cfg := defaultPipelineConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать конфигурацию маршрутов: %v", err)
+		}
+		var fileCfg PipelineConfig
+		if err := json.Unmarshal(data, &fileCfg); err != nil {
+			return nil, fmt.Errorf("неверный формат конфигурации маршрутов: %v", err)
+		}
+		for group, mws := range fileCfg.Groups {
+			cfg.Groups[group] = mws
+		}
+		if fileCfg.RateLimit.RequestsPerMinute > 0 {
+			cfg.RateLimit = fileCfg.RateLimit
+		}
+		if fileCfg.Cache.TTLSec > 0 {
+			cfg.Cache = fileCfg.Cache
+		}
+		cfg.Transforms = fileCfg.Transforms
+	}
+
+	for pattern, rules := range cfg.Transforms {
+		if err := validateTransformRules(pattern, rules); err != nil {
+			return nil, err
+		}
+		log.Printf("Маршрут %s: %d правил преобразования ответа", pattern, len(rules))
+	}
+
+	for group, mws := range cfg.Groups {
+		for _, name := range mws {
+			switch name {
+			case mwAuth, mwRequireAuth, mwRateLimit, mwCache, mwCompress:
+			default:
+				return nil, fmt.Errorf("группа %s: неизвестный middleware %q", group, name)
+			}
+		}
+		log.Printf("Маршруты %s: middleware %v", group, mws)
+	}
+
+	return &pipeline{
+		config:  cfg,
+		limiter: newRateLimiter(cfg.RateLimit),
+		cache:   newResponseCache(cfg.Cache),
+	}, nil
+}
+
+// wrap применяет к обработчику преобразования маршрута pattern
+// (ближе всего к обработчику, чтобы кэш и gzip видели итоговый ответ)
+// и middleware группы
+func (p *pipeline) wrap(group, pattern string, h http.Handler) http.Handler {
+	if rules := p.config.Transforms[pattern]; len(rules) > 0 {
+		h = transformMiddleware(rules, h)
+	}
+	mws := p.config.Groups[group]
+	for i := len(mws) - 1; i >= 0; i-- {
+		switch mws[i] {
+		case mwAuth:
+			h = authMiddleware(h)
+		case mwRequireAuth:
+			h = requireAuthMiddleware(h.ServeHTTP)
+		case mwRateLimit:
+			h = p.limiter.middleware(h)
+		case mwCache:
+			h = p.cache.middleware(h)
+		case mwCompress:
+			h = compressMiddleware(h)
+		}
+	}
+	return h
+}
+
+// ── rate_limit ──────────────────────────────────────────────
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxRateBuckets предел числа ведер. При переполнении сначала удаляются
+// давно заполненные ведра, а если их нет — ведро с самым давним запросом
+const maxRateBuckets = 10000
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // токенов в секунду
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(cfg.RequestsPerMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow списывает токен; при отказе возвращает время до следующего токена
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.evict(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// evict освобождает место под новое ведро; вызывается под mu
+func (l *rateLimiter) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for k, b := range l.buckets {
+		// заполненное ведро неотличимо от нового
+		if now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+			continue
+		}
+		if oldestKey == "" || b.last.Before(oldest) {
+			oldestKey, oldest = k, b.last
+		}
+	}
+	if len(l.buckets) >= maxRateBuckets {
+		delete(l.buckets, oldestKey)
+	}
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _ := r.Context().Value(contextKeyUsername).(string)
+		if key == "" {
+			// X-Forwarded-For учитывается только от доверенных прокси,
+			// иначе подменой заголовка лимит обходится
+			ip, _ := geo.clientIP(r)
+			key = "ip:" + ip.String()
+		}
+		ok, wait := l.allow(key)
+		if !ok {
+			metrics.observeRateLimited(r)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeProblem(w, r, http.StatusTooManyRequests, "Слишком много запросов")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ── cache ───────────────────────────────────────────────────
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache хранит успешные GET-ответы. Ответ зависит от
+// пользователя (фича-флаги) и страны (гео-ограничения), поэтому
+// они входят в ключ; устаревшие ответы (Warning) не кэшируются.
+type responseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cachedResponse
+}
+
+func newResponseCache(cfg CacheConfig) *responseCache {
+	return &responseCache{
+		ttl:        time.Duration(cfg.TTLSec) * time.Second,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[string]cachedResponse),
+	}
+}
+
+func (c *responseCache) key(r *http.Request) string {
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+	return strings.Join([]string{staleCacheKey(r.URL.RequestURI()), username, geo.country(r)}, "|")
+}
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return cachedResponse{}, false
+	}
+	return e, true
+}
+
+func (c *responseCache) set(key string, e cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, old := range c.entries {
+			if now.After(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+	c.entries[key] = e
+}
+
+// captureWriter буферизует ответ, чтобы его можно было сохранить
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (cw *captureWriter) Header() http.Header         { return cw.header }
+func (cw *captureWriter) Write(b []byte) (int, error) { return cw.body.Write(b) }
+func (cw *captureWriter) WriteHeader(code int)        { cw.status = code }
+
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || c.maxEntries <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		e, ok := c.get(key)
+		metrics.observeCache(r, ok)
+		if ok {
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+
+		cw := &captureWriter{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+
+		if cw.status == http.StatusOK && cw.header.Get("Warning") == "" {
+			c.set(key, cachedResponse{
+				status:  cw.status,
+				header:  cw.header.Clone(),
+				body:    cw.body.Bytes(),
+				expires: time.Now().Add(c.ttl),
+			})
+		}
+		for k, v := range cw.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("X-Cache", "MISS")
+		w.WriteHeader(cw.status)
+		w.Write(cw.body.Bytes())
+	})
+}
+
+// ── compress ────────────────────────────────────────────────
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	return g.gz.Write(b)
+}
+
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, gz: gz}, r)
+	})
+}
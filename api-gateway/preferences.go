@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Настройки уведомлений пользователя
+// ─────────────────────────────────────────────────────────────
+
+// notificationPreferencesHandler GET/PUT /me/notifications: настройки
+// хранит comments-service, пользователь берётся из JWT и передаётся в X-User
+func notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	username, _ := r.Context().Value(contextKeyUsername).(string)
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Ошибка чтения тела запроса")
+		return
+	}
+	params := url.Values{"request_id": {requestID}}
+	req, err := http.NewRequest(r.Method, commentsServiceURL+"/preferences/notifications?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка создания запроса настроек")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", username)
+	setServiceToken(req)
+
+	resp, err := commentsHealth.do(moderationClient, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Сервис комментариев недоступен")
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		writeProblem(w, r, http.StatusBadRequest, "reply_delivery должен быть instant, hourly, daily или off")
+		return
+	default:
+		writeProblem(w, r, resp.StatusCode, "Ошибка сервиса комментариев")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	io.Copy(w, resp.Body)
+}
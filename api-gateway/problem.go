@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Ошибки в формате RFC 7807 (application/problem+json)
+// ─────────────────────────────────────────────────────────────
+
+// Problem тело ответа об ошибке
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeProblem отправляет ошибку клиенту. title берётся из статуса,
+// detail — человекочитаемое описание конкретной ситуации.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestID,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
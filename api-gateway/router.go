@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Маршрутизатор с параметрами пути и методами
+// ─────────────────────────────────────────────────────────────
+
+// methodAny маршрут принимает любой метод
+const methodAny = "*"
+
+const contextKeyPathParams contextKey = "path_params"
+
+// route шаблон вида /news/{id}/comments. Последний сегмент "*"
+// совпадает с любым остатком пути хотя бы из одного сегмента (для
+// проксирования): /auth/* не совпадает с /auth.
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+// router сопоставляет запрос с маршрутами по методу и пути.
+// Статические сегменты имеют приоритет над параметрами, поэтому
+// /news/latest и /news/{id} не конфликтуют. Если путь найден, но метод
+// не поддерживается, отвечает 405 с заголовком Allow.
+type router struct {
+	routes []route
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+func (rt *router) Handle(method, pattern string, handler http.Handler) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+func (rt *router) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match проверяет путь и возвращает параметры и «вес» совпадения:
+// чем больше статических сегментов, тем точнее маршрут
+func (r *route) match(segments []string) (map[string]string, int, bool) {
+	params := make(map[string]string)
+	score := 0
+	for i, seg := range r.segments {
+		if seg == "*" {
+			if i >= len(segments) {
+				return nil, 0, false
+			}
+			return params, score, true
+		}
+		if i >= len(segments) {
+			return nil, 0, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			params[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, 0, false
+		}
+		score++
+	}
+	if len(segments) != len(r.segments) {
+		return nil, 0, false
+	}
+	// точное совпадение без wildcard предпочтительнее
+	return params, score + 1, true
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+
+	var best *route
+	var bestParams map[string]string
+	bestScore := -1
+	allowed := make(map[string]bool)
+
+	for i := range rt.routes {
+		rte := &rt.routes[i]
+		params, score, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		if rte.method != methodAny {
+			allowed[rte.method] = true
+		}
+		methodOK := rte.method == methodAny || rte.method == r.Method ||
+			(r.Method == http.MethodHead && rte.method == http.MethodGet)
+		if methodOK && score > bestScore {
+			best, bestParams, bestScore = rte, params, score
+		}
+	}
+
+	if best == nil {
+		if len(allowed) > 0 {
+			methods := make([]string, 0, len(allowed))
+			for m := range allowed {
+				methods = append(methods, m)
+			}
+			sort.Strings(methods)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeProblem(w, r, http.StatusMethodNotAllowed, "Метод не поддерживается")
+			return
+		}
+		writeProblem(w, r, http.StatusNotFound, "Маршрут не найден")
+		return
+	}
+
+	// шаблон, а не путь: аналитика не плодит агрегаты по каждому id
+	if tag := requestTagFrom(r); tag != nil {
+		tag.route = best.pattern
+	}
+	if len(bestParams) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyPathParams, bestParams))
+	}
+	best.handler.ServeHTTP(w, r)
+}
+
+// pathParam возвращает параметр пути, например id для /news/{id}
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(contextKeyPathParams).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterWildcardNeedsSegment(t *testing.T) {
+	rt := newRouter()
+	rt.HandleFunc(methodAny, "/auth/*", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for path, want := range map[string]int{
+		"/auth":           http.StatusNotFound,
+		"/auth/":          http.StatusNotFound,
+		"/auth/login":     http.StatusTeapot,
+		"/auth/oauth/cb":  http.StatusTeapot,
+		"/authentication": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: статус %d, ожидался %d", path, rec.Code, want)
+		}
+	}
+}
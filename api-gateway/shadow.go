@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Зеркалирование трафика (shadow-запросы)
+// ─────────────────────────────────────────────────────────────
+
+const (
+	shadowMaxDiffs     = 200
+	shadowMaxDiffPaths = 50
+)
+
+// ShadowDiff расхождение ответов основного и теневого апстрима
+type ShadowDiff struct {
+	Time          time.Time `json:"time"`
+	RequestID     string    `json:"request_id"`
+	Path          string    `json:"path"`
+	PrimaryStatus int       `json:"primary_status"`
+	ShadowStatus  int       `json:"shadow_status"`
+	Error         string    `json:"error,omitempty"`
+	Differences   []string  `json:"differences,omitempty"`
+}
+
+// ShadowStats счётчики теневых запросов
+type ShadowStats struct {
+	Mirrored int `json:"mirrored"`
+	Matched  int `json:"matched"`
+	Diffed   int `json:"diffed"`
+	Failed   int `json:"failed"`
+}
+
+// shadowMirror асинхронно дублирует часть GET-запросов к news-service
+// на вторичный апстрим и запоминает расхождения. Ответ клиенту
+// от этого никак не зависит.
+type shadowMirror struct {
+	upstream string
+	percent  float64
+	client   *http.Client
+
+	mu    sync.Mutex
+	diffs []ShadowDiff
+	stats ShadowStats
+}
+
+var shadow *shadowMirror
+
+func newShadowMirror(upstream string, percent float64) *shadowMirror {
+	return &shadowMirror{
+		upstream: upstream,
+		percent:  percent,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *shadowMirror) enabled() bool {
+	return s != nil && s.upstream != "" && s.percent > 0
+}
+
+// mirror отправляет тот же запрос (путь с query) на теневой апстрим,
+// если запрос попал в выборку, и сравнивает ответ с основным.
+func (s *shadowMirror) mirror(requestID, pathAndQuery string, primaryStatus int, primaryBody []byte) {
+	if !s.enabled() || rand.Float64()*100 >= s.percent {
+		return
+	}
+	go s.compare(requestID, pathAndQuery, primaryStatus, primaryBody)
+}
+
+func (s *shadowMirror) compare(requestID, pathAndQuery string, primaryStatus int, primaryBody []byte) {
+	diff := ShadowDiff{
+		Time:          time.Now(),
+		RequestID:     requestID,
+		Path:          pathAndQuery,
+		PrimaryStatus: primaryStatus,
+	}
+
+	resp, err := s.client.Get(s.upstream + pathAndQuery)
+	if err != nil {
+		diff.Error = err.Error()
+		s.store(diff, false)
+		return
+	}
+	defer resp.Body.Close()
+	diff.ShadowStatus = resp.StatusCode
+
+	shadowBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		diff.Error = err.Error()
+		s.store(diff, false)
+		return
+	}
+
+	if primaryStatus != resp.StatusCode {
+		diff.Differences = append(diff.Differences, fmt.Sprintf("status: %d != %d", primaryStatus, resp.StatusCode))
+	}
+
+	var primaryJSON, shadowJSON interface{}
+	if err := json.Unmarshal(primaryBody, &primaryJSON); err != nil {
+		diff.Error = "основной ответ не JSON: " + err.Error()
+		s.store(diff, false)
+		return
+	}
+	if err := json.Unmarshal(shadowBody, &shadowJSON); err != nil {
+		diff.Error = "теневой ответ не JSON: " + err.Error()
+		s.store(diff, false)
+		return
+	}
+	diffJSON("$", primaryJSON, shadowJSON, &diff.Differences)
+
+	s.store(diff, true)
+}
+
+func (s *shadowMirror) store(diff ShadowDiff, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Mirrored++
+	switch {
+	case !ok:
+		s.stats.Failed++
+	case len(diff.Differences) == 0:
+		s.stats.Matched++
+		return
+	default:
+		s.stats.Diffed++
+	}
+
+	log.Printf("Shadow: расхождение для %s (%d отличий), request_id: %s", diff.Path, len(diff.Differences), diff.RequestID)
+	s.diffs = append(s.diffs, diff)
+	if len(s.diffs) > shadowMaxDiffs {
+		s.diffs = s.diffs[len(s.diffs)-shadowMaxDiffs:]
+	}
+}
+
+// diffJSON рекурсивно сравнивает два JSON-значения и дописывает
+// пути отличающихся узлов в out (не более shadowMaxDiffPaths).
+func diffJSON(path string, a, b interface{}, out *[]string) {
+	if len(*out) >= shadowMaxDiffPaths {
+		return
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			*out = append(*out, path+": тип отличается")
+			return
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, exists := av[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffJSON(path+"."+k, av[k], bv[k], out)
+		}
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			*out = append(*out, path+": тип отличается")
+			return
+		}
+		if len(av) != len(bv) {
+			*out = append(*out, fmt.Sprintf("%s: длина %d != %d", path, len(av), len(bv)))
+		}
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), av[i], bv[i], out)
+		}
+	default:
+		if a != b {
+			*out = append(*out, fmt.Sprintf("%s: %v != %v", path, a, b))
+		}
+	}
+}
+
+// shadowDiffsHandler отдаёт статистику и последние расхождения
+func shadowDiffsHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"enabled": shadow.enabled(),
+	}
+	if shadow != nil {
+		shadow.mu.Lock()
+		response["upstream"] = shadow.upstream
+		response["percent"] = shadow.percent
+		response["stats"] = shadow.stats
+		response["diffs"] = append([]ShadowDiff{}, shadow.diffs...)
+		shadow.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	a := map[string]interface{}{
+		"id":    1.0,
+		"title": "старый",
+		"tags":  []interface{}{"a", "b"},
+	}
+	b := map[string]interface{}{
+		"id":     1.0,
+		"title":  "новый",
+		"tags":   []interface{}{"a"},
+		"author": "x",
+	}
+	var got []string
+	diffJSON("$", a, b, &got)
+	want := []string{
+		"$.author: <nil> != x",
+		"$.tags: длина 2 != 1",
+		"$.title: старый != новый",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("отличия %q, ожидались %q", got, want)
+	}
+
+	got = nil
+	diffJSON("$", a, []interface{}{}, &got)
+	if len(got) != 1 || got[0] != "$: тип отличается" {
+		t.Fatalf("разные типы: %q", got)
+	}
+}
+
+// Совпавшие ответы только считаются, расхождения и ошибки сохраняются
+func TestShadowMirrorCompare(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/news/1":
+			w.Write([]byte(`{"id":1,"title":"Заголовок"}`))
+		case "/news/2":
+			w.Write([]byte(`{"id":2,"title":"Другой"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("not json"))
+		}
+	}))
+	defer upstream.Close()
+
+	s := newShadowMirror(upstream.URL, 100)
+	s.compare("r1", "/news/1", http.StatusOK, []byte(`{"title":"Заголовок","id":1}`))
+	s.compare("r2", "/news/2", http.StatusOK, []byte(`{"id":2,"title":"Заголовок"}`))
+	s.compare("r3", "/news/3", http.StatusOK, []byte(`{"id":3}`))
+
+	if want := (ShadowStats{Mirrored: 3, Matched: 1, Diffed: 1, Failed: 1}); s.stats != want {
+		t.Fatalf("статистика %+v, ожидалась %+v", s.stats, want)
+	}
+	if len(s.diffs) != 2 {
+		t.Fatalf("сохранено %d расхождений", len(s.diffs))
+	}
+	if d := s.diffs[0]; d.RequestID != "r2" || len(d.Differences) != 1 || d.Differences[0] != "$.title: Заголовок != Другой" {
+		t.Errorf("расхождение %+v", d)
+	}
+	if d := s.diffs[1]; d.ShadowStatus != http.StatusBadGateway || d.Error == "" || d.Differences[0] != "status: 200 != 502" {
+		t.Errorf("ошибка %+v", d)
+	}
+}
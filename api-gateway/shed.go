@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Сброс нагрузки: адаптивный лимит одновременных запросов
+// ─────────────────────────────────────────────────────────────
+
+// Запросы сверх лимита ждут в очереди не дольше queueTimeout, затем
+// получают 503 с Retry-After. Лимит подстраивается по принципу AIMD:
+// растёт на единицу за каждые limit быстрых ответов и уменьшается
+// на 10%, когда латентность превышает целевую.
+const (
+	shedRetryAfter       = "1"
+	shedDecreaseFactor   = 0.9
+	shedDecreaseCooldown = time.Second
+)
+
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	minLimit int
+	maxLimit int
+	inFlight int
+	waiters  []chan struct{}
+
+	maxQueue      int
+	queueTimeout  time.Duration
+	targetLatency time.Duration
+
+	successes    int
+	lastDecrease time.Time
+	shed         int64
+}
+
+var limiter *concurrencyLimiter
+
+// LoadSnapshot текущее состояние лимитера
+type LoadSnapshot struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"in_flight"`
+	Queued   int   `json:"queued"`
+	Shed     int64 `json:"shed_total"`
+}
+
+// newConcurrencyLimiterFromEnv читает LOAD_SHED_MAX_INFLIGHT,
+// LOAD_SHED_MIN_INFLIGHT, LOAD_SHED_QUEUE, LOAD_SHED_QUEUE_TIMEOUT_MS
+// и LOAD_SHED_TARGET_LATENCY_MS
+func newConcurrencyLimiterFromEnv() *concurrencyLimiter {
+	envInt := func(name string, def int) int {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			return v
+		}
+		return def
+	}
+	l := &concurrencyLimiter{
+		maxLimit:      envInt("LOAD_SHED_MAX_INFLIGHT", 200),
+		minLimit:      envInt("LOAD_SHED_MIN_INFLIGHT", 10),
+		maxQueue:      envInt("LOAD_SHED_QUEUE", 100),
+		queueTimeout:  time.Duration(envInt("LOAD_SHED_QUEUE_TIMEOUT_MS", 200)) * time.Millisecond,
+		targetLatency: time.Duration(envInt("LOAD_SHED_TARGET_LATENCY_MS", 1000)) * time.Millisecond,
+	}
+	if l.minLimit > l.maxLimit {
+		l.minLimit = l.maxLimit
+	}
+	l.limit = l.maxLimit
+	return l
+}
+
+// acquire занимает слот, при необходимости дожидаясь его в очереди.
+// false означает, что запрос нужно сбросить.
+func (l *concurrencyLimiter) acquire() bool {
+	l.mu.Lock()
+	if l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if len(l.waiters) >= l.maxQueue {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+	ch := make(chan struct{}, 1)
+	l.waiters = append(l.waiters, ch)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ch {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.shed++
+			return false
+		}
+	}
+	// слот передали одновременно с истечением ожидания
+	<-ch
+	return true
+}
+
+// release освобождает слот (или передаёт его первому в очереди)
+// и корректирует лимит по латентности завершённого запроса
+func (l *concurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if latency > l.targetLatency {
+		l.successes = 0
+		if time.Since(l.lastDecrease) >= shedDecreaseCooldown && l.limit > l.minLimit {
+			l.limit = int(float64(l.limit) * shedDecreaseFactor)
+			if l.limit < l.minLimit {
+				l.limit = l.minLimit
+			}
+			l.lastDecrease = time.Now()
+			log.Printf("Латентность %v выше целевой, лимит одновременных запросов снижен до %d", latency, l.limit)
+		}
+	} else if l.limit < l.maxLimit {
+		l.successes++
+		if l.successes >= l.limit {
+			l.successes = 0
+			l.limit++
+		}
+	}
+
+	if len(l.waiters) > 0 && l.inFlight <= l.limit {
+		ch := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		ch <- struct{}{}
+		return
+	}
+	l.inFlight--
+}
+
+func (l *concurrencyLimiter) snapshot() LoadSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LoadSnapshot{
+		Limit:    l.limit,
+		InFlight: l.inFlight,
+		Queued:   len(l.waiters),
+		Shed:     l.shed,
+	}
+}
+
+// isPriorityRequest запросы, которые не ограничиваются лимитером
+func isPriorityRequest(r *http.Request) bool {
+	return r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/admin/")
+}
+
+// loadSheddingMiddleware отвечает 503, когда gateway перегружен,
+// вместо того чтобы копить запросы и наращивать латентность
+func loadSheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPriorityRequest(r) || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !limiter.acquire() {
+			w.Header().Set("Retry-After", shedRetryAfter)
+			writeProblem(w, r, http.StatusServiceUnavailable, "Gateway перегружен, повторите запрос позже")
+			return
+		}
+		start := time.Now()
+		defer func() { limiter.release(time.Since(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadHandler состояние лимитера для внутреннего листенера
+func loadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(limiter.snapshot())
+}
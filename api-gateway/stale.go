@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Stale-while-revalidate для news-service
+// ─────────────────────────────────────────────────────────────
+
+const (
+	newsServiceURL    = "http://news-service:8082"
+	staleCacheEntries = 1000
+	staleWarning      = `110 - "Response is Stale"`
+)
+
+type staleEntry struct {
+	body     []byte
+	storedAt time.Time
+}
+
+// staleCache хранит последние успешные ответы news-service, чтобы
+// отдавать их клиентам, пока сервис недоступен.
+type staleCache struct {
+	mu         sync.Mutex
+	entries    map[string]staleEntry
+	refreshing map[string]bool
+	maxEntries int
+}
+
+var newsStaleCache = &staleCache{
+	entries:    make(map[string]staleEntry),
+	refreshing: make(map[string]bool),
+	maxEntries: staleCacheEntries,
+}
+
+func (c *staleCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.body, ok
+}
+
+func (c *staleCache) set(key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = staleEntry{body: body, storedAt: time.Now()}
+}
+
+func (c *staleCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range c.entries {
+		if oldestKey == "" || e.storedAt.Before(oldest) {
+			oldestKey, oldest = k, e.storedAt
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+// staleCacheKey убирает из пути request_id, чтобы разные запросы
+// к одному ресурсу попадали в одну запись кэша
+func staleCacheKey(pathAndQuery string) string {
+	u, err := url.Parse(pathAndQuery)
+	if err != nil {
+		return pathAndQuery
+	}
+	q := u.Query()
+	q.Del("request_id")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// fetchNewsUpstream выполняет GET к news-service. При сетевой ошибке
+// или 5xx возвращает последний сохранённый ответ (stale == true) и
+// обновляет запись в фоне. Статусы 4xx отдаются как есть.
+func fetchNewsUpstream(pathAndQuery string) (body []byte, status int, stale bool, err error) {
+	key := staleCacheKey(pathAndQuery)
+
+	body, status, err = getNewsService(pathAndQuery)
+	if err == nil && status == http.StatusOK {
+		newsStaleCache.set(key, body)
+		return body, status, false, nil
+	}
+	if err == nil && status < http.StatusInternalServerError {
+		return body, status, false, nil
+	}
+
+	if cached, ok := newsStaleCache.get(key); ok {
+		log.Printf("news-service недоступен (%v, статус %d), отдаём устаревший ответ для %s", err, status, key)
+		go newsStaleCache.revalidate(key, pathAndQuery)
+		return cached, http.StatusOK, true, nil
+	}
+	return nil, status, false, err
+}
+
+// revalidate повторно запрашивает ресурс в фоне; одновременно
+// выполняется не более одного обновления на ключ
+func (c *staleCache) revalidate(key, pathAndQuery string) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}()
+
+	body, status, err := getNewsService(pathAndQuery)
+	if err == nil && status == http.StatusOK {
+		c.set(key, body)
+	}
+}
+
+// getNewsService выполняет GET к news-service. Одновременные запросы
+// к одному ресурсу объединяются в один вызов апстрима; request_id
+// в апстрим уходит от первого из них.
+func getNewsService(pathAndQuery string) ([]byte, int, error) {
+	body, status, _, err := newsFlights.do(staleCacheKey(pathAndQuery), func() ([]byte, int, error) {
+		return doGetNewsService(pathAndQuery)
+	})
+	return body, status, err
+}
+
+func doGetNewsService(pathAndQuery string) ([]byte, int, error) {
+	resp, err := newsHealth.get(newsServiceURL + pathAndQuery)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("ошибка чтения ответа: %v", err)
+	}
+	return body, resp.StatusCode, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Фоновые проверки /health апстримов и данные для статус-страницы
+// ─────────────────────────────────────────────────────────────
+
+// Окна, за которые считается доступность
+var uptimeWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+const maxIncidents = 50
+
+// probeResult результат одной проверки
+type probeResult struct {
+	at      time.Time
+	ok      bool
+	latency time.Duration
+	err     string
+}
+
+// Incident непрерывный период неудачных проверок апстрима
+type Incident struct {
+	Upstream  string     `json:"upstream"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Duration  int64      `json:"duration_sec"`
+	Failures  int        `json:"failed_probes"`
+	LastError string     `json:"last_error"`
+}
+
+// probeTarget апстрим и его кольцевой буфер результатов
+type probeTarget struct {
+	name string
+	url  string
+
+	history []probeResult // кольцевой буфер
+	next    int
+	full    bool
+	open    *Incident // текущий инцидент, если апстрим недоступен
+}
+
+func (t *probeTarget) add(res probeResult) {
+	t.history[t.next] = res
+	t.next = (t.next + 1) % len(t.history)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// each обходит результаты от новых к старым
+func (t *probeTarget) each(fn func(probeResult) bool) {
+	n := t.next
+	if t.full {
+		n = len(t.history)
+	}
+	for i := 1; i <= n; i++ {
+		if !fn(t.history[(t.next-i+len(t.history))%len(t.history)]) {
+			return
+		}
+	}
+}
+
+// statusProber периодически опрашивает /health апстримов. Размер
+// истории (STATUS_HISTORY_SIZE) ограничивает окно, за которое можно
+// посчитать доступность: по умолчанию 2880 проверок раз в 30 секунд
+// покрывают сутки, для окна 7d нужен больший размер или интервал.
+type statusProber struct {
+	interval time.Duration
+	client   *http.Client
+
+	mu        sync.Mutex
+	targets   []*probeTarget
+	incidents []Incident // завершённые, от старых к новым
+}
+
+var prober *statusProber
+
+func newStatusProberFromEnv() *statusProber {
+	interval := 30 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("STATUS_PROBE_INTERVAL_SEC")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+	size := 2880
+	if v, err := strconv.Atoi(os.Getenv("STATUS_HISTORY_SIZE")); err == nil && v > 0 {
+		size = v
+	}
+	p := &statusProber{
+		interval: interval,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, u := range []struct{ name, url string }{
+		{"news-service", newsServiceURL + "/health"},
+		{"comments-service", commentsServiceURL + "/health"},
+		{"censorship-service", censorshipServiceURL + "/health"},
+	} {
+		p.targets = append(p.targets, &probeTarget{name: u.name, url: u.url, history: make([]probeResult, size)})
+	}
+	return p
+}
+
+func (p *statusProber) run() {
+	p.probeAll()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.probeAll()
+	}
+}
+
+func (p *statusProber) probeAll() {
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func(t *probeTarget) {
+			defer wg.Done()
+			p.record(t, p.probe(t.url))
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (p *statusProber) probe(url string) probeResult {
+	start := time.Now()
+	res := probeResult{at: start}
+	resp, err := p.client.Get(url)
+	res.latency = time.Since(start)
+	if err != nil {
+		res.err = err.Error()
+		return res
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		res.err = fmt.Sprintf("HTTP %d", resp.StatusCode)
+		return res
+	}
+	res.ok = true
+	return res
+}
+
+func (p *statusProber) record(t *probeTarget, res probeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.add(res)
+	switch {
+	case !res.ok && t.open == nil:
+		t.open = &Incident{Upstream: t.name, StartedAt: res.at, Failures: 1, LastError: res.err}
+		log.Printf("Status: %s недоступен: %s", t.name, res.err)
+	case !res.ok:
+		t.open.Failures++
+		t.open.LastError = res.err
+	case t.open != nil:
+		ended := res.at
+		t.open.EndedAt = &ended
+		t.open.Duration = int64(ended.Sub(t.open.StartedAt).Seconds())
+		log.Printf("Status: %s снова доступен после %d с", t.name, t.open.Duration)
+		p.incidents = append(p.incidents, *t.open)
+		if len(p.incidents) > maxIncidents {
+			p.incidents = p.incidents[len(p.incidents)-maxIncidents:]
+		}
+		t.open = nil
+	}
+}
+
+// UpstreamStatus состояние апстрима для статус-страницы
+type UpstreamStatus struct {
+	Name          string             `json:"name"`
+	Status        string             `json:"status"` // up, down, unknown
+	LatencyMs     float64            `json:"latency_ms"`
+	AvgLatencyMs  float64            `json:"avg_latency_ms_1h"`
+	Uptime        map[string]float64 `json:"uptime_percent"`
+	LastCheckedAt *time.Time         `json:"last_checked_at,omitempty"`
+	LastError     string             `json:"last_error,omitempty"`
+}
+
+// StatusPage ответ GET /admin/status
+type StatusPage struct {
+	Status      string           `json:"status"` // operational, degraded, major_outage
+	GeneratedAt time.Time        `json:"generated_at"`
+	IntervalSec int              `json:"probe_interval_sec"`
+	Upstreams   []UpstreamStatus `json:"upstreams"`
+	Incidents   []Incident       `json:"incidents"`
+}
+
+func (p *statusProber) page(now time.Time) StatusPage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	page := StatusPage{
+		GeneratedAt: now,
+		IntervalSec: int(p.interval.Seconds()),
+		Upstreams:   make([]UpstreamStatus, 0, len(p.targets)),
+		Incidents:   []Incident{},
+	}
+	down := 0
+	for _, t := range p.targets {
+		st := UpstreamStatus{Name: t.name, Status: "unknown", Uptime: make(map[string]float64)}
+		first := true
+		var latencySum time.Duration
+		var latencyCount int
+		total := make([]int, len(uptimeWindows))
+		okCount := make([]int, len(uptimeWindows))
+		t.each(func(res probeResult) bool {
+			if first {
+				first = false
+				at := res.at
+				st.LastCheckedAt = &at
+				st.LatencyMs = float64(res.latency.Microseconds()) / 1000
+				st.LastError = res.err
+				st.Status = "down"
+				if res.ok {
+					st.Status = "up"
+				}
+			}
+			age := now.Sub(res.at)
+			if age <= time.Hour && res.ok {
+				latencySum += res.latency
+				latencyCount++
+			}
+			for i, w := range uptimeWindows {
+				if age <= w.d {
+					total[i]++
+					if res.ok {
+						okCount[i]++
+					}
+				}
+			}
+			return true
+		})
+		if latencyCount > 0 {
+			st.AvgLatencyMs = float64((latencySum / time.Duration(latencyCount)).Microseconds()) / 1000
+		}
+		for i, w := range uptimeWindows {
+			if total[i] > 0 {
+				st.Uptime[w.name] = float64(okCount[i]*10000/total[i]) / 100
+			}
+		}
+		if st.Status == "down" {
+			down++
+		}
+		if t.open != nil {
+			inc := *t.open
+			inc.Duration = int64(now.Sub(inc.StartedAt).Seconds())
+			page.Incidents = append(page.Incidents, inc)
+		}
+		page.Upstreams = append(page.Upstreams, st)
+	}
+	// завершённые инциденты от новых к старым после текущих
+	for i := len(p.incidents) - 1; i >= 0; i-- {
+		page.Incidents = append(page.Incidents, p.incidents[i])
+	}
+
+	switch {
+	case down == 0:
+		page.Status = "operational"
+	case down == len(p.targets):
+		page.Status = "major_outage"
+	default:
+		page.Status = "degraded"
+	}
+	return page
+}
+
+// statusHandler GET /admin/status
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(prober.page(time.Now()))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Преобразование JSON-ответов по правилам из конфигурации
+// ─────────────────────────────────────────────────────────────
+
+// Операции правил
+const (
+	transformRename     = "rename"      // переименовать поле path в to
+	transformDrop       = "drop"        // удалить поле path
+	transformSet        = "set"         // записать в path константу value
+	transformDateFormat = "date_format" // переформатировать дату RFC 3339 в layout
+)
+
+// TransformRule одно правило. Путь — ключи через точку; суффикс []
+// означает «для каждого элемента массива», например news[].pub_date;
+// путь вида [].text обходит массив верхнего уровня.
+type TransformRule struct {
+	Op     string      `json:"op"`
+	Path   string      `json:"path"`
+	To     string      `json:"to,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Layout string      `json:"layout,omitempty"`
+}
+
+// Псевдонимы форматов даты для date_format
+var dateLayoutAliases = map[string]string{
+	"date":    "2006-01-02",
+	"rfc1123": time.RFC1123,
+	"rfc3339": time.RFC3339,
+}
+
+func validateTransformRules(pattern string, rules []TransformRule) error {
+	for i, rule := range rules {
+		if rule.Path == "" {
+			return fmt.Errorf("маршрут %s, правило %d: не задан path", pattern, i)
+		}
+		switch rule.Op {
+		case transformDrop, transformSet:
+		case transformRename:
+			if rule.To == "" || strings.ContainsAny(rule.To, ".[]") {
+				return fmt.Errorf("маршрут %s, правило %d: to должно быть именем поля", pattern, i)
+			}
+		case transformDateFormat:
+			if rule.Layout == "" {
+				return fmt.Errorf("маршрут %s, правило %d: не задан layout", pattern, i)
+			}
+		default:
+			return fmt.Errorf("маршрут %s, правило %d: неизвестная операция %q", pattern, i, rule.Op)
+		}
+	}
+	return nil
+}
+
+// applyTransform применяет правило к разобранному JSON
+func applyTransform(doc interface{}, rule TransformRule) {
+	segments := strings.Split(rule.Path, ".")
+	forEachParent(doc, segments, func(obj map[string]interface{}, key string) {
+		switch rule.Op {
+		case transformRename:
+			if v, ok := obj[key]; ok {
+				delete(obj, key)
+				obj[rule.To] = v
+			}
+		case transformDrop:
+			delete(obj, key)
+		case transformSet:
+			obj[key] = rule.Value
+		case transformDateFormat:
+			s, ok := obj[key].(string)
+			if !ok {
+				return
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return
+			}
+			if rule.Layout == "unix" {
+				obj[key] = t.Unix()
+				return
+			}
+			layout := rule.Layout
+			if alias, ok := dateLayoutAliases[layout]; ok {
+				layout = alias
+			}
+			obj[key] = t.Format(layout)
+		}
+	})
+}
+
+// forEachParent находит все объекты, содержащие последний сегмент пути
+func forEachParent(node interface{}, segments []string, fn func(obj map[string]interface{}, key string)) {
+	if segments[0] == "[]" && len(segments) > 1 {
+		items, _ := node.([]interface{})
+		for _, item := range items {
+			forEachParent(item, segments[1:], fn)
+		}
+		return
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	seg := segments[0]
+	if len(segments) == 1 {
+		fn(obj, seg)
+		return
+	}
+	if name, isArray := strings.CutSuffix(seg, "[]"); isArray {
+		items, _ := obj[name].([]interface{})
+		for _, item := range items {
+			forEachParent(item, segments[1:], fn)
+		}
+		return
+	}
+	forEachParent(obj[seg], segments[1:], fn)
+}
+
+// transformMiddleware преобразует успешные JSON-ответы маршрута.
+// Ответы об ошибках (problem+json) не изменяются.
+func transformMiddleware(rules []TransformRule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &captureWriter{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+
+		body := cw.body.Bytes()
+		if cw.status < 300 && strings.HasPrefix(cw.header.Get("Content-Type"), "application/json") {
+			var doc interface{}
+			if err := json.Unmarshal(body, &doc); err == nil {
+				for _, rule := range rules {
+					applyTransform(doc, rule)
+				}
+				if out, err := json.Marshal(doc); err == nil {
+					body = append(out, '\n')
+					cw.header.Del("Content-Length")
+				}
+			}
+		}
+
+		for k, v := range cw.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(cw.status)
+		w.Write(body)
+	})
+}
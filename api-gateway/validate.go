@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// Самопроверка конфигурации: api-gateway -validate
+// ─────────────────────────────────────────────────────────────
+
+// Режим -validate проверяет окружение так же, как его прочитает
+// запуск, но ничего не слушает и не пишет: синтаксис файлов
+// конфигурации, числовые переменные, TLS-сертификаты, DNS-имена
+// апстримов и их /health. Отчёт печатается в stdout в JSON, код
+// выхода 1, если хотя бы одна проверка провалена, — так ошибку
+// конфигурации ловит пайплайн деплоя, а не ночной алерт.
+
+// Результаты проверок
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// Сертификат, истекающий раньше этого срока, даёт предупреждение
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// ValidationCheck результат одной проверки
+type ValidationCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ValidationReport отчёт -validate
+type ValidationReport struct {
+	OK     bool              `json:"ok"`
+	Checks []ValidationCheck `json:"checks"`
+}
+
+func (rep *ValidationReport) add(name, status, detail string) {
+	rep.Checks = append(rep.Checks, ValidationCheck{Name: name, Status: status, Detail: detail})
+	if status == checkFail {
+		rep.OK = false
+	}
+}
+
+// addErr добавляет ok или fail в зависимости от err
+func (rep *ValidationReport) addErr(name string, err error) {
+	if err != nil {
+		rep.add(name, checkFail, err.Error())
+		return
+	}
+	rep.add(name, checkOK, "")
+}
+
+// validateUpstream апстрим для проверки DNS и здоровья; health пустой,
+// если у сервиса нет подходящего эндпоинта
+type validateUpstream struct {
+	name   string
+	base   string
+	health string
+}
+
+var validateUpstreams = []validateUpstream{
+	{"news-service", newsServiceURL, "/health"},
+	{"comments-service", commentsServiceURL, "/health"},
+	{"censorship-service", censorshipServiceURL, "/health"},
+	{"system-aaa", authServiceURL, ""},
+}
+
+// runValidation выполняет все проверки
+func runValidation() ValidationReport {
+	rep := ValidationReport{OK: true, Checks: []ValidationCheck{}}
+
+	// ── Обязательные и числовые переменные ──────────────────────────────────
+	if os.Getenv("JWT_SECRET") == "" {
+		rep.add("env.JWT_SECRET", checkFail, "не задан — запуск невозможен")
+	} else {
+		rep.add("env.JWT_SECRET", checkOK, "")
+	}
+	if os.Getenv("ADMIN_TOKEN") == "" {
+		rep.add("env.ADMIN_TOKEN", checkWarn, "не задан — /admin/* на внутреннем листенере недоступны")
+	} else {
+		rep.add("env.ADMIN_TOKEN", checkOK, "")
+	}
+	if os.Getenv("SERVICE_TOKEN") == "" {
+		rep.add("env.SERVICE_TOKEN", checkWarn, "не задан — comments-service не примет комментарии с автором, модерацию и настройки, а news-service скроет новости с геоограничениями")
+	} else {
+		rep.add("env.SERVICE_TOKEN", checkOK, "")
+	}
+	// При некорректном значении запуск молча берёт значение по умолчанию
+	for _, name := range []string{
+		"ANALYTICS_EXPORT_INTERVAL_SEC", "ANALYTICS_EXPORT_MAX_SIZE_MB", "BACKPRESSURE_WINDOW_SEC", "BACKPRESSURE_MIN_REQUESTS",
+		"RETRY_MAX_ATTEMPTS", "TENANT_METRICS_MAX", "STATUS_PROBE_INTERVAL_SEC", "STATUS_HISTORY_SIZE",
+		"ANOMALY_MIN_SAMPLES", "ANOMALY_MIN_SIZE_DELTA_BYTES", "ANOMALY_MIN_LATENCY_MS",
+	} {
+		if v := os.Getenv(name); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				rep.add("env."+name, checkWarn, fmt.Sprintf("%q не является неотрицательным целым, будет использовано значение по умолчанию", v))
+			}
+		}
+	}
+	for _, name := range []string{
+		"SHADOW_PERCENT", "BACKPRESSURE_ERROR_THRESHOLD", "RETRY_BUDGET_RATIO",
+		"ANOMALY_SIZE_RATIO", "ANOMALY_LATENCY_RATIO",
+	} {
+		if v := os.Getenv(name); v != "" {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				rep.add("env."+name, checkWarn, fmt.Sprintf("%q не является числом, будет использовано значение по умолчанию", v))
+			}
+		}
+	}
+	for _, name := range []string{"PUBLIC_BASE_URL", "SHADOW_UPSTREAM_URL", "ANALYTICS_URL"} {
+		if v := os.Getenv(name); v != "" {
+			if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+				rep.add("env."+name, checkFail, fmt.Sprintf("%q не является абсолютным URL", v))
+			} else {
+				rep.add("env."+name, checkOK, "")
+			}
+		}
+	}
+	for _, name := range []string{"PUBLIC_LISTEN_ADDR", "INTERNAL_LISTEN_ADDR"} {
+		if v := os.Getenv(name); v != "" {
+			if _, _, err := net.SplitHostPort(v); err != nil {
+				rep.add("env."+name, checkFail, err.Error())
+			}
+		}
+	}
+
+	// ── Файлы конфигурации ──────────────────────────────────────────────────
+	_, err := loadPipeline(os.Getenv("ROUTES_CONFIG_PATH"))
+	rep.addErr("config.routes", err)
+	if os.Getenv("GEO_COUNTRY_HEADER") != "" && os.Getenv("GEO_TRUSTED_PROXIES") == "" {
+		rep.add("env.GEO_TRUSTED_PROXIES", checkWarn, "не задан — GEO_COUNTRY_HEADER не используется, страна определяется по адресу соединения")
+	}
+	_, err = newFlagStore(os.Getenv("FEATURE_FLAGS_PATH"))
+	rep.addErr("config.feature_flags", err)
+	_, err = newGeoResolver(os.Getenv("GEO_COUNTRY_HEADER"), os.Getenv("GEOIP_CSV_PATH"), os.Getenv("GEO_TRUSTED_PROXIES"))
+	rep.addErr("config.geoip", err)
+	if path := os.Getenv("ACCESS_LOG_PATH"); path != "" {
+		dir := path[:strings.LastIndex(path, "/")+1]
+		if dir == "" {
+			dir = "."
+		}
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			rep.add("config.access_log", checkFail, fmt.Sprintf("каталог %s недоступен", dir))
+		} else {
+			rep.add("config.access_log", checkOK, "")
+		}
+	}
+
+	// ── TLS ─────────────────────────────────────────────────────────────────
+	validateListenerTLS(&rep)
+	for _, name := range []string{"SHADOW_UPSTREAM_URL", "ANALYTICS_URL"} {
+		if u, err := url.Parse(os.Getenv(name)); err == nil && u.Scheme == "https" {
+			validateRemoteTLS(&rep, "tls."+name, u.Host)
+		}
+	}
+
+	// ── Апстримы ────────────────────────────────────────────────────────────
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, up := range validateUpstreams {
+		u, err := url.Parse(up.base)
+		if err != nil {
+			rep.add("dns."+up.name, checkFail, err.Error())
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+		cancel()
+		if err != nil {
+			rep.add("dns."+up.name, checkFail, err.Error())
+			continue
+		}
+		rep.add("dns."+up.name, checkOK, strings.Join(addrs, ", "))
+
+		if up.health == "" {
+			continue
+		}
+		start := time.Now()
+		resp, err := client.Get(up.base + up.health)
+		if err != nil {
+			rep.add("health."+up.name, checkFail, err.Error())
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			rep.add("health."+up.name, checkFail, fmt.Sprintf("HTTP %d", resp.StatusCode))
+			continue
+		}
+		rep.add("health."+up.name, checkOK, fmt.Sprintf("%d мс", time.Since(start).Milliseconds()))
+	}
+	return rep
+}
+
+// validateListenerTLS проверяет сертификат публичного листенера
+func validateListenerTLS(rep *ValidationReport) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return
+	}
+	if certFile == "" || keyFile == "" {
+		rep.add("tls.listener", checkFail, "TLS_CERT_FILE и TLS_KEY_FILE задаются вместе")
+		return
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		rep.add("tls.listener", checkFail, err.Error())
+		return
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		rep.add("tls.listener", checkFail, err.Error())
+		return
+	}
+	checkCertValidity(rep, "tls.listener", leaf)
+}
+
+// validateRemoteTLS выполняет TLS-рукопожатие с проверкой цепочки
+func validateRemoteTLS(rep *ValidationReport, name, host string) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host += ":443"
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", host, nil)
+	if err != nil {
+		rep.add(name, checkFail, err.Error())
+		return
+	}
+	defer conn.Close()
+	checkCertValidity(rep, name, conn.ConnectionState().PeerCertificates[0])
+}
+
+func checkCertValidity(rep *ValidationReport, name string, cert *x509.Certificate) {
+	now := time.Now()
+	switch {
+	case now.Before(cert.NotBefore):
+		rep.add(name, checkFail, fmt.Sprintf("сертификат действует с %s", cert.NotBefore.Format(time.RFC3339)))
+	case now.After(cert.NotAfter):
+		rep.add(name, checkFail, fmt.Sprintf("сертификат истёк %s", cert.NotAfter.Format(time.RFC3339)))
+	case cert.NotAfter.Sub(now) < certExpiryWarning:
+		rep.add(name, checkWarn, fmt.Sprintf("сертификат истекает %s", cert.NotAfter.Format(time.RFC3339)))
+	default:
+		rep.add(name, checkOK, fmt.Sprintf("действует до %s", cert.NotAfter.Format(time.RFC3339)))
+	}
+}
+
+// runValidateCommand печатает отчёт и завершает процесс
+func runValidateCommand() {
+	rep := runValidation()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+	if !rep.OK {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
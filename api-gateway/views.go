@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Просмотры и популярные новости. POST /news/{id}/view передаётся в
+// news-service с X-Service-Token и X-Viewer — IP клиента (X-Forwarded-For
+// учитывается только от доверенных прокси): по нему news-service считает
+// просмотр одного зрителя не чаще раза в 30 минут. GET /news/popular
+// проксирует самые просматриваемые новости, доступные в стране клиента.
+
+var viewClient = &http.Client{Timeout: 5 * time.Second}
+
+// PopularNews новость из /news/popular с числом просмотров за окно
+type PopularNews struct {
+	NewsShortDetailed
+	Views int `json:"views"`
+}
+
+// PopularNewsResponse ответ /news/popular
+type PopularNewsResponse struct {
+	Window      string        `json:"window"`
+	News        []PopularNews `json:"news"`
+	ServedStale bool          `json:"served_stale,omitempty"`
+}
+
+// newsViewHandler отмечает просмотр новости
+func newsViewHandler(w http.ResponseWriter, r *http.Request) {
+	newsID, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Неверный ID новости")
+		return
+	}
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+
+	upstreamURL := fmt.Sprintf("%s/news/%d/view?request_id=%s", newsServiceURL, newsID, url.QueryEscape(requestID))
+	req, err := http.NewRequest(http.MethodPost, upstreamURL, nil)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось отметить просмотр")
+		return
+	}
+	if ip, _ := geo.clientIP(r); ip != nil {
+		req.Header.Set("X-Viewer", ip.String())
+	}
+	setServiceToken(req)
+
+	resp, err := newsHealth.do(viewClient, req)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, "Сервис новостей недоступен")
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		w.WriteHeader(http.StatusNoContent)
+	case http.StatusNotFound:
+		writeProblem(w, r, http.StatusNotFound, "Новость не найдена")
+	default:
+		writeProblem(w, r, http.StatusBadGateway, "Ошибка сервиса новостей")
+	}
+}
+
+// popularNewsHandler проксирует новости с наибольшим числом просмотров
+func popularNewsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(contextKeyRequestID).(string)
+	params := url.Values{"request_id": {requestID}}
+	for _, key := range []string{"window", "limit"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+	params.Set("country", geo.country(r))
+
+	body, status, stale, err := fetchNewsUpstream("/news/popular?" + params.Encode())
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Не удалось получить популярные новости")
+		return
+	}
+	if status != http.StatusOK {
+		writeListUpstreamError(w, r, status, body)
+		return
+	}
+
+	var popular PopularNewsResponse
+	if err = json.Unmarshal(body, &popular); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, "Ошибка декодирования новостей")
+		return
+	}
+	popular.ServedStale = stale
+	for i := range popular.News {
+		popular.News[i].Links = newsLinks(popular.News[i].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	json.NewEncoder(w).Encode(popular)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"unicode"
+)
+
+// ─── АННОТАЦИИ СОВПАДЕНИЙ ─────────────────────────────────────────────────────
+
+// formatAnnotated значение ?format=, при котором ответ содержит исходный
+// текст и фрагменты, сработавшие на правила
+const formatAnnotated = "annotated"
+
+// Annotation найденный фрагмент. Start/End — смещения в символах
+// (Unicode code points) исходного текста, End не включается.
+type Annotation struct {
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Fragment string `json:"fragment"`
+	RuleID   string `json:"rule_id"`
+	Category string `json:"category"`
+}
+
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// annotateText находит все вхождения правил в тексте без учёта регистра.
+// Вхождения одного правила не пересекаются; вхождения разных правил могут.
+func annotateText(text string, rules []Rule) []Annotation {
+	original := []rune(text)
+	haystack := lowerRunes(text)
+	annotations := []Annotation{}
+
+	for _, rule := range rules {
+		needle := lowerRunes(rule.Word)
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(haystack); {
+			if !runesEqual(haystack[i:i+len(needle)], needle) {
+				i++
+				continue
+			}
+			annotations = append(annotations, Annotation{
+				Start:    i,
+				End:      i + len(needle),
+				Fragment: string(original[i : i+len(needle)]),
+				RuleID:   rule.ID,
+				Category: rule.Category,
+			})
+			i += len(needle)
+		}
+	}
+
+	sort.SliceStable(annotations, func(a, b int) bool {
+		if annotations[a].Start != annotations[b].Start {
+			return annotations[a].Start < annotations[b].Start
+		}
+		return annotations[a].End > annotations[b].End
+	})
+	return annotations
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
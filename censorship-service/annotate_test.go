@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Смещения считаются в символах, а не в байтах: кириллица и эмодзи перед
+// совпадением не должны их сдвигать.
+func TestAnnotateTextRuneOffsets(t *testing.T) {
+	rules := []Rule{
+		{ID: "insults:1", Word: "дурак", Category: "insults"},
+		{ID: "spam:1", Word: "casino", Category: "spam"},
+		{ID: "default:1", Word: "ур", Category: "default"},
+	}
+
+	for _, tc := range []struct {
+		text string
+		want []Annotation
+	}{
+		{"всё хорошо", []Annotation{}},
+		{"Ты ДУРАК", []Annotation{
+			{Start: 3, End: 8, Fragment: "ДУРАК", RuleID: "insults:1", Category: "insults"},
+			{Start: 4, End: 6, Fragment: "УР", RuleID: "default:1", Category: "default"},
+		}},
+		{"🎰 Casino и casino", []Annotation{
+			{Start: 2, End: 8, Fragment: "Casino", RuleID: "spam:1", Category: "spam"},
+			{Start: 11, End: 17, Fragment: "casino", RuleID: "spam:1", Category: "spam"},
+		}},
+		// вхождения одного правила не пересекаются
+		{"урур", []Annotation{
+			{Start: 0, End: 2, Fragment: "ур", RuleID: "default:1", Category: "default"},
+			{Start: 2, End: 4, Fragment: "ур", RuleID: "default:1", Category: "default"},
+		}},
+	} {
+		got := annotateText(tc.text, rules)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: %+v, ожидалось %+v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLoadForbiddenWordsCategories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	data := "плохое\n" +
+		"# просто комментарий\n" +
+		"# category: insults\n" +
+		"  дурак  \n" +
+		"\n" +
+		"болван\n" +
+		"# category:\n" +
+		"#category: spam\n" +
+		"casino\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := loadForbiddenWords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{ID: "default:1", Word: "плохое", Category: "default"},
+		{ID: "insults:1", Word: "дурак", Category: "insults"},
+		{ID: "insults:2", Word: "болван", Category: "insults"},
+		{ID: "spam:1", Word: "casino", Category: "spam"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("правила %+v, ожидалось %+v", rules, want)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	if err := os.WriteFile(empty, []byte("# category: spam\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadForbiddenWords(empty); err == nil {
+		t.Error("файл без слов: ожидалась ошибка")
+	}
+}
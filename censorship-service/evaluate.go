@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ─── ОЦЕНКА НА КОРПУСЕ ────────────────────────────────────────────────────────
+
+// Максимальный размер загружаемого корпуса
+const maxCorpusBytes = 10 << 20
+
+const (
+	verdictApproved = "approved"
+	verdictRejected = "rejected"
+)
+
+type CorpusSample struct {
+	Text     string `json:"text"`
+	Expected string `json:"expected"`
+}
+
+// EvaluationRequest корпус для проверки. Если задан Words, оценивается
+// этот набор правил вместо текущего — так можно проверить изменения до активации.
+type EvaluationRequest struct {
+	Samples []CorpusSample `json:"samples"`
+	Words   []string       `json:"words,omitempty"`
+}
+
+type Misclassified struct {
+	Index    int    `json:"index"`
+	Text     string `json:"text"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// EvaluationResponse метрики считаются для класса "rejected":
+// precision — доля действительно плохих среди отклонённых,
+// recall — доля отклонённых среди всех плохих.
+type EvaluationResponse struct {
+	Total          int             `json:"total"`
+	TruePositives  int             `json:"true_positives"`
+	FalsePositives int             `json:"false_positives"`
+	TrueNegatives  int             `json:"true_negatives"`
+	FalseNegatives int             `json:"false_negatives"`
+	Precision      float64         `json:"precision"`
+	Recall         float64         `json:"recall"`
+	Accuracy       float64         `json:"accuracy"`
+	RuleSet        string          `json:"rule_set"`
+	Misclassified  []Misclassified `json:"misclassified"`
+}
+
+func evaluateCorpus(samples []CorpusSample, rules []Rule) EvaluationResponse {
+	res := EvaluationResponse{Total: len(samples), Misclassified: []Misclassified{}}
+	for i, s := range samples {
+		actual := verdictRejected
+		if checkText(s.Text, rules) {
+			actual = verdictApproved
+		}
+
+		switch {
+		case s.Expected == verdictRejected && actual == verdictRejected:
+			res.TruePositives++
+		case s.Expected == verdictApproved && actual == verdictRejected:
+			res.FalsePositives++
+		case s.Expected == verdictApproved && actual == verdictApproved:
+			res.TrueNegatives++
+		default:
+			res.FalseNegatives++
+		}
+
+		if actual != s.Expected {
+			res.Misclassified = append(res.Misclassified, Misclassified{
+				Index:    i,
+				Text:     s.Text,
+				Expected: s.Expected,
+				Actual:   actual,
+			})
+		}
+	}
+
+	if d := res.TruePositives + res.FalsePositives; d > 0 {
+		res.Precision = float64(res.TruePositives) / float64(d)
+	}
+	if d := res.TruePositives + res.FalseNegatives; d > 0 {
+		res.Recall = float64(res.TruePositives) / float64(d)
+	}
+	if res.Total > 0 {
+		res.Accuracy = float64(res.TruePositives+res.TrueNegatives) / float64(res.Total)
+	}
+	return res
+}
+
+func makeEvaluateHandler(currentRules []Rule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		requestID, _ := r.Context().Value("request_id").(string)
+
+		var req EvaluationRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCorpusBytes)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if len(req.Samples) == 0 {
+			http.Error(w, "Samples are required", http.StatusBadRequest)
+			return
+		}
+		for i := range req.Samples {
+			req.Samples[i].Expected = strings.ToLower(strings.TrimSpace(req.Samples[i].Expected))
+			if req.Samples[i].Expected != verdictApproved && req.Samples[i].Expected != verdictRejected {
+				http.Error(w, "Expected must be \"approved\" or \"rejected\"", http.StatusBadRequest)
+				return
+			}
+		}
+
+		rules, ruleSet := currentRules, "current"
+		if len(req.Words) > 0 {
+			rules, ruleSet = rulesFromWords(req.Words), "candidate"
+		}
+
+		res := evaluateCorpus(req.Samples, rules)
+		res.RuleSet = ruleSet
+
+		log.Printf("[INFO] Оценка корпуса (%s): %d примеров, precision %.3f, recall %.3f, request_id: %s",
+			ruleSet, res.Total, res.Precision, res.Recall, requestID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
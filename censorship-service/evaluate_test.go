@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestEvaluateCorpus(t *testing.T) {
+	rules := rulesFromWords([]string{"дурак", "casino"})
+	samples := []CorpusSample{
+		{Text: "Ты дурак", Expected: verdictRejected},              // true positive
+		{Text: "Лучшее CASINO", Expected: verdictRejected},         // true positive
+		{Text: "Спасибо за статью", Expected: verdictApproved},     // true negative
+		{Text: "Ты идиот", Expected: verdictRejected},              // false negative
+		{Text: "Приеду в casino-город", Expected: verdictApproved}, // false positive
+	}
+
+	res := evaluateCorpus(samples, rules)
+	for name, c := range map[string][2]int{
+		"total":           {res.Total, 5},
+		"true_positives":  {res.TruePositives, 2},
+		"false_positives": {res.FalsePositives, 1},
+		"true_negatives":  {res.TrueNegatives, 1},
+		"false_negatives": {res.FalseNegatives, 1},
+	} {
+		if c[0] != c[1] {
+			t.Errorf("%s: %d, ожидалось %d", name, c[0], c[1])
+		}
+	}
+	for name, c := range map[string][2]float64{
+		"precision": {res.Precision, 2.0 / 3},
+		"recall":    {res.Recall, 2.0 / 3},
+		"accuracy":  {res.Accuracy, 3.0 / 5},
+	} {
+		if c[0] != c[1] {
+			t.Errorf("%s: %v, ожидалось %v", name, c[0], c[1])
+		}
+	}
+
+	if len(res.Misclassified) != 2 {
+		t.Fatalf("ошибки классификации: %+v", res.Misclassified)
+	}
+	for i, want := range []Misclassified{
+		{Index: 3, Text: "Ты идиот", Expected: verdictRejected, Actual: verdictApproved},
+		{Index: 4, Text: "Приеду в casino-город", Expected: verdictApproved, Actual: verdictRejected},
+	} {
+		if res.Misclassified[i] != want {
+			t.Errorf("ошибка %d: %+v, ожидалось %+v", i, res.Misclassified[i], want)
+		}
+	}
+}
+
+// Без отклонённых примеров метрики не делятся на ноль
+func TestEvaluateCorpusNoRejections(t *testing.T) {
+	res := evaluateCorpus([]CorpusSample{{Text: "привет", Expected: verdictApproved}}, rulesFromWords([]string{"дурак"}))
+	if res.Precision != 0 || res.Recall != 0 || res.Accuracy != 1 || len(res.Misclassified) != 0 {
+		t.Fatalf("%+v", res)
+	}
+}
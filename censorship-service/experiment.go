@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─── A/B-ЭКСПЕРИМЕНТ С ПРАВИЛАМИ ──────────────────────────────────────────────
+
+// Каждый текст /censor проверяется и действующим, и кандидатным набором
+// правил. Ответ по-прежнему определяет действующий набор, а вердикт
+// кандидата только учитывается: сколько вердиктов совпало, сколько
+// текстов кандидат отклонил бы сверх действующего и сколько пропустил
+// бы, какие слова дают расхождения, и последние примеры. Так изменение
+// политики измеряется на живом трафике до выката. Кандидат загружается
+// при старте из CANDIDATE_WORDS_PATH или задаётся через POST /admin/experiment.
+
+// Исходы сравнения вердиктов
+const (
+	outcomeAgree             = "agree"
+	outcomeCandidateRejects  = "candidate_rejects"
+	outcomeCandidateApproves = "candidate_approves"
+)
+
+const (
+	experimentRecent  = 50
+	experimentMaxText = 300
+	experimentTopWord = 20
+)
+
+// ExperimentDivergence текст, по которому наборы разошлись
+type ExperimentDivergence struct {
+	Time    time.Time `json:"time"`
+	Outcome string    `json:"outcome"`
+	Text    string    `json:"text"`
+	// Words слова, из-за которых отклоняет тот набор, что отклоняет
+	Words []string `json:"words"`
+}
+
+// WordDivergence сколько расхождений дало слово
+type WordDivergence struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// ExperimentStats состояние эксперимента
+type ExperimentStats struct {
+	Active      bool                   `json:"active"`
+	Source      string                 `json:"source,omitempty"`
+	StartedAt   *time.Time             `json:"started_at,omitempty"`
+	ActiveRules int                    `json:"active_rules"`
+	Candidate   int                    `json:"candidate_rules"`
+	Added       []string               `json:"added_words"`
+	Removed     []string               `json:"removed_words"`
+	Total       int                    `json:"total"`
+	Outcomes    map[string]int         `json:"outcomes"`
+	Agreement   float64                `json:"agreement"`
+	TopWords    []WordDivergence       `json:"top_words"`
+	Recent      []ExperimentDivergence `json:"recent"`
+}
+
+type policyExperiment struct {
+	active []Rule
+
+	mu        sync.Mutex
+	candidate []Rule
+	source    string
+	startedAt time.Time
+	outcomes  map[string]int
+	words     map[string]int
+	recent    []ExperimentDivergence
+}
+
+// newPolicyExperimentFromEnv эксперимент без кандидата или с кандидатом
+// из CANDIDATE_WORDS_PATH
+func newPolicyExperimentFromEnv(active []Rule) (*policyExperiment, error) {
+	e := &policyExperiment{active: active}
+	path := os.Getenv("CANDIDATE_WORDS_PATH")
+	if path == "" {
+		return e, nil
+	}
+	candidate, err := loadForbiddenWords(path)
+	if err != nil {
+		return nil, err
+	}
+	e.start(candidate, path)
+	return e, nil
+}
+
+// start включает кандидата и обнуляет статистику
+func (e *policyExperiment) start(candidate []Rule, source string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.candidate = candidate
+	e.source = source
+	e.startedAt = time.Now()
+	e.outcomes = make(map[string]int)
+	e.words = make(map[string]int)
+	e.recent = nil
+}
+
+func (e *policyExperiment) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.candidate = nil
+	e.source = ""
+}
+
+// observe сравнивает вердикт действующего набора с вердиктом кандидата
+func (e *policyExperiment) observe(text string, activeApproved bool) {
+	e.mu.Lock()
+	candidate := e.candidate
+	e.mu.Unlock()
+	if candidate == nil {
+		return
+	}
+
+	candidateApproved := checkText(text, candidate)
+	outcome := outcomeAgree
+	var words []string
+	switch {
+	case activeApproved && !candidateApproved:
+		outcome = outcomeCandidateRejects
+		words = matchedWords(text, candidate)
+	case !activeApproved && candidateApproved:
+		outcome = outcomeCandidateApproves
+		words = matchedWords(text, e.active)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// кандидата могли сменить, пока шла проверка
+	if e.candidate == nil || &e.candidate[0] != &candidate[0] {
+		return
+	}
+	e.outcomes[outcome]++
+	if outcome == outcomeAgree {
+		return
+	}
+	for _, w := range words {
+		e.words[w]++
+	}
+	if runes := []rune(text); len(runes) > experimentMaxText {
+		text = string(runes[:experimentMaxText])
+	}
+	e.recent = append(e.recent, ExperimentDivergence{Time: time.Now(), Outcome: outcome, Text: text, Words: words})
+	if len(e.recent) > experimentRecent {
+		e.recent = e.recent[len(e.recent)-experimentRecent:]
+	}
+}
+
+// matchedWords слова набора, найденные в тексте
+func matchedWords(text string, rules []Rule) []string {
+	textLower := strings.ToLower(text)
+	var words []string
+	for _, rule := range rules {
+		if strings.Contains(textLower, strings.ToLower(rule.Word)) {
+			words = append(words, rule.Word)
+		}
+	}
+	return words
+}
+
+func (e *policyExperiment) stats() ExperimentStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := ExperimentStats{
+		Active:      e.candidate != nil,
+		Source:      e.source,
+		ActiveRules: len(e.active),
+		Candidate:   len(e.candidate),
+		Added:       []string{},
+		Removed:     []string{},
+		Outcomes:    map[string]int{},
+		TopWords:    []WordDivergence{},
+		Recent:      append([]ExperimentDivergence{}, e.recent...),
+	}
+	if !st.Active {
+		return st
+	}
+	startedAt := e.startedAt
+	st.StartedAt = &startedAt
+	st.Added, st.Removed = diffWords(e.active, e.candidate)
+	for _, o := range []string{outcomeAgree, outcomeCandidateRejects, outcomeCandidateApproves} {
+		st.Outcomes[o] = e.outcomes[o]
+		st.Total += e.outcomes[o]
+	}
+	if st.Total > 0 {
+		st.Agreement = float64(e.outcomes[outcomeAgree]) / float64(st.Total)
+	}
+	for w, n := range e.words {
+		st.TopWords = append(st.TopWords, WordDivergence{Word: w, Count: n})
+	}
+	sort.Slice(st.TopWords, func(i, j int) bool {
+		if st.TopWords[i].Count != st.TopWords[j].Count {
+			return st.TopWords[i].Count > st.TopWords[j].Count
+		}
+		return st.TopWords[i].Word < st.TopWords[j].Word
+	})
+	if len(st.TopWords) > experimentTopWord {
+		st.TopWords = st.TopWords[:experimentTopWord]
+	}
+	return st
+}
+
+// diffWords слова, которые есть только в кандидате и только в действующем наборе
+func diffWords(active, candidate []Rule) (added, removed []string) {
+	set := func(rules []Rule) map[string]bool {
+		m := make(map[string]bool, len(rules))
+		for _, r := range rules {
+			m[strings.ToLower(r.Word)] = true
+		}
+		return m
+	}
+	activeSet, candidateSet := set(active), set(candidate)
+	added, removed = []string{}, []string{}
+	for w := range candidateSet {
+		if !activeSet[w] {
+			added = append(added, w)
+		}
+	}
+	for w := range activeSet {
+		if !candidateSet[w] {
+			removed = append(removed, w)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func (e *policyExperiment) writeMetrics(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	active := 0
+	if e.candidate != nil {
+		active = 1
+	}
+	fmt.Fprintln(w, "# HELP censorship_experiment_active Идёт ли сравнение с кандидатным набором правил")
+	fmt.Fprintln(w, "# TYPE censorship_experiment_active gauge")
+	fmt.Fprintf(w, "censorship_experiment_active %d\n", active)
+	fmt.Fprintln(w, "# HELP censorship_experiment_evaluations_total Сравнения вердиктов с кандидатом по исходу")
+	fmt.Fprintln(w, "# TYPE censorship_experiment_evaluations_total counter")
+	for _, o := range []string{outcomeAgree, outcomeCandidateRejects, outcomeCandidateApproves} {
+		fmt.Fprintf(w, "censorship_experiment_evaluations_total{outcome=%q} %d\n", o, e.outcomes[o])
+	}
+}
+
+// makeExperimentHandler /admin/experiment: GET — статистика, POST
+// {"words": [...]} — новый кандидат (статистика обнуляется), DELETE —
+// остановка эксперимента
+func makeExperimentHandler(e *policyExperiment) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID, _ := r.Context().Value("request_id").(string)
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Words []string `json:"words"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCorpusBytes)).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			var words []string
+			for _, word := range req.Words {
+				if word = strings.TrimSpace(word); word != "" {
+					words = append(words, word)
+				}
+			}
+			if len(words) == 0 {
+				http.Error(w, "Words are required", http.StatusBadRequest)
+				return
+			}
+			e.start(rulesFromWords(words), "admin")
+			log.Printf("[INFO] Запущен эксперимент с кандидатным набором из %d слов, request_id: %s", len(words), requestID)
+		case http.MethodDelete:
+			e.stop()
+			log.Printf("[INFO] Эксперимент с кандидатным набором остановлен, request_id: %s", requestID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.stats())
+	}
+}
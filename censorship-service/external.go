@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─── ВНЕШНИЙ API МОДЕРАЦИИ ────────────────────────────────────────────────────
+
+// Внешний API включается переменной EXTERNAL_MODERATION_URL и вызывается
+// только для текстов, которые прошли локальные правила. Вызовы идут через
+// circuit breaker: после BREAKER_FAILURE_THRESHOLD ошибок подряд API не
+// вызывается BREAKER_OPEN_SECONDS секунд, затем пропускается один пробный
+// запрос. Пока API недоступен, вердикт выносится по EXTERNAL_FALLBACK_POLICY.
+
+// Политики вердикта при недоступности внешнего API
+const (
+	fallbackLocalOnly     = "local_only"      // решают только локальные правила
+	fallbackFlagForReview = "flag_for_review" // комментарий уходит на ручную проверку
+	fallbackApprove       = "approve"         // комментарий одобряется
+)
+
+var fallbackPolicies = map[string]bool{
+	fallbackLocalOnly:     true,
+	fallbackFlagForReview: true,
+	fallbackApprove:       true,
+}
+
+// Состояния circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker считает ошибки подряд и размыкает цепь при достижении порога
+type circuitBreaker struct {
+	threshold int
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, openFor time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, openFor: openFor, state: breakerClosed}
+}
+
+// allow сообщает, можно ли вызвать backend. По истечении openFor цепь
+// переходит в half_open и пропускает один пробный запрос.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openFor {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		log.Printf("[INFO] Circuit breaker внешнего API: half_open, пробный запрос")
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		log.Printf("[INFO] Circuit breaker внешнего API: closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		log.Printf("[WARN] Circuit breaker внешнего API: open после %d ошибок подряд", b.failures)
+	}
+}
+
+// BreakerStatus состояние внешнего API для /health
+type BreakerStatus struct {
+	Enabled        bool       `json:"enabled"`
+	State          string     `json:"state,omitempty"`
+	Failures       int        `json:"consecutive_failures"`
+	OpenedAt       *time.Time `json:"opened_at,omitempty"`
+	FallbackPolicy string     `json:"fallback_policy,omitempty"`
+}
+
+func (b *circuitBreaker) snapshot() (string, int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures, b.openedAt
+}
+
+// externalModerator клиент внешнего API. Запрос: POST {"text": "..."},
+// ответ: {"flagged": true|false}.
+type externalModerator struct {
+	url      string
+	apiKey   string
+	client   *http.Client
+	breaker  *circuitBreaker
+	fallback string
+
+	mu        sync.Mutex
+	calls     map[string]int // ok, flagged, error, short_circuit
+	fallbacks map[string]int // политика -> число вердиктов
+}
+
+// externalVerdict решение внешнего API или fallback-политики
+type externalVerdict struct {
+	Approved    bool
+	NeedsReview bool
+	Fallback    bool
+}
+
+// newExternalModerator читает настройки из окружения; nil, если
+// интеграция не включена
+func newExternalModerator() (*externalModerator, error) {
+	url := strings.TrimSpace(os.Getenv("EXTERNAL_MODERATION_URL"))
+	if url == "" {
+		return nil, nil
+	}
+	fallback := fallbackLocalOnly
+	if v := strings.TrimSpace(os.Getenv("EXTERNAL_FALLBACK_POLICY")); v != "" {
+		if !fallbackPolicies[v] {
+			return nil, fmt.Errorf("EXTERNAL_FALLBACK_POLICY должна быть local_only, flag_for_review или approve, получено %q", v)
+		}
+		fallback = v
+	}
+	return &externalModerator{
+		url:       url,
+		apiKey:    os.Getenv("EXTERNAL_MODERATION_API_KEY"),
+		client:    &http.Client{Timeout: time.Duration(envInt("EXTERNAL_MODERATION_TIMEOUT_MS", 2000)) * time.Millisecond},
+		breaker:   newCircuitBreaker(envInt("BREAKER_FAILURE_THRESHOLD", 5), time.Duration(envInt("BREAKER_OPEN_SECONDS", 30))*time.Second),
+		fallback:  fallback,
+		calls:     make(map[string]int),
+		fallbacks: make(map[string]int),
+	}, nil
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+func (m *externalModerator) count(result string) {
+	m.mu.Lock()
+	m.calls[result]++
+	m.mu.Unlock()
+}
+
+// check проверяет текст, уже одобренный локальными правилами
+func (m *externalModerator) check(text, requestID string) externalVerdict {
+	if !m.breaker.allow() {
+		m.count("short_circuit")
+		return m.fallbackVerdict(requestID, "цепь разомкнута")
+	}
+
+	flagged, err := m.call(text, requestID)
+	if err != nil {
+		m.breaker.failure()
+		m.count("error")
+		return m.fallbackVerdict(requestID, err.Error())
+	}
+	m.breaker.success()
+	if flagged {
+		m.count("flagged")
+		return externalVerdict{Approved: false}
+	}
+	m.count("ok")
+	return externalVerdict{Approved: true}
+}
+
+func (m *externalModerator) call(text, requestID string) (bool, error) {
+	body, _ := json.Marshal(CensorshipRequest{Text: text})
+	req, err := http.NewRequest(http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", requestID)
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("внешний API вернул статус %d", resp.StatusCode)
+	}
+	var res struct {
+		Flagged *bool `json:"flagged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Flagged == nil {
+		return false, fmt.Errorf("некорректный ответ внешнего API")
+	}
+	return *res.Flagged, nil
+}
+
+func (m *externalModerator) fallbackVerdict(requestID, reason string) externalVerdict {
+	m.mu.Lock()
+	m.fallbacks[m.fallback]++
+	m.mu.Unlock()
+	log.Printf("[WARN] Внешний API недоступен (%s), вердикт по политике %s, request_id: %s", reason, m.fallback, requestID)
+
+	if m.fallback == fallbackFlagForReview {
+		return externalVerdict{NeedsReview: true, Fallback: true}
+	}
+	// local_only и approve: локальные правила текст уже пропустили
+	return externalVerdict{Approved: true, Fallback: true}
+}
+
+func (m *externalModerator) status() BreakerStatus {
+	if m == nil {
+		return BreakerStatus{Enabled: false}
+	}
+	state, failures, openedAt := m.breaker.snapshot()
+	st := BreakerStatus{Enabled: true, State: state, Failures: failures, FallbackPolicy: m.fallback}
+	if state != breakerClosed {
+		st.OpenedAt = &openedAt
+	}
+	return st
+}
+
+// ─── МЕТРИКИ ──────────────────────────────────────────────────────────────────
+
+var breakerStateValues = map[string]int{breakerClosed: 0, breakerHalfOpen: 1, breakerOpen: 2}
+
+// makeMetricsHandler GET /metrics в текстовом формате Prometheus
+func makeMetricsHandler(m *externalModerator, samples *sampleStore, experiment *policyExperiment) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		samples.writeMetrics(w)
+		experiment.writeMetrics(w)
+
+		enabled := 0
+		if m != nil {
+			enabled = 1
+		}
+		fmt.Fprintln(w, "# HELP censorship_external_enabled Включена ли интеграция с внешним API модерации")
+		fmt.Fprintln(w, "# TYPE censorship_external_enabled gauge")
+		fmt.Fprintf(w, "censorship_external_enabled %d\n", enabled)
+		if m == nil {
+			return
+		}
+
+		st := m.status()
+		fmt.Fprintln(w, "# HELP censorship_external_breaker_state Состояние circuit breaker: 0 closed, 1 half_open, 2 open")
+		fmt.Fprintln(w, "# TYPE censorship_external_breaker_state gauge")
+		fmt.Fprintf(w, "censorship_external_breaker_state %d\n", breakerStateValues[st.State])
+		fmt.Fprintln(w, "# HELP censorship_external_consecutive_failures Ошибки внешнего API подряд")
+		fmt.Fprintln(w, "# TYPE censorship_external_consecutive_failures gauge")
+		fmt.Fprintf(w, "censorship_external_consecutive_failures %d\n", st.Failures)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		fmt.Fprintln(w, "# HELP censorship_external_requests_total Обращения к внешнему API по исходу")
+		fmt.Fprintln(w, "# TYPE censorship_external_requests_total counter")
+		for _, result := range []string{"ok", "flagged", "error", "short_circuit"} {
+			fmt.Fprintf(w, "censorship_external_requests_total{result=%q} %d\n", result, m.calls[result])
+		}
+		fmt.Fprintln(w, "# HELP censorship_fallback_verdicts_total Вердикты, вынесенные по fallback-политике")
+		fmt.Fprintln(w, "# TYPE censorship_fallback_verdicts_total counter")
+		fmt.Fprintf(w, "censorship_fallback_verdicts_total{policy=%q} %d\n", m.fallback, m.fallbacks[m.fallback])
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := newCircuitBreaker(2, time.Minute)
+
+	b.failure()
+	if !b.allow() {
+		t.Fatal("одна ошибка при пороге 2 не должна размыкать цепь")
+	}
+	b.failure()
+	if state, failures, _ := b.snapshot(); state != breakerOpen || failures != 2 {
+		t.Fatalf("после двух ошибок: %s, %d", state, failures)
+	}
+	if b.allow() {
+		t.Fatal("разомкнутая цепь пропустила запрос")
+	}
+
+	// по истечении openFor пропускается ровно один пробный запрос
+	b.openedAt = time.Now().Add(-2 * time.Minute)
+	if !b.allow() {
+		t.Fatal("пробный запрос не пропущен")
+	}
+	if b.allow() {
+		t.Fatal("в half_open пропущен второй запрос")
+	}
+	b.failure()
+	if state, _, _ := b.snapshot(); state != breakerOpen {
+		t.Fatalf("неудачный пробный запрос: %s, ожидалось open", state)
+	}
+
+	b.openedAt = time.Now().Add(-2 * time.Minute)
+	b.allow()
+	b.success()
+	if state, failures, _ := b.snapshot(); state != breakerClosed || failures != 0 {
+		t.Fatalf("удачный пробный запрос: %s, %d", state, failures)
+	}
+}
+
+func TestExternalFallbackPolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	for policy, want := range map[string]externalVerdict{
+		fallbackLocalOnly:     {Approved: true, Fallback: true},
+		fallbackFlagForReview: {NeedsReview: true, Fallback: true},
+		fallbackApprove:       {Approved: true, Fallback: true},
+	} {
+		m := &externalModerator{
+			url:       backend.URL,
+			client:    backend.Client(),
+			breaker:   newCircuitBreaker(1, time.Minute),
+			fallback:  policy,
+			calls:     make(map[string]int),
+			fallbacks: make(map[string]int),
+		}
+		// первая проверка получает ошибку API, вторая — разомкнутую цепь
+		for _, result := range []string{"error", "short_circuit"} {
+			if got := m.check("текст", "test"); got != want {
+				t.Errorf("%s, %s: %+v, ожидалось %+v", policy, result, got, want)
+			}
+			if m.calls[result] != 1 {
+				t.Errorf("%s: calls %v, ожидался %s", policy, m.calls, result)
+			}
+		}
+		if m.fallbacks[policy] != 2 {
+			t.Errorf("%s: fallbacks %v", policy, m.fallbacks)
+		}
+		if st := m.status(); st.State != breakerOpen || st.OpenedAt == nil || st.FallbackPolicy != policy {
+			t.Errorf("%s: status %+v", policy, st)
+		}
+	}
+}
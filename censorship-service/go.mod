@@ -1,3 +1,5 @@
 module main.go
 
 go 1.21
+
+require github.com/lib/pq v1.12.3
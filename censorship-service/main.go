@@ -21,36 +21,83 @@ type CensorshipRequest struct {
 type CensorshipResponse struct {
 	IsApproved bool   `json:"is_approved"`
 	Message    string `json:"message,omitempty"`
+	// Text и Annotations заполняются только при ?format=annotated
+	Text        string       `json:"text,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// NeedsReview комментарий нужно отправить на ручную проверку
+	NeedsReview bool `json:"needs_review,omitempty"`
+	// Fallback вердикт вынесен без внешнего API по fallback-политике
+	Fallback bool `json:"fallback,omitempty"`
 }
 
 // ─── ЗАГРУЗКА СЛОВ ────────────────────────────────────────────────────────────
 
-func loadForbiddenWords(path string) ([]string, error) {
+// Категория правил без явного заголовка в файле
+const defaultCategory = "default"
+
+// Rule запрещённое слово. ID вида "категория:номер" стабилен, пока
+// порядок слов внутри категории не меняется.
+type Rule struct {
+	ID       string `json:"id"`
+	Word     string `json:"word"`
+	Category string `json:"category"`
+}
+
+// loadForbiddenWords читает правила из файла. Строка "# category: имя"
+// задаёт категорию для следующих слов; прочие строки с # — комментарии.
+func loadForbiddenWords(path string) ([]Rule, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось загрузить список слов из %s: %w", path, err)
 	}
 
-	var words []string
+	var rules []Rule
+	category := defaultCategory
+	counters := make(map[string]int)
 	for _, line := range strings.Split(string(data), "\n") {
 		word := strings.TrimSpace(line)
-		// пропускаем пустые строки и комментарии (#)
-		if word != "" && !strings.HasPrefix(word, "#") {
-			words = append(words, word)
+		if strings.HasPrefix(word, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(word, "#"))
+			if name, ok := strings.CutPrefix(comment, "category:"); ok && strings.TrimSpace(name) != "" {
+				category = strings.TrimSpace(name)
+			}
+			continue
+		}
+		// пропускаем пустые строки
+		if word != "" {
+			counters[category]++
+			rules = append(rules, Rule{
+				ID:       fmt.Sprintf("%s:%d", category, counters[category]),
+				Word:     word,
+				Category: category,
+			})
 		}
 	}
 
-	if len(words) == 0 {
+	if len(rules) == 0 {
 		return nil, fmt.Errorf("файл %s пустой или не содержит слов", path)
 	}
 
-	return words, nil
+	return rules, nil
 }
 
-func checkText(text string, forbiddenWords []string) bool {
+// rulesFromWords правила из простого списка слов (кандидаты в /admin/evaluate)
+func rulesFromWords(words []string) []Rule {
+	rules := make([]Rule, 0, len(words))
+	for i, word := range words {
+		rules = append(rules, Rule{
+			ID:       fmt.Sprintf("%s:%d", defaultCategory, i+1),
+			Word:     word,
+			Category: defaultCategory,
+		})
+	}
+	return rules
+}
+
+func checkText(text string, rules []Rule) bool {
 	textLower := strings.ToLower(text)
-	for _, word := range forbiddenWords {
-		if strings.Contains(textLower, strings.ToLower(word)) {
+	for _, rule := range rules {
+		if strings.Contains(textLower, strings.ToLower(rule.Word)) {
 			return false
 		}
 	}
@@ -59,7 +106,7 @@ func checkText(text string, forbiddenWords []string) bool {
 
 // HANDLERS
 
-func makeCensorHandler(forbiddenWords []string) http.HandlerFunc {
+func makeCensorHandler(rules []Rule, external *externalModerator, samples *sampleStore, experiment *policyExperiment) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -80,39 +127,60 @@ func makeCensorHandler(forbiddenWords []string) http.HandlerFunc {
 			return
 		}
 
-		isApproved := checkText(req.Text, forbiddenWords)
+		isApproved := checkText(req.Text, rules)
+		experiment.observe(req.Text, isApproved)
+
+		resp := CensorshipResponse{IsApproved: isApproved}
+		if isApproved && external != nil {
+			verdict := external.check(req.Text, requestID)
+			isApproved = verdict.Approved
+			resp.IsApproved, resp.NeedsReview, resp.Fallback = verdict.Approved, verdict.NeedsReview, verdict.Fallback
+		}
+		var annotations []Annotation
+		if !resp.IsApproved || r.URL.Query().Get("format") == formatAnnotated {
+			annotations = annotateText(req.Text, rules)
+		}
+		if r.URL.Query().Get("format") == formatAnnotated {
+			resp.Text = req.Text
+			resp.Annotations = annotations
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 
-		if isApproved {
+		if resp.NeedsReview {
+			log.Printf("[INFO] Комментарий отправлен на ручную проверку, request_id: %s", requestID)
+			resp.Message = "Comment requires manual review"
+			w.WriteHeader(http.StatusAccepted)
+		} else if isApproved {
 			log.Printf("[INFO] Комментарий одобрен, request_id: %s", requestID)
+			resp.Message = "Comment approved"
 			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(CensorshipResponse{
-				IsApproved: true,
-				Message:    "Comment approved",
-			})
 		} else {
 			log.Printf("[INFO] Комментарий отклонён, request_id: %s", requestID)
+			resp.Message = "Comment contains inappropriate content"
 			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(CensorshipResponse{
-				IsApproved: false,
-				Message:    "Comment contains inappropriate content",
-			})
+			samples.record(req.Text, annotations, "rejected")
 		}
+		json.NewEncoder(w).Encode(resp)
 	}
 }
 
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// makeHealthCheckHandler отдаёт и состояние внешнего API: пока работает
+// fallback-политика, сервис остаётся здоровым
+func makeHealthCheckHandler(external *externalModerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":              "ok",
+			"timestamp":           time.Now(),
+			"service":             "censorship-service",
+			"external_moderation": external.status(),
+		})
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "ok",
-		"timestamp": time.Now(),
-		"service":   "censorship-service",
-	})
 }
 
 // MIDDLEWARE
@@ -175,17 +243,46 @@ func main() {
 		wordsPath = "forbidden_words.txt"
 	}
 
-	words, err := loadForbiddenWords(wordsPath)
+	rules, err := loadForbiddenWords(wordsPath)
 	if err != nil {
 		log.Fatalf("[FATAL] %v", err)
 	}
-	log.Printf("[INFO] Загружено %d запрещённых слов из %s", len(words), wordsPath)
+	log.Printf("[INFO] Загружено %d запрещённых слов из %s", len(rules), wordsPath)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/censor", makeCensorHandler(words))
-	mux.HandleFunc("/health", healthCheckHandler)
+	external, err := newExternalModerator()
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if external != nil {
+		log.Printf("[INFO] Внешний API модерации включён, fallback-политика: %s", external.fallback)
+	}
+
+	samples, err := newSampleStoreFromEnv()
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if samples != nil {
+		log.Printf("[INFO] Выборка срабатываний правил включена, доля: %g", samples.rate)
+	}
 
-	handler := requestIDMiddleware(mux)
+	experiment, err := newPolicyExperimentFromEnv(rules)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if st := experiment.stats(); st.Active {
+		log.Printf("[INFO] Эксперимент: кандидатный набор из %d слов (%s)", st.Candidate, st.Source)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/censor", makeCensorHandler(rules, external, samples, experiment))
+	mux.HandleFunc("/admin/samples/export", makeSamplesExportHandler(samples))
+	mux.HandleFunc("/admin/evaluate", makeEvaluateHandler(rules))
+	mux.HandleFunc("/admin/experiment", makeExperimentHandler(experiment))
+	mux.HandleFunc("/health", makeHealthCheckHandler(external))
+	mux.HandleFunc("/metrics", makeMetricsHandler(external, samples, experiment))
+
+	handler := serviceAuthMiddleware(mux)
+	handler = requestIDMiddleware(handler)
 	handler = loggingMiddleware(handler)
 
 	log.Println("[INFO] Сервис цензурирования запущен на порту 8083")
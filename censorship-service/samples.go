@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ─── ВЫБОРКА СРАБАТЫВАНИЙ ПРАВИЛ ──────────────────────────────────────────────
+
+// Доля SAMPLE_RATE текстов, на которых сработали правила, сохраняется в
+// таблицу rule_hit_samples: нормализованный текст (нижний регистр,
+// схлопнутые пробелы, не длиннее SAMPLE_MAX_TEXT_LENGTH символов), его
+// SHA-256, правило и вердикт. Это
+// корпус для обучения статистического классификатора — без записи
+// каждого комментария. Запись идёт в фоне через буфер: если база не
+// успевает, образцы отбрасываются, а /censor не замедляется. Образцы
+// старше SAMPLE_RETENTION_DAYS удаляются. SAMPLE_RATE=0 (по умолчанию)
+// отключает выборку, и база сервису не нужна. Персональные данные —
+// адреса почты, ссылки, упоминания и номера телефонов — заменяются
+// метками до записи и ещё раз при выгрузке (для образцов, сохранённых
+// до редактирования). Хеш тоже считается по тексту с метками: хеш
+// исходного текста позволил бы перебором проверить, чей телефон или
+// адрес был в комментарии.
+
+const (
+	defaultSampleMaxText   = 2000
+	defaultSampleRetention = 90
+	sampleBufferSize       = 1000
+)
+
+// Исходы записи образца для /metrics
+const (
+	sampleStored  = "stored"
+	sampleDropped = "dropped"
+	sampleError   = "error"
+)
+
+// RuleHitSample образец срабатывания правила
+type RuleHitSample struct {
+	ID         int64     `json:"id"`
+	TextHash   string    `json:"text_hash"`
+	Normalized string    `json:"normalized"`
+	RuleID     string    `json:"rule_id"`
+	Category   string    `json:"category"`
+	Verdict    string    `json:"verdict"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// sampleStore пишет образцы в фоне
+type sampleStore struct {
+	db        *sql.DB
+	rate      float64
+	maxText   int
+	retention time.Duration
+	queue     chan []RuleHitSample
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// newSampleStoreFromEnv nil, если выборка выключена
+func newSampleStoreFromEnv() (*sampleStore, error) {
+	rate := 0.0
+	if v := os.Getenv("SAMPLE_RATE"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("SAMPLE_RATE должен быть числом от 0 до 1, получено %q", v)
+		}
+		rate = r
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+
+	dbHost, dbPort := os.Getenv("DB_HOST"), os.Getenv("DB_PORT")
+	dbUser, dbPassword, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME")
+	if dbHost == "" || dbPort == "" || dbUser == "" || dbPassword == "" || dbName == "" {
+		return nil, fmt.Errorf("для SAMPLE_RATE > 0 необходимо задать DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME")
+	}
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		dbHost, dbPort, dbUser, dbPassword, dbName))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к БД: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("не удается подключиться к БД: %w", err)
+	}
+
+	s := &sampleStore{
+		db:        db,
+		rate:      rate,
+		maxText:   envInt("SAMPLE_MAX_TEXT_LENGTH", defaultSampleMaxText),
+		retention: time.Duration(envInt("SAMPLE_RETENTION_DAYS", defaultSampleRetention)) * 24 * time.Hour,
+		queue:     make(chan []RuleHitSample, sampleBufferSize),
+		counts:    make(map[string]int),
+	}
+	go s.writer()
+	go s.cleanup(time.Hour)
+	return s, nil
+}
+
+// normalizeSampleText нижний регистр и одиночные пробелы
+func normalizeSampleText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// sampleRedactions шаблоны персональных данных и их метки; порядок важен:
+// адрес почты содержит @ и точки, поэтому заменяется раньше упоминаний и
+// ссылок
+var sampleRedactions = []struct {
+	pattern *regexp.Regexp
+	label   string
+}{
+	{regexp.MustCompile(`[\p{L}\p{N}._%+-]+@[\p{L}\p{N}.-]+\.\p{L}{2,}`), "<email>"},
+	{regexp.MustCompile(`(?:https?://|www\.)\S+`), "<url>"},
+	{regexp.MustCompile(`@[\p{L}\p{N}_]{2,}`), "<user>"},
+	{regexp.MustCompile(`\+?\d[\d\s().-]{6,}\d`), "<phone>"},
+}
+
+// redactSampleText заменяет персональные данные метками
+func redactSampleText(text string) string {
+	for _, r := range sampleRedactions {
+		text = r.pattern.ReplaceAllString(text, r.label)
+	}
+	return text
+}
+
+// record ставит в очередь срабатывания правил для текста с вероятностью rate
+func (s *sampleStore) record(text string, annotations []Annotation, verdict string) {
+	if s == nil || len(annotations) == 0 || rand.Float64() >= s.rate {
+		return
+	}
+	normalized := redactSampleText(normalizeSampleText(text))
+	sum := sha256.Sum256([]byte(normalized))
+	hash := hex.EncodeToString(sum[:])
+	if runes := []rune(normalized); len(runes) > s.maxText {
+		normalized = string(runes[:s.maxText])
+	}
+
+	// одно правило может сработать несколько раз — сохраняем его один раз
+	seen := make(map[string]bool)
+	var batch []RuleHitSample
+	for _, a := range annotations {
+		if seen[a.RuleID] {
+			continue
+		}
+		seen[a.RuleID] = true
+		batch = append(batch, RuleHitSample{
+			TextHash: hash, Normalized: normalized,
+			RuleID: a.RuleID, Category: a.Category, Verdict: verdict,
+		})
+	}
+	select {
+	case s.queue <- batch:
+	default:
+		s.count(sampleDropped, len(batch))
+	}
+}
+
+func (s *sampleStore) count(result string, n int) {
+	s.mu.Lock()
+	s.counts[result] += n
+	s.mu.Unlock()
+}
+
+func (s *sampleStore) writer() {
+	for batch := range s.queue {
+		for _, sample := range batch {
+			_, err := s.db.Exec(`
+				INSERT INTO rule_hit_samples (text_hash, normalized, rule_id, category, verdict)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (text_hash, rule_id) DO NOTHING
+			`, sample.TextHash, sample.Normalized, sample.RuleID, sample.Category, sample.Verdict)
+			if err != nil {
+				log.Printf("[ERROR] Не удалось сохранить образец срабатывания правила %s: %v", sample.RuleID, err)
+				s.count(sampleError, 1)
+				continue
+			}
+			s.count(sampleStored, 1)
+		}
+	}
+}
+
+// cleanup удаляет образцы старше срока хранения
+func (s *sampleStore) cleanup(every time.Duration) {
+	for {
+		res, err := s.db.Exec(`DELETE FROM rule_hit_samples WHERE created_at < $1`, time.Now().Add(-s.retention))
+		if err != nil {
+			log.Printf("[ERROR] Ошибка очистки образцов: %v", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[INFO] Удалено устаревших образцов: %d", n)
+		}
+		time.Sleep(every)
+	}
+}
+
+// writeMetrics счётчики выборки в формате Prometheus
+func (s *sampleStore) writeMetrics(w io.Writer) {
+	enabled := 0
+	if s != nil {
+		enabled = 1
+	}
+	fmt.Fprintln(w, "# HELP censorship_sampling_enabled Включена ли выборка срабатываний правил")
+	fmt.Fprintln(w, "# TYPE censorship_sampling_enabled gauge")
+	fmt.Fprintf(w, "censorship_sampling_enabled %d\n", enabled)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(w, "# HELP censorship_samples_total Образцы срабатываний правил по исходу записи")
+	fmt.Fprintln(w, "# TYPE censorship_samples_total counter")
+	for _, result := range []string{sampleStored, sampleDropped, sampleError} {
+		fmt.Fprintf(w, "censorship_samples_total{result=%q} %d\n", result, s.counts[result])
+	}
+}
+
+// makeSamplesExportHandler GET /admin/samples/export — образцы в формате
+// JSON Lines по возрастанию id. after_id позволяет выгружать порциями
+// и продолжать прерванную выгрузку; since, rule и category сужают выборку.
+func makeSamplesExportHandler(s *sampleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s == nil {
+			http.Error(w, "Sampling is disabled", http.StatusNotFound)
+			return
+		}
+
+		q := r.URL.Query()
+		conditions := []string{"TRUE"}
+		var args []interface{}
+		if v := q.Get("after_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 0 {
+				http.Error(w, "after_id must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			args = append(args, id)
+			conditions = append(conditions, fmt.Sprintf("id > $%d", len(args)))
+		}
+		if v := q.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "since must be RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			args = append(args, t)
+			conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+		}
+		if v := q.Get("rule"); v != "" {
+			args = append(args, pq.Array(strings.Split(v, ",")))
+			conditions = append(conditions, fmt.Sprintf("rule_id = ANY($%d)", len(args)))
+		}
+		if v := q.Get("category"); v != "" {
+			args = append(args, v)
+			conditions = append(conditions, fmt.Sprintf("category = $%d", len(args)))
+		}
+		limit := 10000
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100000 {
+				http.Error(w, "limit must be between 1 and 100000", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		args = append(args, limit)
+
+		rows, err := s.db.Query(fmt.Sprintf(`
+			SELECT id, text_hash, normalized, rule_id, category, verdict, created_at
+			FROM rule_hit_samples
+			WHERE %s
+			ORDER BY id
+			LIMIT $%d
+		`, strings.Join(conditions, " AND "), len(args)), args...)
+		if err != nil {
+			log.Printf("[ERROR] Ошибка выгрузки образцов: %v", err)
+			http.Error(w, "Failed to export samples", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		enc := json.NewEncoder(w)
+		exported := 0
+		for rows.Next() {
+			var sample RuleHitSample
+			if err := rows.Scan(&sample.ID, &sample.TextHash, &sample.Normalized, &sample.RuleID,
+				&sample.Category, &sample.Verdict, &sample.CreatedAt); err != nil {
+				log.Printf("[ERROR] Ошибка выгрузки образцов: %v", err)
+				return
+			}
+			sample.Normalized = redactSampleText(sample.Normalized)
+			enc.Encode(sample)
+			exported++
+		}
+		requestID, _ := r.Context().Value("request_id").(string)
+		log.Printf("[INFO] Выгружено образцов: %d, request_id: %s", exported, requestID)
+	}
+}
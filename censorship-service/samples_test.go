@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSampleRecord(t *testing.T) {
+	s := &sampleStore{rate: 1, maxText: 10, queue: make(chan []RuleHitSample, 1), counts: make(map[string]int)}
+	s.record("  Купите   СПАМ и спам ", []Annotation{
+		{RuleID: "spam:1", Category: "spam"},
+		{RuleID: "spam:1", Category: "spam"},
+		{RuleID: "ads:2", Category: "ads"},
+	}, verdictRejected)
+
+	batch := <-s.queue
+	if len(batch) != 2 || batch[0].RuleID != "spam:1" || batch[1].RuleID != "ads:2" {
+		t.Fatalf("правило, сработавшее дважды, должно сохраниться один раз: %+v", batch)
+	}
+	// хеш считается до обрезки, поэтому длинные тексты с общим началом различаются
+	sum := sha256.Sum256([]byte("купите спам и спам"))
+	for _, sample := range batch {
+		if sample.Normalized != "купите спа" {
+			t.Errorf("normalized %q, ожидалось %q", sample.Normalized, "купите спа")
+		}
+		if sample.TextHash != hex.EncodeToString(sum[:]) {
+			t.Errorf("text_hash %s", sample.TextHash)
+		}
+		if sample.Verdict != verdictRejected {
+			t.Errorf("verdict %q", sample.Verdict)
+		}
+	}
+
+	// буфер полон: образцы отбрасываются и считаются в метриках
+	s.record("спам", []Annotation{{RuleID: "spam:1"}}, verdictRejected)
+	s.record("спам", []Annotation{{RuleID: "spam:1"}, {RuleID: "ads:2"}}, verdictRejected)
+	if got := s.counts[sampleDropped]; got != 2 {
+		t.Fatalf("dropped = %d, ожидалось 2", got)
+	}
+}
+
+func TestSampleRecordSkipped(t *testing.T) {
+	var disabled *sampleStore
+	disabled.record("спам", []Annotation{{RuleID: "spam:1"}}, verdictRejected)
+
+	s := &sampleStore{rate: 1, maxText: 100, queue: make(chan []RuleHitSample, 1), counts: make(map[string]int)}
+	s.record("чистый текст", nil, verdictApproved)
+	if len(s.queue) != 0 {
+		t.Fatal("текст без срабатываний попал в выборку")
+	}
+}
+
+func TestRedactSampleText(t *testing.T) {
+	for text, want := range map[string]string{
+		"без персональных данных":                     "без персональных данных",
+		"пишите на ivan.petrov@mail.ru":               "пишите на <email>",
+		"почта иван@почта.рф и @ivan_p":               "почта <email> и <user>",
+		"смотри https://example.com/a?b=1 и www.x.ru": "смотри <url> и <url>",
+		"звоните +7 (912) 345-67-89 вечером":          "звоните <phone> вечером",
+		"год 2024 и цена 150":                         "год 2024 и цена 150",
+		"@ один символ @a не упоминание":              "@ один символ @a не упоминание",
+	} {
+		if got := redactSampleText(text); got != want {
+			t.Errorf("%q: %q, ожидалось %q", text, got, want)
+		}
+	}
+}
+
+// Хеш образца считается по тексту без персональных данных: тексты,
+// различающиеся только ими, дают один хеш
+func TestSampleRecordHashesRedactedText(t *testing.T) {
+	s := &sampleStore{rate: 1, maxText: 1000, queue: make(chan []RuleHitSample, 2), counts: make(map[string]int)}
+	annotations := []Annotation{{RuleID: "spam:1", Category: "spam"}}
+	s.record("Пиши на a@b.ru", annotations, verdictRejected)
+	s.record("пиши  на c@d.org", annotations, verdictRejected)
+
+	first, second := <-s.queue, <-s.queue
+	if first[0].Normalized != "пиши на <email>" {
+		t.Fatalf("normalized %q", first[0].Normalized)
+	}
+	if first[0].TextHash != second[0].TextHash {
+		t.Fatalf("хеши различаются: %s и %s", first[0].TextHash, second[0].TextHash)
+	}
+}
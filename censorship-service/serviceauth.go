@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Порт сервиса открыт на хосте: /admin/* только с X-Service-Token от gateway
+
+var serviceToken = os.Getenv("SERVICE_TOKEN")
+
+// serviceAuthorized проверяет X-Service-Token за постоянное время
+func serviceAuthorized(r *http.Request) bool {
+	got := r.Header.Get("X-Service-Token")
+	return serviceToken != "" && subtle.ConstantTimeCompare([]byte(got), []byte(serviceToken)) == 1
+}
+
+func serviceAuthMiddleware(next http.Handler) http.Handler {
+	if serviceToken == "" {
+		log.Println("[WARN] SERVICE_TOKEN не задан: /admin/* недоступны")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") && !serviceAuthorized(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
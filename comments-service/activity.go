@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Активность обсуждений: GET /comments/activity?since=...&limit=... отдаёт
+// новости с наибольшим числом одобренных комментариев, оставленных после
+// since (RFC 3339, по умолчанию — за последние сутки). news-service строит
+// по нему /news/trending.
+
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 1000
+	defaultActivitySince = 24 * time.Hour
+	maxActivitySince     = 30 * 24 * time.Hour
+)
+
+// NewsActivity число комментариев новости за период
+type NewsActivity struct {
+	NewsID        int       `json:"news_id"`
+	Comments      int       `json:"comments"`
+	LastCommentAt time.Time `json:"last_comment_at"`
+}
+
+// ActivityResponse ответ /comments/activity
+type ActivityResponse struct {
+	Since time.Time      `json:"since"`
+	News  []NewsActivity `json:"news"`
+}
+
+// commentsActivityHandler GET /comments/activity
+func commentsActivityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	q := r.URL.Query()
+	since := time.Now().Add(-defaultActivitySince)
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	if time.Since(since) > maxActivitySince {
+		http.Error(w, fmt.Sprintf("since must be within %d days", int(maxActivitySince/(24*time.Hour))), http.StatusBadRequest)
+		return
+	}
+	limit := defaultActivityLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxActivityLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxActivityLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := readDB.QueryContext(r.Context(), `
+		SELECT news_id, COUNT(*), MAX(created_at)
+		FROM comments
+		WHERE created_at >= $1 AND status = $2
+		GROUP BY news_id
+		ORDER BY COUNT(*) DESC, MAX(created_at) DESC
+		LIMIT $3
+	`, since, statusApproved, limit)
+	if err != nil {
+		log.Printf("Ошибка получения активности обсуждений: %v", err)
+		http.Error(w, "Failed to get activity", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := ActivityResponse{Since: since, News: []NewsActivity{}}
+	for rows.Next() {
+		var a NewsActivity
+		if err := rows.Scan(&a.NewsID, &a.Comments, &a.LastCommentAt); err != nil {
+			log.Printf("Ошибка чтения активности обсуждений: %v", err)
+			http.Error(w, "Failed to get activity", http.StatusInternalServerError)
+			return
+		}
+		response.News = append(response.News, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка чтения активности обсуждений: %v", err)
+		http.Error(w, "Failed to get activity", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Активность обсуждений с %s: %d новостей, request_id: %s", since.Format(time.RFC3339), len(response.News), requestID)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Заполнение полей модерации у исторических комментариев. Комментарии,
+// созданные до появления пайплайна модерации, получили статус approved
+// по умолчанию, хотя ни разу не проверялись: у них нет ни
+// censor_checked_at, ни moderated_at. Задание проходит по таким
+// комментариям, созданным раньше before, порциями по id и применяет
+// политику:
+//   - approve — комментарий остаётся одобренным и помечается проверенным;
+//   - pending — комментарий скрывается до ночной перепроверки;
+//   - censor  — комментарий сразу проверяется censorship-service, при
+//     недоступности сервиса уходит в pending.
+//
+// Обработанные комментарии помечаются в backfill_policy, поэтому
+// прерванное задание можно просто запустить повторно.
+
+const (
+	backfillApprove = "approve"
+	backfillPending = "pending"
+	backfillCensor  = "censor"
+)
+
+var backfillPolicies = map[string]bool{
+	backfillApprove: true,
+	backfillPending: true,
+	backfillCensor:  true,
+}
+
+const (
+	defaultBackfillChunk = 500
+	maxBackfillChunk     = 5000
+)
+
+// BackfillRequest параметры задания
+type BackfillRequest struct {
+	Policy    string    `json:"policy"`
+	Before    time.Time `json:"before"`
+	ChunkSize int       `json:"chunk_size,omitempty"`
+	DryRun    bool      `json:"dry_run,omitempty"`
+}
+
+// BackfillProgress состояние задания
+type BackfillProgress struct {
+	State      string     `json:"state"` // running, completed, failed
+	Policy     string     `json:"policy"`
+	Before     time.Time  `json:"before"`
+	ChunkSize  int        `json:"chunk_size"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Approved   int        `json:"approved"`
+	Rejected   int        `json:"rejected"`
+	Pending    int        `json:"pending"`
+	Chunks     int        `json:"chunks"`
+	LastID     int        `json:"last_id"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// moderationBackfill выполняет не больше одного задания одновременно
+type moderationBackfill struct {
+	mu       sync.Mutex
+	progress *BackfillProgress
+}
+
+var backfill = &moderationBackfill{}
+
+// backfillCandidatesCondition комментарии без следов модерации
+const backfillCandidatesCondition = `
+            status = 'approved'
+            AND censor_checked_at IS NULL
+            AND moderated_at IS NULL
+            AND backfill_policy IS NULL
+            AND created_at < $1`
+
+func countBackfillCandidates(before time.Time) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM comments WHERE`+backfillCandidatesCondition, before).Scan(&n)
+	return n, err
+}
+
+// start запускает задание в фоне
+func (b *moderationBackfill) start(req BackfillRequest, total int) (BackfillProgress, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.progress != nil && b.progress.State == "running" {
+		return *b.progress, fmt.Errorf("заполнение уже выполняется")
+	}
+	b.progress = &BackfillProgress{
+		State:     "running",
+		Policy:    req.Policy,
+		Before:    req.Before,
+		ChunkSize: req.ChunkSize,
+		Total:     total,
+		StartedAt: time.Now(),
+	}
+	go b.run(req)
+	return *b.progress, nil
+}
+
+func (b *moderationBackfill) snapshot() *BackfillProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.progress == nil {
+		return nil
+	}
+	p := *b.progress
+	return &p
+}
+
+func (b *moderationBackfill) update(fn func(p *BackfillProgress)) {
+	b.mu.Lock()
+	fn(b.progress)
+	b.mu.Unlock()
+}
+
+func (b *moderationBackfill) run(req BackfillRequest) {
+	log.Printf("Начинаем заполнение модерации (политика %s, до %s)", req.Policy, req.Before.Format(time.RFC3339))
+	lastID := 0
+	for {
+		ids, texts, err := backfillChunk(req.Before, lastID, req.ChunkSize)
+		if err == nil && len(ids) > 0 {
+			err = applyBackfillPolicy(req.Policy, ids, texts, b)
+		}
+		if err != nil {
+			log.Printf("Ошибка заполнения модерации после id %d: %v", lastID, err)
+			b.update(func(p *BackfillProgress) {
+				now := time.Now()
+				p.State, p.Error, p.FinishedAt = "failed", err.Error(), &now
+			})
+			return
+		}
+		if len(ids) == 0 {
+			break
+		}
+		lastID = ids[len(ids)-1]
+		b.update(func(p *BackfillProgress) {
+			p.Chunks++
+			p.Processed += len(ids)
+			p.LastID = lastID
+		})
+		snap := b.snapshot()
+		log.Printf("Заполнение модерации: обработано %d из %d", snap.Processed, snap.Total)
+	}
+	b.update(func(p *BackfillProgress) {
+		now := time.Now()
+		p.State, p.FinishedAt = "completed", &now
+	})
+	log.Printf("Заполнение модерации завершено")
+}
+
+// backfillChunk очередная порция кандидатов после lastID
+func backfillChunk(before time.Time, lastID, limit int) ([]int, []string, error) {
+	rows, err := db.Query(`
+        SELECT id, text
+        FROM comments
+        WHERE`+backfillCandidatesCondition+` AND id > $2
+        ORDER BY id ASC
+        LIMIT $3
+    `, before, lastID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	var texts []string
+	for rows.Next() {
+		var id int
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		texts = append(texts, text)
+	}
+	return ids, texts, rows.Err()
+}
+
+// applyBackfillPolicy обновляет порцию комментариев
+func applyBackfillPolicy(policy string, ids []int, texts []string, b *moderationBackfill) error {
+	switch policy {
+	case backfillApprove:
+		_, err := db.Exec(`
+            UPDATE comments
+            SET censor_checked_at = NOW(), backfill_policy = $2
+            WHERE id = ANY($1)
+        `, pq.Array(ids), policy)
+		if err == nil {
+			b.update(func(p *BackfillProgress) { p.Approved += len(ids) })
+		}
+		return err
+	case backfillPending:
+		_, err := db.Exec(`
+            UPDATE comments
+            SET status = $3, backfill_policy = $2
+            WHERE id = ANY($1)
+        `, pq.Array(ids), policy, statusPending)
+		if err == nil {
+			b.update(func(p *BackfillProgress) { p.Pending += len(ids) })
+		}
+		return err
+	default:
+		for i, id := range ids {
+			status := statusPending
+			if approved, err := rechecker.censor(texts[i]); err == nil {
+				status = statusRejected
+				if approved {
+					status = statusApproved
+				}
+			}
+			_, err := db.Exec(`
+                UPDATE comments
+                SET status = $2,
+                    censor_checked_at = CASE WHEN $2 = 'pending' THEN censor_checked_at ELSE NOW() END,
+                    backfill_policy = $3
+                WHERE id = $1
+            `, id, status, policy)
+			if err != nil {
+				return err
+			}
+			b.update(func(p *BackfillProgress) {
+				switch status {
+				case statusApproved:
+					p.Approved++
+				case statusRejected:
+					p.Rejected++
+				default:
+					p.Pending++
+				}
+			})
+		}
+		return nil
+	}
+}
+
+// moderationBackfillHandler POST запускает заполнение, GET возвращает прогресс
+func moderationBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		progress := backfill.snapshot()
+		if progress == nil {
+			http.Error(w, "No backfill has been started", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(progress)
+	case http.MethodPost:
+		var req BackfillRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !backfillPolicies[req.Policy] {
+			http.Error(w, "policy must be approve, pending or censor", http.StatusBadRequest)
+			return
+		}
+		if req.Before.IsZero() {
+			http.Error(w, "before is required", http.StatusBadRequest)
+			return
+		}
+		if req.ChunkSize == 0 {
+			req.ChunkSize = defaultBackfillChunk
+		}
+		if req.ChunkSize < 1 || req.ChunkSize > maxBackfillChunk {
+			http.Error(w, fmt.Sprintf("chunk_size must be between 1 and %d", maxBackfillChunk), http.StatusBadRequest)
+			return
+		}
+
+		total, err := countBackfillCandidates(req.Before)
+		if err != nil {
+			log.Printf("Ошибка подсчёта комментариев для заполнения: %v", err)
+			http.Error(w, "Failed to count comments", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if req.DryRun {
+			json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "policy": req.Policy, "total": total})
+			return
+		}
+
+		progress, err := backfill.start(req, total)
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(progress)
+			return
+		}
+		log.Printf("Запущено заполнение модерации для %d комментариев, request_id: %s", total, requestID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(progress)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
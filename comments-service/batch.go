@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Пакетное получение комментариев для нескольких новостей одним
+// запросом: GET /comments?news_ids=1,2,3. Gateway использует его при
+// выдаче списков новостей вместо отдельного запроса на каждую новость.
+
+const (
+	maxBatchNewsIDs       = 100
+	defaultBatchNodeLimit = 50
+)
+
+// NewsComments часть дерева комментариев одной новости
+type NewsComments struct {
+	Comments     []Comment `json:"comments"`
+	Total        int       `json:"total"`
+	Continuation string    `json:"continuation,omitempty"`
+}
+
+// BatchCommentsResponse ответ пакетного запроса. Некорректные ID
+// попадают в errors и не мешают остальным.
+type BatchCommentsResponse struct {
+	Results map[string]NewsComments `json:"results"`
+	Errors  map[string]string       `json:"errors,omitempty"`
+}
+
+// getCommentsBatchHandler GET /comments?news_ids=1,2,3[&max_nodes=50]
+func getCommentsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	raw := strings.Split(r.URL.Query().Get("news_ids"), ",")
+	if len(raw) > maxBatchNewsIDs {
+		http.Error(w, "Too many news IDs", http.StatusBadRequest)
+		return
+	}
+	maxNodes := defaultBatchNodeLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("max_nodes")); err == nil && v > 0 {
+		maxNodes = v
+	}
+	if maxNodes > commentsMaxNodes {
+		maxNodes = commentsMaxNodes
+	}
+	language, err := parseLanguageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := BatchCommentsResponse{
+		Results: make(map[string]NewsComments),
+		Errors:  make(map[string]string),
+	}
+	var ids []int
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			response.Errors[s] = "invalid news ID"
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 && len(response.Errors) == 0 {
+		http.Error(w, "news_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	byNews, err := getCommentsByNewsIDs(ids)
+	if err != nil {
+		log.Printf("Ошибка пакетного получения комментариев: %v", err)
+		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
+		return
+	}
+
+	// Бюджет байт делится между новостями, чтобы ответ в целом
+	// оставался в пределах COMMENTS_MAX_BYTES
+	maxBytes := commentsMaxBytes
+	if len(ids) > 0 {
+		maxBytes = commentsMaxBytes / len(ids)
+	}
+	for _, id := range ids {
+		comments := byNews[id]
+		page, next, _ := paginateCommentTree(comments, "", language, maxNodes, maxBytes)
+		if page == nil {
+			page = []Comment{}
+		}
+		response.Results[strconv.Itoa(id)] = NewsComments{
+			Comments:     page,
+			Total:        len(comments),
+			Continuation: next,
+		}
+	}
+
+	log.Printf("Пакетное получение комментариев для %d новостей, request_id: %s", len(ids), requestID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getCommentsByNewsIDs получает одобренные комментарии нескольких
+// новостей одним запросом
+func getCommentsByNewsIDs(ids []int) (map[int][]Comment, error) {
+	byNews := make(map[int][]Comment, len(ids))
+	if len(ids) == 0 {
+		return byNews, nil
+	}
+	rows, err := readDB.Query(`
+        SELECT id, news_id, parent_id, text, status, language, created_at
+        FROM comments
+        WHERE news_id = ANY($1) AND status = $2
+        ORDER BY created_at ASC
+    `, pq.Array(ids), statusApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.NewsID, &c.ParentID, &c.Text, &c.Status, &c.Language, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		byNews[c.NewsID] = append(byNews[c.NewsID], c)
+	}
+	return byNews, rows.Err()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Массовая модерация с окном отмены: действие над списком комментариев
+// ставится в очередь и применяется только через moderationUndoWindow.
+// До этого момента модератор может отменить его, и комментарии
+// останутся нетронутыми. Очередь хранится в БД и переживает перезапуск.
+
+// Состояния пакетного действия
+const (
+	batchQueued    = "queued"
+	batchCommitted = "committed"
+	batchReverted  = "reverted"
+)
+
+const maxBulkComments = 500
+
+var moderationUndoWindow = 30 * time.Second
+
+// BulkModerationRequest тело POST /moderation/bulk
+type BulkModerationRequest struct {
+	Action     string `json:"action"`
+	CommentIDs []int  `json:"comment_ids"`
+}
+
+// ModerationBatch пакетное действие модератора
+type ModerationBatch struct {
+	ID          int        `json:"id"`
+	Moderator   string     `json:"moderator"`
+	Action      string     `json:"action"`
+	CommentIDs  []int      `json:"comment_ids"`
+	State       string     `json:"state"`
+	CommitAt    time.Time  `json:"commit_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// bulkActionStatus статус, в который действие переводит комментарии
+var bulkActionStatus = map[string]string{
+	"approve": statusApproved,
+	"reject":  statusRejected,
+	"delete":  statusDeleted,
+}
+
+const batchColumns = `id, moderator, action, comment_ids, state, commit_at, created_at, completed_at`
+
+func scanBatch(row interface{ Scan(...interface{}) error }) (*ModerationBatch, error) {
+	var b ModerationBatch
+	var ids pq.Int64Array
+	if err := row.Scan(&b.ID, &b.Moderator, &b.Action, &ids, &b.State, &b.CommitAt, &b.CreatedAt, &b.CompletedAt); err != nil {
+		return nil, err
+	}
+	b.CommentIDs = make([]int, len(ids))
+	for i, id := range ids {
+		b.CommentIDs[i] = int(id)
+	}
+	return &b, nil
+}
+
+// bulkModerationHandler ставит в очередь пакетное действие (POST /moderation/bulk)
+func bulkModerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	moderator := strings.TrimSpace(r.Header.Get("X-Moderator"))
+	if moderator == "" {
+		http.Error(w, "X-Moderator header is required", http.StatusUnauthorized)
+		return
+	}
+
+	var req BulkModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if _, ok := bulkActionStatus[req.Action]; !ok {
+		http.Error(w, "Action must be approve, reject or delete", http.StatusBadRequest)
+		return
+	}
+	ids := uniqueIDs(req.CommentIDs)
+	if len(ids) == 0 || len(ids) > maxBulkComments {
+		http.Error(w, "comment_ids must contain from 1 to 500 IDs", http.StatusBadRequest)
+		return
+	}
+
+	// Все комментарии пакета должны существовать и входить в назначения
+	var found, allowed int
+	err := db.QueryRow(`
+        SELECT COUNT(*), COUNT(*) FILTER (WHERE `+assignedCondition+`)
+        FROM comments c
+        WHERE c.id = ANY($2)
+    `, moderator, pq.Array(ids)).Scan(&found, &allowed)
+	if err != nil {
+		log.Printf("Ошибка проверки пакета модерации: %v", err)
+		http.Error(w, "Failed to queue bulk action", http.StatusInternalServerError)
+		return
+	}
+	if found != len(ids) {
+		http.Error(w, "Some comments not found", http.StatusNotFound)
+		return
+	}
+	if allowed != len(ids) {
+		log.Printf("Модератор %s не назначен на часть комментариев пакета, request_id: %s", moderator, requestID)
+		http.Error(w, "Some comments are outside of moderator assignments", http.StatusForbidden)
+		return
+	}
+
+	batch, err := scanBatch(db.QueryRow(`
+        INSERT INTO moderation_batches (moderator, action, comment_ids, commit_at)
+        VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+        RETURNING `+batchColumns,
+		moderator, req.Action, pq.Array(ids), int(moderationUndoWindow.Seconds())))
+	if err != nil {
+		log.Printf("Ошибка сохранения пакета модерации: %v", err)
+		http.Error(w, "Failed to queue bulk action", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Модератор %s поставил в очередь %s для %d комментариев (пакет %d), request_id: %s",
+		moderator, req.Action, len(ids), batch.ID, requestID)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(batch)
+}
+
+// bulkBatchHandler состояние пакета (GET) и отмена (DELETE)
+// по /moderation/bulk/{id}; модератор видит только свои пакеты
+func bulkBatchHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	moderator := strings.TrimSpace(r.Header.Get("X-Moderator"))
+	if moderator == "" {
+		http.Error(w, "X-Moderator header is required", http.StatusUnauthorized)
+		return
+	}
+	batchID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/moderation/bulk/"))
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return
+	}
+
+	var batch *ModerationBatch
+	switch r.Method {
+	case http.MethodGet:
+		batch, err = scanBatch(db.QueryRow(`
+            SELECT `+batchColumns+`
+            FROM moderation_batches
+            WHERE id = $1 AND moderator = $2
+        `, batchID, moderator))
+
+	case http.MethodDelete:
+		// Строку пакета, который сейчас применяется, держит блокировка
+		// commitDueBatches; после её снятия состояние уже committed
+		batch, err = scanBatch(db.QueryRow(`
+            UPDATE moderation_batches
+            SET state = $3, completed_at = NOW()
+            WHERE id = $1 AND moderator = $2 AND state = $4
+            RETURNING `+batchColumns,
+			batchID, moderator, batchReverted, batchQueued))
+		if err == sql.ErrNoRows {
+			var exists bool
+			if db.QueryRow("SELECT EXISTS(SELECT 1 FROM moderation_batches WHERE id = $1 AND moderator = $2)",
+				batchID, moderator).Scan(&exists); exists {
+				http.Error(w, "Batch is already committed or reverted", http.StatusConflict)
+				return
+			}
+		}
+		if err == nil {
+			log.Printf("Модератор %s отменил пакет %d, request_id: %s", moderator, batchID, requestID)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка работы с пакетом модерации %d: %v", batchID, err)
+		http.Error(w, "Failed to process batch", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(batch)
+}
+
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// runBatchCommitter периодически применяет пакеты с истёкшим окном отмены
+func runBatchCommitter(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for {
+			committed, err := commitDueBatch()
+			if err != nil {
+				log.Printf("Ошибка применения пакета модерации: %v", err)
+				break
+			}
+			if !committed {
+				break
+			}
+		}
+	}
+}
+
+// commitDueBatch применяет один пакет, у которого истекло окно отмены.
+// Возвращает false, если таких пакетов нет.
+func commitDueBatch() (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	batch, err := scanBatch(tx.QueryRow(`
+        SELECT `+batchColumns+`
+        FROM moderation_batches
+        WHERE state = $1 AND commit_at <= NOW()
+        ORDER BY commit_at
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    `, batchQueued))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(`
+        UPDATE comments
+        SET status = $2, moderated_by = $3, moderated_at = NOW()
+        WHERE id = ANY($1)
+    `, pq.Array(batch.CommentIDs), bulkActionStatus[batch.Action], batch.Moderator)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`
+        UPDATE moderation_batches SET state = $2, completed_at = NOW() WHERE id = $1
+    `, batch.ID, batchCommitted); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	n, _ := result.RowsAffected()
+	log.Printf("Пакет %d применён: %s для %d комментариев, модератор %s", batch.ID, batch.Action, n, batch.Moderator)
+	return true, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Проверка согласованности ссылок на новости. Комментарии хранят
+// news_id без внешнего ключа: после удаления или скрытия новости в
+// news-service они остаются «сиротами». Задание раз в
+// CONSISTENCY_CHECK_INTERVAL_HOURS проходит по всем news_id, на которые
+// ссылаются комментарии, и проверяет их пакетным запросом
+// GET /news/batch. Действие с комментариями отсутствующих новостей
+// задаёт ORPHAN_ACTION:
+//   - flag    — комментарий помечается orphaned_at и остаётся как есть;
+//     если новость снова появилась, пометка снимается;
+//   - archive — комментарий переводится в статус archived и перестаёт
+//     выдаваться.
+//
+// Если отсутствующих новостей больше CONSISTENCY_MAX_ORPHAN_RATIO от
+// проверенных (например, news-service подключён к пустой базе), прогон
+// прерывается без изменений.
+
+const (
+	orphanFlag    = "flag"
+	orphanArchive = "archive"
+)
+
+// Размер пакета news_id в одном запросе к news-service (его maxBatchIDs)
+const consistencyChunk = 100
+
+// Сколько ID отсутствующих новостей сохраняется в отчёте прогона
+const maxReportedMissing = 100
+
+// ConsistencyRun результат прогона проверки
+type ConsistencyRun struct {
+	Action      string     `json:"action"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	NewsChecked int        `json:"news_checked"`
+	NewsMissing int        `json:"news_missing"`
+	// MissingIDs первые отсутствующие новости
+	MissingIDs []int  `json:"missing_ids,omitempty"`
+	Flagged    int    `json:"flagged"`
+	Archived   int    `json:"archived"`
+	Restored   int    `json:"restored"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OrphanSummary комментарии одной отсутствующей новости
+type OrphanSummary struct {
+	NewsID     int       `json:"news_id"`
+	Comments   int       `json:"comments"`
+	Archived   int       `json:"archived"`
+	OrphanedAt time.Time `json:"orphaned_at"`
+}
+
+// consistencyChecker выполняет не больше одного прогона одновременно
+type consistencyChecker struct {
+	newsURL        string
+	action         string
+	maxOrphanRatio float64
+	client         *http.Client
+
+	mu      sync.Mutex
+	running bool
+	last    *ConsistencyRun
+}
+
+var consistency *consistencyChecker
+
+var errConsistencyRunning = errors.New("проверка уже выполняется")
+
+func newConsistencyCheckerFromEnv() *consistencyChecker {
+	newsURL := os.Getenv("NEWS_SERVICE_URL")
+	if newsURL == "" {
+		newsURL = "http://news-service:8082"
+	}
+	action := os.Getenv("ORPHAN_ACTION")
+	if action != orphanArchive {
+		action = orphanFlag
+	}
+	ratio := 0.5
+	if v, err := strconv.ParseFloat(os.Getenv("CONSISTENCY_MAX_ORPHAN_RATIO"), 64); err == nil && v > 0 && v <= 1 {
+		ratio = v
+	}
+	return &consistencyChecker{
+		newsURL:        strings.TrimRight(newsURL, "/"),
+		action:         action,
+		maxOrphanRatio: ratio,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// runEvery запускает проверку с указанным периодом
+func (c *consistencyChecker) runEvery(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := c.run(); err != nil {
+			log.Printf("Ошибка проверки ссылок на новости: %v", err)
+		}
+	}
+}
+
+// run проверяет все news_id и применяет действие к комментариям отсутствующих новостей
+func (c *consistencyChecker) run() (ConsistencyRun, error) {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return ConsistencyRun{}, errConsistencyRunning
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	run := ConsistencyRun{Action: c.action, StartedAt: time.Now()}
+	err := c.check(&run)
+	now := time.Now()
+	run.FinishedAt = &now
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.running = false
+	c.last = &run
+	c.mu.Unlock()
+	return run, err
+}
+
+func (c *consistencyChecker) check(run *ConsistencyRun) error {
+	log.Printf("Начинаем проверку ссылок комментариев на новости (действие %s)", c.action)
+
+	// Сначала собираем отсутствующие новости целиком: решение о
+	// применении действия принимается по всему прогону
+	var missing, found []int
+	lastID := 0
+	for {
+		ids, err := referencedNewsIDs(lastID, consistencyChunk)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		lastID = ids[len(ids)-1]
+		chunkMissing, err := c.fetchMissing(ids)
+		if err != nil {
+			return fmt.Errorf("news-service: %v", err)
+		}
+		absent := make(map[int]bool, len(chunkMissing))
+		for _, id := range chunkMissing {
+			absent[id] = true
+		}
+		for _, id := range ids {
+			if absent[id] {
+				missing = append(missing, id)
+			} else {
+				found = append(found, id)
+			}
+		}
+		run.NewsChecked += len(ids)
+	}
+	run.NewsMissing = len(missing)
+	if len(missing) > maxReportedMissing {
+		run.MissingIDs = missing[:maxReportedMissing]
+	} else {
+		run.MissingIDs = missing
+	}
+
+	if run.NewsChecked > 0 && float64(len(missing))/float64(run.NewsChecked) > c.maxOrphanRatio {
+		return fmt.Errorf("отсутствуют %d из %d новостей — больше допустимой доли %.2f, изменения не применены",
+			len(missing), run.NewsChecked, c.maxOrphanRatio)
+	}
+
+	for start := 0; start < len(missing); start += consistencyChunk {
+		end := start + consistencyChunk
+		if end > len(missing) {
+			end = len(missing)
+		}
+		n, err := markOrphans(missing[start:end], c.action)
+		if err != nil {
+			return err
+		}
+		if c.action == orphanArchive {
+			run.Archived += n
+		} else {
+			run.Flagged += n
+		}
+	}
+	for start := 0; start < len(found); start += consistencyChunk {
+		end := start + consistencyChunk
+		if end > len(found) {
+			end = len(found)
+		}
+		n, err := clearOrphanFlags(found[start:end])
+		if err != nil {
+			return err
+		}
+		run.Restored += n
+	}
+
+	log.Printf("Проверка ссылок завершена: новостей %d, отсутствуют %d, помечено %d, архивировано %d, восстановлено %d",
+		run.NewsChecked, run.NewsMissing, run.Flagged, run.Archived, run.Restored)
+	return nil
+}
+
+// fetchMissing ID новостей, которых news-service не вернул
+func (c *consistencyChecker) fetchMissing(ids []int) ([]int, error) {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	resp, err := c.client.Get(c.newsURL + "/news/batch?ids=" + url.QueryEscape(strings.Join(parts, ",")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var batch struct {
+		Missing []int `json:"missing"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, err
+	}
+	return batch.Missing, nil
+}
+
+// referencedNewsIDs очередная порция news_id видимых комментариев после lastID
+func referencedNewsIDs(lastID, limit int) ([]int, error) {
+	rows, err := db.Query(`
+        SELECT DISTINCT news_id
+        FROM comments
+        WHERE status NOT IN ($1, $2) AND news_id > $3
+        ORDER BY news_id
+        LIMIT $4
+    `, statusDeleted, statusArchived, lastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// markOrphans помечает или архивирует комментарии отсутствующих новостей
+func markOrphans(newsIDs []int, action string) (int, error) {
+	query := `
+        UPDATE comments
+        SET orphaned_at = NOW()
+        WHERE news_id = ANY($1) AND status NOT IN ($2, $3) AND orphaned_at IS NULL
+    `
+	if action == orphanArchive {
+		query = `
+        UPDATE comments
+        SET status = $3, orphaned_at = COALESCE(orphaned_at, NOW())
+        WHERE news_id = ANY($1) AND status NOT IN ($2, $3)
+    `
+	}
+	res, err := db.Exec(query, pq.Array(newsIDs), statusDeleted, statusArchived)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// clearOrphanFlags снимает пометку с комментариев вновь найденных новостей;
+// архивированные комментарии не восстанавливаются
+func clearOrphanFlags(newsIDs []int) (int, error) {
+	res, err := db.Exec(`
+        UPDATE comments
+        SET orphaned_at = NULL
+        WHERE news_id = ANY($1) AND orphaned_at IS NOT NULL AND status <> $2
+    `, pq.Array(newsIDs), statusArchived)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// getOrphanSummaries комментарии-сироты по новостям
+func getOrphanSummaries() ([]OrphanSummary, error) {
+	rows, err := db.Query(`
+        SELECT news_id, COUNT(*), COUNT(*) FILTER (WHERE status = $1), MIN(orphaned_at)
+        FROM comments
+        WHERE orphaned_at IS NOT NULL
+        GROUP BY news_id
+        ORDER BY news_id
+    `, statusArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orphans := []OrphanSummary{}
+	for rows.Next() {
+		var o OrphanSummary
+		if err := rows.Scan(&o.NewsID, &o.Comments, &o.Archived, &o.OrphanedAt); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
+
+// consistencyCheckHandler POST запускает проверку и возвращает её результат
+func consistencyCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, err := consistency.run()
+	if err == errConsistencyRunning {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// прерванный прогон тоже возвращается: в нём видно, на каком этапе и почему он остановился
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err != nil {
+		log.Printf("Ошибка проверки ссылок на новости: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// consistencyReportHandler отчёт: последний прогон и комментарии-сироты по новостям
+func consistencyReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orphans, err := getOrphanSummaries()
+	if err != nil {
+		log.Printf("Ошибка получения комментариев-сирот: %v", err)
+		http.Error(w, "Failed to get report", http.StatusInternalServerError)
+		return
+	}
+
+	consistency.mu.Lock()
+	report := map[string]interface{}{
+		"action":   consistency.action,
+		"running":  consistency.running,
+		"last_run": consistency.last,
+		"orphans":  orphans,
+	}
+	consistency.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Пулы соединений с БД. Чтение веток комментариев идёт через отдельный
+// пул readDB, а создание комментариев, модерация и фоновые задания —
+// через db. Поток записей под вирусной новостью занимает только свой
+// пул, и чтение веток не ждёт свободного соединения. Размеры пулов
+// задаются DB_WRITE_MAX_OPEN / DB_WRITE_MAX_IDLE (по умолчанию 10 и 5)
+// и DB_READ_MAX_OPEN / DB_READ_MAX_IDLE (20 и 10), время жизни
+// соединения — DB_CONN_MAX_LIFETIME_SEC (300). Если заданы
+// DB_READ_HOST и остальные DB_READ_*, читающий пул подключается к
+// реплике, иначе — к той же БД.
+
+var readDB *sql.DB
+
+// poolConfig размеры пула соединений
+type poolConfig struct {
+	maxOpen  int
+	maxIdle  int
+	lifetime time.Duration
+}
+
+func envPositive(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// envOr значение переменной или def, если она не задана
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func openPool(connStr string, cfg poolConfig) (*sql.DB, error) {
+	pool, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxOpenConns(cfg.maxOpen)
+	pool.SetMaxIdleConns(cfg.maxIdle)
+	pool.SetConnMaxLifetime(cfg.lifetime)
+	if err := pool.Ping(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// openDatabases открывает пишущий и читающий пулы
+func openDatabases(host, port, user, password, name string) error {
+	lifetime := time.Duration(envPositive("DB_CONN_MAX_LIFETIME_SEC", 300)) * time.Second
+	dsn := func(host, port, user, password, name string) string {
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable client_encoding=UTF8",
+			host, port, user, password, name)
+	}
+
+	var err error
+	db, err = openPool(dsn(host, port, user, password, name), poolConfig{
+		maxOpen:  envPositive("DB_WRITE_MAX_OPEN", 10),
+		maxIdle:  envPositive("DB_WRITE_MAX_IDLE", 5),
+		lifetime: lifetime,
+	})
+	if err != nil {
+		return fmt.Errorf("пишущий пул: %v", err)
+	}
+	readDB, err = openPool(dsn(
+		envOr("DB_READ_HOST", host),
+		envOr("DB_READ_PORT", port),
+		envOr("DB_READ_USER", user),
+		envOr("DB_READ_PASSWORD", password),
+		envOr("DB_READ_NAME", name),
+	), poolConfig{
+		maxOpen:  envPositive("DB_READ_MAX_OPEN", 20),
+		maxIdle:  envPositive("DB_READ_MAX_IDLE", 10),
+		lifetime: lifetime,
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("читающий пул: %v", err)
+	}
+	return nil
+}
+
+// metricsHandler GET /metrics — состояние пулов соединений в формате
+// Prometheus
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pools := []struct {
+		name  string
+		stats sql.DBStats
+	}{
+		{"write", db.Stats()},
+		{"read", readDB.Stats()},
+	}
+	metrics := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) float64
+	}{
+		{"comments_db_pool_max_open", "gauge", "Наибольшее число соединений пула",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"comments_db_pool_open", "gauge", "Открытые соединения",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"comments_db_pool_in_use", "gauge", "Занятые соединения",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"comments_db_pool_idle", "gauge", "Свободные соединения",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"comments_db_pool_wait_total", "counter", "Сколько раз запрос ждал свободного соединения",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"comments_db_pool_wait_seconds_total", "counter", "Суммарное ожидание свободного соединения",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, p := range pools {
+			fmt.Fprintf(w, "%s{pool=%q} %g\n", m.name, p.name, m.value(p.stats))
+		}
+	}
+}
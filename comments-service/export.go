@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Выгрузка данных пользователя по запросу на доступ (GDPR). Gateway
+// собирает архив /my/data постранично: страницы идут по возрастанию ID,
+// next_cursor — ID последнего комментария страницы, total — сколько
+// всего комментариев у пользователя (для отображения хода выгрузки).
+
+const (
+	defaultExportPageSize = 500
+	maxExportPageSize     = 1000
+)
+
+// ExportedComment комментарий пользователя в выгрузке
+type ExportedComment struct {
+	ID          int        `json:"id"`
+	NewsID      int        `json:"news_id"`
+	ParentID    *int       `json:"parent_id,omitempty"`
+	Text        string     `json:"text"`
+	Status      string     `json:"status"`
+	Category    string     `json:"category,omitempty"`
+	Language    string     `json:"language,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ModeratedAt *time.Time `json:"moderated_at,omitempty"`
+}
+
+// CommentsExportPage страница выгрузки комментариев
+type CommentsExportPage struct {
+	Username   string            `json:"username"`
+	Total      int               `json:"total"`
+	Comments   []ExportedComment `json:"comments"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// exportCommentsHandler GET /export/comments?cursor=&limit= — комментарии
+// пользователя из X-User
+func exportCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	username := strings.TrimSpace(r.Header.Get("X-User"))
+	if username == "" {
+		http.Error(w, "X-User header is required", http.StatusUnauthorized)
+		return
+	}
+	afterID := 0
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		afterID = id
+	}
+	limit := defaultExportPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxExportPageSize {
+			http.Error(w, "limit must be from 1 to 1000", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	page := CommentsExportPage{Username: username, Comments: []ExportedComment{}}
+	if err := db.QueryRow("SELECT COUNT(*) FROM comments WHERE author = $1", username).Scan(&page.Total); err != nil {
+		log.Printf("Ошибка подсчёта комментариев пользователя %s: %v", username, err)
+		http.Error(w, "Failed to export comments", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.Query(`
+        SELECT id, news_id, parent_id, text, status, category, language, created_at, moderated_at
+        FROM comments
+        WHERE author = $1 AND id > $2
+        ORDER BY id
+        LIMIT $3
+    `, username, afterID, limit+1)
+	if err != nil {
+		log.Printf("Ошибка выгрузки комментариев пользователя %s: %v", username, err)
+		http.Error(w, "Failed to export comments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ExportedComment
+		if err := rows.Scan(&c.ID, &c.NewsID, &c.ParentID, &c.Text, &c.Status, &c.Category,
+			&c.Language, &c.CreatedAt, &c.ModeratedAt); err != nil {
+			log.Printf("Ошибка чтения комментария при выгрузке: %v", err)
+			http.Error(w, "Failed to export comments", http.StatusInternalServerError)
+			return
+		}
+		page.Comments = append(page.Comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка выгрузки комментариев пользователя %s: %v", username, err)
+		http.Error(w, "Failed to export comments", http.StatusInternalServerError)
+		return
+	}
+	if len(page.Comments) > limit {
+		page.Comments = page.Comments[:limit]
+		page.NextCursor = strconv.Itoa(page.Comments[limit-1].ID)
+	}
+
+	log.Printf("Выгрузка комментариев пользователя %s: %d из %d, request_id: %s",
+		username, len(page.Comments), page.Total, requestID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(page)
+}
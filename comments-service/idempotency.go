@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Максимальная длина ключа идемпотентности
+const maxIdempotencyKeyLength = 512
+
+// idempotencyTTL время хранения ключей (IDEMPOTENCY_TTL_HOURS)
+var idempotencyTTL = 24 * time.Hour
+
+// errIdempotencyMismatch ключ уже использован с другим телом запроса
+var errIdempotencyMismatch = fmt.Errorf("idempotency key reused with different payload")
+
+// commentRequestHash отпечаток тела запроса, чтобы отличать повтор
+// от повторного использования ключа для другого комментария
+func commentRequestHash(req CommentRequest) string {
+	parent := ""
+	if req.ParentID != nil {
+		parent = fmt.Sprint(*req.ParentID)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s", req.NewsID, parent, req.Text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// findIdempotentComment ищет комментарий, созданный ранее с этим ключом
+func findIdempotentComment(key, requestHash string) (int, bool, error) {
+	var commentID int
+	var storedHash string
+	err := db.QueryRow(`
+        SELECT comment_id, request_hash
+        FROM idempotency_keys
+        WHERE key = $1 AND created_at > $2
+    `, key, time.Now().Add(-idempotencyTTL)).Scan(&commentID, &storedHash)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if storedHash != requestHash {
+		return 0, false, errIdempotencyMismatch
+	}
+	return commentID, true, nil
+}
+
+// insertComment сохраняет комментарий и, если задан ключ, привязывает его
+// к комментарию в одной транзакции. При гонке двух одинаковых запросов
+// второй получает ID комментария, созданного первым (replayed == true).
+func insertComment(req CommentRequest, key string) (commentID int, replayed bool, err error) {
+	language := detectLanguage(req.Text)
+	// approved от gateway означает, что текст уже прошёл цензуру: отметка
+	// проверки не даёт заданию заполнения модерации принять его за
+	// исторический комментарий
+	query := `
+        INSERT INTO comments (news_id, parent_id, text, created_at, status, category, language, author, censor_checked_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id
+    `
+	now := time.Now()
+	var checkedAt *time.Time
+	if req.Status == statusApproved {
+		checkedAt = &now
+	}
+	if key == "" {
+		err = db.QueryRow(query, req.NewsID, req.ParentID, req.Text, now, req.Status, req.Category, language, req.Author, checkedAt).Scan(&commentID)
+		return commentID, false, err
+	}
+
+	requestHash := commentRequestHash(req)
+	if id, found, err := findIdempotentComment(key, requestHash); err != nil || found {
+		return id, found, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND created_at <= $2",
+		key, time.Now().Add(-idempotencyTTL))
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = tx.QueryRow(query, req.NewsID, req.ParentID, req.Text, now, req.Status, req.Category, language, req.Author, checkedAt).Scan(&commentID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	result, err := tx.Exec(`
+        INSERT INTO idempotency_keys (key, request_hash, comment_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (key) DO NOTHING
+    `, key, requestHash, commentID)
+	if err != nil {
+		return 0, false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		tx.Rollback()
+		id, found, err := findIdempotentComment(key, requestHash)
+		if err == nil && !found {
+			err = fmt.Errorf("idempotency key %q disappeared", key)
+		}
+		return id, found, err
+	}
+
+	return commentID, false, tx.Commit()
+}
+
+// cleanupIdempotencyKeys периодически удаляет просроченные ключи
+func cleanupIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at <= $1", time.Now().Add(-idempotencyTTL))
+		if err != nil {
+			log.Printf("Ошибка очистки ключей идемпотентности: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Удалено просроченных ключей идемпотентности: %d", n)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Язык комментария определяется при создании и хранится в колонке
+// language (код ISO 639-1, пустой — не удалось определить). По нему
+// фильтруются выдачи (?language=ru). Определение эвристическое: по
+// письменности, для кириллицы — по буквам, характерным для языка, для
+// латиницы — по частым служебным словам. Модератор может запросить
+// перевод комментария через внешний API (TRANSLATION_URL).
+
+// Минимум букв для уверенного определения языка
+const minLanguageLetters = 8
+
+// languagePattern формат кода языка в параметрах запросов
+var languagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+// latinStopwords частые служебные слова языков с латиницей
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "this", "was", "for", "with", "you", "not"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "zu", "ein", "eine", "mit", "sie", "auf", "den", "auch"},
+	"fr": {"le", "la", "les", "et", "est", "un", "une", "des", "que", "pas", "je", "pour", "dans", "ce", "qui"},
+	"es": {"el", "los", "las", "y", "es", "un", "una", "que", "no", "por", "con", "para", "del", "se", "lo"},
+	"it": {"il", "lo", "gli", "e", "è", "un", "una", "che", "non", "per", "con", "del", "della", "sono", "di"},
+	"pl": {"i", "w", "nie", "się", "na", "jest", "to", "że", "do", "z", "jak", "ale", "co", "tak", "już"},
+}
+
+// detectLanguage код языка текста или пустая строка
+func detectLanguage(text string) string {
+	var letters, cyrillic, latin int
+	scripts := map[string]int{}
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// японский текст содержит и иероглифы, и кану
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for lang, n := range scripts {
+		if n > letters/2 && (lang != "zh" || scripts["ja"] == 0) {
+			return lang
+		}
+	}
+	if letters < minLanguageLetters {
+		return ""
+	}
+	lower := strings.ToLower(text)
+	switch {
+	case cyrillic > letters/2:
+		return detectCyrillic(lower)
+	case latin > letters/2:
+		return detectLatin(lower)
+	}
+	return ""
+}
+
+// detectCyrillic различает украинский, белорусский и русский
+func detectCyrillic(lower string) string {
+	switch {
+	case strings.ContainsAny(lower, "їєґ"):
+		return "uk"
+	case strings.ContainsRune(lower, 'ў'):
+		return "be"
+	case strings.ContainsRune(lower, 'і') && !strings.ContainsAny(lower, "ыэъ"):
+		return "uk"
+	}
+	return "ru"
+}
+
+// detectLatin выбирает язык с наибольшей долей служебных слов
+func detectLatin(lower string) string {
+	words := strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	best, bestScore := "", 0
+	for lang, stopwords := range latinStopwords {
+		set := make(map[string]bool, len(stopwords))
+		for _, w := range stopwords {
+			set[w] = true
+		}
+		score := 0
+		for _, w := range words {
+			if set[w] {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && score > 0 && lang < best) {
+			best, bestScore = lang, score
+		}
+	}
+	return best
+}
+
+// parseLanguageParam читает ?language=; пустая строка — без фильтра
+func parseLanguageParam(r *http.Request) (string, error) {
+	lang := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("language")))
+	if lang != "" && !languagePattern.MatchString(lang) {
+		return "", fmt.Errorf("language must be a two-letter ISO 639-1 code")
+	}
+	return lang, nil
+}
+
+// translator внешний сервис перевода
+type translator interface {
+	translate(text, source, target string) (string, error)
+}
+
+// httpTranslator вызывает TRANSLATION_URL: POST {"text", "source",
+// "target"} и ожидает {"translated_text"}. Ключ TRANSLATION_API_KEY
+// передаётся в заголовке Authorization: Bearer.
+type httpTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (t *httpTranslator) translate(text, source, target string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"text": text, "source": source, "target": target})
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("сервис перевода вернул статус %d", resp.StatusCode)
+	}
+	var result struct {
+		TranslatedText string `json:"translated_text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("ошибка разбора ответа сервиса перевода: %v", err)
+	}
+	return result.TranslatedText, nil
+}
+
+var translation translator
+
+// Язык перевода без параметра target
+const defaultTranslationTarget = "ru"
+
+// newTranslatorFromEnv nil, если TRANSLATION_URL не задан
+func newTranslatorFromEnv() translator {
+	u := os.Getenv("TRANSLATION_URL")
+	if u == "" {
+		return nil
+	}
+	timeout := 10
+	if v, err := strconv.Atoi(os.Getenv("TRANSLATION_TIMEOUT_SEC")); err == nil && v > 0 {
+		timeout = v
+	}
+	return &httpTranslator{
+		url:    u,
+		apiKey: os.Getenv("TRANSLATION_API_KEY"),
+		client: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}
+}
+
+// CommentTranslation перевод комментария для модератора
+type CommentTranslation struct {
+	CommentID      int    `json:"comment_id"`
+	SourceLanguage string `json:"source_language"`
+	TargetLanguage string `json:"target_language"`
+	Text           string `json:"text"`
+	TranslatedText string `json:"translated_text"`
+}
+
+// commentTranslationHandler GET /moderation/comments/{id}/translation?target=ru
+// переводит комментарий в пределах назначений модератора. Комментарий
+// на целевом языке возвращается без обращения к сервису перевода.
+func commentTranslationHandler(w http.ResponseWriter, r *http.Request, commentID int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	moderator := strings.TrimSpace(r.Header.Get("X-Moderator"))
+	if moderator == "" {
+		http.Error(w, "X-Moderator header is required", http.StatusUnauthorized)
+		return
+	}
+	target := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("target")))
+	if target == "" {
+		target = defaultTranslationTarget
+	}
+	if !languagePattern.MatchString(target) {
+		http.Error(w, "target must be a two-letter ISO 639-1 code", http.StatusBadRequest)
+		return
+	}
+
+	var allowed bool
+	tr := CommentTranslation{CommentID: commentID, TargetLanguage: target}
+	err := db.QueryRow(`
+        SELECT `+assignedCondition+`, c.text, c.language
+        FROM comments c
+        WHERE c.id = $2
+    `, moderator, commentID).Scan(&allowed, &tr.Text, &tr.SourceLanguage)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения комментария %d для перевода: %v", commentID, err)
+		http.Error(w, "Failed to translate comment", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Comment is outside of moderator assignments", http.StatusForbidden)
+		return
+	}
+
+	if tr.SourceLanguage == target {
+		tr.TranslatedText = tr.Text
+	} else {
+		if translation == nil {
+			http.Error(w, "Translation is not configured", http.StatusNotImplemented)
+			return
+		}
+		tr.TranslatedText, err = translation.translate(tr.Text, tr.SourceLanguage, target)
+		if err != nil {
+			log.Printf("Ошибка перевода комментария %d: %v, request_id: %s", commentID, err, requestID)
+			http.Error(w, "Translation service failed", http.StatusBadGateway)
+			return
+		}
+		log.Printf("Комментарий %d переведён (%s → %s) для модератора %s, request_id: %s",
+			commentID, tr.SourceLanguage, target, moderator, requestID)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(tr)
+}
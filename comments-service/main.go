@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
@@ -18,19 +17,30 @@ import (
 
 // Comment структура комментария
 type Comment struct {
-	ID        int       `json:"id"`
-	NewsID    int       `json:"news_id"`
-	ParentID  *int      `json:"parent_id,omitempty"`
-	Text      string    `json:"text"`
+	ID       int    `json:"id"`
+	NewsID   int    `json:"news_id"`
+	ParentID *int   `json:"parent_id,omitempty"`
+	Text     string `json:"text"`
+	Status   string `json:"status"`
+	// Language код языка ISO 639-1, определённый при создании
+	Language  string    `json:"language,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	Children  []Comment `json:"children,omitempty"`
 }
 
-// CommentRequest структура для создания комментария
+// CommentRequest структура для создания комментария.
+// Status задаёт gateway: pending, если цензуру не удалось выполнить.
+// Без X-Service-Token Status игнорируется и комментарий создаётся в
+// статусе pending до перепроверки.
 type CommentRequest struct {
 	NewsID   int    `json:"news_id"`
 	ParentID *int   `json:"parent_id,omitempty"`
 	Text     string `json:"text"`
+	Status   string `json:"status,omitempty"`
+	// Category категория новости — по ней назначаются модераторы
+	Category string `json:"category,omitempty"`
+	// Author имя пользователя из заголовка X-User, который передаёт gateway
+	Author string `json:"-"`
 }
 
 var db *sql.DB
@@ -110,30 +120,85 @@ func main() {
 		log.Fatal("Необходимо задать все переменные окружения: DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME")
 	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable client_encoding=UTF8",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
-
-	var err error
-	db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatal("Ошибка подключения к БД:", err)
-	}
-	defer db.Close()
-	if err = db.Ping(); err != nil {
+	if err := openDatabases(dbHost, dbPort, dbUser, dbPassword, dbName); err != nil {
 		log.Fatal("Не удается подключиться к БД:", err)
 	}
+	defer db.Close()
+	defer readDB.Close()
 
-	_, err = db.Exec("SET client_encoding TO 'UTF8'")
+	_, err := db.Exec("SET client_encoding TO 'UTF8'")
 	if err != nil {
 		log.Printf("Предупреждение: не удалось установить кодировку UTF-8: %v", err)
 	}
 
+	censorURL := os.Getenv("CENSORSHIP_URL")
+	if censorURL == "" {
+		censorURL = "http://censorship-service:8083/censor"
+	}
+	maxAttempts := 5
+	if v, err := strconv.Atoi(os.Getenv("PENDING_MAX_ATTEMPTS")); err == nil && v > 0 {
+		maxAttempts = v
+	}
+	recheckHour := 3
+	if v, err := strconv.Atoi(os.Getenv("PENDING_RECHECK_HOUR")); err == nil && v >= 0 && v < 24 {
+		recheckHour = v
+	}
+	rechecker = newPendingRechecker(censorURL, maxAttempts)
+	go rechecker.runNightly(recheckHour)
+
+	if v, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS")); err == nil && v > 0 {
+		idempotencyTTL = time.Duration(v) * time.Hour
+	}
+	go cleanupIdempotencyKeys(time.Hour)
+
+	if v, err := strconv.Atoi(os.Getenv("COMMENTS_MAX_NODES")); err == nil && v > 0 {
+		commentsMaxNodes = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("COMMENTS_MAX_BYTES")); err == nil && v > 0 {
+		commentsMaxBytes = v
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("MODERATION_UNDO_SECONDS")); err == nil && v > 0 {
+		moderationUndoWindow = time.Duration(v) * time.Second
+	}
+	go runBatchCommitter(time.Second)
+
+	translation = newTranslatorFromEnv()
+	snapshotStore = newObjectStoreFromEnv()
+
+	consistency = newConsistencyCheckerFromEnv()
+	consistencyInterval := 24
+	if v, err := strconv.Atoi(os.Getenv("CONSISTENCY_CHECK_INTERVAL_HOURS")); err == nil && v >= 0 {
+		consistencyInterval = v
+	}
+	if consistencyInterval > 0 {
+		go consistency.runEvery(time.Duration(consistencyInterval) * time.Hour)
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/comments", commentsHandler)
 	mux.HandleFunc("/comments/", getCommentsByNewsHandler)
+	mux.HandleFunc("/comments/activity", commentsActivityHandler)
 	mux.HandleFunc("/health", healthCheckHandler)
-	handler := requestIDMiddleware(mux)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/admin/pending/recheck", pendingRecheckHandler)
+	mux.HandleFunc("/admin/pending/stats", pendingStatsHandler)
+	mux.HandleFunc("/admin/backfill/moderation", moderationBackfillHandler)
+	mux.HandleFunc("/admin/consistency/check", consistencyCheckHandler)
+	mux.HandleFunc("/admin/consistency/report", consistencyReportHandler)
+	mux.HandleFunc("/admin/moderators", moderatorsAdminHandler)
+	mux.HandleFunc("/moderation/queue", moderationQueueHandler)
+	mux.HandleFunc("/moderation/comments/", moderateCommentHandler)
+	mux.HandleFunc("/moderation/bulk", bulkModerationHandler)
+	mux.HandleFunc("/moderation/bulk/", bulkBatchHandler)
+	mux.HandleFunc("/preferences/notifications", notificationPreferencesHandler)
+	mux.HandleFunc("/admin/notification-preferences", notificationPreferencesLookupHandler)
+	mux.HandleFunc("/export/comments", exportCommentsHandler)
+	mux.HandleFunc("/admin/snapshots", snapshotsHandler)
+	mux.HandleFunc("/admin/snapshots/", snapshotHandler)
+	handler := serviceAuthMiddleware(mux)
+	handler = requestIDMiddleware(handler)
 	handler = loggingMiddleware(handler)
 
 	log.Println("Сервис комментариев запущен на порту 8081")
@@ -145,6 +210,8 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		createCommentHandler(w, r)
+	case http.MethodGet:
+		getCommentsBatchHandler(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -171,6 +238,20 @@ func createCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Comment text is required", http.StatusBadRequest)
 		return
 	}
+	commentReq.Category = strings.ToLower(strings.TrimSpace(commentReq.Category))
+	commentReq.Author = strings.TrimSpace(r.Header.Get("X-User"))
+	if !serviceAuthorized(r) {
+		// статус после цензуры задаёт только gateway
+		commentReq.Status = statusPending
+	}
+	switch commentReq.Status {
+	case "":
+		commentReq.Status = statusApproved
+	case statusApproved, statusPending:
+	default:
+		http.Error(w, "Invalid comment status", http.StatusBadRequest)
+		return
+	}
 
 	// Проверяем существование родительского комментария если указан
 	if commentReq.ParentID != nil {
@@ -182,14 +263,17 @@ func createCommentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var commentID int
-	query := `
-        INSERT INTO comments (news_id, parent_id, text, created_at)
-        VALUES ($1, $2, $3, $4)
-        RETURNING id
-    `
-	err = db.QueryRow(query, commentReq.NewsID, commentReq.ParentID, commentReq.Text,
-		time.Now()).Scan(&commentID)
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
+	commentID, replayed, err := insertComment(commentReq, idempotencyKey)
+	if err == errIdempotencyMismatch {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		log.Printf("Ошибка сохранения комментария: %v", err)
 		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
@@ -204,10 +288,14 @@ func createCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Создан новый комментарий: ID=%d, NewsID=%d, Text=%s, request_id=%s",
-		comment.ID, comment.NewsID, comment.Text, requestID)
-
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if replayed {
+		log.Printf("Повтор запроса с Idempotency-Key, возвращаем комментарий ID=%d, request_id=%s", comment.ID, requestID)
+		w.Header().Set("Idempotent-Replayed", "true")
+	} else {
+		log.Printf("Создан новый комментарий: ID=%d, NewsID=%d, Status=%s, Text=%s, request_id=%s",
+			comment.ID, comment.NewsID, comment.Status, comment.Text, requestID)
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(comment)
 }
@@ -234,6 +322,12 @@ func getCommentsByNewsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	language, err := parseLanguageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("Получение комментариев для новости ID: %d, request_id: %s", newsID, requestID)
 
 	comments, err := getCommentsByNewsID(newsID)
@@ -243,13 +337,21 @@ func getCommentsByNewsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Строим дерево комментариев
-	commentTree := buildCommentTree(comments)
+	// Строим дерево комментариев; большие деревья отдаются частями
+	commentTree, next, err := paginateCommentTree(comments, r.URL.Query().Get("continuation"), language,
+		commentsMaxNodes, commentsMaxBytes)
+	if err != nil {
+		http.Error(w, "Invalid continuation token", http.StatusBadRequest)
+		return
+	}
 
 	log.Printf("Найдено комментариев: %d (всего %d) для новости %d, request_id: %s",
 		len(commentTree), len(comments), newsID, requestID)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if next != "" {
+		w.Header().Set("X-Continuation-Token", next)
+	}
 	json.NewEncoder(w).Encode(commentTree)
 }
 
@@ -272,6 +374,12 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		status["database"] = "connected"
 	}
+	if err := readDB.Ping(); err != nil {
+		status["status"] = "error"
+		status["read_database"] = "disconnected"
+	} else {
+		status["read_database"] = "connected"
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(status)
@@ -280,7 +388,7 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 // getCommentByID получает комментарий по ID
 func getCommentByID(id int) (*Comment, error) {
 	query := `
-        SELECT id, news_id, parent_id, text, created_at
+        SELECT id, news_id, parent_id, text, status, language, created_at
         FROM comments
         WHERE id = $1
     `
@@ -291,22 +399,24 @@ func getCommentByID(id int) (*Comment, error) {
 		&comment.NewsID,
 		&comment.ParentID,
 		&comment.Text,
+		&comment.Status,
+		&comment.Language,
 		&comment.CreatedAt,
 	)
 
 	return comment, err
 }
 
-// getCommentsByNewsID получает все комментарии для новости
+// getCommentsByNewsID получает все одобренные комментарии для новости
 func getCommentsByNewsID(newsID int) ([]Comment, error) {
 	query := `
-        SELECT id, news_id, parent_id, text, created_at
+        SELECT id, news_id, parent_id, text, status, language, created_at
         FROM comments
-        WHERE news_id = $1
+        WHERE news_id = $1 AND status = $2
         ORDER BY created_at ASC
     `
 
-	rows, err := db.Query(query, newsID)
+	rows, err := readDB.Query(query, newsID, statusApproved)
 	if err != nil {
 		return nil, err
 	}
@@ -320,6 +430,8 @@ func getCommentsByNewsID(newsID int) ([]Comment, error) {
 			&comment.NewsID,
 			&comment.ParentID,
 			&comment.Text,
+			&comment.Status,
+			&comment.Language,
 			&comment.CreatedAt,
 		)
 		if err != nil {
@@ -331,30 +443,48 @@ func getCommentsByNewsID(newsID int) ([]Comment, error) {
 	return comments, nil
 }
 
-// buildCommentTree строит дерево комментариев
+// buildCommentTree строит дерево комментариев.
+// Комментарии без найденного родителя отбрасываются.
 func buildCommentTree(comments []Comment) []Comment {
 	if len(comments) == 0 {
 		return []Comment{}
 	}
-	commentMap := make(map[int]*Comment)
-	for i := range comments {
-		comments[i].Children = make([]Comment, 0)
-		commentMap[comments[i].ID] = &comments[i]
-	}
-	for i := range comments {
-		if comments[i].ParentID != nil {
-			parentID := *comments[i].ParentID
-			if parent, exists := commentMap[parentID]; exists {
-				parent.Children = append(parent.Children, comments[i])
-			}
+	return assembleTree(comments, func(c Comment, present map[int]bool) bool {
+		return c.ParentID == nil
+	})
+}
+
+// assembleTree собирает лес из плоского списка с сохранением порядка.
+// isRoot решает, какие узлы становятся корнями; остальные вешаются
+// на родителя из списка (если он есть) на любую глубину.
+func assembleTree(comments []Comment, isRoot func(c Comment, present map[int]bool) bool) []Comment {
+	present := make(map[int]bool, len(comments))
+	for _, c := range comments {
+		present[c.ID] = true
+	}
+	children := make(map[int][]int)
+	var rootIdx []int
+	for i, c := range comments {
+		if isRoot(c, present) {
+			rootIdx = append(rootIdx, i)
+		} else if c.ParentID != nil && present[*c.ParentID] {
+			children[*c.ParentID] = append(children[*c.ParentID], i)
 		}
 	}
-	var roots []Comment
-	for i := range comments {
-		if comments[i].ParentID == nil {
-			roots = append(roots, comments[i])
+
+	var build func(i int) Comment
+	build = func(i int) Comment {
+		c := comments[i]
+		c.Children = make([]Comment, 0, len(children[c.ID]))
+		for _, ci := range children[c.ID] {
+			c.Children = append(c.Children, build(ci))
 		}
+		return c
 	}
 
+	roots := make([]Comment, 0, len(rootIdx))
+	for _, i := range rootIdx {
+		roots = append(roots, build(i))
+	}
 	return roots
 }
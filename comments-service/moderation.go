@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Делегирование модерации: модератор видит и обрабатывает только
+// комментарии к назначенным ему новостям или категориям новостей.
+// Имя модератора передаёт gateway в заголовке X-Moderator.
+
+const moderationQueueLimit = 100
+
+// ModeratorAssignment назначение модератора на новость или категорию
+type ModeratorAssignment struct {
+	ID        int       `json:"id"`
+	Moderator string    `json:"moderator"`
+	NewsID    *int      `json:"news_id,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerationAction решение модератора по комментарию
+type ModerationAction struct {
+	Action string `json:"action"`
+}
+
+// moderatorsAdminHandler управляет назначениями:
+// GET ?moderator= — список, POST — создать, DELETE ?id= — удалить
+func moderatorsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		assignments, err := listAssignments(r.URL.Query().Get("moderator"))
+		if err != nil {
+			log.Printf("Ошибка получения назначений модераторов: %v", err)
+			http.Error(w, "Failed to get assignments", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(assignments)
+
+	case http.MethodPost:
+		var a ModeratorAssignment
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		a.Moderator = strings.TrimSpace(a.Moderator)
+		a.Category = strings.ToLower(strings.TrimSpace(a.Category))
+		if a.Moderator == "" {
+			http.Error(w, "Moderator is required", http.StatusBadRequest)
+			return
+		}
+		if (a.NewsID == nil) == (a.Category == "") {
+			http.Error(w, "Exactly one of news_id or category is required", http.StatusBadRequest)
+			return
+		}
+		err := db.QueryRow(`
+            INSERT INTO moderator_assignments (moderator, news_id, category)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (moderator, (COALESCE(news_id, 0)), category) DO UPDATE SET moderator = EXCLUDED.moderator
+            RETURNING id, created_at
+        `, a.Moderator, a.NewsID, a.Category).Scan(&a.ID, &a.CreatedAt)
+		if err != nil {
+			log.Printf("Ошибка сохранения назначения модератора: %v", err)
+			http.Error(w, "Failed to save assignment", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Модератор %s назначен: news_id=%v, category=%q", a.Moderator, a.NewsID, a.Category)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid assignment ID", http.StatusBadRequest)
+			return
+		}
+		result, err := db.Exec("DELETE FROM moderator_assignments WHERE id = $1", id)
+		if err != nil {
+			log.Printf("Ошибка удаления назначения модератора: %v", err)
+			http.Error(w, "Failed to delete assignment", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Assignment not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listAssignments(moderator string) ([]ModeratorAssignment, error) {
+	rows, err := db.Query(`
+        SELECT id, moderator, news_id, category, created_at
+        FROM moderator_assignments
+        WHERE $1 = '' OR moderator = $1
+        ORDER BY moderator, id
+    `, moderator)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []ModeratorAssignment{}
+	for rows.Next() {
+		var a ModeratorAssignment
+		if err := rows.Scan(&a.ID, &a.Moderator, &a.NewsID, &a.Category, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// assignedCondition условие «комментарий c входит в назначения модератора $1»
+const assignedCondition = `EXISTS (
+            SELECT 1 FROM moderator_assignments a
+            WHERE a.moderator = $1
+              AND (a.news_id = c.news_id OR (a.category <> '' AND a.category = c.category))
+        )`
+
+// moderationQueueHandler очередь модератора: pending и dead_letter
+// комментарии в пределах его назначений, постранично по after_id
+func moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	moderator := strings.TrimSpace(r.Header.Get("X-Moderator"))
+	if moderator == "" {
+		http.Error(w, "X-Moderator header is required", http.StatusUnauthorized)
+		return
+	}
+	afterID, _ := strconv.Atoi(r.URL.Query().Get("after_id"))
+	language, err := parseLanguageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+        SELECT c.id, c.news_id, c.parent_id, c.text, c.status, c.language, c.created_at
+        FROM comments c
+        WHERE c.status IN ($2, $3) AND c.id > $4 AND `+assignedCondition+`
+            AND ($6 = '' OR c.language = $6)
+        ORDER BY c.id ASC
+        LIMIT $5
+    `, moderator, statusPending, statusDeadLetter, afterID, moderationQueueLimit, language)
+	if err != nil {
+		log.Printf("Ошибка получения очереди модерации: %v", err)
+		http.Error(w, "Failed to get moderation queue", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	queue := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.NewsID, &c.ParentID, &c.Text, &c.Status, &c.Language, &c.CreatedAt); err != nil {
+			http.Error(w, "Failed to get moderation queue", http.StatusInternalServerError)
+			return
+		}
+		queue = append(queue, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// moderateCommentHandler одобряет или отклоняет комментарий
+// (POST /moderation/comments/{id}), если он входит в назначения модератора
+func moderateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if rest := strings.TrimPrefix(r.URL.Path, "/moderation/comments/"); strings.HasSuffix(rest, "/translation") {
+		commentID, err := strconv.Atoi(strings.TrimSuffix(rest, "/translation"))
+		if err != nil {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+		commentTranslationHandler(w, r, commentID)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	moderator := strings.TrimSpace(r.Header.Get("X-Moderator"))
+	if moderator == "" {
+		http.Error(w, "X-Moderator header is required", http.StatusUnauthorized)
+		return
+	}
+	commentID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/moderation/comments/"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var action ModerationAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	var status string
+	switch action.Action {
+	case "approve":
+		status = statusApproved
+	case "reject":
+		status = statusRejected
+	default:
+		http.Error(w, "Action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+
+	var allowed bool
+	err = db.QueryRow(`
+        SELECT `+assignedCondition+`
+        FROM comments c
+        WHERE c.id = $2
+    `, moderator, commentID).Scan(&allowed)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка проверки назначения модератора: %v", err)
+		http.Error(w, "Failed to moderate comment", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Printf("Модератор %s не назначен на комментарий %d, request_id: %s", moderator, commentID, requestID)
+		http.Error(w, "Comment is outside of moderator assignments", http.StatusForbidden)
+		return
+	}
+
+	_, err = db.Exec(`
+        UPDATE comments
+        SET status = $2, moderated_by = $3, moderated_at = NOW()
+        WHERE id = $1
+    `, commentID, status, moderator)
+	if err != nil {
+		log.Printf("Ошибка модерации комментария %d: %v", commentID, err)
+		http.Error(w, "Failed to moderate comment", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Модератор %s перевёл комментарий %d в статус %s, request_id: %s", moderator, commentID, status, requestID)
+
+	comment, err := getCommentByID(commentID)
+	if err != nil {
+		http.Error(w, "Comment moderated but failed to retrieve", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(comment)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Клиент S3-совместимого хранилища (AWS S3, MinIO и т. п.) для снимков
+// веток комментариев. Запросы подписываются AWS Signature V4, ссылки на
+// скачивание — предподписанные URL с ограниченным сроком действия.
+// Хранилище включается переменной SNAPSHOT_S3_BUCKET.
+
+// Наибольший срок действия предподписанной ссылки, допустимый в S3
+const maxPresignTTL = 7 * 24 * time.Hour
+
+type objectStore struct {
+	endpoint  string // https://s3.amazonaws.com или http://minio:9000
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	linkTTL   time.Duration
+	client    *http.Client
+}
+
+var snapshotStore *objectStore
+
+// newObjectStoreFromEnv читает SNAPSHOT_S3_*; nil, если бакет не задан
+func newObjectStoreFromEnv() *objectStore {
+	bucket := os.Getenv("SNAPSHOT_S3_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+	s := &objectStore{
+		endpoint:  strings.TrimRight(os.Getenv("SNAPSHOT_S3_ENDPOINT"), "/"),
+		bucket:    bucket,
+		region:    os.Getenv("SNAPSHOT_S3_REGION"),
+		accessKey: os.Getenv("SNAPSHOT_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("SNAPSHOT_S3_SECRET_KEY"),
+		prefix:    os.Getenv("SNAPSHOT_S3_PREFIX"),
+		linkTTL:   24 * time.Hour,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3.amazonaws.com"
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.prefix == "" {
+		s.prefix = "comment-snapshots/"
+	}
+	if v, err := strconv.Atoi(os.Getenv("SNAPSHOT_LINK_TTL_SEC")); err == nil && v > 0 {
+		s.linkTTL = time.Duration(v) * time.Second
+	}
+	if s.linkTTL > maxPresignTTL {
+		s.linkTTL = maxPresignTTL
+	}
+	return s
+}
+
+// put сохраняет объект под ключом key
+func (s *objectStore) put(key string, body []byte, contentType string) error {
+	canonicalURI := s.objectURI(key)
+	req, err := http.NewRequest(http.MethodPut, s.endpoint+canonicalURI, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, canonicalURI, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// presign предподписанная ссылка на скачивание объекта на linkTTL
+func (s *objectStore) presign(key string) (string, time.Time) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	canonicalURI := s.objectURI(key)
+	u, _ := url.Parse(s.endpoint + canonicalURI)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(s.linkTTL.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	rawQuery := canonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, canonicalURI, rawQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.signature(now, scope, amzDate, canonicalRequest)
+	return s.endpoint + canonicalURI + "?" + rawQuery + "&X-Amz-Signature=" + signature, now.Add(s.linkTTL)
+}
+
+func (s *objectStore) objectURI(key string) string {
+	return "/" + s3Escape(s.bucket, false) + "/" + s3Escape(key, true)
+}
+
+func (s *objectStore) sign(req *http.Request, canonicalURI string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Подписываются host, content-type и все x-amz-* заголовки
+	signed := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			signed[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalURI, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, s.signature(now, scope, amzDate, canonicalRequest)))
+}
+
+func (s *objectStore) signature(now time.Time, scope, amzDate, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape кодирует строку по правилам SigV4: без изменений остаются
+// только A-Z a-z 0-9 - _ . ~ (и '/', если keepSlash)
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
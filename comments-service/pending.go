@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Статусы модерации комментария
+const (
+	statusApproved   = "approved"
+	statusPending    = "pending"
+	statusRejected   = "rejected"
+	statusDeadLetter = "dead_letter"
+	statusDeleted    = "deleted"
+	// statusArchived комментарий к удалённой новости (проверка согласованности)
+	statusArchived = "archived"
+)
+
+// censorshipRequest тело запроса к censorship-service
+type censorshipRequest struct {
+	Text string `json:"text"`
+}
+
+// PendingJobStats метрики задания перепроверки
+type PendingJobStats struct {
+	Runs        int       `json:"runs"`
+	LastRunAt   time.Time `json:"last_run_at,omitempty"`
+	Checked     int       `json:"checked"`
+	Approved    int       `json:"approved"`
+	Rejected    int       `json:"rejected"`
+	Failed      int       `json:"failed"`
+	DeadLetters int       `json:"dead_letters"`
+}
+
+// PendingRun состояние прогона перепроверки
+type PendingRun struct {
+	State       string     `json:"state"` // running, completed, failed
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Checked     int        `json:"checked"`
+	Approved    int        `json:"approved"`
+	Rejected    int        `json:"rejected"`
+	Failed      int        `json:"failed"`
+	DeadLetters int        `json:"dead_letters"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// pendingRechecker повторно отправляет на цензуру комментарии,
+// застрявшие в статусе pending (например, пока censorship-service
+// был недоступен). После maxAttempts неудач комментарий помечается
+// как dead_letter и больше не перепроверяется автоматически.
+type pendingRechecker struct {
+	censorURL   string
+	maxAttempts int
+	batchSize   int
+	client      *http.Client
+
+	mu      sync.Mutex
+	running bool
+	stats   PendingJobStats
+	// last текущий или последний прогон
+	last *PendingRun
+}
+
+// errRecheckRunning прогон уже выполняется
+var errRecheckRunning = errors.New("перепроверка уже выполняется")
+
+var rechecker *pendingRechecker
+
+func newPendingRechecker(censorURL string, maxAttempts int) *pendingRechecker {
+	return &pendingRechecker{
+		censorURL:   censorURL,
+		maxAttempts: maxAttempts,
+		batchSize:   100,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// runNightly запускает перепроверку каждый день в указанный час (UTC)
+func (p *pendingRechecker) runNightly(hour int) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.Add(24 * time.Hour)
+		}
+		time.Sleep(time.Until(next))
+		if _, err := p.run(); err != nil {
+			log.Printf("Ошибка перепроверки pending-комментариев: %v", err)
+		}
+	}
+}
+
+// begin отмечает начало прогона; если прогон уже идёт, возвращает его
+// состояние и errRecheckRunning
+func (p *pendingRechecker) begin() (PendingRun, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return *p.last, errRecheckRunning
+	}
+	p.running = true
+	p.last = &PendingRun{State: "running", StartedAt: time.Now()}
+	return *p.last, nil
+}
+
+// start запускает прогон в фоне и возвращает его начальное состояние
+func (p *pendingRechecker) start() (PendingRun, error) {
+	run, err := p.begin()
+	if err != nil {
+		return run, err
+	}
+	go func() {
+		if _, err := p.process(); err != nil {
+			log.Printf("Ошибка перепроверки pending-комментариев: %v", err)
+		}
+	}()
+	return run, nil
+}
+
+// run обрабатывает все pending-комментарии и возвращает статистику прогона
+func (p *pendingRechecker) run() (PendingJobStats, error) {
+	if _, err := p.begin(); err != nil {
+		return PendingJobStats{}, err
+	}
+	return p.process()
+}
+
+// process выполняет прогон, начатый begin
+func (p *pendingRechecker) process() (run PendingJobStats, err error) {
+	defer func() {
+		p.mu.Lock()
+		p.running = false
+		p.progress(run)
+		now := time.Now()
+		p.last.FinishedAt = &now
+		p.last.State = "completed"
+		if err != nil {
+			p.last.State = "failed"
+			p.last.Error = err.Error()
+		}
+		p.mu.Unlock()
+	}()
+
+	log.Println("Начинаем перепроверку pending-комментариев...")
+	lastID := 0
+	for {
+		comments, err := getPendingComments(lastID, p.batchSize)
+		if err != nil {
+			return run, err
+		}
+		if len(comments) == 0 {
+			break
+		}
+		for _, c := range comments {
+			lastID = c.ID
+			run.Checked++
+			p.recheck(c, &run)
+		}
+		p.mu.Lock()
+		p.progress(run)
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.stats.Runs++
+	p.stats.LastRunAt = time.Now()
+	p.stats.Checked += run.Checked
+	p.stats.Approved += run.Approved
+	p.stats.Rejected += run.Rejected
+	p.stats.Failed += run.Failed
+	p.stats.DeadLetters += run.DeadLetters
+	p.mu.Unlock()
+
+	log.Printf("Перепроверка завершена: проверено %d, одобрено %d, отклонено %d, ошибок %d, в dead letter %d",
+		run.Checked, run.Approved, run.Rejected, run.Failed, run.DeadLetters)
+	return run, nil
+}
+
+func (p *pendingRechecker) recheck(c Comment, run *PendingJobStats) {
+	approved, err := p.censor(c.Text)
+	if errors.Is(err, errNeedsReview) {
+		// вердикт по fallback-политике censorship-service: попытка не
+		// засчитывается, комментарий ждёт восстановления внешнего API
+		return
+	}
+	if err != nil {
+		run.Failed++
+		attempts, dbErr := recordCensorFailure(c.ID, err.Error(), p.maxAttempts)
+		if dbErr != nil {
+			log.Printf("Ошибка обновления комментария %d: %v", c.ID, dbErr)
+			return
+		}
+		if attempts >= p.maxAttempts {
+			run.DeadLetters++
+			log.Printf("Комментарий %d перемещён в dead letter после %d попыток: %v", c.ID, attempts, err)
+		}
+		return
+	}
+
+	status := statusRejected
+	if approved {
+		status = statusApproved
+		run.Approved++
+	} else {
+		run.Rejected++
+	}
+	if err := setCommentStatus(c.ID, status); err != nil {
+		log.Printf("Ошибка обновления комментария %d: %v", c.ID, err)
+	}
+}
+
+// errNeedsReview censorship-service не вынес вердикт (внешний API модерации
+// недоступен) и оставил комментарий на ручную проверку
+var errNeedsReview = errors.New("требуется ручная проверка")
+
+// censor возвращает вердикт censorship-service или ошибку, если сервис недоступен
+func (p *pendingRechecker) censor(text string) (bool, error) {
+	body, _ := json.Marshal(censorshipRequest{Text: text})
+	resp, err := p.client.Post(p.censorURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusBadRequest:
+		return false, nil
+	case http.StatusAccepted:
+		return false, errNeedsReview
+	default:
+		return false, fmt.Errorf("censorship-service вернул статус %d", resp.StatusCode)
+	}
+}
+
+// progress переносит счётчики прогона в last; вызывается под mu
+func (p *pendingRechecker) progress(run PendingJobStats) {
+	p.last.Checked = run.Checked
+	p.last.Approved = run.Approved
+	p.last.Rejected = run.Rejected
+	p.last.Failed = run.Failed
+	p.last.DeadLetters = run.DeadLetters
+}
+
+func (p *pendingRechecker) snapshot() PendingJobStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// lastRun состояние текущего или последнего прогона; nil, если прогонов не было
+func (p *pendingRechecker) lastRun() *PendingRun {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last == nil {
+		return nil
+	}
+	run := *p.last
+	return &run
+}
+
+// getPendingComments возвращает очередную порцию pending-комментариев
+func getPendingComments(afterID, limit int) ([]Comment, error) {
+	query := `
+        SELECT id, news_id, parent_id, text, created_at
+        FROM comments
+        WHERE status = $1 AND id > $2
+        ORDER BY id ASC
+        LIMIT $3
+    `
+	rows, err := db.Query(query, statusPending, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.NewsID,
+			&comment.ParentID,
+			&comment.Text,
+			&comment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// recordCensorFailure увеличивает счётчик попыток и при достижении
+// лимита переводит комментарий в dead_letter
+func recordCensorFailure(id int, reason string, maxAttempts int) (int, error) {
+	query := `
+        UPDATE comments
+        SET censor_attempts = censor_attempts + 1,
+            last_censor_error = $2,
+            censor_checked_at = NOW(),
+            status = CASE WHEN censor_attempts + 1 >= $3 THEN $4 ELSE status END
+        WHERE id = $1
+        RETURNING censor_attempts
+    `
+	var attempts int
+	err := db.QueryRow(query, id, reason, maxAttempts, statusDeadLetter).Scan(&attempts)
+	return attempts, err
+}
+
+func setCommentStatus(id int, status string) error {
+	_, err := db.Exec(`
+        UPDATE comments
+        SET status = $2, censor_checked_at = NOW(), last_censor_error = NULL
+        WHERE id = $1
+    `, id, status)
+	return err
+}
+
+// pendingRecheckHandler POST запускает перепроверку в фоне (202 с
+// состоянием прогона), GET возвращает состояние текущего или последнего
+// прогона
+func pendingRecheckHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		run := rechecker.lastRun()
+		if run == nil {
+			http.Error(w, "No recheck has been started", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(run)
+	case http.MethodPost:
+		run, err := rechecker.start()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(run)
+			return
+		}
+		log.Printf("Запущена перепроверка pending-комментариев, request_id: %s", requestID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(run)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// pendingStatsHandler возвращает метрики задания и число комментариев по статусам
+func pendingStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := make(map[string]int)
+	rows, err := db.Query("SELECT status, COUNT(*) FROM comments GROUP BY status")
+	if err != nil {
+		log.Printf("Ошибка получения статистики комментариев: %v", err)
+		http.Error(w, "Failed to get stats", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			http.Error(w, "Failed to get stats", http.StatusInternalServerError)
+			return
+		}
+		counts[status] = count
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":          rechecker.snapshot(),
+		"by_status":    counts,
+		"max_attempts": rechecker.maxAttempts,
+	})
+}
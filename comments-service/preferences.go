@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Настройки доставки уведомлений об ответах на комментарии. Пайплайн
+// уведомлений читает их пакетно через /admin/notification-preferences;
+// пользователь меняет свои настройки через gateway, который передаёт
+// имя пользователя в заголовке X-User.
+
+// Режимы доставки уведомлений об ответах
+const (
+	deliveryInstant = "instant"
+	deliveryHourly  = "hourly"
+	deliveryDaily   = "daily"
+	deliveryOff     = "off"
+)
+
+var deliveryModes = map[string]bool{
+	deliveryInstant: true,
+	deliveryHourly:  true,
+	deliveryDaily:   true,
+	deliveryOff:     true,
+}
+
+const maxPreferencesLookup = 500
+
+// NotificationPreferences настройки пользователя. Пользователь без
+// сохранённых настроек получает уведомления сразу (instant).
+type NotificationPreferences struct {
+	Username      string     `json:"username"`
+	ReplyDelivery string     `json:"reply_delivery"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+func getNotificationPreferences(usernames []string) (map[string]NotificationPreferences, error) {
+	prefs := make(map[string]NotificationPreferences, len(usernames))
+	for _, u := range usernames {
+		prefs[u] = NotificationPreferences{Username: u, ReplyDelivery: deliveryInstant}
+	}
+	rows, err := db.Query(`
+        SELECT username, reply_delivery, updated_at
+        FROM notification_preferences
+        WHERE username = ANY($1)
+    `, pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p NotificationPreferences
+		var updatedAt time.Time
+		if err := rows.Scan(&p.Username, &p.ReplyDelivery, &updatedAt); err != nil {
+			return nil, err
+		}
+		p.UpdatedAt = &updatedAt
+		prefs[p.Username] = p
+	}
+	return prefs, rows.Err()
+}
+
+// notificationPreferencesHandler GET и PUT /preferences/notifications
+// для пользователя из X-User
+func notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	username := strings.TrimSpace(r.Header.Get("X-User"))
+	if username == "" {
+		http.Error(w, "X-User header is required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req NotificationPreferences
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		req.ReplyDelivery = strings.ToLower(strings.TrimSpace(req.ReplyDelivery))
+		if !deliveryModes[req.ReplyDelivery] {
+			http.Error(w, "reply_delivery must be instant, hourly, daily or off", http.StatusBadRequest)
+			return
+		}
+		_, err := db.Exec(`
+            INSERT INTO notification_preferences (username, reply_delivery, updated_at)
+            VALUES ($1, $2, NOW())
+            ON CONFLICT (username) DO UPDATE SET
+                reply_delivery = EXCLUDED.reply_delivery,
+                updated_at = NOW()
+        `, username, req.ReplyDelivery)
+		if err != nil {
+			log.Printf("Ошибка сохранения настроек уведомлений %s: %v", username, err)
+			http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Пользователь %s выбрал доставку уведомлений %s, request_id: %s", username, req.ReplyDelivery, requestID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs, err := getNotificationPreferences([]string{username})
+	if err != nil {
+		log.Printf("Ошибка получения настроек уведомлений %s: %v", username, err)
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(prefs[username])
+}
+
+// notificationPreferencesLookupHandler GET /admin/notification-preferences?usernames=a,b
+// пакетное чтение настроек для пайплайна уведомлений; неизвестные
+// пользователи возвращаются с настройками по умолчанию
+func notificationPreferencesLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, u := range strings.Split(r.URL.Query().Get("usernames"), ",") {
+		u = strings.TrimSpace(u)
+		if u != "" && !seen[u] {
+			seen[u] = true
+			usernames = append(usernames, u)
+		}
+	}
+	if len(usernames) == 0 || len(usernames) > maxPreferencesLookup {
+		http.Error(w, "usernames must contain from 1 to 500 names", http.StatusBadRequest)
+		return
+	}
+
+	prefs, err := getNotificationPreferences(usernames)
+	if err != nil {
+		log.Printf("Ошибка пакетного получения настроек уведомлений: %v", err)
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+	result := make([]NotificationPreferences, 0, len(usernames))
+	for _, u := range usernames {
+		result = append(result, prefs[u])
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Порт сервиса открыт на хосте: /admin/*, X-User и X-Moderator только с X-Service-Token от gateway
+
+var serviceToken = os.Getenv("SERVICE_TOKEN")
+
+// serviceAuthorized проверяет X-Service-Token за постоянное время
+func serviceAuthorized(r *http.Request) bool {
+	got := r.Header.Get("X-Service-Token")
+	return serviceToken != "" && subtle.ConstantTimeCompare([]byte(got), []byte(serviceToken)) == 1
+}
+
+func serviceAuthMiddleware(next http.Handler) http.Handler {
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN не задан: /admin/* и заголовки X-User, X-Moderator отключены")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serviceAuthorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		r.Header.Del("X-User")
+		r.Header.Del("X-Moderator")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Снимки веток комментариев для архива закрытых обсуждений и запросов
+// на сохранение доказательств. POST /admin/snapshots сохраняет полную
+// ветку новости (только одобренные комментарии, как её видят читатели)
+// в хранилище в двух видах — JSON и самостоятельная HTML-страница — и
+// запоминает в таблице comment_snapshots ключи объектов и их SHA-256.
+// Ссылки на скачивание предподписаны и выдаются заново при каждом
+// чтении снимка.
+
+const snapshotTimeLayout = "20060102T150405Z"
+
+// Snapshot сохранённый снимок ветки
+type Snapshot struct {
+	ID           int       `json:"id"`
+	NewsID       int       `json:"news_id"`
+	CommentCount int       `json:"comment_count"`
+	Reason       string    `json:"reason,omitempty"`
+	JSONKey      string    `json:"json_key"`
+	HTMLKey      string    `json:"html_key"`
+	JSONSHA256   string    `json:"json_sha256"`
+	HTMLSHA256   string    `json:"html_sha256"`
+	CreatedAt    time.Time `json:"created_at"`
+	// JSONURL и HTMLURL предподписанные ссылки до LinksExpireAt
+	JSONURL       string    `json:"json_url"`
+	HTMLURL       string    `json:"html_url"`
+	LinksExpireAt time.Time `json:"links_expire_at"`
+}
+
+// threadSnapshot содержимое JSON-снимка
+type threadSnapshot struct {
+	NewsID       int       `json:"news_id"`
+	GeneratedAt  time.Time `json:"generated_at"`
+	CommentCount int       `json:"comment_count"`
+	Comments     []Comment `json:"comments"`
+}
+
+var snapshotHTML = template.Must(template.New("snapshot").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Комментарии к новости #{{.NewsID}}</title>
+<style>
+body { font-family: sans-serif; max-width: 860px; margin: 2em auto; color: #222; }
+ul { list-style: none; padding-left: 1.5em; border-left: 1px solid #ddd; }
+.comment { margin: .8em 0; }
+.meta { color: #777; font-size: .85em; }
+.text { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Комментарии к новости #{{.NewsID}}</h1>
+<p class="meta">Снимок от {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}, комментариев: {{.CommentCount}}</p>
+{{template "thread" .Comments}}
+</body>
+</html>
+{{define "thread"}}{{if .}}<ul>
+{{range .}}<li class="comment" id="c{{.ID}}">
+<div class="meta">#{{.ID}} · {{.CreatedAt.Format "2006-01-02 15:04:05"}}{{if .ParentID}} · ответ на <a href="#c{{.ParentID}}">#{{.ParentID}}</a>{{end}}</div>
+<div class="text">{{.Text}}</div>
+{{template "thread" .Children}}</li>
+{{end}}</ul>{{end}}{{end}}
+`))
+
+const snapshotColumns = `id, news_id, comment_count, reason, json_key, html_key, json_sha256, html_sha256, created_at`
+
+func scanSnapshot(row interface{ Scan(...interface{}) error }) (Snapshot, error) {
+	var s Snapshot
+	err := row.Scan(&s.ID, &s.NewsID, &s.CommentCount, &s.Reason, &s.JSONKey, &s.HTMLKey,
+		&s.JSONSHA256, &s.HTMLSHA256, &s.CreatedAt)
+	if err == nil {
+		s.JSONURL, s.LinksExpireAt = snapshotStore.presign(s.JSONKey)
+		s.HTMLURL, _ = snapshotStore.presign(s.HTMLKey)
+	}
+	return s, err
+}
+
+// createSnapshot отрисовывает ветку новости, сохраняет её в хранилище
+// и записывает снимок
+func createSnapshot(newsID int, reason string) (Snapshot, error) {
+	comments, err := getCommentsByNewsID(newsID)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("получение комментариев: %w", err)
+	}
+	thread := threadSnapshot{
+		NewsID:       newsID,
+		GeneratedAt:  time.Now().UTC(),
+		CommentCount: len(comments),
+		Comments:     buildCommentTree(comments),
+	}
+	jsonBody, err := json.MarshalIndent(thread, "", "  ")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var htmlBody bytes.Buffer
+	if err := snapshotHTML.Execute(&htmlBody, thread); err != nil {
+		return Snapshot{}, fmt.Errorf("отрисовка HTML: %w", err)
+	}
+
+	base := fmt.Sprintf("%snews-%d/%s", snapshotStore.prefix, newsID, thread.GeneratedAt.Format(snapshotTimeLayout))
+	jsonKey, htmlKey := base+".json", base+".html"
+	if err := snapshotStore.put(jsonKey, jsonBody, "application/json; charset=utf-8"); err != nil {
+		return Snapshot{}, fmt.Errorf("сохранение %s: %w", jsonKey, err)
+	}
+	if err := snapshotStore.put(htmlKey, htmlBody.Bytes(), "text/html; charset=utf-8"); err != nil {
+		return Snapshot{}, fmt.Errorf("сохранение %s: %w", htmlKey, err)
+	}
+
+	return scanSnapshot(db.QueryRow(`
+        INSERT INTO comment_snapshots (news_id, comment_count, reason, json_key, html_key, json_sha256, html_sha256)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING `+snapshotColumns,
+		newsID, thread.CommentCount, reason, jsonKey, htmlKey, sha256Hex(jsonBody), sha256Hex(htmlBody.Bytes())))
+}
+
+// snapshotsHandler POST /admin/snapshots {"news_id", "reason"} — новый
+// снимок; GET /admin/snapshots?news_id= — снимки новости, новые первыми
+func snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	if snapshotStore == nil {
+		http.Error(w, "Snapshot storage is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			NewsID int    `json:"news_id"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.NewsID <= 0 {
+			http.Error(w, "News ID is required and must be positive", http.StatusBadRequest)
+			return
+		}
+		snapshot, err := createSnapshot(req.NewsID, strings.TrimSpace(req.Reason))
+		if err != nil {
+			log.Printf("Ошибка создания снимка ветки новости %d: %v, request_id: %s", req.NewsID, err, requestID)
+			http.Error(w, "Failed to create snapshot", http.StatusBadGateway)
+			return
+		}
+		log.Printf("Создан снимок %d ветки новости %d: комментариев %d, request_id: %s",
+			snapshot.ID, snapshot.NewsID, snapshot.CommentCount, requestID)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Location", fmt.Sprintf("/admin/snapshots/%d", snapshot.ID))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(snapshot)
+	case http.MethodGet:
+		newsID, err := strconv.Atoi(r.URL.Query().Get("news_id"))
+		if err != nil || newsID <= 0 {
+			http.Error(w, "Invalid news ID", http.StatusBadRequest)
+			return
+		}
+		rows, err := db.Query(`SELECT `+snapshotColumns+` FROM comment_snapshots WHERE news_id = $1 ORDER BY id DESC`, newsID)
+		if err != nil {
+			log.Printf("Ошибка получения снимков новости %d: %v", newsID, err)
+			http.Error(w, "Failed to get snapshots", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		snapshots := []Snapshot{}
+		for rows.Next() {
+			s, err := scanSnapshot(rows)
+			if err != nil {
+				log.Printf("Ошибка чтения снимка: %v", err)
+				http.Error(w, "Failed to get snapshots", http.StatusInternalServerError)
+				return
+			}
+			snapshots = append(snapshots, s)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(snapshots)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// snapshotHandler GET /admin/snapshots/{id} — снимок со свежими ссылками
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if snapshotStore == nil {
+		http.Error(w, "Snapshot storage is not configured", http.StatusNotImplemented)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/snapshots/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid snapshot ID", http.StatusBadRequest)
+		return
+	}
+	snapshot, err := scanSnapshot(db.QueryRow(`SELECT `+snapshotColumns+` FROM comment_snapshots WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения снимка %d: %v", id, err)
+		http.Error(w, "Failed to get snapshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ограничения размера ответа с деревом комментариев
+// (COMMENTS_MAX_NODES, COMMENTS_MAX_BYTES)
+var (
+	commentsMaxNodes = 1000
+	commentsMaxBytes = 1 << 20
+)
+
+// Накладные расходы на узел в JSON помимо его собственных полей
+// (массив children, запятые, скобки)
+const commentNodeOverhead = 16
+
+// flattenCommentTree возвращает комментарии в порядке обхода дерева
+// в глубину (родитель, затем его ответы), без поля Children
+func flattenCommentTree(roots []Comment) []Comment {
+	var flat []Comment
+	var walk func(c Comment)
+	walk = func(c Comment) {
+		children := c.Children
+		c.Children = nil
+		flat = append(flat, c)
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+	return flat
+}
+
+func encodeContinuationToken(commentID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("c:" + strconv.Itoa(commentID)))
+}
+
+func decodeContinuationToken(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(raw), "c:"))
+	if err != nil || !strings.HasPrefix(string(raw), "c:") {
+		return 0, fmt.Errorf("invalid continuation token")
+	}
+	return id, nil
+}
+
+// paginateCommentTree отдаёт часть дерева, укладывающуюся в бюджет узлов
+// и байт, начиная с комментария из токена. Узлы, чей родитель остался
+// на предыдущей странице, становятся корнями страницы — клиент
+// прикрепляет их по parent_id. Пустой next означает конец дерева.
+// Непустой language оставляет только комментарии на этом языке; ответ
+// на комментарий на другом языке тоже становится корнем.
+func paginateCommentTree(comments []Comment, token, language string, maxNodes, maxBytes int) (page []Comment, next string, err error) {
+	flat := flattenCommentTree(buildCommentTree(comments))
+	if language != "" {
+		filtered := flat[:0]
+		for _, c := range flat {
+			if c.Language == language {
+				filtered = append(filtered, c)
+			}
+		}
+		flat = filtered
+	}
+
+	start := 0
+	if token != "" {
+		id, err := decodeContinuationToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+		start = -1
+		for i, c := range flat {
+			if c.ID == id {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, "", fmt.Errorf("continuation token refers to unknown comment")
+		}
+	}
+
+	end, size := start, 0
+	for end < len(flat) {
+		b, _ := json.Marshal(flat[end])
+		nodeSize := len(b) + commentNodeOverhead
+		// хотя бы один узел попадает на страницу всегда
+		if end > start && (end-start >= maxNodes || size+nodeSize > maxBytes) {
+			break
+		}
+		size += nodeSize
+		end++
+	}
+
+	if end < len(flat) {
+		next = encodeContinuationToken(flat[end].ID)
+	}
+
+	pageComments := flat[start:end]
+	page = assembleTree(pageComments, func(c Comment, present map[int]bool) bool {
+		return c.ParentID == nil || !present[*c.ParentID]
+	})
+	return page, next, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func intPtr(v int) *int { return &v }
+
+// testThread ветка 1 ── 2 ── 3, ответ 5 на 1 и отдельный корень 4
+func testThread() []Comment {
+	return []Comment{
+		{ID: 1, NewsID: 1, Text: "первый", Status: "approved", Language: "ru"},
+		{ID: 2, NewsID: 1, ParentID: intPtr(1), Text: "reply to the first", Status: "approved", Language: "en"},
+		{ID: 3, NewsID: 1, ParentID: intPtr(2), Text: "ответ на ответ", Status: "approved", Language: "ru"},
+		{ID: 4, NewsID: 1, Text: "второй", Status: "approved", Language: "ru"},
+		{ID: 5, NewsID: 1, ParentID: intPtr(1), Text: "ещё ответ на первый", Status: "approved", Language: "ru"},
+	}
+}
+
+// treeShape записывает лес как "1(2(3) 5) 4"
+func treeShape(roots []Comment) string {
+	parts := make([]string, 0, len(roots))
+	for _, c := range roots {
+		s := strconv.Itoa(c.ID)
+		if len(c.Children) > 0 {
+			s += "(" + treeShape(c.Children) + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestBuildCommentTree(t *testing.T) {
+	if got := buildCommentTree(nil); got == nil || len(got) != 0 {
+		t.Fatalf("пустой список должен давать пустой массив, а не null: %#v", got)
+	}
+	if got := treeShape(buildCommentTree(testThread())); got != "1(2(3) 5) 4" {
+		t.Fatalf("дерево %q", got)
+	}
+	// ответ на отсутствующий комментарий в дерево не попадает
+	orphan := Comment{ID: 6, NewsID: 1, ParentID: intPtr(99), Text: "сирота"}
+	if got := treeShape(buildCommentTree(append(testThread(), orphan))); got != "1(2(3) 5) 4" {
+		t.Fatalf("дерево с сиротой %q", got)
+	}
+}
+
+// commentPages листает дерево по токенам до конца
+func commentPages(t *testing.T, language string, maxNodes, maxBytes int) []string {
+	t.Helper()
+	var pages []string
+	token := ""
+	for len(pages) < 10 {
+		page, next, err := paginateCommentTree(testThread(), token, language, maxNodes, maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, treeShape(page))
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	return pages
+}
+
+func TestPaginateCommentTree(t *testing.T) {
+	if got := commentPages(t, "", 100, 1<<20); strings.Join(got, " | ") != "1(2(3) 5) 4" {
+		t.Errorf("без ограничений: %q", got)
+	}
+	// ответы, чей родитель остался на предыдущей странице, становятся корнями
+	if got := commentPages(t, "", 2, 1<<20); strings.Join(got, " | ") != "1(2) | 3 5 | 4" {
+		t.Errorf("по два узла: %q", got)
+	}
+	// бюджет байт меньше одного узла: страница всё равно не пустая
+	if got := commentPages(t, "", 100, 1); strings.Join(got, " | ") != "1 | 2 | 3 | 5 | 4" {
+		t.Errorf("бюджет байт: %q", got)
+	}
+	// ответ на комментарий на другом языке становится корнем
+	if got := commentPages(t, "ru", 100, 1<<20); strings.Join(got, " | ") != "1(5) 3 4" {
+		t.Errorf("только ru: %q", got)
+	}
+	if got := commentPages(t, "ru", 3, 1<<20); strings.Join(got, " | ") != "1(5) 3 | 4" {
+		t.Errorf("ru по три узла: %q", got)
+	}
+	if got := commentPages(t, "de", 100, 1<<20); strings.Join(got, " | ") != "" {
+		t.Errorf("нет комментариев на языке: %q", got)
+	}
+}
+
+func TestPaginateCommentTreeBadToken(t *testing.T) {
+	// не base64, неизвестный комментарий и base64 без префикса "c:"
+	for _, token := range []string{"не base64!", encodeContinuationToken(42), "eDox"} {
+		if _, _, err := paginateCommentTree(testThread(), token, "", 10, 1<<20); err == nil {
+			t.Errorf("токен %q: ожидалась ошибка", token)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+-- Выборка срабатываний правил цензуры для обучения классификатора
+CREATE TABLE IF NOT EXISTS rule_hit_samples (
+    id BIGSERIAL PRIMARY KEY,
+    -- SHA-256 нормализованного текста
+    text_hash CHAR(64) NOT NULL,
+    normalized TEXT NOT NULL,
+    rule_id VARCHAR(255) NOT NULL,
+    category VARCHAR(255) NOT NULL DEFAULT '',
+    verdict VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (text_hash, rule_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rule_hit_samples_created_at ON rule_hit_samples(created_at);
+CREATE INDEX IF NOT EXISTS idx_rule_hit_samples_rule_id ON rule_hit_samples(rule_id);
@@ -1,4 +1,8 @@
-CREATE TABLE comments (
+-- Скрипт идемпотентен: на пустой базе его выполняет docker-entrypoint-initdb.d,
+-- а существующую базу обновляет повторный запуск (psql -f init_comments_db.sql).
+-- Таблица comments создаётся в исходном виде; колонки, появившиеся позже,
+-- добавляются через ADD COLUMN IF NOT EXISTS, чтобы дойти и до старых баз.
+CREATE TABLE IF NOT EXISTS comments (
     id SERIAL PRIMARY KEY,
     news_id INTEGER NOT NULL,
     parent_id INTEGER REFERENCES comments(id),
@@ -6,7 +10,87 @@ CREATE TABLE comments (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'approved';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS censor_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS last_censor_error TEXT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS censor_checked_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS category VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_by VARCHAR(255);
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMP;
+-- политика, применённая при заполнении модерации исторических комментариев
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS backfill_policy VARCHAR(20);
+-- момент, когда проверка согласованности не нашла новость комментария
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS orphaned_at TIMESTAMP;
+-- язык текста (ISO 639-1), определённый при создании; пустой — не определён
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS language VARCHAR(8) NOT NULL DEFAULT '';
+-- пользователь, оставивший комментарий; пустой — комментарий до учёта авторов
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS author VARCHAR(255) NOT NULL DEFAULT '';
+
 
 CREATE INDEX IF NOT EXISTS idx_comments_news_id ON comments(news_id);
 CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
-CREATE INDEX IF NOT EXISTS idx_comments_created_at ON comments(created_at DESC);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_comments_created_at ON comments(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_comments_status ON comments(status);
+CREATE INDEX IF NOT EXISTS idx_comments_category ON comments(category);
+CREATE INDEX IF NOT EXISTS idx_comments_language ON comments(language);
+CREATE INDEX IF NOT EXISTS idx_comments_author ON comments(author, id) WHERE author <> '';
+CREATE INDEX IF NOT EXISTS idx_comments_orphaned_at ON comments(news_id) WHERE orphaned_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(512) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    comment_id INTEGER NOT NULL REFERENCES comments(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+-- Назначения модераторов: на конкретную новость или на категорию
+CREATE TABLE IF NOT EXISTS moderator_assignments (
+    id SERIAL PRIMARY KEY,
+    moderator VARCHAR(255) NOT NULL,
+    news_id INTEGER,
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((news_id IS NULL) <> (category = ''))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_moderator_assignments_unique
+    ON moderator_assignments(moderator, (COALESCE(news_id, 0)), category);
+
+-- Пакетные действия модераторов с окном отмены
+CREATE TABLE IF NOT EXISTS moderation_batches (
+    id SERIAL PRIMARY KEY,
+    moderator VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    comment_ids INTEGER[] NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'queued',
+    commit_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_batches_due ON moderation_batches(commit_at) WHERE state = 'queued';
+
+-- Настройки доставки уведомлений об ответах
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    username VARCHAR(255) PRIMARY KEY,
+    reply_delivery VARCHAR(20) NOT NULL DEFAULT 'instant'
+        CHECK (reply_delivery IN ('instant', 'hourly', 'daily', 'off')),
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Снимки веток комментариев в объектном хранилище
+CREATE TABLE IF NOT EXISTS comment_snapshots (
+    id SERIAL PRIMARY KEY,
+    news_id INTEGER NOT NULL,
+    comment_count INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    json_key VARCHAR(1000) NOT NULL,
+    html_key VARCHAR(1000) NOT NULL,
+    json_sha256 VARCHAR(64) NOT NULL,
+    html_sha256 VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_snapshots_news_id ON comment_snapshots(news_id);
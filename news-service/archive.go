@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Архив исходных лент: каждая загруженная лента сжимается gzip и
+// сохраняется в S3-совместимое хранилище (AWS S3, MinIO и т. п.) под
+// ключом <prefix><лента>/<время>.xml.gz (.json.gz для JSON Feed). Сохранённую ленту можно
+// повторно обработать (replay), например после исправления парсера.
+// Архив включается переменной ARCHIVE_S3_BUCKET.
+
+// feedArchive клиент S3 с подписью запросов AWS Signature V4
+type feedArchive struct {
+	endpoint  string // https://s3.amazonaws.com или http://minio:9000
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+var archive *feedArchive
+
+// ArchivedPayload сохранённая лента
+type ArchivedPayload struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+const archiveTimeLayout = "20060102T150405Z"
+
+func newFeedArchiveFromEnv() *feedArchive {
+	bucket := os.Getenv("ARCHIVE_S3_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+	a := &feedArchive{
+		endpoint:  strings.TrimRight(os.Getenv("ARCHIVE_S3_ENDPOINT"), "/"),
+		bucket:    bucket,
+		region:    os.Getenv("ARCHIVE_S3_REGION"),
+		accessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		prefix:    os.Getenv("ARCHIVE_S3_PREFIX"),
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://s3.amazonaws.com"
+	}
+	if a.region == "" {
+		a.region = "us-east-1"
+	}
+	if a.prefix == "" {
+		a.prefix = "feeds/"
+	}
+	return a
+}
+
+func (a *feedArchive) enabled() bool {
+	return a != nil
+}
+
+// feedKeyPrefix каталог ленты: хост и короткий хеш полного URL,
+// чтобы ленты одного сайта не смешивались
+func (a *feedArchive) feedKeyPrefix(feedURL string) string {
+	host := "feed"
+	if u, err := url.Parse(feedURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	sum := sha256.Sum256([]byte(feedURL))
+	return a.prefix + host + "-" + hex.EncodeToString(sum[:4]) + "/"
+}
+
+// store сохраняет ленту; ошибки только логируются, загрузка новостей
+// от архива не зависит
+func (a *feedArchive) store(ctx context.Context, feedURL string, payload []byte, jsonFeed bool, fetchedAt time.Time) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(payload)
+	gz.Close()
+
+	ext := ".xml.gz"
+	if jsonFeed {
+		ext = ".json.gz"
+	}
+	key := a.feedKeyPrefix(feedURL) + fetchedAt.UTC().Format(archiveTimeLayout) + ext
+	headers := map[string]string{
+		"Content-Type":        "application/gzip",
+		"x-amz-meta-feed-url": feedURL,
+	}
+	resp, err := a.do(ctx, http.MethodPut, "/"+key, nil, buf.Bytes(), headers)
+	if err != nil {
+		log.Printf("Ошибка архивирования ленты %s: %v", feedURL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Ошибка архивирования ленты %s: HTTP %d", feedURL, resp.StatusCode)
+	}
+}
+
+// fetch возвращает распакованную ленту и URL, с которого она была загружена
+func (a *feedArchive) fetch(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := a.do(ctx, http.MethodGet, "/"+key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("объект %s не найден", key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	defer gz.Close()
+	payload, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, resp.Header.Get("x-amz-meta-feed-url"), nil
+}
+
+// list перечисляет сохранённые ленты (ListObjectsV2), новые первыми
+func (a *feedArchive) list(ctx context.Context, feedURL string, limit int) ([]ArchivedPayload, error) {
+	prefix := a.prefix
+	if feedURL != "" {
+		prefix = a.feedKeyPrefix(feedURL)
+	}
+	var payloads []ArchivedPayload
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := a.do(ctx, http.MethodGet, "/", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			payloads = append(payloads, ArchivedPayload{Key: c.Key, Size: c.Size, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(payloads, func(i, j int) bool {
+		return payloads[i].LastModified.After(payloads[j].LastModified)
+	})
+	if limit > 0 && len(payloads) > limit {
+		payloads = payloads[:limit]
+	}
+	return payloads, nil
+}
+
+// do выполняет запрос к бакету (path-style) с подписью SigV4
+func (a *feedArchive) do(ctx context.Context, method, objectPath string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	canonicalURI := "/" + s3Escape(a.bucket, false) + s3Escape(objectPath, true)
+	rawQuery := canonicalQuery(query)
+	reqURL := a.endpoint + canonicalURI
+	if rawQuery != "" {
+		reqURL += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	a.sign(req, canonicalURI, rawQuery, body, time.Now().UTC())
+	return a.client.Do(req)
+}
+
+func (a *feedArchive) sign(req *http.Request, canonicalURI, rawQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Подписываются host и все x-amz-* заголовки
+	signed := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			signed[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalURI, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + a.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape кодирует строку по правилам SigV4: без изменений остаются
+// только A-Z a-z 0-9 - _ . ~ (и '/', если keepSlash)
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// replayPayload повторно обрабатывает сохранённую ленту. Существующие
+// новости перезаписываются результатом текущего парсера.
+func replayPayload(ctx context.Context, key string) (parsed, stored int, err error) {
+	payload, feedURL, err := archive.fetch(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	items, err := parseFeed(payload, "", feedURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	src := sourceByURL(ctx, feedURL)
+	for _, item := range items {
+		if outcome := storeNewsItem(ctx, item, src, storeOverwrite); outcome == newsAdded || outcome == newsUpdated {
+			stored++
+		}
+	}
+	log.Printf("Replay %s (%s): разобрано %d элементов, сохранено %d", key, feedURL, len(items), stored)
+	return len(items), stored, nil
+}
+
+// archiveListHandler GET /admin/archive?feed=<url>&limit=50
+func archiveListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !archive.enabled() {
+		http.Error(w, "Feed archive is disabled", http.StatusNotFound)
+		return
+	}
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	payloads, err := archive.list(ctx, r.URL.Query().Get("feed"), limit)
+	if err != nil {
+		log.Printf("Ошибка получения списка архива: %v", err)
+		http.Error(w, "Failed to list archive", http.StatusBadGateway)
+		return
+	}
+	if payloads == nil {
+		payloads = []ArchivedPayload{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payloads)
+}
+
+// archiveReplayHandler POST /admin/archive/replay?key=<ключ>
+func archiveReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !archive.enabled() {
+		http.Error(w, "Feed archive is disabled", http.StatusNotFound)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	parsed, stored, err := replayPayload(ctx, key)
+	if err != nil {
+		log.Printf("Ошибка replay %s: %v", key, err)
+		http.Error(w, "Replay failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":    key,
+		"parsed": parsed,
+		"stored": stored,
+	})
+}
+
+// runReplayCommand режим командной строки: news-service replay <ключ>...
+func runReplayCommand(keys []string) {
+	if !archive.enabled() {
+		log.Fatal("Архив лент не настроен: задайте ARCHIVE_S3_BUCKET")
+	}
+	if len(keys) == 0 {
+		log.Fatal("Использование: news-service replay <ключ> [<ключ>...]")
+	}
+	failed := 0
+	for _, key := range keys {
+		if _, _, err := replayPayload(context.Background(), key); err != nil {
+			log.Printf("Ошибка replay %s: %v", key, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// AuthorStat автор и число его новостей
+type AuthorStat struct {
+	Author string `json:"author"`
+	Count  int    `json:"count"`
+}
+
+// Ограничение длины колонки news.author
+const maxAuthorLength = 255
+
+// extractAuthor возвращает автора новости RSS: dc:creator, иначе <author>.
+// В RSS 2.0 <author> обычно имеет вид "email (Имя)" — берём имя.
+func extractAuthor(item rssItem) string {
+	return limitAuthor(parseAuthor(item))
+}
+
+// limitAuthor обрезает имя автора по длине колонки news.author
+func limitAuthor(author string) string {
+	if runes := []rune(author); len(runes) > maxAuthorLength {
+		author = string(runes[:maxAuthorLength])
+	}
+	return author
+}
+
+func parseAuthor(item rssItem) string {
+	if creator := strings.TrimSpace(item.Creator); creator != "" {
+		return creator
+	}
+	author := strings.TrimSpace(item.Author)
+	if open := strings.Index(author, "("); open >= 0 {
+		if end := strings.LastIndex(author, ")"); end > open {
+			if name := strings.TrimSpace(author[open+1 : end]); name != "" {
+				return name
+			}
+		}
+	}
+	return author
+}
+
+// authorsHandler возвращает список авторов с количеством новостей
+func authorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+	log.Printf("Запрос списка авторов, request_id: %s", requestID)
+
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	authors, err := getAuthors(ctx)
+	if err != nil {
+		log.Printf("Ошибка получения авторов: %v", err)
+		http.Error(w, "Failed to get authors", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authors)
+}
+
+// getAuthors получает авторов опубликованных новостей, самых активных первыми
+func getAuthors(ctx context.Context) ([]AuthorStat, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT author, COUNT(*)
+		FROM news
+		WHERE author <> '' AND available_at <= NOW()
+		GROUP BY author
+		ORDER BY COUNT(*) DESC, author ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []AuthorStat{}
+	for rows.Next() {
+		var a AuthorStat
+		if err := rows.Scan(&a.Author, &a.Count); err != nil {
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+	return authors, rows.Err()
+}
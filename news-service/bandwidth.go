@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Учёт трафика лент. Каждая попытка загрузки (в том числе неудачная и
+// ответ 304) добавляет скачанные байты и время загрузки в суточную
+// строку ленты в таблице feed_bandwidth. Если у источника задан
+// daily_budget_bytes и лента за текущие сутки скачала столько же или
+// больше, планировщик пропускает её до следующих суток. Ручная загрузка
+// через /admin/refresh лимит не проверяет.
+
+const (
+	defaultBandwidthDays = 7
+	maxBandwidthDays     = 90
+)
+
+// BandwidthUsage трафик ленты за сутки
+type BandwidthUsage struct {
+	FeedURL string `json:"feed_url"`
+	Day     string `json:"day"`
+	Bytes   int64  `json:"bytes"`
+	FetchMs int64  `json:"fetch_ms"`
+	Fetches int    `json:"fetches"`
+}
+
+// recordBandwidth добавляет попытку загрузки в суточный учёт ленты
+func recordBandwidth(ctx context.Context, feedURL string, bytes int64, duration time.Duration) {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO feed_bandwidth (feed_url, day, bytes, fetch_ms, fetches)
+		VALUES ($1, CURRENT_DATE, $2, $3, 1)
+		ON CONFLICT (feed_url, day) DO UPDATE SET
+			bytes = feed_bandwidth.bytes + EXCLUDED.bytes,
+			fetch_ms = feed_bandwidth.fetch_ms + EXCLUDED.fetch_ms,
+			fetches = feed_bandwidth.fetches + 1
+	`, feedURL, bytes, duration.Milliseconds())
+	if err != nil {
+		log.Printf("Ошибка учёта трафика %s: %v", feedURL, err)
+	}
+}
+
+// bandwidthToday байты, скачанные лентами за текущие сутки
+func bandwidthToday(ctx context.Context) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT feed_url, bytes FROM feed_bandwidth WHERE day = CURRENT_DATE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var feedURL string
+		var bytes int64
+		if err := rows.Scan(&feedURL, &bytes); err != nil {
+			return nil, err
+		}
+		usage[feedURL] = bytes
+	}
+	return usage, rows.Err()
+}
+
+// budgetWarned ленты, о превышении лимита которых уже написано
+// в журнал, и сутки, за которые это сделано
+var budgetWarned = struct {
+	sync.Mutex
+	days map[string]string
+}{days: make(map[string]string)}
+
+// overBudget лента исчерпала суточный лимит трафика; о превышении
+// пишется в журнал один раз за сутки
+func overBudget(src feedSource, used int64) bool {
+	if src.DailyBudgetBytes <= 0 || used < src.DailyBudgetBytes {
+		return false
+	}
+	today := time.Now().Format("2006-01-02")
+	budgetWarned.Lock()
+	defer budgetWarned.Unlock()
+	if budgetWarned.days[src.URL] != today {
+		budgetWarned.days[src.URL] = today
+		log.Printf("[WARN] Лента %s исчерпала суточный лимит трафика: %d из %d байт, загрузка приостановлена до следующих суток",
+			src.URL, used, src.DailyBudgetBytes)
+	}
+	return true
+}
+
+// feedsBandwidthHandler GET /admin/feeds/bandwidth?days=7[&url=] —
+// суточный трафик лент, от новых суток к старым
+func feedsBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	days := defaultBandwidthDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 || d > maxBandwidthDays {
+			http.Error(w, "days must be from 1 to 90", http.StatusBadRequest)
+			return
+		}
+		days = d
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT feed_url, TO_CHAR(day, 'YYYY-MM-DD'), bytes, fetch_ms, fetches
+		FROM feed_bandwidth
+		WHERE day > CURRENT_DATE - $1::int AND ($2 = '' OR feed_url = $2)
+		ORDER BY day DESC, bytes DESC
+	`, days, r.URL.Query().Get("url"))
+	if err != nil {
+		log.Printf("Ошибка получения трафика лент: %v", err)
+		http.Error(w, "Failed to get feed bandwidth", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	usage := []BandwidthUsage{}
+	for rows.Next() {
+		var u BandwidthUsage
+		if err := rows.Scan(&u.FeedURL, &u.Day, &u.Bytes, &u.FetchMs, &u.Fetches); err != nil {
+			log.Printf("Ошибка чтения трафика лент: %v", err)
+			http.Error(w, "Failed to get feed bandwidth", http.StatusInternalServerError)
+			return
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка получения трафика лент: %v", err)
+		http.Error(w, "Failed to get feed bandwidth", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Пакетное получение новостей одним запросом к базе: GET /news?ids=1,2,3
+// (или прежний адрес /news/batch?ids=). В news попадают только
+// опубликованные новости (available_at <= NOW()), остальные ID —
+// несуществующие и ещё скрытые — перечисляются в missing. Используется
+// comments-service для проверки ссылок комментариев на новости и gateway
+// для обогащения списков.
+
+const maxBatchIDs = 100
+
+// NewsBatchResponse ответ пакетного запроса
+type NewsBatchResponse struct {
+	News    []News `json:"news"`
+	Missing []int  `json:"missing"`
+}
+
+// newsBatchHandler GET /news?ids=1,2,3 и GET /news/batch?ids=1,2,3
+func newsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	ids, err := parseBatchIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	news, err := getNewsByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("Ошибка пакетного получения новостей: %v", err)
+		http.Error(w, "Failed to get news", http.StatusInternalServerError)
+		return
+	}
+
+	found := make(map[int]bool, len(news))
+	for _, n := range news {
+		found[n.ID] = true
+	}
+	response := NewsBatchResponse{News: news, Missing: []int{}}
+	for _, id := range ids {
+		if !found[id] {
+			response.Missing = append(response.Missing, id)
+		}
+	}
+	log.Printf("Пакетный запрос %d новостей: найдено %d, request_id: %s", len(ids), len(news), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseBatchIDs разбирает список ID через запятую без повторов
+func parseBatchIDs(raw string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, s := range strings.Split(raw, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid news ID %q", s)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("ids is required")
+	}
+	if len(ids) > maxBatchIDs {
+		return nil, fmt.Errorf("too many news IDs, maximum is %d", maxBatchIDs)
+	}
+	return ids, nil
+}
+
+// getNewsByIDs получает опубликованные новости по списку ID
+func getNewsByIDs(ctx context.Context, ids []int) ([]News, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		WHERE id = ANY($1) AND available_at <= NOW()
+		ORDER BY id
+	`, newsColumns), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	news := []News{}
+	for rows.Next() {
+		n, err := scanNews(rows)
+		if err != nil {
+			return nil, err
+		}
+		news = append(news, n)
+	}
+	return news, rows.Err()
+}
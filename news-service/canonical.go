@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Канонические ссылки новостей. Агрегаторы часто дают ссылки на
+// трекеры переходов, которые перенаправляют на настоящую страницу.
+// Новость сохраняется сразу с исходной ссылкой (или уже известной
+// канонической), а фоновые воркеры проходят по редиректам (не более
+// LINK_RESOLVE_MAX_HOPS переходов, LINK_RESOLVE_TIMEOUT_SEC на всю
+// цепочку) и заменяют news.link конечным URL — по нему работают
+// дедупликация и ссылки в API. Если конечный URL уже у другой новости,
+// новость помечается её дублем. Исходная ссылка из ленты хранится в
+// news.source_link. Запросы идут через safeTransport и очередь хоста
+// (politeness) на каждом переходе. LINK_RESOLVE_MAX_HOPS=0 отключает
+// разрешение.
+
+const (
+	resolveQueueSize = 1000
+	resolveWorkers   = 4
+)
+
+// resolveTask новость, ссылку которой нужно разрешить
+type resolveTask struct {
+	id   int
+	link string
+	// после разрешения скачать полный текст статьи
+	extract bool
+	feedURL string
+}
+
+// linkResolver разрешает ссылки элементов лент
+type linkResolver struct {
+	maxHops int
+	timeout time.Duration
+	client  *http.Client
+	tasks   chan resolveTask
+}
+
+var resolver *linkResolver
+
+// Ограничение длины колонки news.link
+const maxLinkLength = 1000
+
+var errTooManyRedirects = errors.New("слишком много перенаправлений")
+
+func newLinkResolverFromEnv() *linkResolver {
+	maxHops := 5
+	if v, err := strconv.Atoi(os.Getenv("LINK_RESOLVE_MAX_HOPS")); err == nil && v >= 0 {
+		maxHops = v
+	}
+	if maxHops == 0 {
+		return nil
+	}
+	timeout := 5
+	if v, err := strconv.Atoi(os.Getenv("LINK_RESOLVE_TIMEOUT_SEC")); err == nil && v > 0 {
+		timeout = v
+	}
+	return &linkResolver{
+		maxHops: maxHops,
+		timeout: time.Duration(timeout) * time.Second,
+		client:  noRedirectClient(time.Duration(timeout) * time.Second),
+		tasks:   make(chan resolveTask, resolveQueueSize),
+	}
+}
+
+func (l *linkResolver) enabled() bool {
+	return l != nil
+}
+
+// known сохранённая ссылка новости с исходной или канонической ссылкой
+// link; false — ссылка ещё не встречалась и её нужно разрешить
+func (l *linkResolver) known(ctx context.Context, link string) (string, bool) {
+	if !l.enabled() {
+		return link, true
+	}
+	var known string
+	err := db.QueryRowContext(ctx, `
+		(SELECT link FROM news WHERE source_link = $1 LIMIT 1)
+		UNION ALL
+		(SELECT link FROM news WHERE link = $1 LIMIT 1)
+		LIMIT 1
+	`, link).Scan(&known)
+	if err == nil {
+		return known, true
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Ошибка поиска канонической ссылки %s: %v", link, err)
+	}
+	return link, false
+}
+
+// enqueue ставит новость в очередь разрешения. При переполненной очереди
+// ссылка остаётся исходной, а полный текст запрашивается по ней.
+func (l *linkResolver) enqueue(task resolveTask) {
+	select {
+	case l.tasks <- task:
+	default:
+		log.Printf("[WARN] Очередь разрешения ссылок переполнена, ссылка %s остаётся исходной", task.link)
+		if task.extract {
+			extractor.enqueue(extractTask{link: task.link, feedURL: task.feedURL})
+		}
+	}
+}
+
+// run запускает воркеры разрешения до отмены ctx
+func (l *linkResolver) run(ctx context.Context) {
+	if !l.enabled() {
+		return
+	}
+	for i := 0; i < resolveWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case task := <-l.tasks:
+					l.process(ctx, task)
+				}
+			}
+		}()
+	}
+}
+
+func (l *linkResolver) process(ctx context.Context, task resolveTask) {
+	link := stripTrackingParams(task.link)
+	resolveCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	resolved, err := l.resolve(resolveCtx, task.link)
+	cancel()
+	switch {
+	case err != nil:
+		log.Printf("Не удалось разрешить ссылку %s: %v", task.link, err)
+	case len(resolved) > maxLinkLength:
+	case stripTrackingParams(resolved) != link:
+		canonical := stripTrackingParams(resolved)
+		stored, err := applyCanonicalLink(ctx, task.id, canonical)
+		if err != nil {
+			log.Printf("Ошибка сохранения канонической ссылки новости %d: %v", task.id, err)
+			break
+		}
+		log.Printf("Ссылка %s ведёт на %s", task.link, canonical)
+		link = stored
+	}
+	if task.extract {
+		extractor.enqueue(extractTask{link: link, feedURL: task.feedURL})
+	}
+}
+
+// applyCanonicalLink заменяет ссылку новости id конечным URL. Если он уже
+// у другой новости, ссылка остаётся прежней, а новость помечается дублем
+// той. Возвращает ссылку, под которой новость хранится.
+func applyCanonicalLink(ctx context.Context, id int, canonical string) (string, error) {
+	var link string
+	err := db.QueryRowContext(ctx, `
+		UPDATE news SET link = $2, link_key = $3
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM news WHERE link = $2)
+		RETURNING link
+	`, id, canonical, linkKey(canonical)).Scan(&link)
+	if err == nil {
+		searchIndex.enqueue(id)
+		return link, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = db.QueryRowContext(ctx, `
+		UPDATE news SET duplicate_of = o.id, duplicate_reason = 'link'
+		FROM news o
+		WHERE news.id = $1 AND o.link = $2 AND o.id <> news.id AND news.duplicate_of IS NULL
+		RETURNING news.link
+	`, id, canonical).Scan(&link)
+	if err == nil {
+		searchIndex.enqueue(id)
+		return link, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = db.QueryRowContext(ctx, `SELECT link FROM news WHERE id = $1`, id).Scan(&link)
+	return link, err
+}
+
+// resolve проходит по редиректам: сначала HEAD, а если сервер его не
+// поддерживает — GET без чтения тела
+func (l *linkResolver) resolve(ctx context.Context, link string) (string, error) {
+	final, status, err := l.request(ctx, http.MethodHead, link)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		final, status, err = l.request(ctx, http.MethodGet, link)
+	}
+	if err != nil {
+		return "", err
+	}
+	if status >= 400 {
+		return "", fmt.Errorf("HTTP %d", status)
+	}
+	return final, nil
+}
+
+// request конечный URL и статус; тело ответа не читается, очередь хоста
+// освобождается сразу
+func (l *linkResolver) request(ctx context.Context, method, link string) (string, int, error) {
+	header := http.Header{"User-Agent": {"news-service/1.0 (+link resolver)"}}
+	resp, release, err := politeGet(ctx, l.client, method, link, header, l.maxHops)
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+	release()
+	return resp.Request.URL.String(), resp.StatusCode, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Рубрики новостей. Категории элементов лент сохраняются в news_tags
+// (по строке на пару новость — рубрика); по ним работает фильтр
+// category= в /news/filter и список /news/categories.
+
+// CategoryStat рубрика и число её новостей
+type CategoryStat struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+// Ограничение длины колонки news_tags.tag
+const maxCategoryLength = 255
+
+// normalizeCategories убирает пустые рубрики и повторы без учёта
+// регистра, сохраняя порядок из ленты
+func normalizeCategories(raw []string) []string {
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for _, c := range raw {
+		c = strings.TrimSpace(c)
+		if runes := []rune(c); len(runes) > maxCategoryLength {
+			c = string(runes[:maxCategoryLength])
+		}
+		key := strings.ToLower(c)
+		if c == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, c)
+	}
+	return tags
+}
+
+// saveNewsTags сохраняет рубрики новости с указанной ссылкой. При
+// replace прежние рубрики удаляются (перезапись при replay).
+func saveNewsTags(ctx context.Context, link string, categories []string, replace bool) error {
+	tags := normalizeCategories(categories)
+	if replace {
+		if _, err := db.ExecContext(ctx, `
+			DELETE FROM news_tags
+			WHERE news_id = (SELECT id FROM news WHERE link = $1)
+		`, link); err != nil {
+			return err
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO news_tags (news_id, tag)
+		SELECT n.id, t.tag
+		FROM news n, unnest($2::text[]) AS t(tag)
+		WHERE n.link = $1
+		ON CONFLICT DO NOTHING
+	`, link, pq.Array(tags))
+	return err
+}
+
+// categoriesHandler возвращает список рубрик с количеством новостей
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+	log.Printf("Запрос списка рубрик, request_id: %s", requestID)
+
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	categories, err := getCategories(ctx)
+	if err != nil {
+		log.Printf("Ошибка получения рубрик: %v", err)
+		http.Error(w, "Failed to get categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// getCategories получает рубрики опубликованных новостей, самые
+// наполненные первыми. Рубрики, различающиеся только регистром,
+// считаются одной.
+func getCategories(ctx context.Context) ([]CategoryStat, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT MIN(t.tag), COUNT(DISTINCT t.news_id)
+		FROM news_tags t
+		JOIN news n ON n.id = t.news_id
+		WHERE n.available_at <= NOW()
+		GROUP BY LOWER(t.tag)
+		ORDER BY COUNT(DISTINCT t.news_id) DESC, MIN(t.tag) ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []CategoryStat{}
+	for rows.Next() {
+		var c CategoryStat
+		if err := rows.Scan(&c.Category, &c.Count); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
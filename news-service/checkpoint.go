@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Контрольные точки загрузки: для каждой ленты хранится время последней
+// успешной загрузки и GUID самой свежей обработанной новости. После
+// перезапуска сервис не загружает ленты, обновлённые в пределах их
+// интервала загрузки, а при загрузке пропускает уже обработанные элементы.
+// Там же ведётся статистика загрузок: последняя ошибка, число неудач
+// подряд, добавленные новости и длительность — по ней в
+// /admin/feeds/status видно, почему источник перестал давать новости.
+
+// FeedCheckpoint состояние загрузки ленты
+type FeedCheckpoint struct {
+	FeedURL         string     `json:"feed_url"`
+	LastSuccessAt   *time.Time `json:"last_success_at,omitempty"`
+	LastItemGUID    string     `json:"last_item_guid,omitempty"`
+	LastItemPubDate *time.Time `json:"last_item_pub_date,omitempty"`
+	// LastItemCount элементов в ленте при последней загрузке
+	LastItemCount int `json:"last_item_count"`
+	// EmptyFetches загрузок, в которых лента не дала ни одного элемента
+	EmptyFetches int `json:"empty_fetches"`
+	// LastError и LastErrorAt последняя неудачная загрузка
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// ConsecutiveFailures неудачных загрузок подряд; сбрасывается успешной
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Quarantined лента в карантине: загружается раз в quarantine_interval_sec
+	Quarantined bool `json:"quarantined"`
+	// Трафик ленты за текущие сутки и её суточный лимит (0 — без лимита);
+	// заполняются только в /admin/feeds/status
+	BytesToday       int64 `json:"bytes_today"`
+	FetchMsToday     int64 `json:"fetch_ms_today"`
+	FetchesToday     int   `json:"fetches_today"`
+	DailyBudgetBytes int64 `json:"daily_budget_bytes"`
+	OverBudget       bool  `json:"over_budget"`
+	// LastItemsAdded и TotalItemsAdded новостей, добавленных последней
+	// успешной загрузкой и за всё время
+	LastItemsAdded  int   `json:"last_items_added"`
+	TotalItemsAdded int64 `json:"total_items_added"`
+	// LastDurationMs длительность последней загрузки, успешной или нет
+	LastDurationMs int `json:"last_duration_ms"`
+	// ETag и LastModified валидаторы последнего ответа 200 для условных
+	// запросов; NotModifiedFetches — загрузок, завершившихся ответом 304
+	ETag               string    `json:"etag,omitempty"`
+	LastModified       string    `json:"last_modified,omitempty"`
+	NotModifiedFetches int       `json:"not_modified_fetches"`
+	UpdatedAt          time.Time `json:"updated_at"`
+
+	// Source, SourceDBID и Enabled источник ленты из таблицы sources;
+	// пустые, если источник удалён
+	Source     string `json:"source,omitempty"`
+	SourceDBID *int   `json:"source_db_id,omitempty"`
+	Enabled    *bool  `json:"enabled,omitempty"`
+	// Languages новости источника по языкам (unknown — язык не определён);
+	// только в /admin/feeds/status
+	Languages map[string]int `json:"languages,omitempty"`
+}
+
+const checkpointColumns = `last_success_at, last_item_guid, last_item_pub_date, last_item_count, empty_fetches,
+	last_error, last_error_at, consecutive_failures, last_items_added, total_items_added, last_duration_ms, etag, last_modified, not_modified_fetches, updated_at`
+
+// checkpointFields поля FeedCheckpoint в порядке checkpointColumns
+func (cp *FeedCheckpoint) checkpointFields() []interface{} {
+	return []interface{}{&cp.LastSuccessAt, &cp.LastItemGUID, &cp.LastItemPubDate, &cp.LastItemCount, &cp.EmptyFetches,
+		&cp.LastError, &cp.LastErrorAt, &cp.ConsecutiveFailures, &cp.LastItemsAdded, &cp.TotalItemsAdded,
+		&cp.LastDurationMs, &cp.ETag, &cp.LastModified, &cp.NotModifiedFetches, &cp.UpdatedAt}
+}
+
+// itemGUID идентификатор элемента ленты: <guid>, иначе ссылка
+func itemGUID(item FeedItem) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return guid
+	}
+	return strings.TrimSpace(item.Link)
+}
+
+func getCheckpoint(ctx context.Context, feedURL string) (*FeedCheckpoint, error) {
+	cp := &FeedCheckpoint{FeedURL: feedURL}
+	err := db.QueryRowContext(ctx, `SELECT `+checkpointColumns+` FROM feed_checkpoints WHERE feed_url = $1`, feedURL).
+		Scan(cp.checkpointFields()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cp, err
+}
+
+// saveCheckpoint фиксирует успешную загрузку ленты из itemCount элементов,
+// добавившую added новостей, и валидаторы ответа. Если новых элементов не
+// было, GUID последней новости остаётся прежним.
+func saveCheckpoint(ctx context.Context, feedURL, lastGUID string, lastPubDate *time.Time, itemCount, added int, duration time.Duration, v feedValidators) error {
+	emptyFetch := 0
+	if itemCount == 0 {
+		emptyFetch = 1
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO feed_checkpoints (feed_url, last_success_at, last_item_guid, last_item_pub_date, last_item_count, empty_fetches,
+			last_items_added, total_items_added, last_duration_ms, etag, last_modified, updated_at)
+		VALUES ($1, NOW(), $2, $3, $4, $5, $6, $6, $7, $8, $9, NOW())
+		ON CONFLICT (feed_url) DO UPDATE SET
+			last_success_at = NOW(),
+			last_item_guid = CASE WHEN EXCLUDED.last_item_guid = '' THEN feed_checkpoints.last_item_guid ELSE EXCLUDED.last_item_guid END,
+			last_item_pub_date = COALESCE(EXCLUDED.last_item_pub_date, feed_checkpoints.last_item_pub_date),
+			last_item_count = EXCLUDED.last_item_count,
+			empty_fetches = feed_checkpoints.empty_fetches + EXCLUDED.empty_fetches,
+			consecutive_failures = 0,
+			last_items_added = EXCLUDED.last_items_added,
+			total_items_added = feed_checkpoints.total_items_added + EXCLUDED.last_items_added,
+			last_duration_ms = EXCLUDED.last_duration_ms,
+			etag = EXCLUDED.etag,
+			last_modified = EXCLUDED.last_modified,
+			updated_at = NOW()
+	`, feedURL, lastGUID, lastPubDate, itemCount, emptyFetch, added, duration.Milliseconds(), v.ETag, v.LastModified)
+	return err
+}
+
+// saveNotModified фиксирует загрузку, на которую лента ответила 304:
+// она успешна, но контрольная точка и валидаторы не меняются
+func saveNotModified(ctx context.Context, feedURL string, duration time.Duration) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE feed_checkpoints
+		SET last_success_at = NOW(),
+			consecutive_failures = 0,
+			last_items_added = 0,
+			last_duration_ms = $2,
+			not_modified_fetches = not_modified_fetches + 1,
+			updated_at = NOW()
+		WHERE feed_url = $1
+	`, feedURL, duration.Milliseconds())
+	return err
+}
+
+// saveFetchFailure фиксирует неудачную загрузку ленты и возвращает
+// число неудач подряд; контрольная точка при этом не меняется
+func saveFetchFailure(ctx context.Context, feedURL string, fetchErr error, duration time.Duration) (failures int, err error) {
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO feed_checkpoints (feed_url, last_error, last_error_at, consecutive_failures, last_duration_ms, updated_at)
+		VALUES ($1, $2, NOW(), 1, $3, NOW())
+		ON CONFLICT (feed_url) DO UPDATE SET
+			last_error = EXCLUDED.last_error,
+			last_error_at = NOW(),
+			consecutive_failures = feed_checkpoints.consecutive_failures + 1,
+			last_duration_ms = EXCLUDED.last_duration_ms,
+			updated_at = NOW()
+		RETURNING consecutive_failures
+	`, feedURL, truncateRunes(fetchErr.Error(), 1000), duration.Milliseconds()).Scan(&failures)
+	return failures, err
+}
+
+// failureStreaks неудачи подряд по лентам, у которых они есть
+func failureStreaks(ctx context.Context) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT feed_url, consecutive_failures FROM feed_checkpoints WHERE consecutive_failures > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	streaks := make(map[string]int)
+	for rows.Next() {
+		var feedURL string
+		var n int
+		if err := rows.Scan(&feedURL, &n); err != nil {
+			return nil, err
+		}
+		streaks[feedURL] = n
+	}
+	return streaks, rows.Err()
+}
+
+// newItemsSince возвращает границы [from, to) элементов, появившихся в
+// ленте после контрольной точки. Обычно ленты отдают новости от новых к
+// старым, и новое — всё, что идёт до последнего обработанного GUID; если
+// первый элемент старше последнего, лента идёт от старых к новым, и новое
+// — всё после него. Если GUID в ленте не найден (лента обновилась
+// целиком), обрабатываются все элементы.
+func newItemsSince(items []FeedItem, lastGUID string) (from, to int) {
+	if lastGUID == "" {
+		return 0, len(items)
+	}
+	for i, item := range items {
+		if itemGUID(item) == lastGUID {
+			if oldestFirst(items) {
+				return i + 1, len(items)
+			}
+			return 0, i
+		}
+	}
+	return 0, len(items)
+}
+
+// oldestFirst лента отдаёт новости от старых к новым
+func oldestFirst(items []FeedItem) bool {
+	return len(items) > 1 && items[0].PubDate.Before(items[len(items)-1].PubDate)
+}
+
+// lastSuccess время последней успешной загрузки ленты; нулевое, если
+// лента ещё не загружалась или контрольную точку прочитать не удалось
+func lastSuccess(ctx context.Context, feedURL string) time.Time {
+	cp, err := getCheckpoint(ctx, feedURL)
+	if err != nil {
+		log.Printf("Ошибка чтения контрольной точки %s: %v", feedURL, err)
+		return time.Time{}
+	}
+	if cp == nil || cp.LastSuccessAt == nil {
+		return time.Time{}
+	}
+	return *cp.LastSuccessAt
+}
+
+// feedsStatusHandler возвращает состояние загрузки всех лент: и
+// источников, которые ещё ни разу не загружались, и удалённых
+// источников, для которых осталась контрольная точка. Ленты с
+// неудачными загрузками подряд идут первыми.
+func feedsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	languages, err := sourceLanguages(ctx)
+	if err != nil {
+		log.Printf("Ошибка подсчёта новостей по языкам: %v", err)
+		http.Error(w, "Failed to get feed status", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(c.feed_url, s.url), s.id, s.url, s.source_key, s.enabled,
+			COALESCE(c.last_item_guid, ''), c.last_success_at, c.last_item_pub_date,
+			COALESCE(c.last_item_count, 0), COALESCE(c.empty_fetches, 0),
+			COALESCE(c.last_error, ''), c.last_error_at, COALESCE(c.consecutive_failures, 0),
+			COALESCE(c.last_items_added, 0), COALESCE(c.total_items_added, 0),
+			COALESCE(c.last_duration_ms, 0), COALESCE(c.etag, ''), COALESCE(c.last_modified, ''),
+			COALESCE(c.not_modified_fetches, 0), COALESCE(c.updated_at, s.updated_at),
+			COALESCE(b.bytes, 0), COALESCE(b.fetch_ms, 0), COALESCE(b.fetches, 0),
+			COALESCE(s.daily_budget_bytes, 0)
+		FROM feed_checkpoints c
+		FULL OUTER JOIN sources s ON s.url = c.feed_url
+		LEFT JOIN feed_bandwidth b ON b.feed_url = COALESCE(c.feed_url, s.url) AND b.day = CURRENT_DATE
+		ORDER BY COALESCE(c.consecutive_failures, 0) DESC, 1
+	`)
+	if err != nil {
+		log.Printf("Ошибка получения контрольных точек: %v", err)
+		http.Error(w, "Failed to get feed status", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	checkpoints := []FeedCheckpoint{}
+	for rows.Next() {
+		var cp FeedCheckpoint
+		var sourceURL, sourceKey sql.NullString
+		if err := rows.Scan(&cp.FeedURL, &cp.SourceDBID, &sourceURL, &sourceKey, &cp.Enabled,
+			&cp.LastItemGUID, &cp.LastSuccessAt, &cp.LastItemPubDate, &cp.LastItemCount, &cp.EmptyFetches,
+			&cp.LastError, &cp.LastErrorAt, &cp.ConsecutiveFailures, &cp.LastItemsAdded, &cp.TotalItemsAdded,
+			&cp.LastDurationMs, &cp.ETag, &cp.LastModified, &cp.NotModifiedFetches, &cp.UpdatedAt,
+			&cp.BytesToday, &cp.FetchMsToday, &cp.FetchesToday, &cp.DailyBudgetBytes); err != nil {
+			log.Printf("Ошибка чтения контрольной точки: %v", err)
+			http.Error(w, "Failed to get feed status", http.StatusInternalServerError)
+			return
+		}
+		if sourceURL.Valid {
+			cp.Source = feedSource{URL: sourceURL.String, ID: sourceKey.String}.sourceID()
+			cp.Languages = languages[cp.Source]
+		}
+		cp.Quarantined = quarantined(cp.ConsecutiveFailures)
+		cp.OverBudget = cp.DailyBudgetBytes > 0 && cp.BytesToday >= cp.DailyBudgetBytes
+		checkpoints = append(checkpoints, cp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkpoints)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Условная загрузка лент: ETag и Last-Modified ответа сохраняются в
+// контрольной точке и отправляются при следующей загрузке как
+// If-None-Match и If-Modified-Since. Лента, которая не изменилась,
+// отвечает 304 без тела — её не нужно ни скачивать, ни разбирать.
+
+// feedValidators валидаторы ответа ленты
+type feedValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// apply добавляет условные заголовки к запросу ленты
+func (v feedValidators) apply(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// validatorsFrom валидаторы из ответа 200; слишком длинные значения
+// не сохраняются
+func validatorsFrom(resp *http.Response) feedValidators {
+	v := feedValidators{
+		ETag:         strings.TrimSpace(resp.Header.Get("ETag")),
+		LastModified: strings.TrimSpace(resp.Header.Get("Last-Modified")),
+	}
+	if len(v.ETag) > 1000 {
+		v.ETag = ""
+	}
+	if len(v.LastModified) > 100 {
+		v.LastModified = ""
+	}
+	return v
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Отмена запросов к БД. Обработчики передают в запросы контекст HTTP-
+// запроса с собственным дедлайном: если клиент отключился или время
+// вышло, PostgreSQL прерывает запрос и соединение возвращается в пул.
+// Фоновые задания используют serviceCtx и прерываются при остановке.
+
+const (
+	// searchTimeout списки и поиск новостей
+	searchTimeout = 10 * time.Second
+	// lookupTimeout выборка по ключу: детальная новость, пакет, справочники
+	lookupTimeout = 5 * time.Second
+	// adminTimeout служебные маршруты
+	adminTimeout = 30 * time.Second
+)
+
+// handlerContext контекст запроса с дедлайном обработчика
+func handlerContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// isQueryTimeout запрос прерван по statement_timeout или дедлайну обработчика
+func isQueryTimeout(err error) bool {
+	return isStatementTimeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Пул соединений с БД. Размер пула и время жизни соединения задаются в
+// config.json (db_max_open_conns, db_max_idle_conns,
+// db_conn_max_lifetime_sec), а statement_timeout сессии —
+// db_statement_timeout_ms. Сервер прерывает запрос дольше таймаута,
+// поэтому медленный полнотекстовый поиск не занимает соединение
+// бесконечно и не выбирает весь пул; такой запрос списка новостей
+// получает 503. Миграции выполняются без таймаута.
+
+const (
+	defaultDBMaxOpenConns     = 20
+	defaultDBMaxIdleConns     = 10
+	defaultDBConnMaxLifetime  = 5 * time.Minute
+	defaultDBStatementTimeout = 10 * time.Second
+	pqQueryCanceledErrorCode  = "57014"
+)
+
+// dbPoolConfig настройки пула соединений
+type dbPoolConfig struct {
+	maxOpen          int
+	maxIdle          int
+	lifetime         time.Duration
+	statementTimeout time.Duration // 0 — без таймаута
+}
+
+// poolConfigFrom настройки пула из config.json с умолчаниями
+func poolConfigFrom(cfg config) (dbPoolConfig, error) {
+	pc := dbPoolConfig{
+		maxOpen:          defaultDBMaxOpenConns,
+		maxIdle:          defaultDBMaxIdleConns,
+		lifetime:         defaultDBConnMaxLifetime,
+		statementTimeout: defaultDBStatementTimeout,
+	}
+	if cfg.DBMaxOpenConns > 0 {
+		pc.maxOpen = cfg.DBMaxOpenConns
+	}
+	if cfg.DBMaxIdleConns > 0 {
+		pc.maxIdle = cfg.DBMaxIdleConns
+	}
+	if pc.maxIdle > pc.maxOpen {
+		return pc, fmt.Errorf("db_max_idle_conns (%d) больше db_max_open_conns (%d)", pc.maxIdle, pc.maxOpen)
+	}
+	if cfg.DBConnMaxLifetimeSec > 0 {
+		pc.lifetime = time.Duration(cfg.DBConnMaxLifetimeSec) * time.Second
+	}
+	if cfg.DBStatementTimeoutMs != 0 {
+		pc.statementTimeout = time.Duration(max(cfg.DBStatementTimeoutMs, 0)) * time.Millisecond
+	}
+	return pc, nil
+}
+
+// openDB открывает пул; statement_timeout передаётся параметром сессии
+// в строке подключения и действует на каждое соединение пула
+func openDB(connStr string, pc dbPoolConfig) (*sql.DB, error) {
+	if pc.statementTimeout > 0 {
+		connStr += fmt.Sprintf(" statement_timeout=%d", pc.statementTimeout.Milliseconds())
+	}
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	pool := sql.OpenDB(metricsConnector{connector})
+	pool.SetMaxOpenConns(pc.maxOpen)
+	pool.SetMaxIdleConns(pc.maxIdle)
+	pool.SetConnMaxLifetime(pc.lifetime)
+	return pool, nil
+}
+
+// isStatementTimeout запрос прерван по statement_timeout
+func isStatementTimeout(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqQueryCanceledErrorCode
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Дедупликация новостей. Одна и та же история приходит из разных лент
+// под разными ссылками. Перед сохранением новой новости ищется
+// оригинал: сначала по нормализованной ссылке (link_key — без схемы,
+// www., фрагмента, трекинговых параметров и с отсортированным запросом),
+// затем по simhash заголовка среди новостей за dedup_window_hours
+// вокруг её pub_date. Найденный дубль сохраняется со ссылкой на
+// оригинал (duplicate_of) и не попадает в списки, пока клиенту виден
+// оригинал: если оригинал ещё под эмбарго или недоступен в стране
+// клиента, вместо него показывается первая видимая копия. Детальная
+// новость перечисляет связанные публикации в related. Ложные
+// срабатывания снимаются через DELETE /admin/duplicates/{id}.
+
+const (
+	defaultTitleDistance = 3
+	defaultDedupWindow   = 48 * time.Hour
+	// заголовки короче не сравниваются: слишком много общих слов
+	minTitleTokens = 4
+	// сколько новостей окна сравнивается по заголовку
+	dedupScanLimit = 2000
+)
+
+// Причины, по которым новость признана дублем
+const (
+	duplicateByLink  = "link"
+	duplicateByTitle = "title"
+)
+
+var (
+	// titleDistance порог расстояния Хэмминга для заголовков; -1 —
+	// сравнение заголовков выключено
+	titleDistance = defaultTitleDistance
+	dedupWindow   = defaultDedupWindow
+)
+
+// trackingParams параметры ссылок, не влияющие на содержимое страницы.
+// ref, from и rss сюда не входят: у многих изданий они выбирают
+// материал или его версию
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "yclid": true, "dclid": true, "msclkid": true,
+	"mc_cid": true, "mc_eid": true, "_ga": true, "_openstat": true, "ref_src": true,
+	"cmpid": true, "igshid": true,
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// stripTrackingParams убирает из ссылки трекинговые параметры и
+// фрагмент; ссылка остаётся рабочей
+func stripTrackingParams(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	q := u.Query()
+	changed := u.Fragment != ""
+	for name := range q {
+		if isTrackingParam(name) {
+			q.Del(name)
+			changed = true
+		}
+	}
+	if !changed {
+		return link
+	}
+	u.Fragment = ""
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// linkKey ключ ссылки для сравнения: хост без www. и порта по
+// умолчанию, путь без завершающего слэша, параметры без трекинговых
+// в порядке имён. Схема не учитывается. AMP-версии и зеркала сводятся
+// к основному адресу (см. mirrors.go)
+func linkKey(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return strings.ToLower(link)
+	}
+	u = foldAMP(u)
+	host := mirrors.canonical(strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."))
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	q := u.Query()
+	var names []string
+	for name := range q {
+		if !isTrackingParam(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		values := q[name]
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(v))
+		}
+	}
+	key := host + path
+	if len(params) > 0 {
+		key += "?" + strings.Join(params, "&")
+	}
+	if len(key) > maxLinkLength {
+		key = key[:maxLinkLength]
+	}
+	return key
+}
+
+// titleFingerprint simhash заголовка по отдельным словам: в коротком
+// тексте шинглы из нескольких слов почти не пересекаются. Для коротких
+// заголовков — nil
+func titleFingerprint(title string) *int64 {
+	tokens := contentTokens(title)
+	if len(tokens) < minTitleTokens {
+		return nil
+	}
+	var weights [64]int
+	for _, token := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	var fp uint64
+	for i, w := range weights {
+		if w > 0 {
+			fp |= 1 << uint(i)
+		}
+	}
+	v := int64(fp)
+	return &v
+}
+
+// duplicateMatch оригинал, дублем которого признана новость
+type duplicateMatch struct {
+	id     int
+	reason string
+	// stored сама новость, сохранённая под исходной ссылкой из ленты
+	// (до очистки трекинговых параметров ссылки сохранялись как есть)
+	stored bool
+}
+
+// findDuplicate ищет оригинал новой новости по ключу ссылки, а затем
+// по заголовку; nil — новость самостоятельная. Новость, уже сохранённая
+// под исходной ссылкой sourceLink, дублем самой себя не считается:
+// возвращается совпадение с stored.
+func findDuplicate(ctx context.Context, link, sourceLink, key string, titleFP *int64, pubDate time.Time) (*duplicateMatch, error) {
+	var id int
+	var stored bool
+	err := db.QueryRowContext(ctx, `
+		SELECT id, link = $3 FROM news
+		WHERE link_key = $1 AND link <> $2 AND (duplicate_of IS NULL OR link = $3)
+		ORDER BY link = $3 DESC, id
+		LIMIT 1
+	`, key, link, sourceLink).Scan(&id, &stored)
+	if err == nil {
+		return &duplicateMatch{id: id, reason: duplicateByLink, stored: stored}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if titleFP == nil || titleDistance < 0 {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title_simhash FROM news
+		WHERE title_simhash IS NOT NULL AND duplicate_of IS NULL
+			AND pub_date BETWEEN $1 AND $2 AND link <> $3
+		ORDER BY pub_date DESC
+		LIMIT $4
+	`, pubDate.Add(-dedupWindow), pubDate.Add(dedupWindow), link, dedupScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	best, bestDistance := 0, titleDistance+1
+	for rows.Next() {
+		var candidate int
+		var fp int64
+		if err := rows.Scan(&candidate, &fp); err != nil {
+			return nil, err
+		}
+		if d := hammingDistance(uint64(fp), uint64(*titleFP)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if best == 0 {
+		return nil, nil
+	}
+	return &duplicateMatch{id: best, reason: duplicateByTitle}, nil
+}
+
+// backfillLinkKeys шаг миграции 0010: считает link_key и simhash
+// заголовка для новостей без ключа — сохранённых до появления
+// дедупликации и тех, чей ключ изменился вместе с linkKey. Уже
+// сохранённые новости дублями не помечаются
+func backfillLinkKeys(ctx context.Context, tx *sql.Tx) error {
+	// зеркала доменов входят в ключ
+	if err := mirrors.loadFrom(ctx, tx); err != nil {
+		return err
+	}
+	total := 0
+	for {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, COALESCE(link, ''), title
+			FROM news
+			WHERE link_key IS NULL
+			ORDER BY id
+			LIMIT $1
+		`, fingerprintBatch)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		var keys []string
+		// simhash коротких заголовков — NULL
+		var titleFPs []interface{}
+		for rows.Next() {
+			var id int
+			var link, title string
+			if err := rows.Scan(&id, &link, &title); err != nil {
+				rows.Close()
+				return err
+			}
+			var fp interface{}
+			if v := titleFingerprint(title); v != nil {
+				fp = *v
+			}
+			ids, keys, titleFPs = append(ids, id), append(keys, linkKey(link)), append(titleFPs, fp)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE news SET link_key = k.key, title_simhash = k.fp
+			FROM unnest($1::int[], $2::text[], $3::bigint[]) AS k(id, key, fp)
+			WHERE news.id = k.id
+		`, pq.Array(ids), pq.Array(keys), pq.Array(titleFPs)); err != nil {
+			return err
+		}
+		total += len(ids)
+	}
+	if total > 0 {
+		log.Printf("Рассчитаны ключи дедупликации для %d новостей", total)
+	}
+	return nil
+}
+
+// duplicateCondition условие для дублей в списках: дубль скрыт, если
+// клиенту на момент as_of виден его оригинал или более ранняя копия.
+// Внешняя таблица запроса — news без псевдонима.
+func (p paging) duplicateCondition(args *[]interface{}) string {
+	return fmt.Sprintf(`(duplicate_of IS NULL OR NOT EXISTS (
+		SELECT 1 FROM news o
+		WHERE (o.id = news.duplicate_of OR (o.duplicate_of = news.duplicate_of AND o.id < news.id))
+			AND %s AND %s))`, snapshotCondition(p.AsOf, args), p.geoCondition(args))
+}
+
+// RelatedNews публикация той же истории в другом источнике
+type RelatedNews struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Link        string    `json:"link"`
+	SourceID    string    `json:"source_id,omitempty"`
+	SourceTitle string    `json:"source_title,omitempty"`
+	PubDate     time.Time `json:"pub_date"`
+}
+
+// relatedNews оригинал и все его дубли, кроме самой новости, доступные
+// в стране p.Country
+func relatedNews(ctx context.Context, n *News, p paging) ([]RelatedNews, error) {
+	root := n.ID
+	if n.DuplicateOf != nil {
+		root = *n.DuplicateOf
+	}
+	args := []interface{}{root, n.ID}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, title, link, source_id, source_title, pub_date
+		FROM news
+		WHERE (id = $1 OR duplicate_of = $1) AND id <> $2 AND available_at <= NOW() AND %s
+		ORDER BY pub_date, id
+	`, p.geoCondition(&args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var related []RelatedNews
+	for rows.Next() {
+		var rn RelatedNews
+		if err := rows.Scan(&rn.ID, &rn.Title, &rn.Link, &rn.SourceID, &rn.SourceTitle, &rn.PubDate); err != nil {
+			return nil, err
+		}
+		related = append(related, rn)
+	}
+	return related, rows.Err()
+}
+
+// DuplicateRecord новость, признанная дублем
+type DuplicateRecord struct {
+	RelatedNews
+	DuplicateOf int    `json:"duplicate_of"`
+	Reason      string `json:"reason"`
+	Original    string `json:"original_title"`
+}
+
+// duplicatesHandler GET /admin/duplicates?limit= — последние дубли
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.id, d.title, d.link, d.source_id, d.source_title, d.pub_date, d.duplicate_of, d.duplicate_reason, o.title
+		FROM news d
+		JOIN news o ON o.id = d.duplicate_of
+		ORDER BY d.id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		log.Printf("Ошибка получения дублей: %v", err)
+		http.Error(w, "Failed to get duplicates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	duplicates := []DuplicateRecord{}
+	for rows.Next() {
+		var d DuplicateRecord
+		if err := rows.Scan(&d.ID, &d.Title, &d.Link, &d.SourceID, &d.SourceTitle, &d.PubDate, &d.DuplicateOf, &d.Reason, &d.Original); err != nil {
+			log.Printf("Ошибка чтения дубля: %v", err)
+			http.Error(w, "Failed to get duplicates", http.StatusInternalServerError)
+			return
+		}
+		duplicates = append(duplicates, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(duplicates)
+}
+
+// duplicateHandler DELETE /admin/duplicates/{id} — новость перестаёт
+// считаться дублем и возвращается в списки
+func duplicateHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/duplicates/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid news ID", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `
+		UPDATE news SET duplicate_of = NULL, duplicate_reason = ''
+		WHERE id = $1 AND duplicate_of IS NOT NULL
+	`, id)
+	if err != nil {
+		log.Printf("Ошибка снятия отметки дубля с новости %d: %v", id, err)
+		http.Error(w, "Failed to update news", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Duplicate not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Новость %d больше не считается дублем, request_id: %s", id, requestID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// String описание дубля для журнала
+func (m *duplicateMatch) String() string {
+	return fmt.Sprintf("дубль новости %d (%s)", m.id, m.reason)
+}
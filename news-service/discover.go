@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Поиск ленты по адресу сайта. GET /admin/sources/discover?url=example.com
+// скачивает страницу и возвращает ленты, объявленные в ней тегами
+// <link rel="alternate"> с типом RSS, Atom или JSON Feed. Если адрес сам
+// оказался лентой, возвращается он. Если объявленных лент нет,
+// проверяются типичные адреса (/feed, /rss.xml, ...): кандидатом
+// становится тот, что разбирается как лента. Выбранная лента добавляется
+// обычным POST /admin/sources. Запросы к сайту идут через ограничитель
+// по хосту, как и загрузка лент, и только в публичный интернет
+// (safeTransport). Ссылки типа application/json объявляют и API вроде
+// wp-json, поэтому такие кандидаты скачиваются и остаются, только если
+// разбираются как JSON Feed.
+
+// Редиректов при поиске ленты не больше
+const maxDiscoveryRedirects = 10
+
+var discoveryClient = noRedirectClient(0)
+
+// discoveryFeedTypes типы <link rel="alternate">, которые считаются лентой
+var discoveryFeedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/rdf+xml":   true,
+	"application/feed+json": true,
+	"application/json":      true,
+}
+
+// discoveryProbePaths адреса лент, которые проверяются, если страница не
+// объявляет ни одной
+var discoveryProbePaths = []string{"/feed", "/rss", "/feed.xml", "/rss.xml", "/atom.xml", "/index.xml", "/feed.json"}
+
+// FeedCandidate лента, найденная на сайте
+type FeedCandidate struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+	Type  string `json:"type,omitempty"`
+	// Found как найдена: link — тег страницы, direct — адрес сам лента,
+	// probe — типичный адрес
+	Found string `json:"found"`
+	// SourceID ID источника, если лента уже добавлена
+	SourceID int `json:"source_id,omitempty"`
+}
+
+// FeedDiscoveryResponse ответ /admin/sources/discover
+type FeedDiscoveryResponse struct {
+	URL        string          `json:"url"`
+	Candidates []FeedCandidate `json:"candidates"`
+}
+
+// discoveryPage скачанная страница или лента
+type discoveryPage struct {
+	body        []byte
+	contentType string
+	// finalURL адрес после редиректов — база относительных ссылок
+	finalURL *url.URL
+}
+
+// discoverFetch скачивает адрес для поиска ленты
+func discoverFetch(ctx context.Context, link string) (*discoveryPage, error) {
+	header := http.Header{"User-Agent": {"news-service/1.0 (+feed discovery)"}}
+	resp, release, err := politeGet(ctx, discoveryClient, http.MethodGet, link, header, maxDiscoveryRedirects)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &feedStatusError{code: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleBytes))
+	if err != nil {
+		return nil, err
+	}
+	return &discoveryPage{body: body, contentType: resp.Header.Get("Content-Type"), finalURL: resp.Request.URL}, nil
+}
+
+// feedType тип ленты, если страница разбирается как лента
+func (p *discoveryPage) feedType() (string, bool) {
+	if _, err := parseFeed(p.body, p.contentType, p.finalURL.String()); err != nil {
+		return "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(p.contentType)
+	return mediaType, true
+}
+
+// feedLinks ленты, объявленные тегами <link rel="alternate">
+func (p *discoveryPage) feedLinks() []FeedCandidate {
+	base := p.finalURL
+	var found []FeedCandidate
+	for _, t := range tokenizeHTML(string(p.body)) {
+		if t.closing || (t.name != "link" && t.name != "base") {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, m := range htmlAttrPattern.FindAllStringSubmatch(t.attrs, -1) {
+			attrs[strings.ToLower(m[1])] = strings.TrimSpace(html.UnescapeString(m[2] + m[3] + m[4]))
+		}
+		if t.name == "base" {
+			if u, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				base = u
+			}
+			continue
+		}
+		feedType := strings.ToLower(attrs["type"])
+		if !containsString(strings.Fields(strings.ToLower(attrs["rel"])), "alternate") || !discoveryFeedTypes[feedType] {
+			continue
+		}
+		u, err := base.Parse(attrs["href"])
+		if err != nil || attrs["href"] == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		found = append(found, FeedCandidate{URL: u.String(), Title: attrs["title"], Type: feedType, Found: "link"})
+	}
+	return found
+}
+
+// discoverFeeds ленты сайта siteURL
+func discoverFeeds(ctx context.Context, siteURL string) ([]FeedCandidate, error) {
+	page, err := discoverFetch(ctx, siteURL)
+	if err != nil {
+		return nil, err
+	}
+	if feedType, ok := page.feedType(); ok {
+		return []FeedCandidate{{URL: page.finalURL.String(), Type: feedType, Found: "direct"}}, nil
+	}
+
+	candidates := []FeedCandidate{}
+	seen := make(map[string]bool)
+	for _, c := range page.feedLinks() {
+		key := sourceURLKey(c.URL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if c.Type == "application/json" {
+			p, err := discoverFetch(ctx, c.URL)
+			if err != nil {
+				continue
+			}
+			if _, ok := p.feedType(); !ok {
+				continue
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+
+	for _, path := range discoveryProbePaths {
+		if ctx.Err() != nil {
+			break
+		}
+		probe := page.finalURL.ResolveReference(&url.URL{Path: path}).String()
+		p, err := discoverFetch(ctx, probe)
+		if err != nil {
+			continue
+		}
+		if feedType, ok := p.feedType(); ok {
+			return []FeedCandidate{{URL: p.finalURL.String(), Type: feedType, Found: "probe"}}, nil
+		}
+	}
+	return candidates, nil
+}
+
+// discoverHandler GET /admin/sources/discover?url=
+func discoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	raw := strings.TrimSpace(r.URL.Query().Get("url"))
+	if raw != "" && !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be a site address, e.g. example.com or https://example.com", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	candidates, err := discoverFeeds(ctx, u.String())
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, поиск ленты %s отменён, request_id: %s", u, requestID)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка поиска ленты на %s: %v, request_id: %s", u, err, requestID)
+		http.Error(w, "Failed to fetch site", http.StatusBadGateway)
+		return
+	}
+
+	records, err := loadSources(ctx, false)
+	if err != nil {
+		log.Printf("Ошибка получения источников: %v", err)
+		http.Error(w, "Failed to get sources", http.StatusInternalServerError)
+		return
+	}
+	known := make(map[string]int, len(records))
+	for _, rec := range records {
+		known[sourceURLKey(rec.URL)] = rec.ID
+	}
+	for i := range candidates {
+		candidates[i].SourceID = known[sourceURLKey(candidates[i].URL)]
+	}
+	log.Printf("Поиск ленты на %s: найдено %d, request_id: %s", u, len(candidates), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FeedDiscoveryResponse{URL: u.String(), Candidates: candidates})
+}
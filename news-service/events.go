@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// События о новых новостях в NATS. На каждую новую новость в тему
+// NATS_SUBJECT (по умолчанию news.created) публикуется сообщение с её ID,
+// заголовком, ссылкой, источником и датами, чтобы кэш gateway, рассылки
+// и поисковый индекс обновлялись по подписке, а не опросом базы.
+// Событие о новости под эмбарго публикуется, когда наступает её
+// available_at, как и отправка вебхуков: до этого заголовок и ссылка не
+// покидают сервис. Публикация включается переменной NATS_URL
+// (nats://[user:pass@|token@]host:4222). Клиент реализует текстовый
+// протокол NATS поверх TCP без TLS. События копятся в очереди в памяти
+// (EVENTS_QUEUE_SIZE) и отправляются пачками; пачка считается
+// доставленной после PONG сервера на PING, иначе отправляется заново
+// после переподключения, так что подписчик может получить событие
+// дважды. При переполнении очереди и перезапуске сервиса события, в том
+// числе ожидающие конца эмбарго, теряются.
+
+// eventNewsCreated тип события о новой новости в NATS и вебхуках
+const eventNewsCreated = "news.created"
+
+const (
+	defaultNATSSubject      = eventNewsCreated
+	defaultEventsQueueSize  = 10000
+	eventsBatchSize         = 100
+	natsDialTimeout         = 5 * time.Second
+	natsFlushTimeout        = 5 * time.Second
+	maxNATSReconnectBackoff = 30 * time.Second
+)
+
+// NewsEvent сообщение о новой новости
+type NewsEvent struct {
+	Event       string    `json:"event"`
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Link        string    `json:"link"`
+	SourceID    string    `json:"source_id"`
+	SourceTitle string    `json:"source_title,omitempty"`
+	PubDate     time.Time `json:"pub_date"`
+	// AvailableAt конец эмбарго: до него новость не видна в API
+	AvailableAt time.Time `json:"available_at"`
+	// DuplicateOf оригинал истории; дубль не попадает в списки
+	DuplicateOf *int      `json:"duplicate_of,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// EventStats состояние публикации событий
+type EventStats struct {
+	Enabled   bool   `json:"enabled"`
+	Subject   string `json:"subject,omitempty"`
+	Connected bool   `json:"connected"`
+	Pending   int    `json:"pending"`
+	// Embargoed события, ждущие конца эмбарго новости
+	Embargoed  int        `json:"embargoed"`
+	Published  int        `json:"published"`
+	Dropped    int        `json:"dropped"`
+	Reconnects int        `json:"reconnects"`
+	LastError  string     `json:"last_error,omitempty"`
+	LastErrAt  *time.Time `json:"last_error_at,omitempty"`
+}
+
+// eventPublisher очередь событий и подключение к NATS
+type eventPublisher struct {
+	server  *url.URL
+	subject string
+	queue   chan NewsEvent
+	// wake будит run, когда появилось событие под эмбарго
+	wake chan struct{}
+
+	mu sync.Mutex
+	// embargoed события о новостях, available_at которых ещё не наступил
+	embargoed []NewsEvent
+	stats     EventStats
+}
+
+var events *eventPublisher
+
+func newEventPublisherFromEnv() (*eventPublisher, error) {
+	raw := os.Getenv("NATS_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("NATS_URL должен иметь вид nats://host:4222")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := os.Getenv("NATS_SUBJECT")
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+	if strings.ContainsAny(subject, " \t\r\n*>") {
+		return nil, fmt.Errorf("NATS_SUBJECT не может содержать пробелы и символы * и >")
+	}
+	size := defaultEventsQueueSize
+	if v := os.Getenv("EVENTS_QUEUE_SIZE"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			return nil, fmt.Errorf("EVENTS_QUEUE_SIZE должен быть положительным числом")
+		}
+	}
+	return &eventPublisher{
+		server:  u,
+		subject: subject,
+		queue:   make(chan NewsEvent, size),
+		wake:    make(chan struct{}, 1),
+		stats:   EventStats{Enabled: true, Subject: subject},
+	}, nil
+}
+
+func (p *eventPublisher) enabled() bool {
+	return p != nil
+}
+
+// publish ставит событие в очередь, не блокируя сохранение новости;
+// событие о новости под эмбарго ждёт её available_at
+func (p *eventPublisher) publish(ev NewsEvent) {
+	if !p.enabled() {
+		return
+	}
+	ev.Event = eventNewsCreated
+	if ev.AvailableAt.After(time.Now()) {
+		p.mu.Lock()
+		if len(p.embargoed) >= cap(p.queue) {
+			p.stats.Dropped++
+			p.mu.Unlock()
+			log.Printf("[WARN] Очередь событий под эмбарго переполнена, событие о новости %d потеряно", ev.ID)
+			return
+		}
+		p.embargoed = append(p.embargoed, ev)
+		p.mu.Unlock()
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	select {
+	case p.queue <- ev:
+	default:
+		p.mu.Lock()
+		p.stats.Dropped++
+		p.mu.Unlock()
+		log.Printf("[WARN] Очередь событий переполнена, событие о новости %d потеряно", ev.ID)
+	}
+}
+
+// due забирает события, эмбарго которых закончилось, и возвращает паузу
+// до ближайшего следующего (-1 — ждать нечего)
+func (p *eventPublisher) due(now time.Time) ([]NewsEvent, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ready []NewsEvent
+	wait := time.Duration(-1)
+	rest := p.embargoed[:0]
+	for _, ev := range p.embargoed {
+		if !ev.AvailableAt.After(now) {
+			ready = append(ready, ev)
+			continue
+		}
+		if w := ev.AvailableAt.Sub(now); wait < 0 || w < wait {
+			wait = w
+		}
+		rest = append(rest, ev)
+	}
+	p.embargoed = rest
+	return ready, wait
+}
+
+// run отправляет события до отмены ctx, переподключаясь при ошибках
+func (p *eventPublisher) run(ctx context.Context) {
+	var conn *natsConn
+	defer func() {
+		if conn != nil {
+			conn.close()
+		}
+	}()
+	var batch [][]byte
+	backoff := time.Second
+	for {
+		if len(batch) == 0 {
+			ready, wait := p.due(time.Now())
+			for _, ev := range ready {
+				batch = p.appendEvent(batch, ev)
+			}
+			if len(batch) == 0 {
+				var timer <-chan time.Time
+				if wait >= 0 {
+					timer = time.After(wait)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case ev := <-p.queue:
+					batch = p.appendEvent(batch, ev)
+				case <-p.wake:
+					continue
+				case <-timer:
+					continue
+				}
+			}
+		drain:
+			for len(batch) < eventsBatchSize {
+				select {
+				case ev := <-p.queue:
+					batch = p.appendEvent(batch, ev)
+				default:
+					break drain
+				}
+			}
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if conn == nil {
+			var err error
+			if conn, err = dialNATS(ctx, p.server); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.failed(fmt.Errorf("подключение к NATS %s: %w", p.server.Host, err))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > maxNATSReconnectBackoff {
+					backoff = maxNATSReconnectBackoff
+				}
+				continue
+			}
+			backoff = time.Second
+			p.mu.Lock()
+			p.stats.Connected = true
+			p.mu.Unlock()
+			log.Printf("Подключено к NATS %s, тема %s", p.server.Host, p.subject)
+		}
+
+		if err := conn.publish(p.subject, batch); err != nil {
+			conn.close()
+			conn = nil
+			p.failed(fmt.Errorf("публикация в NATS: %w", err))
+			p.mu.Lock()
+			p.stats.Connected = false
+			p.stats.Reconnects++
+			p.mu.Unlock()
+			continue
+		}
+		p.mu.Lock()
+		p.stats.Published += len(batch)
+		p.mu.Unlock()
+		batch = batch[:0]
+	}
+}
+
+// appendEvent добавляет событие к пачке; слишком большое для сервера
+// событие отбрасывается
+func (p *eventPublisher) appendEvent(batch [][]byte, ev NewsEvent) [][]byte {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Ошибка кодирования события о новости %d: %v", ev.ID, err)
+		return batch
+	}
+	return append(batch, data)
+}
+
+func (p *eventPublisher) failed(err error) {
+	log.Printf("Ошибка отправки событий: %v", err)
+	now := time.Now()
+	p.mu.Lock()
+	p.stats.LastError = err.Error()
+	p.stats.LastErrAt = &now
+	p.mu.Unlock()
+}
+
+// snapshot копия состояния для /admin/events
+func (p *eventPublisher) snapshot() EventStats {
+	if !p.enabled() {
+		return EventStats{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Pending = len(p.queue)
+	stats.Embargoed = len(p.embargoed)
+	return stats
+}
+
+// natsConn подключение к серверу NATS: публикация и ответы на PING
+type natsConn struct {
+	conn       net.Conn
+	maxPayload int
+
+	mu    sync.Mutex // запись в conn
+	w     *bufio.Writer
+	pongs chan struct{}
+	errs  chan error
+}
+
+// natsInfo поля приветствия INFO сервера
+type natsInfo struct {
+	MaxPayload  int  `json:"max_payload"`
+	TLSRequired bool `json:"tls_required"`
+}
+
+func dialNATS(ctx context.Context, server *url.URL) (*natsConn, error) {
+	d := net.Dialer{Timeout: natsDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", server.Host)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{
+		conn:  conn,
+		w:     bufio.NewWriter(conn),
+		pongs: make(chan struct{}, 1),
+		errs:  make(chan error, 1),
+	}
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info) != nil {
+		conn.Close()
+		return nil, fmt.Errorf("неожиданное приветствие сервера: %q", strings.TrimSpace(line))
+	}
+	if info.TLSRequired {
+		conn.Close()
+		return nil, errors.New("сервер требует TLS, который не поддерживается")
+	}
+	c.maxPayload = info.MaxPayload
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "news-service",
+		"lang":     "go",
+		"version":  "1.0",
+		"protocol": 0,
+	}
+	if user := server.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			opts["user"], opts["pass"] = user.Username(), pass
+		} else {
+			opts["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", connect)
+	if err := c.w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// при ошибке авторизации сервер отвечает -ERR вместо PONG
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, errors.New(line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	go c.read(r)
+	return c, nil
+}
+
+// read обрабатывает сообщения сервера до закрытия подключения
+func (c *natsConn) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			c.mu.Lock()
+			c.w.WriteString("PONG\r\n")
+			err = c.w.Flush()
+			c.mu.Unlock()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		case line == "PONG":
+			select {
+			case c.pongs <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			c.fail(errors.New(line))
+		}
+	}
+}
+
+func (c *natsConn) fail(err error) {
+	select {
+	case c.errs <- err:
+	default:
+	}
+}
+
+// publish отправляет пачку и ждёт PONG: сервер обработал все сообщения
+// перед PING
+func (c *natsConn) publish(subject string, batch [][]byte) error {
+	c.mu.Lock()
+	for _, data := range batch {
+		if c.maxPayload > 0 && len(data) > c.maxPayload {
+			log.Printf("Событие длиной %d байт больше max_payload сервера NATS (%d), пропущено", len(data), c.maxPayload)
+			continue
+		}
+		fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(data))
+		c.w.Write(data)
+		c.w.WriteString("\r\n")
+	}
+	c.w.WriteString("PING\r\n")
+	c.conn.SetWriteDeadline(time.Now().Add(natsFlushTimeout))
+	err := c.w.Flush()
+	c.conn.SetWriteDeadline(time.Time{})
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.pongs:
+		return nil
+	case err := <-c.errs:
+		return err
+	case <-time.After(natsFlushTimeout):
+		return errors.New("нет ответа PONG")
+	}
+}
+
+func (c *natsConn) close() {
+	c.conn.Close()
+}
+
+// eventsHandler GET /admin/events — состояние публикации событий
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events.snapshot())
+}
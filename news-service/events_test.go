@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewEventPublisherFromEnv(t *testing.T) {
+	t.Setenv("NATS_URL", "")
+	if p, err := newEventPublisherFromEnv(); p != nil || err != nil {
+		t.Fatalf("без NATS_URL: %v, %v", p, err)
+	}
+
+	t.Setenv("NATS_URL", "nats://secret@nats")
+	p, err := newEventPublisherFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.server.Host != "nats:4222" || p.subject != eventNewsCreated || cap(p.queue) != defaultEventsQueueSize {
+		t.Fatalf("по умолчанию: %s %s %d", p.server.Host, p.subject, cap(p.queue))
+	}
+
+	for env, value := range map[string]string{
+		"NATS_URL":          "http://nats:4222",
+		"NATS_SUBJECT":      "news.>",
+		"EVENTS_QUEUE_SIZE": "0",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := newEventPublisherFromEnv(); err == nil {
+				t.Errorf("%s=%s: ожидалась ошибка", env, value)
+			}
+		})
+	}
+}
+
+// fakeNATS принимает одно подключение, отвечает на PING и пересылает
+// строку CONNECT и тела PUB в каналы
+func fakeNATS(t *testing.T) (addr string, connects chan string, pubs chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	connects, pubs = make(chan string, 1), make(chan string, 10)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"max_payload\":1048576}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				connects <- strings.TrimPrefix(line, "CONNECT ")
+			case line == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case strings.HasPrefix(line, "PUB "):
+				fields := strings.Fields(line)
+				n, _ := strconv.Atoi(fields[len(fields)-1])
+				body := make([]byte, n+2)
+				if _, err := io.ReadFull(r, body); err != nil {
+					return
+				}
+				pubs <- fields[1] + " " + string(body[:n])
+			}
+		}
+	}()
+	return ln.Addr().String(), connects, pubs
+}
+
+func TestEventPublisherDeliversToNATS(t *testing.T) {
+	addr, connects, pubs := fakeNATS(t)
+	t.Setenv("NATS_URL", "nats://secret@"+addr)
+	t.Setenv("NATS_SUBJECT", "test.news")
+	p, err := newEventPublisherFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.run(ctx)
+
+	p.publish(NewsEvent{ID: 7, Title: "Заголовок", SourceID: "src", AvailableAt: time.Now().Add(-time.Minute)})
+
+	select {
+	case connect := <-connects:
+		var opts map[string]interface{}
+		if err := json.Unmarshal([]byte(connect), &opts); err != nil || opts["auth_token"] != "secret" {
+			t.Fatalf("CONNECT %s", connect)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("нет подключения к NATS")
+	}
+
+	select {
+	case pub := <-pubs:
+		subject, body, _ := strings.Cut(pub, " ")
+		var ev NewsEvent
+		if err := json.Unmarshal([]byte(body), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if subject != "test.news" || ev.Event != eventNewsCreated || ev.ID != 7 || ev.Title != "Заголовок" {
+			t.Fatalf("опубликовано %s: %+v", subject, ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("событие не опубликовано")
+	}
+
+	// пачка засчитывается после PONG
+	deadline := time.Now().Add(5 * time.Second)
+	for p.snapshot().Published != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("статистика %+v", p.snapshot())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := p.snapshot(); !st.Connected || st.Pending != 0 || st.Dropped != 0 {
+		t.Fatalf("статистика %+v", st)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Выгрузка новостей для аналитики и резервных копий:
+// GET /admin/export?format=ndjson|csv. Новости читаются порциями по
+// exportBatchSize в порядке ID (keyset по id, без OFFSET) и сразу
+// пишутся в ответ, поэтому память не зависит от объёма выгрузки, а
+// медленный клиент просто задерживает чтение следующей порции. В
+// выгрузку попадают все новости, включая дубли (duplicate_of) и ещё не
+// опубликованные. date_from/date_to (YYYY-MM-DD, по pub_date), source и
+// category сужают выборку, after_id продолжает прерванную выгрузку.
+// Ошибка посреди выгрузки обрывает ответ — продолжить можно с последнего
+// полученного id. Выгрузка раскрывает новости под эмбарго, поэтому
+// обработчик сам проверяет служебный токен, не полагаясь только на
+// serviceAuthMiddleware перед mux.
+
+const (
+	exportNDJSON    = "ndjson"
+	exportCSV       = "csv"
+	exportBatchSize = 1000
+)
+
+var exportCSVHeader = []string{"id", "pub_date", "created_at", "source_id", "source_title", "author",
+	"title", "link", "description", "content_text", "image_url", "geo_restriction", "duplicate_of", "language"}
+
+// exportHandler GET /admin/export
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+	if !serviceAuthorized(r) {
+		log.Printf("Выгрузка новостей без служебного токена отклонена, request_id: %s", requestID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = exportNDJSON
+	}
+	if format != exportNDJSON && format != exportCSV {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+
+	var args []interface{}
+	var conditions []string
+	afterID := 0
+	if v := q.Get("after_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			http.Error(w, "after_id must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		afterID = id
+	}
+	if v := q.Get("date_from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "date_from must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		args = append(args, t)
+		conditions = append(conditions, fmt.Sprintf("pub_date >= $%d", len(args)))
+	}
+	if v := q.Get("date_to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "date_to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		args = append(args, t.Add(24*time.Hour))
+		conditions = append(conditions, fmt.Sprintf("pub_date < $%d", len(args)))
+	}
+	if v := strings.TrimSpace(q.Get("source")); v != "" {
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf("source_id = $%d", len(args)))
+	}
+	if v := strings.TrimSpace(q.Get("category")); v != "" {
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM news_tags t WHERE t.news_id = news.id AND LOWER(t.tag) = LOWER($%d))", len(args)))
+	}
+	// после фильтров идут id последней новости порции и размер порции
+	conditions = append(conditions, fmt.Sprintf("id > $%d", len(args)+1))
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		WHERE %s
+		ORDER BY id
+		LIMIT $%d
+	`, newsColumns, strings.Join(conditions, " AND "), len(args)+2)
+
+	log.Printf("Выгрузка новостей (%s) начата с id > %d, request_id: %s", format, afterID, requestID)
+
+	ext, contentType := "ndjson", "application/x-ndjson; charset=utf-8"
+	if format == exportCSV {
+		ext, contentType = "csv", "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="news-export.%s"`, ext))
+
+	enc := json.NewEncoder(w)
+	cw := csv.NewWriter(w)
+	if format == exportCSV {
+		cw.Write(exportCSVHeader)
+	}
+	rc := http.NewResponseController(w)
+
+	exported := 0
+	for {
+		batch, err := exportBatch(r.Context(), query, append(args, afterID, exportBatchSize))
+		if err != nil {
+			if r.Context().Err() != nil {
+				log.Printf("Клиент отключился, выгрузка новостей прервана после id %d, request_id: %s", afterID, requestID)
+			} else {
+				log.Printf("Ошибка выгрузки новостей после id %d: %v, request_id: %s", afterID, err, requestID)
+			}
+			return
+		}
+		for _, n := range batch {
+			if format == exportCSV {
+				cw.Write(exportCSVRecord(n))
+			} else {
+				enc.Encode(n)
+			}
+		}
+		if format == exportCSV {
+			cw.Flush()
+			err = cw.Error()
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil && err != http.ErrNotSupported {
+			log.Printf("Выгрузка новостей прервана после id %d: %v, request_id: %s", afterID, err, requestID)
+			return
+		}
+		exported += len(batch)
+		if len(batch) < exportBatchSize {
+			break
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+	log.Printf("Выгрузка новостей (%s) завершена: %d, request_id: %s", format, exported, requestID)
+}
+
+// exportBatch очередная порция выгрузки
+func exportBatch(ctx context.Context, query string, args []interface{}) ([]News, error) {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	batch := make([]News, 0, exportBatchSize)
+	for rows.Next() {
+		n, err := scanNews(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, n)
+	}
+	return batch, rows.Err()
+}
+
+func exportCSVRecord(n News) []string {
+	duplicateOf := ""
+	if n.DuplicateOf != nil {
+		duplicateOf = strconv.Itoa(*n.DuplicateOf)
+	}
+	return []string{
+		strconv.Itoa(n.ID),
+		n.PubDate.UTC().Format(time.RFC3339),
+		n.CreatedAt.UTC().Format(time.RFC3339),
+		n.SourceID,
+		n.SourceTitle,
+		n.Author,
+		n.Title,
+		n.Link,
+		n.Description,
+		n.ContentText,
+		n.ImageURL,
+		strings.Join(n.GeoRestriction, ","),
+		duplicateOf,
+		n.Language,
+	}
+}
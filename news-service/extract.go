@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Полный текст статей. Многие ленты отдают только анонс. Для источников
+// с extract_full_content новая новость ставится в очередь, фоновый
+// воркер скачивает страницу по её ссылке, выделяет основной текст
+// (абзацы контейнера с наибольшим весом, как в readability) и сохраняет
+// его в content, если он длиннее текста из ленты. Чтобы не нагружать
+// сайты, страницы одного хоста запрашиваются не чаще раза в
+// extract_host_interval_sec, а очередь ограничена extract_queue_size.
+// Очередь живёт в памяти, поэтому при запуске в неё возвращаются
+// новости последних суток, текст которых так и не был извлечён.
+
+const (
+	defaultExtractHostInterval = 10 * time.Second
+	defaultExtractQueueSize    = 1000
+	extractTimeout             = 15 * time.Second
+	maxArticleBytes            = 2 << 20
+	maxExtractRedirects        = 10
+	// requeueExtractWindow при запуске в очередь возвращаются новости за
+	// этот срок без полного текста: очередь хранится только в памяти
+	requeueExtractWindow = 24 * time.Hour
+	// абзацы короче — подписи, кнопки и прочий шум
+	minParagraphChars = 25
+	// извлечённый текст короче считается неудачей
+	minArticleChars = 200
+)
+
+var (
+	extractHostInterval = defaultExtractHostInterval
+	extractQueueSize    = defaultExtractQueueSize
+)
+
+// containerTags теги, между которыми распределяется вес абзацев
+var containerTags = map[string]bool{"div": true, "article": true, "section": true, "main": true, "td": true, "body": true}
+
+// boilerplateTags теги разметки страницы, пропускаемые вместе с содержимым
+var boilerplateTags = map[string]bool{"nav": true, "header": true, "footer": true, "aside": true, "button": true}
+
+var (
+	classIDPattern     = regexp.MustCompile(`(?is)\b(?:class|id)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	negativeBlockRegex = regexp.MustCompile(`(?i)comment|sidebar|footer|nav|menu|promo|related|share|social|banner|advert|subscribe|cookie|popup`)
+)
+
+// extractTask новость, для которой нужен полный текст
+type extractTask struct {
+	link    string
+	feedURL string
+}
+
+// ExtractionStats счётчики извлечения полного текста
+type ExtractionStats struct {
+	Pending   int `json:"pending"`
+	Extracted int `json:"extracted"`
+	// Kept текст из ленты оказался не короче извлечённого
+	Kept    int `json:"kept"`
+	Failed  int `json:"failed"`
+	Dropped int `json:"dropped"`
+}
+
+type contentExtractor struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	pending   []extractTask
+	nextFetch map[string]time.Time // хост → когда его можно запрашивать снова
+	stats     ExtractionStats
+	wake      chan struct{}
+}
+
+var extractor = &contentExtractor{
+	client:    noRedirectClient(extractTimeout),
+	nextFetch: make(map[string]time.Time),
+	wake:      make(chan struct{}, 1),
+}
+
+// enqueue ставит новость в очередь; при переполненной очереди новость
+// остаётся с текстом из ленты
+func (e *contentExtractor) enqueue(task extractTask) {
+	e.mu.Lock()
+	if len(e.pending) >= extractQueueSize {
+		e.stats.Dropped++
+		e.mu.Unlock()
+		return
+	}
+	e.pending = append(e.pending, task)
+	e.mu.Unlock()
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next первая задача, хост которой можно запрашивать, или время,
+// когда такая появится
+func (e *contentExtractor) next(now time.Time) (extractTask, bool, time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wait := time.Duration(-1)
+	for i, task := range e.pending {
+		host := hostOf(task.link)
+		if at := e.nextFetch[host]; at.After(now) {
+			if d := at.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		e.pending = append(e.pending[:i], e.pending[i+1:]...)
+		e.nextFetch[host] = now.Add(extractHostInterval)
+		return task, true, 0
+	}
+	// интервалы прошедших хостов больше не нужны
+	for host, at := range e.nextFetch {
+		if !at.After(now) {
+			delete(e.nextFetch, host)
+		}
+	}
+	return extractTask{}, false, wait
+}
+
+func hostOf(link string) string {
+	if u, err := url.Parse(link); err == nil {
+		return strings.ToLower(u.Host)
+	}
+	return link
+}
+
+// requeuePending ставит в очередь недавние новости источников с
+// extract_full_content, текст которых не был извлечён до перезапуска
+func (e *contentExtractor) requeuePending(ctx context.Context) {
+	records, err := loadSources(ctx, true)
+	if err != nil {
+		log.Printf("Ошибка загрузки источников для извлечения текста: %v", err)
+		return
+	}
+	count := 0
+	for _, rec := range records {
+		if !rec.ExtractFullContent {
+			continue
+		}
+		src := rec.feed()
+		rows, err := db.QueryContext(ctx, `
+			SELECT link FROM news
+			WHERE source_id = $1 AND NOT content_extracted AND duplicate_of IS NULL AND created_at > $2
+			ORDER BY created_at DESC
+			LIMIT $3
+		`, src.sourceID(), time.Now().Add(-requeueExtractWindow), extractQueueSize)
+		if err != nil {
+			log.Printf("Ошибка выборки новостей %s для извлечения текста: %v", src.URL, err)
+			continue
+		}
+		for rows.Next() {
+			var link string
+			if err := rows.Scan(&link); err != nil {
+				log.Printf("Ошибка чтения новости для извлечения текста: %v", err)
+				break
+			}
+			e.enqueue(extractTask{link: link, feedURL: src.URL})
+			count++
+		}
+		rows.Close()
+	}
+	if count > 0 {
+		log.Printf("В очередь извлечения текста возвращено новостей: %d", count)
+	}
+}
+
+// run обрабатывает очередь до отмены ctx
+func (e *contentExtractor) run(ctx context.Context) {
+	for {
+		task, ok, wait := e.next(time.Now())
+		if ok {
+			e.process(ctx, task)
+			continue
+		}
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.wake:
+		case <-timer:
+		}
+	}
+}
+
+func (e *contentExtractor) process(ctx context.Context, task extractTask) {
+	content, err := e.fetchArticle(ctx, task.link)
+	if err == nil {
+		var kept bool
+		kept, err = saveExtractedContent(ctx, task.link, content)
+		e.mu.Lock()
+		if err == nil && kept {
+			e.stats.Kept++
+		} else if err == nil {
+			e.stats.Extracted++
+		}
+		e.mu.Unlock()
+	}
+	if err != nil {
+		log.Printf("Не удалось извлечь текст статьи %s (лента %s): %v", task.link, task.feedURL, err)
+		e.mu.Lock()
+		e.stats.Failed++
+		e.mu.Unlock()
+	}
+}
+
+// fetchArticle скачивает страницу статьи и выделяет основной текст
+func (e *contentExtractor) fetchArticle(ctx context.Context, link string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+	header := http.Header{
+		"User-Agent": {"news-service/1.0 (+content extractor)"},
+		"Accept":     {"text/html,application/xhtml+xml"},
+	}
+	resp, release, err := politeGet(ctx, e.client, http.MethodGet, link, header, maxExtractRedirects)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return "", fmt.Errorf("не HTML: %s", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleBytes))
+	if err != nil {
+		return "", err
+	}
+	content := extractArticle(string(body), resp.Request.URL)
+	if len([]rune(htmlToText(content))) < minArticleChars {
+		return "", fmt.Errorf("основной текст не найден")
+	}
+	return content, nil
+}
+
+// saveExtractedContent заменяет содержимое новости извлечённым текстом,
+// если он длиннее; true — текст из ленты оставлен
+func saveExtractedContent(ctx context.Context, link, content string) (bool, error) {
+	text := htmlToText(content)
+	var id int
+	err := db.QueryRowContext(ctx, `
+		UPDATE news
+		SET content = $2, content_text = $3, content_simhash = $4, content_extracted = TRUE
+		WHERE link = $1 AND char_length(content_text) < char_length($3)
+		RETURNING id
+	`, link, content, text, contentFingerprint("", content)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	searchIndex.enqueue(id)
+	return false, nil
+}
+
+// articleBlock контейнер страницы и вес его абзацев
+type articleBlock struct {
+	name     string
+	parent   int
+	score    float64
+	negative bool
+}
+
+type articleParagraph struct {
+	block int
+	html  string
+}
+
+// extractArticle основной текст страницы: абзацы <p> контейнера с
+// наибольшим весом и вложенных в него. Вес абзаца растёт с длиной и
+// числом запятых, контейнер получает вес своих абзацев полностью, а
+// родитель — наполовину. Навигация, шапка, подвал и блоки с классами
+// вроде comments или sidebar пропускаются.
+func extractArticle(page string, base *url.URL) string {
+	blocks := []articleBlock{{name: "", parent: -1}}
+	stack := []int{0}
+	var paragraphs []articleParagraph
+	var raw, text strings.Builder
+	inParagraph := false
+	skip := ""
+
+	flush := func() {
+		if !inParagraph {
+			return
+		}
+		inParagraph = false
+		plain := strings.TrimSpace(blankPattern.ReplaceAllString(html.UnescapeString(text.String()), " "))
+		if len([]rune(plain)) < minParagraphChars {
+			return
+		}
+		top := stack[len(stack)-1]
+		score := 1 + float64(strings.Count(plain, ",")+strings.Count(plain, "，")) + float64(len([]rune(plain)))/100
+		blocks[top].score += score
+		if p := blocks[top].parent; p >= 0 {
+			blocks[p].score += score / 2
+		}
+		paragraphs = append(paragraphs, articleParagraph{block: top, html: raw.String()})
+	}
+
+	for _, t := range tokenizeHTML(page) {
+		if skip != "" {
+			if t.closing && t.name == skip {
+				skip = ""
+			}
+			continue
+		}
+		switch {
+		case t.name == "":
+			if inParagraph {
+				raw.WriteString(t.text)
+				text.WriteString(t.text)
+			}
+		case (droppedTags[t.name] || boilerplateTags[t.name]) && !t.closing:
+			if !strings.HasSuffix(strings.TrimSpace(t.attrs), "/") {
+				skip = t.name
+			}
+		case t.name == "p":
+			flush()
+			if !t.closing {
+				inParagraph = true
+				raw.Reset()
+				text.Reset()
+			}
+		case containerTags[t.name]:
+			flush()
+			if !t.closing {
+				blocks = append(blocks, articleBlock{
+					name:     t.name,
+					parent:   stack[len(stack)-1],
+					negative: negativeBlockRegex.MatchString(classAndID(t.attrs)),
+				})
+				stack = append(stack, len(blocks)-1)
+				continue
+			}
+			for i := len(stack) - 1; i > 0; i-- {
+				if blocks[stack[i]].name == t.name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case inParagraph:
+			if t.closing {
+				raw.WriteString("</" + t.name + ">")
+			} else {
+				raw.WriteString("<" + t.name + t.attrs + ">")
+			}
+		}
+	}
+	flush()
+
+	// внутри отрицательного блока абзацы не учитываются
+	excluded := func(b int) bool {
+		for ; b >= 0; b = blocks[b].parent {
+			if blocks[b].negative {
+				return true
+			}
+		}
+		return false
+	}
+	best := -1
+	for i, b := range blocks {
+		if b.score > 0 && !excluded(i) && (best < 0 || b.score > blocks[best].score) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	within := func(b int) bool {
+		for ; b >= 0; b = blocks[b].parent {
+			if b == best {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out strings.Builder
+	for _, p := range paragraphs {
+		if !within(p.block) || excluded(p.block) {
+			continue
+		}
+		if s := sanitizeHTML("<p>"+p.html+"</p>", base); s != "" {
+			out.WriteString(s)
+			out.WriteString("\n")
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// classAndID значения атрибутов class и id тега
+func classAndID(attrs string) string {
+	var parts []string
+	for _, m := range classIDPattern.FindAllStringSubmatch(attrs, -1) {
+		parts = append(parts, m[1]+m[2])
+	}
+	return strings.Join(parts, " ")
+}
+
+// extractionHandler GET /admin/extraction — очередь и счётчики
+// извлечения полного текста
+func extractionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	extractor.mu.Lock()
+	stats := extractor.stats
+	stats.Pending = len(extractor.pending)
+	extractor.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
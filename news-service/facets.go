@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Гистограмма по датам для /news/filter. С facets=date ответ содержит
+// блок facets.date: число новостей, подходящих под все параметры запроса
+// (без учёта страницы), по дням публикации или, с facet_interval=month,
+// по месяцам. Фронтенд рисует по ней шкалу времени и выбор дат.
+// Гистограмма всегда считается в PostgreSQL, в том числе при поиске
+// через внешний движок.
+
+const (
+	facetDate = "date"
+
+	facetIntervalDay   = "day"
+	facetIntervalMonth = "month"
+)
+
+// NewsFacets дополнительные разрезы выдачи /news/filter
+type NewsFacets struct {
+	Date *DateFacet `json:"date,omitempty"`
+}
+
+// DateFacet число новостей по дням или месяцам
+type DateFacet struct {
+	Interval string       `json:"interval"`
+	Buckets  []DateBucket `json:"buckets"`
+}
+
+// DateBucket день (YYYY-MM-DD) или месяц (YYYY-MM) и число новостей
+type DateBucket struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// parseDateFacet разбирает facets и facet_interval; пустой интервал —
+// гистограмма не нужна
+func parseDateFacet(facets, interval string) (string, error) {
+	if facets == "" {
+		return "", nil
+	}
+	if facets != facetDate {
+		return "", fmt.Errorf("facets must be %s", facetDate)
+	}
+	switch interval {
+	case "":
+		return facetIntervalDay, nil
+	case facetIntervalDay, facetIntervalMonth:
+		return interval, nil
+	default:
+		return "", fmt.Errorf("facet_interval must be %s or %s", facetIntervalDay, facetIntervalMonth)
+	}
+}
+
+// getDateFacet гистограмма новостей фильтра f по интервалу
+func getDateFacet(ctx context.Context, f newsFilter, p paging, interval string) (*DateFacet, error) {
+	whereClause, _, args, err := newsFilterWhere(ctx, f, p)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT date_trunc('%s', pub_date) AS bucket, COUNT(*)
+		FROM news
+		%s AND pub_date IS NOT NULL
+		GROUP BY bucket
+		ORDER BY bucket
+	`, interval, whereClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	layout := "2006-01-02"
+	if interval == facetIntervalMonth {
+		layout = "2006-01"
+	}
+	facet := &DateFacet{Interval: interval, Buckets: []DateBucket{}}
+	for rows.Next() {
+		var bucket time.Time
+		var n int
+		if err := rows.Scan(&bucket, &n); err != nil {
+			return nil, err
+		}
+		facet.Buckets = append(facet.Buckets, DateBucket{Date: bucket.Format(layout), Count: n})
+	}
+	return facet, rows.Err()
+}
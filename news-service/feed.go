@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Разбор лент. RSS 2.0, RSS 1.0 (RDF), Atom 1.0 и JSON Feed 1.1 приводятся к общей
+// модели FeedItem, с которой работают загрузка, контрольные точки и
+// архив. JSON Feed определяется по Content-Type или суффиксу URL,
+// XML-форматы — по корневому элементу документа.
+
+const (
+	atomNamespace  = "http://www.w3.org/2005/Atom"
+	rdfNamespace   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rss10Namespace = "http://purl.org/rss/1.0/"
+)
+
+// FeedItem новость из ленты независимо от её формата
+type FeedItem struct {
+	Title       string
+	Description string
+	Content     string
+	Link        string
+	GUID        string
+	PubDate     time.Time
+	Author      string
+	// Categories рубрики элемента: <category> в RSS, dc:subject в RSS 1.0,
+	// <category> в Atom, tags в JSON Feed
+	Categories []string
+	// FeedTitle заголовок ленты, из которой получен элемент
+	FeedTitle string
+	// Images кандидаты в иллюстрации из вложений и media:* в порядке
+	// предпочтения; если их нет, иллюстрация ищется в тексте
+	Images []string
+}
+
+// feedParseError скачанную ленту не удалось разобрать
+type feedParseError struct {
+	err error
+}
+
+func (e *feedParseError) Error() string { return e.err.Error() }
+
+func (e *feedParseError) Unwrap() error { return e.err }
+
+// parseFeed определяет формат ленты и разбирает её. contentType может
+// быть пустым (например, при replay из архива).
+func parseFeed(body []byte, contentType, feedURL string) ([]FeedItem, error) {
+	if isJSONFeed(contentType, feedURL, body) {
+		return parseJSONFeed(body)
+	}
+	root, err := feedRoot(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга ленты: %v", err)
+	}
+	switch {
+	case root.Local == "rss":
+		return parseRSS(body)
+	case root.Local == "RDF" && root.Space == rdfNamespace:
+		return parseRDF(body)
+	case root.Local == "feed" && root.Space == atomNamespace:
+		return parseAtom(body)
+	default:
+		return nil, fmt.Errorf("неизвестный формат ленты: <%s>", root.Local)
+	}
+}
+
+// feedRoot имя корневого элемента XML
+func feedRoot(body []byte) (xml.Name, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return xml.Name{}, fmt.Errorf("пустой документ")
+		}
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
+
+// ─── RSS 2.0 ──────────────────────────────────────────────────────────────────
+
+// RSS структура для парсинга RSS-ленты
+type RSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel Channel  `xml:"channel"`
+}
+
+// Channel содержит список новостей
+type Channel struct {
+	Title string    `xml:"title"`
+	Items []rssItem `xml:"item"`
+}
+
+// rssItem представляет одну новость из RSS
+type rssItem struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	// media:* объявлены раньше Content: поле без пространства имён
+	// иначе забрало бы и <media:content>
+	Enclosures []feedEnclosure `xml:"enclosure"`
+	mediaRSS
+	Content    string   `xml:"content"`
+	Creator    string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Author     string   `xml:"author"`
+	Categories []string `xml:"category"`
+}
+
+func parseRSS(body []byte) ([]FeedItem, error) {
+	var rss RSS
+	if err := xml.Unmarshal(body, &rss); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга RSS: %v", err)
+	}
+	items := make([]FeedItem, 0, len(rss.Channel.Items))
+	for _, it := range rss.Channel.Items {
+		items = append(items, FeedItem{
+			Title:       it.Title,
+			Description: it.Description,
+			Content:     it.Content,
+			Link:        it.Link,
+			GUID:        it.GUID,
+			PubDate:     parsePubDate(it.PubDate),
+			Author:      extractAuthor(it),
+			Categories:  it.Categories,
+			FeedTitle:   strings.TrimSpace(rss.Channel.Title),
+			Images:      append(enclosureImages(it.Enclosures), it.mediaRSS.images()...),
+		})
+	}
+	return items, nil
+}
+
+// ─── RSS 1.0 (RDF) ────────────────────────────────────────────────────────────
+
+// В RSS 1.0 элементы <item> лежат рядом с <channel>, а не внутри него,
+// и все находятся в пространстве имён RSS 1.0 — поэтому структура RSS
+// 2.0 разбирает такую ленту без ошибок, но без единого элемента.
+type rdfFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF"`
+	Channel struct {
+		Title string `xml:"http://purl.org/rss/1.0/ title"`
+	} `xml:"http://purl.org/rss/1.0/ channel"`
+	Items []rdfItem `xml:"http://purl.org/rss/1.0/ item"`
+}
+
+type rdfItem struct {
+	About       string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string   `xml:"http://purl.org/rss/1.0/ title"`
+	Link        string   `xml:"http://purl.org/rss/1.0/ link"`
+	Description string   `xml:"http://purl.org/rss/1.0/ description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Subjects    []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+}
+
+func parseRDF(body []byte) ([]FeedItem, error) {
+	var feed rdfFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга RSS 1.0: %v", err)
+	}
+	items := make([]FeedItem, 0, len(feed.Items))
+	for _, it := range feed.Items {
+		items = append(items, FeedItem{
+			Title:       it.Title,
+			Description: it.Description,
+			Content:     it.Content,
+			Link:        it.Link,
+			GUID:        strings.TrimSpace(it.About),
+			PubDate:     parsePubDate(strings.TrimSpace(it.Date)),
+			Author:      limitAuthor(strings.TrimSpace(it.Creator)),
+			Categories:  it.Subjects,
+			FeedTitle:   strings.TrimSpace(feed.Channel.Title),
+		})
+	}
+	return items, nil
+}
+
+// ─── Atom 1.0 ─────────────────────────────────────────────────────────────────
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   atomText     `xml:"http://www.w3.org/2005/Atom title"`
+	Authors []atomPerson `xml:"http://www.w3.org/2005/Atom author"`
+	Entries []atomEntry  `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type atomEntry struct {
+	mediaRSS
+	ID         string         `xml:"http://www.w3.org/2005/Atom id"`
+	Title      atomText       `xml:"http://www.w3.org/2005/Atom title"`
+	Summary    atomText       `xml:"http://www.w3.org/2005/Atom summary"`
+	Content    atomText       `xml:"http://www.w3.org/2005/Atom content"`
+	Published  string         `xml:"http://www.w3.org/2005/Atom published"`
+	Updated    string         `xml:"http://www.w3.org/2005/Atom updated"`
+	Links      []atomLink     `xml:"http://www.w3.org/2005/Atom link"`
+	Authors    []atomPerson   `xml:"http://www.w3.org/2005/Atom author"`
+	Categories []atomCategory `xml:"http://www.w3.org/2005/Atom category"`
+}
+
+// atomText текстовая конструкция Atom: type="text" и "html" содержат
+// текст (html — экранированную разметку), "xhtml" — вложенный <div>
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (t atomText) value() string {
+	if t.Type != "xhtml" {
+		return strings.TrimSpace(t.Text)
+	}
+	inner := strings.TrimSpace(t.Inner)
+	// обёртку <div xmlns="http://www.w3.org/1999/xhtml"> не сохраняем
+	if strings.HasPrefix(inner, "<div") && strings.HasSuffix(inner, "</div>") {
+		if end := strings.Index(inner, ">"); end >= 0 {
+			inner = strings.TrimSpace(inner[end+1 : len(inner)-len("</div>")])
+		}
+	}
+	return inner
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// atomCategory рубрика Atom: term — идентификатор, label — название для людей
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+func atomCategories(cats []atomCategory) []string {
+	names := make([]string, 0, len(cats))
+	for _, c := range cats {
+		if strings.TrimSpace(c.Label) != "" {
+			names = append(names, c.Label)
+		} else {
+			names = append(names, c.Term)
+		}
+	}
+	return names
+}
+
+type atomPerson struct {
+	Name string `xml:"http://www.w3.org/2005/Atom name"`
+}
+
+func parseAtom(body []byte) ([]FeedItem, error) {
+	var feed atomFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга Atom: %v", err)
+	}
+	items := make([]FeedItem, 0, len(feed.Entries))
+	for _, e := range feed.Entries {
+		// published — дата первой публикации; если её нет, берём updated
+		date := strings.TrimSpace(e.Published)
+		if date == "" {
+			date = strings.TrimSpace(e.Updated)
+		}
+		authors := e.Authors
+		if len(authors) == 0 {
+			authors = feed.Authors
+		}
+		var author string
+		if len(authors) > 0 {
+			author = limitAuthor(strings.TrimSpace(authors[0].Name))
+		}
+		items = append(items, FeedItem{
+			Title:       e.Title.value(),
+			Description: e.Summary.value(),
+			Content:     e.Content.value(),
+			Link:        alternateLink(e.Links),
+			GUID:        strings.TrimSpace(e.ID),
+			PubDate:     parsePubDate(date),
+			Author:      author,
+			Categories:  atomCategories(e.Categories),
+			FeedTitle:   feed.Title.value(),
+			Images:      append(atomEnclosureImages(e.Links), e.mediaRSS.images()...),
+		})
+	}
+	return items, nil
+}
+
+// alternateLink ссылка на страницу новости: rel="alternate" (или без rel),
+// предпочтительно text/html
+func alternateLink(links []atomLink) string {
+	var fallback string
+	for _, l := range links {
+		if l.Rel != "" && l.Rel != "alternate" {
+			continue
+		}
+		if l.Type == "" || l.Type == "text/html" {
+			return strings.TrimSpace(l.Href)
+		}
+		if fallback == "" {
+			fallback = strings.TrimSpace(l.Href)
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Источники лент хранятся в таблице sources. Пока таблица пуста, она
+// заполняется списком rss из config.json; после этого источники
+// добавляются, меняются, отключаются и удаляются через /admin/sources
+// без перезапуска — планировщик перечитывает таблицу на каждом тике.
+
+// SourceRecord источник ленты в таблице sources
+type SourceRecord struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// SourceID и Title идентификатор и название издания (source_id и
+	// source_title новостей); пустые значения вычисляются по ленте
+	SourceID       string   `json:"source_id"`
+	Title          string   `json:"title"`
+	EmbargoMinutes int      `json:"embargo_minutes"`
+	GeoRestriction []string `json:"geo_restriction"`
+	Priority       string   `json:"priority"`
+	BypassEmbargo  bool     `json:"bypass_embargo"`
+	// FetchIntervalSec интервал загрузки; 0 — период приоритета
+	FetchIntervalSec int `json:"fetch_interval_sec"`
+	// DailyBudgetBytes суточный лимит скачанных байт; 0 — без лимита
+	DailyBudgetBytes int64 `json:"daily_budget_bytes"`
+	// ExtractFullContent полный текст новостей скачивается со страниц статей
+	ExtractFullContent bool `json:"extract_full_content"`
+	// Language конфигурация полнотекстового поиска; пустая — по тексту
+	Language string `json:"language"`
+	// Auth доступ к закрытой ленте без секретов
+	Auth      *SourceAuthInfo `json:"auth,omitempty"`
+	Enabled   bool            `json:"enabled"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	// credentials зашифрованный доступ, auth — расшифрованный
+	credentials string
+	auth        *SourceAuth
+}
+
+// sourcePatch поля запросов POST и PATCH; отсутствующие поля не меняются
+type sourcePatch struct {
+	URL                *string     `json:"url"`
+	SourceID           *string     `json:"source_id"`
+	Title              *string     `json:"title"`
+	EmbargoMinutes     *int        `json:"embargo_minutes"`
+	GeoRestriction     *[]string   `json:"geo_restriction"`
+	Priority           *string     `json:"priority"`
+	BypassEmbargo      *bool       `json:"bypass_embargo"`
+	FetchIntervalSec   *int        `json:"fetch_interval_sec"`
+	DailyBudgetBytes   *int64      `json:"daily_budget_bytes"`
+	ExtractFullContent *bool       `json:"extract_full_content"`
+	Language           *string     `json:"language"`
+	Auth               *SourceAuth `json:"auth"`
+	Enabled            *bool       `json:"enabled"`
+}
+
+func (p sourcePatch) apply(rec *SourceRecord) {
+	if p.URL != nil {
+		rec.URL = strings.TrimSpace(*p.URL)
+	}
+	if p.SourceID != nil {
+		rec.SourceID = strings.ToLower(strings.TrimSpace(*p.SourceID))
+	}
+	if p.Title != nil {
+		rec.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.EmbargoMinutes != nil {
+		rec.EmbargoMinutes = *p.EmbargoMinutes
+	}
+	if p.GeoRestriction != nil {
+		rec.GeoRestriction = *p.GeoRestriction
+	}
+	if p.Priority != nil {
+		rec.Priority = *p.Priority
+	}
+	if p.BypassEmbargo != nil {
+		rec.BypassEmbargo = *p.BypassEmbargo
+	}
+	if p.FetchIntervalSec != nil {
+		rec.FetchIntervalSec = *p.FetchIntervalSec
+	}
+	if p.DailyBudgetBytes != nil {
+		rec.DailyBudgetBytes = *p.DailyBudgetBytes
+	}
+	if p.ExtractFullContent != nil {
+		rec.ExtractFullContent = *p.ExtractFullContent
+	}
+	if p.Language != nil {
+		rec.Language = strings.ToLower(strings.TrimSpace(*p.Language))
+	}
+	if p.Enabled != nil {
+		rec.Enabled = *p.Enabled
+	}
+}
+
+// feed источник в виде, с которым работает загрузка
+func (rec SourceRecord) feed() feedSource {
+	return feedSource{
+		URL:                rec.URL,
+		ID:                 rec.SourceID,
+		Title:              rec.Title,
+		EmbargoMinutes:     rec.EmbargoMinutes,
+		GeoRestriction:     rec.GeoRestriction,
+		Priority:           rec.Priority,
+		BypassEmbargo:      rec.BypassEmbargo,
+		FetchIntervalSec:   rec.FetchIntervalSec,
+		DailyBudgetBytes:   rec.DailyBudgetBytes,
+		ExtractFullContent: rec.ExtractFullContent,
+		Language:           rec.Language,
+		auth:               rec.auth,
+	}
+}
+
+const sourceColumns = `id, url, source_key, title, embargo_minutes, geo_restriction, priority,
+	bypass_embargo, fetch_interval_sec, daily_budget_bytes, extract_full_content, language, credentials, enabled, created_at, updated_at`
+
+func scanSource(row rowScanner) (SourceRecord, error) {
+	var rec SourceRecord
+	var geoRestriction string
+	err := row.Scan(&rec.ID, &rec.URL, &rec.SourceID, &rec.Title, &rec.EmbargoMinutes, &geoRestriction,
+		&rec.Priority, &rec.BypassEmbargo, &rec.FetchIntervalSec, &rec.DailyBudgetBytes, &rec.ExtractFullContent,
+		&rec.Language, &rec.credentials, &rec.Enabled, &rec.CreatedAt, &rec.UpdatedAt)
+	if err == nil && rec.credentials != "" {
+		// без ключа или с другим ключом лента загружается без доступа
+		auth, authErr := openCredentials(rec.credentials)
+		if authErr != nil {
+			log.Printf("Источник %d (%s): %v", rec.ID, rec.URL, authErr)
+		}
+		rec.auth = auth
+		rec.Auth = auth.info()
+		if rec.Auth == nil {
+			rec.Auth = &SourceAuthInfo{}
+		}
+	}
+	rec.GeoRestriction = splitGeoRestriction(geoRestriction)
+	if rec.GeoRestriction == nil {
+		rec.GeoRestriction = []string{}
+	}
+	return rec, err
+}
+
+// seedSources однократно переносит источники из config.json в таблицу
+// sources. Перенос отмечается в service_markers, поэтому источники,
+// удалённые через /admin/sources, при перезапуске не возвращаются.
+func seedSources(ctx context.Context, sources []feedSource) error {
+	res, err := db.ExecContext(ctx, `INSERT INTO service_markers (name) VALUES ('sources_seeded') ON CONFLICT (name) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	for _, src := range sources {
+		rec := SourceRecord{
+			URL:                src.URL,
+			SourceID:           strings.ToLower(strings.TrimSpace(src.ID)),
+			Title:              strings.TrimSpace(src.Title),
+			EmbargoMinutes:     src.EmbargoMinutes,
+			GeoRestriction:     src.GeoRestriction,
+			Priority:           src.Priority,
+			BypassEmbargo:      src.BypassEmbargo,
+			FetchIntervalSec:   src.FetchIntervalSec,
+			DailyBudgetBytes:   src.DailyBudgetBytes,
+			ExtractFullContent: src.ExtractFullContent,
+			Language:           strings.ToLower(strings.TrimSpace(src.Language)),
+			Enabled:            true,
+		}
+		if _, err := insertSource(ctx, rec); err != nil && !isUniqueViolation(err) {
+			// метка снимается, чтобы перенос повторился при следующем запуске
+			if _, delErr := db.ExecContext(ctx, `DELETE FROM service_markers WHERE name = 'sources_seeded'`); delErr != nil {
+				log.Printf("Ошибка снятия метки переноса источников: %v", delErr)
+			}
+			return fmt.Errorf("источник %s: %w", src.URL, err)
+		}
+	}
+	log.Printf("Источники из config.json перенесены в таблицу sources: %d", len(sources))
+	return nil
+}
+
+// loadSources источники по id; enabledOnly — только включённые
+func loadSources(ctx context.Context, enabledOnly bool) ([]SourceRecord, error) {
+	query := `SELECT ` + sourceColumns + ` FROM sources`
+	if enabledOnly {
+		query += ` WHERE enabled`
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []SourceRecord{}
+	for rows.Next() {
+		rec, err := scanSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func getSource(ctx context.Context, id int) (*SourceRecord, error) {
+	rec, err := scanSource(db.QueryRowContext(ctx, `SELECT `+sourceColumns+` FROM sources WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// sourceByURL источник ленты по адресу; для удалённых источников —
+// источник без настроек
+func sourceByURL(ctx context.Context, feedURL string) feedSource {
+	rec, err := scanSource(db.QueryRowContext(ctx, `SELECT `+sourceColumns+` FROM sources WHERE url = $1`, feedURL))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Ошибка чтения источника %s: %v", feedURL, err)
+		}
+		return feedSource{URL: feedURL}
+	}
+	return rec.feed()
+}
+
+func insertSource(ctx context.Context, rec SourceRecord) (SourceRecord, error) {
+	return scanSource(db.QueryRowContext(ctx, `
+		INSERT INTO sources (url, source_key, title, embargo_minutes, geo_restriction, priority,
+			bypass_embargo, fetch_interval_sec, daily_budget_bytes, extract_full_content, language, credentials, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+sourceColumns,
+		rec.URL, rec.SourceID, rec.Title, rec.EmbargoMinutes, strings.ToUpper(strings.Join(rec.GeoRestriction, ",")),
+		rec.Priority, rec.BypassEmbargo, rec.FetchIntervalSec, rec.DailyBudgetBytes, rec.ExtractFullContent, rec.Language, rec.credentials, rec.Enabled))
+}
+
+func updateSource(ctx context.Context, rec SourceRecord) (SourceRecord, error) {
+	return scanSource(db.QueryRowContext(ctx, `
+		UPDATE sources
+		SET url = $2, source_key = $3, title = $4, embargo_minutes = $5, geo_restriction = $6,
+			priority = $7, bypass_embargo = $8, fetch_interval_sec = $9, daily_budget_bytes = $10,
+			extract_full_content = $11, language = $12, credentials = $13, enabled = $14, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+sourceColumns,
+		rec.ID, rec.URL, rec.SourceID, rec.Title, rec.EmbargoMinutes, strings.ToUpper(strings.Join(rec.GeoRestriction, ",")),
+		rec.Priority, rec.BypassEmbargo, rec.FetchIntervalSec, rec.DailyBudgetBytes, rec.ExtractFullContent, rec.Language, rec.credentials, rec.Enabled))
+}
+
+// isUniqueViolation ошибка уникальности (источник с таким url уже есть)
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// adminSourcesHandler GET — все источники, POST — добавление
+func adminSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		records, err := loadSources(ctx, false)
+		if err != nil {
+			log.Printf("Ошибка получения источников: %v", err)
+			http.Error(w, "Failed to get sources", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	case http.MethodPost:
+		var patch sourcePatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		rec := SourceRecord{Enabled: true}
+		patch.apply(&rec)
+		if err := validateSource(rec.feed()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := patch.sealAuth(&rec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := insertSource(ctx, rec)
+		if isUniqueViolation(err) {
+			http.Error(w, "Source with this url already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка добавления источника %s: %v", rec.URL, err)
+			http.Error(w, "Failed to create source", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Добавлен источник %d (%s), request_id: %s", created.ID, created.URL, requestID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminSourceHandler /admin/sources/{id}: GET, PATCH, DELETE
+func adminSourceHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/sources/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid source ID", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch:
+		rec, err := getSource(ctx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Source not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка получения источника %d: %v", id, err)
+			http.Error(w, "Failed to get source", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			var patch sourcePatch
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			prevHost := hostOf(rec.URL)
+			patch.apply(rec)
+			if hostOf(rec.URL) != prevHost && patch.Auth == nil && rec.credentials != "" {
+				// доступ выдан для прежнего хоста
+				rec.auth, rec.credentials, rec.Auth = nil, "", nil
+				log.Printf("Источник %d перенесён на хост %s, доступ к ленте удалён, request_id: %s", id, hostOf(rec.URL), requestID)
+			}
+			if err := validateSource(rec.feed()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := patch.sealAuth(rec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			updated, err := updateSource(ctx, *rec)
+			if isUniqueViolation(err) {
+				http.Error(w, "Source with this url already exists", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Printf("Ошибка обновления источника %d: %v", id, err)
+				http.Error(w, "Failed to update source", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Источник %d изменён (%s, enabled=%t), request_id: %s", id, updated.URL, updated.Enabled, requestID)
+			rec = &updated
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+	case http.MethodDelete:
+		res, err := db.ExecContext(ctx, `DELETE FROM sources WHERE id = $1`, id)
+		if err != nil {
+			log.Printf("Ошибка удаления источника %d: %v", id, err)
+			http.Error(w, "Failed to delete source", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Source not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Источник %d удалён, request_id: %s", id, requestID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
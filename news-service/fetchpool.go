@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Ленты загружаются параллельно пулом из fetch_workers воркеров, чтобы
+// одна медленная лента не задерживала остальные дольше периода
+// загрузки. Каждую ленту ограничивает свой таймаут feed_timeout_sec.
+// serviceCtx отменяется при остановке сервиса: новые ленты не берутся
+// в работу, а начатые загрузки дорабатываются и записываются, пока не
+// истечёт shutdown_timeout_sec; после этого скачивание прерывается, а
+// уже скачанные ленты дописываются в базу.
+
+const (
+	defaultFetchWorkers = 4
+	defaultFeedTimeout  = 30 * time.Second
+	// defaultShutdownTimeout сколько при остановке ждать HTTP-запросы и
+	// начатые загрузки лент
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+var (
+	fetchWorkers    = defaultFetchWorkers
+	feedTimeout     = defaultFeedTimeout
+	shutdownTimeout = defaultShutdownTimeout
+
+	serviceCtx = context.Background()
+	// activeFetches идущие загрузки; при остановке сервис ждёт их завершения
+	activeFetches sync.WaitGroup
+	// drainCtx отменяется, когда время на завершение загрузок вышло
+	drainCtx, abortFetches = context.WithCancel(context.Background())
+)
+
+// fetchFeeds загружает ленты пулом воркеров. report вызывается после
+// каждой ленты, по одному вызову за раз.
+func fetchFeeds(ctx context.Context, sources []feedSource, report func(src feedSource, added int, err error)) {
+	if ctx.Err() != nil {
+		return
+	}
+	activeFetches.Add(1)
+	defer activeFetches.Done()
+
+	// отмена ctx останавливает только выдачу лент воркерам: взятая в
+	// работу лента загружается до конца или до отмены drainCtx
+	feedCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stopAbort := context.AfterFunc(drainCtx, cancel)
+	defer stopAbort()
+
+	workers := fetchWorkers
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+	queue := make(chan feedSource)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range queue {
+				added, err := ingestFeed(feedCtx, src)
+				mu.Lock()
+				report(src, added, err)
+				mu.Unlock()
+			}
+		}()
+	}
+feeds:
+	for _, src := range sources {
+		select {
+		case queue <- src:
+		case <-ctx.Done():
+			break feeds
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// waitFetches дожидается начатых загрузок лент. Если ctx истёк раньше,
+// скачивание прерывается, и ждём только записи уже скачанных лент.
+func waitFetches(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		activeFetches.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	log.Printf("Загрузка лент не завершилась за %v: прерываем, скачанные ленты дописываются", shutdownTimeout)
+	abortFetches()
+	<-done
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Частичный ответ. Параметр fields в /news/latest и /news/filter
+// (fields=id,title,pub_date) оставляет у новостей списка только
+// перечисленные поля: из базы читаются только их колонки, так что
+// content и content_text, которые могут занимать сотни килобайт на
+// страницу, не читаются и не передаются, если не нужны. id и pub_date
+// читаются всегда — по ним строится next_cursor, — но в ответ попадают,
+// только если запрошены. Пагинация, курсор и facets от fields не зависят.
+
+// newsFields поля новости, которые можно запросить в fields, — колонки
+// newsColumns под теми же именами
+var newsFields = strings.Split(newsColumns, ", ")
+
+// parseNewsFields разбирает параметр fields; nil — все поля
+func parseNewsFields(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !containsString(newsFields, f) {
+			return nil, fmt.Errorf("fields must be a comma-separated list of: %s", strings.Join(newsFields, ", "))
+		}
+		if !containsString(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// selectedColumns колонки для выборки страницы: запрошенные поля, id и
+// pub_date в порядке newsColumns
+func (p paging) selectedColumns() []string {
+	if p.Fields == nil {
+		return newsFields
+	}
+	cols := make([]string, 0, len(p.Fields)+2)
+	for _, col := range newsFields {
+		if col == "id" || col == "pub_date" || containsString(p.Fields, col) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// columns список колонок выборки страницы для SELECT
+func (p paging) columns() string {
+	if p.Fields == nil {
+		return newsColumns
+	}
+	return strings.Join(p.selectedColumns(), ", ")
+}
+
+// scan читает новость, выбранную с колонками p.columns()
+func (p paging) scan(row rowScanner) (News, error) {
+	return scanNewsColumns(row, p.selectedColumns())
+}
+
+// scanNewsColumns читает новость с колонками cols из newsFields
+func scanNewsColumns(row rowScanner, cols []string) (News, error) {
+	var n News
+	var geoRestriction string
+	dest := make([]interface{}, len(cols))
+	for i, col := range cols {
+		switch col {
+		case "id":
+			dest[i] = &n.ID
+		case "title":
+			dest[i] = &n.Title
+		case "content":
+			dest[i] = &n.Content
+		case "description":
+			dest[i] = &n.Description
+		case "link":
+			dest[i] = &n.Link
+		case "pub_date":
+			dest[i] = &n.PubDate
+		case "created_at":
+			dest[i] = &n.CreatedAt
+		case "geo_restriction":
+			dest[i] = &geoRestriction
+		case "author":
+			dest[i] = &n.Author
+		case "source_id":
+			dest[i] = &n.SourceID
+		case "source_title":
+			dest[i] = &n.SourceTitle
+		case "image_url":
+			dest[i] = &n.ImageURL
+		case "content_text":
+			dest[i] = &n.ContentText
+		case "duplicate_of":
+			dest[i] = &n.DuplicateOf
+		case "language":
+			dest[i] = &n.Language
+		case "revision":
+			dest[i] = &n.Revision
+		case "updated_at":
+			dest[i] = &n.UpdatedAt
+		default:
+			return n, fmt.Errorf("неизвестная колонка новости %q", col)
+		}
+	}
+	err := row.Scan(dest...)
+	n.GeoRestriction = splitGeoRestriction(geoRestriction)
+	return n, err
+}
+
+// encodeNewsList пишет ответ со списком; с fields у новостей остаются
+// только запрошенные поля
+func encodeNewsList(w io.Writer, response NewsListResponse, fields []string) error {
+	if fields == nil {
+		return json.NewEncoder(w).Encode(response)
+	}
+	partial := make([]map[string]json.RawMessage, 0, len(response.News))
+	for _, n := range response.News {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return err
+		}
+		item := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				item[f] = v
+			}
+		}
+		partial = append(partial, item)
+	}
+	// поле news внешней структуры скрывает news из NewsListResponse
+	return json.NewEncoder(w).Encode(struct {
+		NewsListResponse
+		News []map[string]json.RawMessage `json:"news"`
+	}{response, partial})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Simhash-отпечатки содержимого новостей: близкие тексты дают отпечатки
+// с малым расстоянием Хэмминга. Используются для поиска перепечаток
+// между источниками и подбора порога дедупликации.
+
+const (
+	// shingleSize число слов в шингле
+	shingleSize = 3
+	// defaultMaxDistance порог расстояния Хэмминга по умолчанию
+	defaultMaxDistance = 3
+	// maxBandDistance максимальный порог, при котором поиск по 4 полосам
+	// по 16 бит гарантированно находит все пары (принцип Дирихле)
+	maxBandDistance = 3
+	// similarScanLimit сколько последних новостей сравнивается
+	similarScanLimit = 5000
+	fingerprintBatch = 500
+)
+
+// contentTokens разбивает текст на слова в нижнем регистре
+func contentTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// simhash вычисляет 64-битный отпечаток текста по шинглам из слов
+func simhash(text string) uint64 {
+	tokens := contentTokens(text)
+	if len(tokens) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	add := func(shingle string) {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	if len(tokens) < shingleSize {
+		add(strings.Join(tokens, " "))
+	}
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		add(strings.Join(tokens[i:i+shingleSize], " "))
+	}
+
+	var fp uint64
+	for i, w := range weights {
+		if w > 0 {
+			fp |= 1 << uint(i)
+		}
+	}
+	return fp
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// contentFingerprint отпечаток новости: содержимое, а если его нет — заголовок
+func contentFingerprint(title, content string) int64 {
+	text := content
+	if strings.TrimSpace(text) == "" {
+		text = title
+	}
+	return int64(simhash(text))
+}
+
+// backfillFingerprints считает отпечатки для новостей, сохранённых
+// до появления колонки content_simhash
+func backfillFingerprints(ctx context.Context) {
+	total := 0
+	for {
+		rows, err := db.QueryContext(ctx, `
+			SELECT id, title, COALESCE(content, '')
+			FROM news
+			WHERE content_simhash IS NULL
+			ORDER BY id
+			LIMIT $1
+		`, fingerprintBatch)
+		if err != nil {
+			log.Printf("Ошибка выборки новостей без отпечатка: %v", err)
+			return
+		}
+
+		type pending struct {
+			id int
+			fp int64
+		}
+		var batch []pending
+		for rows.Next() {
+			var id int
+			var title, content string
+			if err := rows.Scan(&id, &title, &content); err != nil {
+				rows.Close()
+				log.Printf("Ошибка чтения новости без отпечатка: %v", err)
+				return
+			}
+			batch = append(batch, pending{id, contentFingerprint(title, content)})
+		}
+		rows.Close()
+
+		if len(batch) == 0 {
+			break
+		}
+		for _, p := range batch {
+			if _, err := db.ExecContext(ctx, "UPDATE news SET content_simhash = $1 WHERE id = $2", p.fp, p.id); err != nil {
+				log.Printf("Ошибка сохранения отпечатка новости %d: %v", p.id, err)
+				return
+			}
+		}
+		total += len(batch)
+	}
+	if total > 0 {
+		log.Printf("Рассчитаны отпечатки для %d новостей", total)
+	}
+}
+
+// SimilarItem новость в отчёте о похожем содержимом
+type SimilarItem struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Link    string    `json:"link"`
+	Source  string    `json:"source"`
+	PubDate time.Time `json:"pub_date"`
+}
+
+// SimilarPair пара новостей с близкими отпечатками
+type SimilarPair struct {
+	A          SimilarItem `json:"a"`
+	B          SimilarItem `json:"b"`
+	Distance   int         `json:"distance"`
+	Similarity float64     `json:"similarity"`
+}
+
+type fingerprintedItem struct {
+	SimilarItem
+	fp uint64
+}
+
+// similarHandler ищет новости с почти одинаковым содержимым.
+// Параметры: max_distance (0–3), id (только пары с этой новостью),
+// cross_source=true (только пары из разных источников), since (YYYY-MM-DD).
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+	log.Printf("Запрос похожих новостей, request_id: %s", requestID)
+
+	q := r.URL.Query()
+	maxDistance := defaultMaxDistance
+	if v := q.Get("max_distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > maxBandDistance {
+			http.Error(w, "max_distance must be between 0 and 3", http.StatusBadRequest)
+			return
+		}
+		maxDistance = d
+	}
+	onlyID := 0
+	if v := q.Get("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid news ID", http.StatusBadRequest)
+			return
+		}
+		onlyID = id
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid since date", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	crossSource := q.Get("cross_source") == "true"
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	items, err := loadFingerprints(ctx, since)
+	if err != nil {
+		log.Printf("Ошибка загрузки отпечатков: %v", err)
+		http.Error(w, "Failed to load fingerprints", http.StatusInternalServerError)
+		return
+	}
+
+	pairs := findSimilarPairs(items, maxDistance, func(a, b fingerprintedItem) bool {
+		if onlyID != 0 && a.ID != onlyID && b.ID != onlyID {
+			return false
+		}
+		return !crossSource || a.Source != b.Source
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func loadFingerprints(ctx context.Context, since time.Time) ([]fingerprintedItem, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title, link, pub_date, content_simhash
+		FROM news
+		WHERE content_simhash IS NOT NULL AND pub_date >= $1
+		ORDER BY pub_date DESC, id DESC
+		LIMIT $2
+	`, since, similarScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []fingerprintedItem
+	for rows.Next() {
+		var it fingerprintedItem
+		var fp int64
+		if err := rows.Scan(&it.ID, &it.Title, &it.Link, &it.PubDate, &fp); err != nil {
+			return nil, err
+		}
+		it.fp = uint64(fp)
+		if u, err := url.Parse(it.Link); err == nil {
+			it.Source = strings.TrimPrefix(u.Hostname(), "www.")
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// findSimilarPairs сравнивает только новости, совпадающие хотя бы в одной
+// 16-битной полосе отпечатка: при расстоянии не больше 3 такая полоса
+// обязательно найдётся, а число сравнений остаётся небольшим
+func findSimilarPairs(items []fingerprintedItem, maxDistance int, keep func(a, b fingerprintedItem) bool) []SimilarPair {
+	type pairKey struct{ a, b int }
+	seen := make(map[pairKey]bool)
+	pairs := []SimilarPair{}
+
+	for band := uint(0); band < 4; band++ {
+		buckets := make(map[uint64][]int)
+		for i, it := range items {
+			key := (it.fp >> (band * 16)) & 0xFFFF
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, idx := range buckets {
+			for x := 0; x < len(idx); x++ {
+				for y := x + 1; y < len(idx); y++ {
+					a, b := items[idx[x]], items[idx[y]]
+					k := pairKey{a.ID, b.ID}
+					if a.ID > b.ID {
+						k = pairKey{b.ID, a.ID}
+					}
+					if seen[k] {
+						continue
+					}
+					seen[k] = true
+					d := hammingDistance(a.fp, b.fp)
+					if d > maxDistance || !keep(a, b) {
+						continue
+					}
+					pairs = append(pairs, SimilarPair{
+						A:          a.SimilarItem,
+						B:          b.SimilarItem,
+						Distance:   d,
+						Similarity: 1 - float64(d)/64,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Distance != pairs[j].Distance {
+			return pairs[i].Distance < pairs[j].Distance
+		}
+		return pairs[i].A.PubDate.After(pairs[j].A.PubDate)
+	})
+	return pairs
+}
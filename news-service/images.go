@@ -0,0 +1,158 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Иллюстрации новостей. Для каждого элемента ленты выбирается одна
+// картинка для превью: вложение <enclosure> (в Atom — link rel="enclosure")
+// с типом image/*, затем media:content и media:thumbnail (Media RSS),
+// в JSON Feed — image, banner_image и вложения-картинки. Если ничего
+// такого нет, берётся первый <img> из текста новости. Относительные
+// адреса разрешаются относительно ссылки на новость; сохраняются только
+// http(s)-адреса.
+
+// Ограничение длины колонки news.image_url
+const maxImageURLLength = 1000
+
+// feedEnclosure вложение RSS <enclosure url type length>
+type feedEnclosure struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type mediaContent struct {
+	URL        string           `xml:"url,attr"`
+	Type       string           `xml:"type,attr"`
+	Medium     string           `xml:"medium,attr"`
+	Thumbnails []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// mediaRSS элементы Media RSS, встречающиеся в <item> и <entry>
+type mediaRSS struct {
+	MediaContents   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnails []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	MediaGroups     []struct {
+		Contents   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+		Thumbnails []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	} `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// images картинки Media RSS: сначала media:content, затем миниатюры
+func (m mediaRSS) images() []string {
+	contents := append([]mediaContent{}, m.MediaContents...)
+	thumbnails := append([]mediaThumbnail{}, m.MediaThumbnails...)
+	for _, g := range m.MediaGroups {
+		contents = append(contents, g.Contents...)
+		thumbnails = append(thumbnails, g.Thumbnails...)
+	}
+	var urls []string
+	for _, c := range contents {
+		if c.Medium == "image" || (c.Medium == "" && isImage(c.Type, c.URL)) {
+			urls = append(urls, c.URL)
+		}
+		thumbnails = append(thumbnails, c.Thumbnails...)
+	}
+	for _, t := range thumbnails {
+		urls = append(urls, t.URL)
+	}
+	return urls
+}
+
+func enclosureImages(enclosures []feedEnclosure) []string {
+	var urls []string
+	for _, e := range enclosures {
+		if isImage(e.Type, e.URL) {
+			urls = append(urls, e.URL)
+		}
+	}
+	return urls
+}
+
+func atomEnclosureImages(links []atomLink) []string {
+	var urls []string
+	for _, l := range links {
+		if l.Rel == "enclosure" && isImage(l.Type, l.Href) {
+			urls = append(urls, l.Href)
+		}
+	}
+	return urls
+}
+
+// isImage вложение — картинка: по MIME-типу, а без него — по расширению
+func isImage(mimeType, rawURL string) bool {
+	if mimeType = strings.ToLower(strings.TrimSpace(mimeType)); mimeType != "" {
+		return strings.HasPrefix(mimeType, "image/")
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif":
+		return true
+	}
+	return false
+}
+
+var (
+	imgTagPattern  = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+	imgSrcPattern  = regexp.MustCompile(`(?is)\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	pixelAttrRegex = regexp.MustCompile(`(?is)\b(?:width|height)\s*=\s*["']?[01]["'\s/>]`)
+)
+
+// firstImageInHTML адрес первой картинки в HTML; счётчики-пиксели 1×1
+// пропускаются
+func firstImageInHTML(s string) string {
+	for _, tag := range imgTagPattern.FindAllString(s, -1) {
+		if pixelAttrRegex.MatchString(tag) {
+			continue
+		}
+		if m := imgSrcPattern.FindStringSubmatch(tag); m != nil {
+			return html.UnescapeString(m[1] + m[2])
+		}
+	}
+	return ""
+}
+
+// itemImageURL иллюстрация элемента ленты или пустая строка
+func itemImageURL(item FeedItem, link string) string {
+	candidates := append([]string{}, item.Images...)
+	candidates = append(candidates, firstImageInHTML(item.Content), firstImageInHTML(item.Description))
+	base, _ := url.Parse(link)
+	for _, c := range candidates {
+		if u := absoluteImageURL(c, base); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+func absoluteImageURL(raw string, base *url.URL) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	if s := u.String(); len(s) <= maxImageURLLength {
+		return s
+	}
+	return ""
+}
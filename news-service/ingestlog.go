@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Журнал загрузки лент. Записи о загрузке пишутся структурированно:
+// у каждой есть feed_url, source_id и priority ленты, а у итоговых —
+// числа элементов и длительность, поэтому по журналу строятся графики и
+// алерты. Каждый прогон (по расписанию или ручной) завершается записью
+// "Прогон загрузки завершён" с итогами. Формат задаёт ingest_log_format
+// в config.json: text (key=value, по умолчанию) или json.
+
+const (
+	ingestLogText = "text"
+	ingestLogJSON = "json"
+)
+
+var ingestLog = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// setIngestLogFormat выбирает формат журнала загрузки
+func setIngestLogFormat(format string) error {
+	switch format {
+	case "", ingestLogText:
+		ingestLog = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	case ingestLogJSON:
+		ingestLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	default:
+		return fmt.Errorf("ingest_log_format должен быть %s или %s", ingestLogText, ingestLogJSON)
+	}
+	return nil
+}
+
+// feedLog журнал с полями ленты
+func feedLog(src feedSource) *slog.Logger {
+	return ingestLog.With("feed_url", src.URL, "source_id", src.sourceID(), "priority", src.priority())
+}
+
+// Источники прогона загрузки
+const (
+	triggerSchedule = "schedule"
+	triggerRefresh  = "refresh"
+)
+
+// ingestRun итоги одного прогона загрузки лент
+type ingestRun struct {
+	log    *slog.Logger
+	start  time.Time
+	feeds  int
+	failed int
+	added  int
+}
+
+// newIngestRun начинает прогон; attrs дополняют trigger во всех его записях
+func newIngestRun(trigger string, feeds int, attrs ...any) *ingestRun {
+	l := ingestLog.With(append([]any{"trigger", trigger}, attrs...)...)
+	l.Info("Прогон загрузки начат", "feeds", feeds)
+	return &ingestRun{log: l, start: time.Now(), feeds: feeds}
+}
+
+// report учитывает результат ленты; вызывается из report fetchFeeds
+func (r *ingestRun) report(added int, err error) {
+	if err != nil {
+		r.failed++
+	}
+	r.added += added
+}
+
+// finish пишет итоговую запись прогона
+func (r *ingestRun) finish() {
+	r.log.Info("Прогон загрузки завершён", "feeds", r.feeds, "failed", r.failed, "added", r.added,
+		"duration_ms", time.Since(r.start).Milliseconds())
+}
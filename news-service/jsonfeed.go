@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// ─── JSON Feed 1.1 ────────────────────────────────────────────────────────────
+
+// jsonFeed лента формата https://jsonfeed.org/version/1.1. Поле author
+// из версии 1.0 читается для совместимости со старыми лентами.
+type jsonFeed struct {
+	Version string           `json:"version"`
+	Title   string           `json:"title"`
+	Authors []jsonFeedAuthor `json:"authors"`
+	Author  *jsonFeedAuthor  `json:"author"`
+	Items   []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            json.RawMessage  `json:"id"`
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Author        *jsonFeedAuthor  `json:"author"`
+	Tags          []string         `json:"tags"`
+	Image         string           `json:"image"`
+	BannerImage   string           `json:"banner_image"`
+	Attachments   []struct {
+		URL      string `json:"url"`
+		MimeType string `json:"mime_type"`
+	} `json:"attachments"`
+}
+
+// images картинки элемента: image, banner_image, вложения-картинки
+func (it jsonFeedItem) images() []string {
+	urls := []string{it.Image, it.BannerImage}
+	for _, a := range it.Attachments {
+		if isImage(a.MimeType, a.URL) {
+			urls = append(urls, a.URL)
+		}
+	}
+	return urls
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// isJSONFeed JSON Feed определяется по Content-Type, суффиксу .json в
+// пути URL, а если ни то, ни другое не задано — по первому символу документа
+func isJSONFeed(contentType, feedURL string, body []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "application/feed+json", "application/json":
+			return true
+		}
+	}
+	if u, err := url.Parse(feedURL); err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".json") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+}
+
+func parseJSONFeed(body []byte) ([]FeedItem, error) {
+	var feed jsonFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга JSON Feed: %v", err)
+	}
+	if !strings.HasPrefix(feed.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("ошибка парсинга JSON Feed: неизвестная версия %q", feed.Version)
+	}
+	feedAuthor := firstAuthorName(feed.Authors, feed.Author)
+
+	items := make([]FeedItem, 0, len(feed.Items))
+	for _, it := range feed.Items {
+		content := it.ContentHTML
+		if strings.TrimSpace(content) == "" {
+			content = it.ContentText
+		}
+		link := it.URL
+		if strings.TrimSpace(link) == "" {
+			link = it.ExternalURL
+		}
+		date := strings.TrimSpace(it.DatePublished)
+		if date == "" {
+			date = strings.TrimSpace(it.DateModified)
+		}
+		author := firstAuthorName(it.Authors, it.Author)
+		if author == "" {
+			author = feedAuthor
+		}
+		items = append(items, FeedItem{
+			Title:       it.Title,
+			Description: it.Summary,
+			Content:     content,
+			Link:        link,
+			GUID:        jsonFeedID(it.ID),
+			PubDate:     parsePubDate(date),
+			Author:      limitAuthor(author),
+			Categories:  it.Tags,
+			FeedTitle:   strings.TrimSpace(feed.Title),
+			Images:      it.images(),
+		})
+	}
+	return items, nil
+}
+
+// jsonFeedID id элемента — строка по спецификации, но некоторые
+// генераторы пишут число
+func jsonFeedID(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func firstAuthorName(authors []jsonFeedAuthor, legacy *jsonFeedAuthor) string {
+	for _, a := range authors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			return name
+		}
+	}
+	if legacy != nil {
+		return strings.TrimSpace(legacy.Name)
+	}
+	return ""
+}
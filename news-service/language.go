@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Язык новости. При сохранении новости определяется её язык (код
+// ISO 639-1, news.language): по language источника, а если он не задан
+// — по преобладающей письменности текста (кириллица — ru, латиница —
+// en). Язык, который не удалось определить, остаётся пустым. Параметр
+// lang в /news/latest и /news/filter оставляет новости на указанных
+// языках (через запятую), а /admin/feeds/status показывает, сколько
+// новостей каждого источника на каком языке.
+
+const (
+	languageRussian = "ru"
+	languageEnglish = "en"
+	// languageUnknown ключ новостей без языка в статистике по языкам
+	languageUnknown = "unknown"
+)
+
+// languageCodes код языка конфигурации полнотекстового поиска
+var languageCodes = map[string]string{
+	"arabic": "ar", "danish": "da", "dutch": "nl", "english": "en", "finnish": "fi",
+	"french": "fr", "german": "de", "hungarian": "hu", "italian": "it", "norwegian": "no",
+	"portuguese": "pt", "romanian": "ro", "russian": "ru", "spanish": "es", "swedish": "sv",
+	"turkish": "tr",
+}
+
+var languageCodeRe = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// newsLanguage язык новости источника
+func (s feedSource) newsLanguage(title, text string) string {
+	if code := languageCodes[s.Language]; code != "" {
+		return code
+	}
+	return detectLanguage(title + " " + text)
+}
+
+// detectLanguage язык текста по преобладающей письменности; пустой, если
+// в тексте нет ни кириллицы, ни латиницы
+func detectLanguage(text string) string {
+	cyrillic, latin := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case cyrillic == 0 && latin == 0:
+		return ""
+	case cyrillic >= latin:
+		return languageRussian
+	default:
+		return languageEnglish
+	}
+}
+
+// parseLanguages разбирает параметр lang: коды языков через запятую
+func parseLanguages(raw string) ([]string, error) {
+	var langs []string
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if !languageCodeRe.MatchString(v) {
+			return nil, fmt.Errorf("lang must be a comma-separated list of language codes, e.g. ru,en")
+		}
+		if !containsString(langs, v) {
+			langs = append(langs, v)
+		}
+	}
+	return langs, nil
+}
+
+// sourceLanguages число новостей каждого источника по языкам
+func sourceLanguages(ctx context.Context) (map[string]map[string]int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT source_id, language, COUNT(*) FROM news GROUP BY source_id, language`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make(map[string]map[string]int)
+	for rows.Next() {
+		var source, lang string
+		var n int
+		if err := rows.Scan(&source, &lang, &n); err != nil {
+			return nil, err
+		}
+		if lang == "" {
+			lang = languageUnknown
+		}
+		if stats[source] == nil {
+			stats[source] = make(map[string]int)
+		}
+		stats[source][lang] = n
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Кэш списков новостей в Redis. Первые страницы /news/latest и
+// /news/filter (LIST_CACHE_MAX_PAGE, по умолчанию 3) хранятся в Redis
+// LIST_CACHE_TTL_SEC секунд (по умолчанию 10), поэтому одинаковые
+// запросы gateway не доходят до PostgreSQL. Ключ — путь и параметры
+// запроса без request_id; запросы с cursor и as_of не кэшируются.
+// Одинаковые промахи внутри экземпляра сервиса ждут один запрос к БД.
+// Когда загрузка ленты добавляет новости, номер поколения кэша
+// (news:cache:gen) увеличивается и старые ключи перестают читаться,
+// истекая по TTL. Изменения через /admin (склейка дублей, удаление
+// источника) видны после истечения TTL. Кэш включается переменной
+// REDIS_URL; при ошибках Redis запросы на несколько секунд идут мимо
+// кэша.
+
+const (
+	defaultListCacheTTL     = 10 * time.Second
+	defaultListCacheMaxPage = 3
+	listCacheGenKey         = "news:cache:gen"
+	listCacheKeyPrefix      = "news:list:"
+	listCacheBackoff        = 5 * time.Second
+)
+
+// ListCacheStats состояние кэша списков
+type ListCacheStats struct {
+	Enabled       bool       `json:"enabled"`
+	TTLSec        int        `json:"ttl_sec,omitempty"`
+	MaxPage       int        `json:"max_page,omitempty"`
+	Hits          int        `json:"hits"`
+	Misses        int        `json:"misses"`
+	Shared        int        `json:"shared"`
+	Invalidations int        `json:"invalidations"`
+	Errors        int        `json:"errors"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrAt     *time.Time `json:"last_error_at,omitempty"`
+}
+
+// newsListCache кэш первых страниц списков
+type newsListCache struct {
+	redis   *redisClient
+	ttl     time.Duration
+	maxPage int
+
+	mu        sync.Mutex
+	inflight  map[string]*listCacheCall
+	downUntil time.Time
+	stats     ListCacheStats
+}
+
+// listCacheCall запрос к БД, результата которого ждут одинаковые промахи
+type listCacheCall struct {
+	done chan struct{}
+	body []byte // nil, если ответ не удалось закэшировать
+}
+
+var listCache *newsListCache
+
+func newListCacheFromEnv() (*newsListCache, error) {
+	raw := os.Getenv("REDIS_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	client, err := newRedisClient(raw)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %v", err)
+	}
+	ttl := defaultListCacheTTL
+	if v := os.Getenv("LIST_CACHE_TTL_SEC"); v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec <= 0 {
+			return nil, fmt.Errorf("LIST_CACHE_TTL_SEC должен быть положительным числом")
+		}
+		ttl = time.Duration(sec) * time.Second
+	}
+	maxPage := defaultListCacheMaxPage
+	if v := os.Getenv("LIST_CACHE_MAX_PAGE"); v != "" {
+		if maxPage, err = strconv.Atoi(v); err != nil || maxPage <= 0 {
+			return nil, fmt.Errorf("LIST_CACHE_MAX_PAGE должен быть положительным числом")
+		}
+	}
+	return &newsListCache{
+		redis:    client,
+		ttl:      ttl,
+		maxPage:  maxPage,
+		inflight: make(map[string]*listCacheCall),
+		stats:    ListCacheStats{Enabled: true, TTLSec: int(ttl / time.Second), MaxPage: maxPage},
+	}, nil
+}
+
+func (c *newsListCache) enabled() bool {
+	return c != nil
+}
+
+// cacheable кэшируется ли запрос: только первые страницы без курсора и as_of
+func (c *newsListCache) cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	q := r.URL.Query()
+	if q.Get("cursor") != "" || q.Get("as_of") != "" {
+		return false
+	}
+	if v := q.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err == nil && page > c.maxPage {
+			return false
+		}
+	}
+	return true
+}
+
+// available false, пока после ошибки Redis не истекла пауза
+func (c *newsListCache) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().After(c.downUntil)
+}
+
+func (c *newsListCache) fail(op string, err error) {
+	now := time.Now()
+	c.mu.Lock()
+	c.stats.Errors++
+	c.stats.LastError = err.Error()
+	c.stats.LastErrAt = &now
+	c.downUntil = now.Add(listCacheBackoff)
+	c.mu.Unlock()
+	log.Printf("[WARN] Кэш списков: ошибка Redis (%s), запросы идут мимо кэша: %v", op, err)
+}
+
+func (c *newsListCache) count(field *int) {
+	c.mu.Lock()
+	*field++
+	c.mu.Unlock()
+}
+
+// key ключ кэша запроса в текущем поколении
+func (c *newsListCache) key(ctx context.Context, r *http.Request) (string, error) {
+	gen, err := c.redis.do(ctx, "GET", listCacheGenKey)
+	if err == errRedisNil {
+		gen = "0"
+	} else if err != nil {
+		return "", err
+	}
+	q := r.URL.Query()
+	q.Del("request_id")
+	// ответ зависит от того, какой стране верим, а не от параметра
+	if country := requestCountry(r); country != nil {
+		q.Set("country", *country)
+	} else {
+		q.Del("country")
+	}
+	return listCacheKeyPrefix + gen + ":" + r.URL.Path + "?" + q.Encode(), nil
+}
+
+// wrap отдаёт кэшируемые запросы из Redis, а промахи кэширует
+func (c *newsListCache) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if !c.enabled() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !c.cacheable(r) || !c.available() {
+			next(w, r)
+			return
+		}
+		key, err := c.key(r.Context(), r)
+		if err != nil {
+			c.fail("GET", err)
+			next(w, r)
+			return
+		}
+		body, err := c.redis.do(r.Context(), "GET", key)
+		if err == nil {
+			c.count(&c.stats.Hits)
+			writeCachedList(w, "HIT", []byte(body))
+			return
+		}
+		if err != errRedisNil {
+			c.fail("GET", err)
+			next(w, r)
+			return
+		}
+
+		c.mu.Lock()
+		if call, ok := c.inflight[key]; ok {
+			c.stats.Shared++
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if call.body != nil {
+				writeCachedList(w, "HIT", call.body)
+				return
+			}
+			next(w, r)
+			return
+		}
+		call := &listCacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		c.stats.Misses++
+		c.mu.Unlock()
+
+		rec := &cacheRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		defer func() {
+			if rec.statusCode == http.StatusOK && rec.body.Len() > 0 {
+				call.body = rec.body.Bytes()
+			}
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+			close(call.done)
+			if call.body != nil {
+				ctx := context.WithoutCancel(r.Context())
+				ms := strconv.FormatInt(c.ttl.Milliseconds(), 10)
+				if _, err := c.redis.do(ctx, "SET", key, string(call.body), "PX", ms); err != nil {
+					c.fail("SET", err)
+				}
+			}
+		}()
+		w.Header().Set("X-Cache", "MISS")
+		next(rec, r)
+	}
+}
+
+func writeCachedList(w http.ResponseWriter, status string, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", status)
+	w.Write(body)
+}
+
+// cacheRecorder копия ответа обработчика для записи в кэш
+type cacheRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	rec.statusCode = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// invalidate начинает новое поколение кэша после добавления новостей
+func (c *newsListCache) invalidate(ctx context.Context) {
+	if !c.enabled() {
+		return
+	}
+	if _, err := c.redis.do(ctx, "INCR", listCacheGenKey); err != nil {
+		c.fail("INCR", err)
+		return
+	}
+	c.count(&c.stats.Invalidations)
+}
+
+func (c *newsListCache) snapshot() ListCacheStats {
+	if !c.enabled() {
+		return ListCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// listCacheHandler GET /admin/cache
+func listCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listCache.snapshot())
+}
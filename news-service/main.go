@@ -4,64 +4,182 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-const PER_PAGE = 15
-
 // config структура для конфигурации из config.json
 type config struct {
-	RSS           []string `json:"rss"`
-	RequestPeriod int      `json:"request_period"`
-}
-
-// RSS структура для парсинга RSS-ленты
-type RSS struct {
-	XMLName xml.Name `xml:"rss"`
-	Channel Channel  `xml:"channel"`
+	// RSS начальный список источников: переносится в таблицу sources,
+	// пока она пуста; дальше источники правятся через /admin/sources
+	RSS           []feedSource `json:"rss"`
+	RequestPeriod int          `json:"request_period"`
+	// DefaultPerPage и MaxPerPage размер страницы списков по умолчанию
+	// и наибольший допустимый per_page
+	DefaultPerPage int `json:"default_per_page,omitempty"`
+	MaxPerPage     int `json:"max_per_page,omitempty"`
+	// HighPriorityPeriodSec период загрузки лент с priority high
+	HighPriorityPeriodSec int `json:"high_priority_period_sec,omitempty"`
+	// FetchWorkers сколько лент загружается одновременно,
+	// FeedTimeoutSec таймаут загрузки одной ленты
+	FetchWorkers   int `json:"fetch_workers,omitempty"`
+	FeedTimeoutSec int `json:"feed_timeout_sec,omitempty"`
+	// ShutdownTimeoutSec сколько при остановке ждать HTTP-запросы и
+	// начатые загрузки лент
+	ShutdownTimeoutSec int `json:"shutdown_timeout_sec,omitempty"`
+	// IngestLogFormat формат журнала загрузки лент: text или json
+	IngestLogFormat string `json:"ingest_log_format,omitempty"`
+	// TrendingWindowHours окно /news/trending по умолчанию
+	TrendingWindowHours int `json:"trending_window_hours,omitempty"`
+	// FeedTitle заголовок исходящей ленты /feed.rss и /feed.atom
+	FeedTitle string `json:"feed_title,omitempty"`
+	// SitemapIntervalMin период перестроения карты сайта
+	SitemapIntervalMin int `json:"sitemap_interval_min,omitempty"`
+	// SearchLanguages языки, на которых разбирается поисковый запрос
+	SearchLanguages []string `json:"search_languages,omitempty"`
+	// FetchRetries повторов временной ошибки (0 — по умолчанию, -1 — без
+	// повторов), RetryBackoffSec первая пауза перед повтором
+	FetchRetries    int `json:"fetch_retries,omitempty"`
+	RetryBackoffSec int `json:"retry_backoff_sec,omitempty"`
+	// HostRPS и HostConcurrency ограничение исходящих запросов к одному
+	// хосту (0 — по умолчанию, -1 — без ограничения)
+	HostRPS         float64 `json:"host_rps,omitempty"`
+	HostConcurrency int     `json:"host_concurrency,omitempty"`
+	// FetchJitterPercent разброс интервала загрузки лент, процентов
+	// (0 — по умолчанию, -1 — без разброса)
+	FetchJitterPercent int `json:"fetch_jitter_percent,omitempty"`
+	// QuarantineAfter неудач подряд до карантина (-1 — без карантина),
+	// QuarantineIntervalSec интервал загрузки ленты в карантине
+	QuarantineAfter       int `json:"quarantine_after,omitempty"`
+	QuarantineIntervalSec int `json:"quarantine_interval_sec,omitempty"`
+	// DedupTitleDistance порог расстояния Хэмминга simhash заголовков
+	// (-1 — дубли ищутся только по ссылке), DedupWindowHours окно
+	// публикации вокруг pub_date, в котором ищется оригинал
+	DedupTitleDistance int `json:"dedup_title_distance,omitempty"`
+	DedupWindowHours   int `json:"dedup_window_hours,omitempty"`
+	// RetentionDays срок хранения новостей (0 — бессрочно),
+	// RetentionAction archive или delete, RetentionIntervalHours период
+	// задания очистки
+	RetentionDays          int    `json:"retention_days,omitempty"`
+	RetentionAction        string `json:"retention_action,omitempty"`
+	RetentionIntervalHours int    `json:"retention_interval_hours,omitempty"`
+	// ExtractHostIntervalSec пауза между запросами страниц статей одного
+	// хоста, ExtractQueueSize наибольшая очередь извлечения полного текста
+	ExtractHostIntervalSec int `json:"extract_host_interval_sec,omitempty"`
+	ExtractQueueSize       int `json:"extract_queue_size,omitempty"`
+	// WebhookRetries повторов доставки вебхука (0 — по умолчанию, -1 —
+	// без повторов), WebhookTimeoutSec таймаут запроса, WebhookQueueSize
+	// наибольшая очередь доставок
+	WebhookRetries    int `json:"webhook_retries,omitempty"`
+	WebhookTimeoutSec int `json:"webhook_timeout_sec,omitempty"`
+	WebhookQueueSize  int `json:"webhook_queue_size,omitempty"`
+	// DBMaxOpenConns и DBMaxIdleConns размер пула соединений,
+	// DBConnMaxLifetimeSec время жизни соединения, DBStatementTimeoutMs
+	// statement_timeout запросов (-1 — без таймаута)
+	DBMaxOpenConns       int `json:"db_max_open_conns,omitempty"`
+	DBMaxIdleConns       int `json:"db_max_idle_conns,omitempty"`
+	DBConnMaxLifetimeSec int `json:"db_conn_max_lifetime_sec,omitempty"`
+	DBStatementTimeoutMs int `json:"db_statement_timeout_ms,omitempty"`
 }
 
-// Channel содержит список новостей
-type Channel struct {
-	Items []Item `xml:"item"`
+// feedSource RSS-источник. В config.json задаётся либо строкой с URL,
+// либо объектом с дополнительными флагами лицензирования.
+type feedSource struct {
+	URL string `json:"url"`
+	// ID и Title идентификатор и название источника; по умолчанию —
+	// имя хоста ленты и заголовок ленты
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title,omitempty"`
+	// EmbargoMinutes новости источника публикуются только через
+	// указанное число минут после pub_date
+	EmbargoMinutes int `json:"embargo_minutes,omitempty"`
+	// GeoRestriction коды стран (ISO 3166-1 alpha-2), в которых
+	// разрешён показ; пустой список — без ограничений
+	GeoRestriction []string `json:"geo_restriction,omitempty"`
+	// Priority "high" для лент срочных новостей, загружаемых чаще
+	Priority string `json:"priority,omitempty"`
+	// BypassEmbargo новости срочной ленты публикуются без выдержки
+	BypassEmbargo bool `json:"bypass_embargo,omitempty"`
+	// FetchIntervalSec интервал загрузки ленты; 0 — период её приоритета
+	FetchIntervalSec int `json:"fetch_interval_sec,omitempty"`
+	// DailyBudgetBytes сколько байт лента может скачать за сутки;
+	// 0 — без ограничения
+	DailyBudgetBytes int64 `json:"daily_budget_bytes,omitempty"`
+	// ExtractFullContent лента отдаёт анонсы: полный текст новостей
+	// скачивается со страниц статей
+	ExtractFullContent bool `json:"extract_full_content,omitempty"`
+	// Language конфигурация полнотекстового поиска PostgreSQL для новостей
+	// источника; пустая — язык определяется по тексту новости
+	Language string `json:"language,omitempty"`
+	// auth доступ к закрытой ленте; задаётся только через /admin/sources
+	auth *SourceAuth
 }
 
-// Item представляет одну новость из RSS
-type Item struct {
-	Title       string `xml:"title"`
-	Description string `xml:"description"`
-	Link        string `xml:"link"`
-	PubDate     string `xml:"pubDate"`
-	Content     string `xml:"content"`
+func (s *feedSource) UnmarshalJSON(data []byte) error {
+	var u string
+	if err := json.Unmarshal(data, &u); err == nil {
+		*s = feedSource{URL: u}
+		return nil
+	}
+	type plain feedSource
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*s = feedSource(p)
+	return nil
 }
 
 // News структура новости в базе данных
 type News struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Content     string    `json:"content"`
-	Description string    `json:"description"`
-	Link        string    `json:"link"`
-	PubDate     time.Time `json:"pub_date"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	Description    string    `json:"description"`
+	Link           string    `json:"link"`
+	PubDate        time.Time `json:"pub_date"`
+	CreatedAt      time.Time `json:"created_at"`
+	GeoRestriction []string  `json:"geo_restriction,omitempty"`
+	Author         string    `json:"author,omitempty"`
+	SourceID       string    `json:"source_id,omitempty"`
+	SourceTitle    string    `json:"source_title,omitempty"`
+	// ImageURL иллюстрация новости: вложение, media:content или первая
+	// картинка в тексте
+	ImageURL string `json:"image_url,omitempty"`
+	// ContentText содержимое без разметки
+	ContentText string `json:"content_text,omitempty"`
+	// DuplicateOf оригинал, если новость — та же история из другой ленты
+	DuplicateOf *int `json:"duplicate_of,omitempty"`
+	// Language язык новости (ISO 639-1), пустой — не определён
+	Language string `json:"language,omitempty"`
+	// Revision номер редакции, UpdatedAt — время последней правки в ленте
+	Revision  int        `json:"revision"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Related другие публикации той же истории (только в детальной новости)
+	Related []RelatedNews `json:"related,omitempty"`
 }
 
 // NewsListResponse ответ со списком новостей
 type NewsListResponse struct {
 	News       []News     `json:"news"`
 	Pagination Pagination `json:"pagination"`
+	// NextCursor курсор следующей страницы (keyset по pub_date, id)
+	NextCursor *string `json:"next_cursor,omitempty"`
+	// Facets разрезы выдачи, только в /news/filter с facets
+	Facets *NewsFacets `json:"facets,omitempty"`
 }
 
 // Pagination структура пагинации
@@ -119,6 +237,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap даёт http.ResponseController доступ к Flush исходного ответа
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Получение IP адреса клиента
 func getClientIP(r *http.Request) string {
 	forwarded := r.Header.Get("X-Forwarded-For")
@@ -149,6 +272,101 @@ func main() {
 		log.Fatal("не удалось распарсить config.json:", err)
 	}
 
+	if err := setPageSizes(cfg.DefaultPerPage, cfg.MaxPerPage); err != nil {
+		log.Fatal("некорректный config.json:", err)
+	}
+	if cfg.FetchWorkers > 0 {
+		fetchWorkers = cfg.FetchWorkers
+	}
+	if cfg.FeedTimeoutSec > 0 {
+		feedTimeout = time.Duration(cfg.FeedTimeoutSec) * time.Second
+	}
+	if err := setSearchLanguages(cfg.SearchLanguages); err != nil {
+		log.Fatal("некорректный config.json:", err)
+	}
+	if err := setIngestLogFormat(cfg.IngestLogFormat); err != nil {
+		log.Fatal("некорректный config.json:", err)
+	}
+	if cfg.TrendingWindowHours > 0 {
+		trendingWindow = time.Duration(min(cfg.TrendingWindowHours, maxTrendingWindow)) * time.Hour
+	}
+	if cfg.FeedTitle != "" {
+		outFeedTitle = cfg.FeedTitle
+	}
+	if cfg.SitemapIntervalMin > 0 {
+		sitemapInterval = time.Duration(cfg.SitemapIntervalMin) * time.Minute
+	}
+	if cfg.ShutdownTimeoutSec > 0 {
+		shutdownTimeout = time.Duration(cfg.ShutdownTimeoutSec) * time.Second
+	}
+	if cfg.FetchRetries != 0 {
+		fetchRetries = max(cfg.FetchRetries, 0)
+	}
+	if cfg.RetryBackoffSec > 0 {
+		retryBackoff = time.Duration(cfg.RetryBackoffSec) * time.Second
+	}
+	if cfg.QuarantineAfter != 0 {
+		quarantineAfter = max(cfg.QuarantineAfter, 0)
+	}
+	if cfg.HostRPS != 0 {
+		politeness.rps = max(cfg.HostRPS, 0)
+	}
+	if cfg.HostConcurrency != 0 {
+		politeness.concurrency = max(cfg.HostConcurrency, 0)
+	}
+	if cfg.FetchJitterPercent != 0 {
+		fetchJitterPercent = min(max(cfg.FetchJitterPercent, 0), maxFetchJitterPercent)
+	}
+	if cfg.QuarantineIntervalSec > 0 {
+		quarantineInterval = time.Duration(cfg.QuarantineIntervalSec) * time.Second
+	}
+	if cfg.DedupTitleDistance != 0 {
+		titleDistance = max(cfg.DedupTitleDistance, -1)
+	}
+	if cfg.DedupWindowHours > 0 {
+		dedupWindow = time.Duration(cfg.DedupWindowHours) * time.Hour
+	}
+	if cfg.RetentionDays > 0 {
+		if cfg.RetentionDays < minRetentionDays {
+			log.Fatalf("некорректный config.json: retention_days должен быть не меньше %d", minRetentionDays)
+		}
+		retentionDays = cfg.RetentionDays
+	}
+	switch cfg.RetentionAction {
+	case "":
+	case retentionArchive, retentionDelete:
+		retentionAction = cfg.RetentionAction
+	default:
+		log.Fatal("некорректный config.json: retention_action должен быть archive или delete")
+	}
+	if cfg.RetentionIntervalHours > 0 {
+		retentionInterval = time.Duration(cfg.RetentionIntervalHours) * time.Hour
+	}
+	if cfg.ExtractHostIntervalSec > 0 {
+		extractHostInterval = time.Duration(cfg.ExtractHostIntervalSec) * time.Second
+	}
+	if cfg.ExtractQueueSize > 0 {
+		extractQueueSize = cfg.ExtractQueueSize
+	}
+	if cfg.WebhookRetries != 0 {
+		webhookRetries = max(cfg.WebhookRetries, 0)
+	}
+	if cfg.WebhookTimeoutSec > 0 {
+		webhookTimeout = time.Duration(cfg.WebhookTimeoutSec) * time.Second
+	}
+	if cfg.WebhookQueueSize > 0 {
+		webhookQueueSize = cfg.WebhookQueueSize
+	}
+	for _, src := range cfg.RSS {
+		if err := validateSource(src); err != nil {
+			log.Fatal("некорректный config.json:", err)
+		}
+	}
+	poolCfg, err := poolConfigFrom(cfg)
+	if err != nil {
+		log.Fatal("некорректный config.json:", err)
+	}
+
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbUser := os.Getenv("DB_USER")
@@ -162,7 +380,7 @@ func main() {
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
 
-	db, err = sql.Open("postgres", connStr)
+	db, err = openDB(connStr, poolCfg)
 	if err != nil {
 		log.Fatal("Ошибка подключения к БД:", err)
 	}
@@ -171,119 +389,491 @@ func main() {
 	if err = db.Ping(); err != nil {
 		log.Fatal("Не удается подключиться к БД:", err)
 	}
+	if err := migrate(context.Background()); err != nil {
+		log.Fatal("Не удалось применить миграции:", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--migrate-only" {
+		log.Println("Миграции применены")
+		return
+	}
 
-	// Запускаем периодическое обновление новостей в отдельной горутине
-	go func() {
-		ticker := time.NewTicker(time.Duration(cfg.RequestPeriod) * time.Minute)
-		defer ticker.Stop()
+	resolver = newLinkResolverFromEnv()
+	archive = newFeedArchiveFromEnv()
+	if archive.enabled() {
+		log.Printf("Исходные ленты архивируются в s3://%s/%s", archive.bucket, archive.prefix)
+	}
+	if err := mirrors.load(context.Background()); err != nil {
+		log.Fatal("Не удалось загрузить зеркала доменов:", err)
+	}
+	if events, err = newEventPublisherFromEnv(); err != nil {
+		log.Fatal("Некорректные настройки событий:", err)
+	}
+	if listCache, err = newListCacheFromEnv(); err != nil {
+		log.Fatal("Некорректные настройки кэша списков:", err)
+	}
+	if sitemap, err = newSitemapBuilderFromEnv(); err != nil {
+		log.Fatal("Некорректные настройки карты сайта:", err)
+	}
+	if searchEngine, err = newSearchBackendFromEnv(); err != nil {
+		log.Fatal("Некорректные настройки поиска:", err)
+	}
+	if err := webhooks.load(context.Background()); err != nil {
+		log.Fatal("Не удалось загрузить вебхуки:", err)
+	}
+	if err := seedSources(context.Background(), cfg.RSS); err != nil {
+		log.Fatal("Не удалось перенести источники из config.json:", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplayCommand(os.Args[2:])
+		return
+	}
 
-		for range ticker.C {
-			updateNewsFromRSS(cfg.RSS)
-		}
+	// Срочные и обычные ленты загружаются независимыми циклами. После
+	// перезапуска сразу загружаются только ленты, не обновлявшиеся в
+	// течение своего интервала; остальные дождутся своей очереди
+	var stop context.CancelFunc
+	serviceCtx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	highPeriod := defaultHighPriorityPeriod
+	if cfg.HighPriorityPeriodSec > 0 {
+		highPeriod = time.Duration(cfg.HighPriorityPeriodSec) * time.Second
+	}
+	go newFeedScheduler(priorityHigh, highPeriod).run(serviceCtx)
+	go newFeedScheduler(priorityRegular, time.Duration(cfg.RequestPeriod)*time.Minute).run(serviceCtx)
+	go backfillFingerprints(serviceCtx)
+	go func() {
+		extractor.requeuePending(serviceCtx)
+		extractor.run(serviceCtx)
 	}()
+	resolver.run(serviceCtx)
+	go runRetentionLoop(serviceCtx)
+	go webhooks.run(serviceCtx)
+	go views.run(serviceCtx)
+	if events.enabled() {
+		go events.run(serviceCtx)
+	}
+	if searchEngine != nil {
+		go searchIndex.run(serviceCtx)
+	}
+	if sitemap.enabled() {
+		go sitemap.run(serviceCtx)
+	}
 
-	updateNewsFromRSS(cfg.RSS)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/news/latest", latestNewsHandler)
-	mux.HandleFunc("/news/filter", filterNewsHandler)
+	mux.HandleFunc("/news/latest", listCache.wrap(latestNewsHandler))
+	mux.HandleFunc("/news/filter", listCache.wrap(filterNewsHandler))
+	mux.HandleFunc("/news/authors", authorsHandler)
+	mux.HandleFunc("/news/categories", categoriesHandler)
+	mux.HandleFunc("/news", newsBatchHandler)
+	mux.HandleFunc("/news/batch", newsBatchHandler)
+	mux.HandleFunc("/news/top", topStoriesHandler)
+	mux.HandleFunc("/news/trending", trendingNewsHandler)
+	mux.HandleFunc("/news/popular", popularNewsHandler)
+	mux.HandleFunc("/sources", sourcesHandler)
+	mux.HandleFunc("/feed.rss", outFeedHandler)
+	mux.HandleFunc("/feed.atom", outFeedHandler)
+	mux.HandleFunc("/sitemap.xml", sitemapIndexHandler)
+	mux.HandleFunc("/sitemaps/", sitemapPageHandler)
 	mux.HandleFunc("/news/", newsDetailHandler)
+	mux.HandleFunc("/admin/fingerprints/similar", similarHandler)
+	mux.HandleFunc("/admin/feeds/status", feedsStatusHandler)
+	mux.HandleFunc("/admin/feeds/bandwidth", feedsBandwidthHandler)
+	mux.HandleFunc("/admin/hosts", hostsHandler)
+	mux.HandleFunc("/admin/ingestion/stats", ingestionStatsHandler)
+	mux.HandleFunc("/admin/archive", archiveListHandler)
+	mux.HandleFunc("/admin/archive/replay", archiveReplayHandler)
+	mux.HandleFunc("/admin/sources", adminSourcesHandler)
+	mux.HandleFunc("/admin/sources/", adminSourceHandler)
+	mux.HandleFunc("/admin/sources/opml", adminOPMLHandler)
+	mux.HandleFunc("/admin/sources/discover", discoverHandler)
+	mux.HandleFunc("/admin/refresh", refreshHandler)
+	mux.HandleFunc("/admin/refresh/", refreshJobHandler)
+	mux.HandleFunc("/admin/top-stories", adminTopStoriesHandler)
+	mux.HandleFunc("/admin/top-stories/", adminTopStoryHandler)
+	mux.HandleFunc("/admin/sanitize", sanitizeHandler)
+	mux.HandleFunc("/admin/extraction", extractionHandler)
+	mux.HandleFunc("/admin/duplicates", duplicatesHandler)
+	mux.HandleFunc("/admin/duplicates/", duplicateHandler)
+	mux.HandleFunc("/admin/domain-aliases", domainAliasesHandler)
+	mux.HandleFunc("/admin/domain-aliases/", domainAliasHandler)
+	mux.HandleFunc("/admin/retention", retentionHandler)
+	mux.HandleFunc("/admin/webhooks", adminWebhooksHandler)
+	mux.HandleFunc("/admin/webhooks/", adminWebhookHandler)
+	mux.HandleFunc("/admin/events", eventsHandler)
+	mux.HandleFunc("/admin/cache", listCacheHandler)
+	mux.HandleFunc("/admin/sitemap", sitemapAdminHandler)
+	mux.HandleFunc("/admin/export", exportHandler)
+	mux.HandleFunc("/admin/search", searchAdminHandler)
+	mux.HandleFunc("/admin/search/reindex", searchReindexHandler)
 	mux.HandleFunc("/health", healthCheckHandler)
-	handler := requestIDMiddleware(mux)
+	mux.HandleFunc("/metrics", metricsHandler)
+	handler := serviceAuthMiddleware(mux)
+	handler = requestIDMiddleware(handler)
+	handler = metricsMiddleware(mux, handler)
 	handler = loggingMiddleware(handler)
 
+	srv := &http.Server{Addr: ":8082", Handler: handler}
+	// при остановке сервер перестаёт принимать запросы и дожидается
+	// текущих, а планировщики перестают брать ленты; начатая загрузка
+	// дописывается в базу. На всё вместе отводится shutdown_timeout_sec
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-serviceCtx.Done()
+		log.Println("Остановка сервиса новостей...")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Ошибка остановки HTTP-сервера: %v", err)
+		}
+		waitFetches(ctx)
+		// просмотры пишутся и после истечения shutdown_timeout_sec
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), viewsFlushTimeout)
+		defer cancelFlush()
+		if err := views.flush(flushCtx); err != nil {
+			log.Printf("Ошибка записи просмотров при остановке: %v", err)
+		}
+	}()
+
 	log.Println("Сервис новостей запущен на порту 8082")
-	log.Fatal(http.ListenAndServe(":8082", handler))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	log.Println("Сервис новостей остановлен")
 }
 
-// updateNewsFromRSS загружает новости из RSS-источников
-func updateNewsFromRSS(rssSources []string) {
-	log.Println("Начинаем обновление новостей из RSS...")
-	totalAdded := 0
-	for _, rssURL := range rssSources {
-		items, err := fetchRSSFeed(rssURL)
-		if err != nil {
-			log.Printf("Ошибка загрузки RSS %s: %v", rssURL, err)
-			continue
+// updateNewsFromRSS загружает новости из RSS-источников одного приоритета
+func updateNewsFromRSS(ctx context.Context, priority string, rssSources []feedSource) {
+	run := newIngestRun(triggerSchedule, len(rssSources), "priority", priority)
+	fetchFeeds(ctx, rssSources, func(src feedSource, added int, err error) {
+		run.report(added, err)
+	})
+	run.finish()
+}
+
+// ingestFeed загружает одну ленту и сохраняет её новые элементы;
+// возвращает число добавленных новостей
+func ingestFeed(ctx context.Context, src feedSource) (int, error) {
+	start := time.Now()
+	flog := feedLog(src)
+	lastGUID := ""
+	var cond feedValidators
+	if cp, err := getCheckpoint(ctx, src.URL); err != nil {
+		flog.Error("Ошибка чтения контрольной точки", "error", err)
+	} else if cp != nil {
+		lastGUID = cp.LastItemGUID
+		cond = feedValidators{ETag: cp.ETag, LastModified: cp.LastModified}
+	}
+
+	items, validators, notModified, err := fetchWithRetry(ctx, src, cond)
+	if err != nil && ctx.Err() != nil {
+		// сервис останавливается: это не сбой ленты
+		flog.Info("Загрузка ленты прервана остановкой сервиса", "duration_ms", time.Since(start).Milliseconds())
+		return 0, err
+	}
+	ingestion.fetched(src, err)
+	if err != nil {
+		failures, saveErr := saveFetchFailure(ctx, src.URL, err, time.Since(start))
+		if saveErr != nil {
+			flog.Error("Ошибка сохранения статуса загрузки", "error", saveErr)
+		}
+		flog.Error("Ошибка загрузки ленты", "error", err, "consecutive_failures", failures,
+			"duration_ms", time.Since(start).Milliseconds())
+		reportFailureStreak(src, failures, err)
+		return 0, err
+	}
+	// лента уже скачана: её новости и контрольная точка сохраняются и при
+	// остановке сервиса, чтобы лента не осталась обработанной наполовину
+	saveCtx := context.WithoutCancel(ctx)
+	if notModified {
+		if err := saveNotModified(saveCtx, src.URL, time.Since(start)); err != nil {
+			flog.Error("Ошибка сохранения контрольной точки", "error", err)
 		}
-		added := 0
-		for _, item := range items {
-			if saveNewsItem(item) {
-				added++
+		flog.Info("Лента не изменилась", "duration_ms", time.Since(start).Milliseconds())
+		return 0, nil
+	}
+	if len(items) == 0 {
+		// лента разобрана без ошибок, но пуста: чаще всего это
+		// неподдерживаемый вариант формата, а не отсутствие новостей
+		flog.Warn("Лента не содержит ни одного элемента, проверьте её формат")
+	}
+	from, to := newItemsSince(items, lastGUID)
+	metrics.itemsFetched.add(float64(len(items)), src.sourceID())
+
+	// новые элементы — items[from:to]; остальные уже обработаны и
+	// проверяются только на правки. newest — самый свежий по дате из
+	// новых, он становится контрольной точкой.
+	added, updated, failed := 0, 0, 0
+	newest := -1
+	for i, item := range items {
+		mode := storeRevise
+		if i >= from && i < to {
+			mode = storeInsert
+			if newest < 0 || item.PubDate.After(items[newest].PubDate) {
+				newest = i
+			}
+		}
+		switch storeNewsItem(saveCtx, item, src, mode) {
+		case newsAdded:
+			added++
+			ingestion.added(src, item.PubDate)
+		case newsUpdated:
+			updated++
+		case newsFailed:
+			if mode == storeInsert {
+				failed++
 			}
 		}
-		totalAdded += added
-		log.Printf("Загружено %d новостей из %s", added, rssURL)
 	}
-	log.Printf("Обновление завершено. Добавлено новостей: %d", totalAdded)
+	metrics.itemsInserted.add(float64(added), src.sourceID())
+	if added > 0 || updated > 0 {
+		listCache.invalidate(saveCtx)
+	}
+
+	// Контрольная точка сохраняется после обработки всей ленты: при
+	// падении посередине элементы будут обработаны повторно, а дубли
+	// отсекаются уникальностью ссылки. Если новый элемент не сохранился,
+	// контрольная точка и валидаторы не сдвигаются — иначе следующая
+	// загрузка пропустила бы его или получила бы 304.
+	var newestGUID string
+	var newestPubDate *time.Time
+	if failed > 0 {
+		validators = cond
+	} else if newest >= 0 {
+		newestGUID = itemGUID(items[newest])
+		newestPubDate = &items[newest].PubDate
+	}
+	if err := saveCheckpoint(saveCtx, src.URL, newestGUID, newestPubDate, len(items), added, time.Since(start), validators); err != nil {
+		flog.Error("Ошибка сохранения контрольной точки", "error", err)
+	}
+	flog.Info("Лента загружена", "items", len(items), "fresh", to-from, "added", added, "updated", updated, "failed", failed,
+		"duration_ms", time.Since(start).Milliseconds())
+	return added, nil
 }
 
-// fetchRSSFeed загружает и парсит RSS-ленту
-func fetchRSSFeed(rssURL string) ([]Item, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(rssURL)
+// fetchRSSFeed загружает и парсит ленту (RSS 2.0, Atom 1.0 или JSON Feed).
+// Валидаторы cond прошлой загрузки отправляются условными заголовками;
+// если лента не изменилась (304), notModified == true и разбор не
+// выполняется.
+func fetchRSSFeed(ctx context.Context, rssURL string, auth *SourceAuth, cond feedValidators) (items []FeedItem, validators feedValidators, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rssURL, nil)
+	if err != nil {
+		return nil, cond, false, fmt.Errorf("ошибка загрузки RSS: %v", err)
+	}
+	cond.apply(req)
+	auth.apply(req)
+	release, err := politeness.acquire(ctx, rssURL)
+	if err != nil {
+		return nil, cond, false, fmt.Errorf("ошибка загрузки RSS: %w", err)
+	}
+	defer release()
+	start := time.Now()
+	var downloaded int64
+	// трафик учитывается и для загрузки, прерванной таймаутом
+	defer func() { recordBandwidth(context.WithoutCancel(ctx), rssURL, downloaded, time.Since(start)) }()
+	client := &http.Client{CheckRedirect: auth.checkRedirect}
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка загрузки RSS: %v", err)
+		return nil, cond, false, fmt.Errorf("ошибка загрузки RSS: %w", err)
 	}
 	defer resp.Body.Close()
+	politeness.observe(rssURL, resp)
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, cond, true, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP ошибка: %d", resp.StatusCode)
+		return nil, cond, false, &feedStatusError{code: resp.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
+	downloaded = int64(len(body))
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %v", err)
+		return nil, cond, false, fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if archive.enabled() {
+		go archive.store(context.WithoutCancel(ctx), rssURL, body, isJSONFeed(contentType, rssURL, body), time.Now())
 	}
 
-	var rss RSS
-	err = xml.Unmarshal(body, &rss)
+	items, err = parseFeed(body, contentType, rssURL)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга RSS: %v", err)
+		return nil, cond, false, &feedParseError{err: err}
 	}
-
-	return rss.Channel.Items, nil
+	return items, validatorsFrom(resp), false, nil
 }
 
-func saveNewsItem(item Item) bool {
-	var pubDate time.Time
-	if item.PubDate != "" {
-		if parsed, err := time.Parse(time.RFC1123, item.PubDate); err == nil {
-			pubDate = parsed
-		} else if parsed, err := time.Parse(time.RFC1123Z, item.PubDate); err == nil {
-			pubDate = parsed
-		} else {
-			pubDate = time.Now()
+// parsePubDate разбирает дату публикации; пустая или нераспознанная
+// дата заменяется текущим временем
+func parsePubDate(value string) time.Time {
+	if value != "" {
+		if parsed, err := time.Parse(time.RFC1123, value); err == nil {
+			return parsed
+		} else if parsed, err := time.Parse(time.RFC1123Z, value); err == nil {
+			return parsed
+		} else if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			return parsed
 		}
-	} else {
-		pubDate = time.Now()
 	}
+	return time.Now()
+}
+
+// storeNewsItem сохраняет новость в режиме mode. Новая новость сразу
+// вставляется; если ссылка уже сохранена, сверяется хеш содержимого, и
+// изменившаяся новость обновляется. storeRevise только ищет и сверяет, а
+// storeOverwrite перезаписывает новость (используется при replay).
+func storeNewsItem(ctx context.Context, item FeedItem, src feedSource, mode storeMode) saveOutcome {
+	overwrite := mode == storeOverwrite
+	pubDate := item.PubDate
 
 	title := strings.TrimSpace(item.Title)
 	description := strings.TrimSpace(item.Description)
 	content := strings.TrimSpace(item.Content)
-	link := strings.TrimSpace(item.Link)
+	sourceLink := strings.TrimSpace(item.Link)
+	author := item.Author
 
-	if title == "" || link == "" {
-		return false
+	if title == "" || sourceLink == "" {
+		return newsSkipped
+	}
+	// при проверке на правки ссылка не разрешается по сети: новость
+	// ищется по сохранённой ссылке или исходной ссылке из ленты
+	// новая ссылка сохраняется как есть и разрешается в фоне
+	link := stripTrackingParams(sourceLink)
+	resolved := true
+	if mode != storeRevise {
+		var known string
+		known, resolved = resolver.known(ctx, sourceLink)
+		link = stripTrackingParams(known)
+	}
+	hash := itemContentHash(item)
+	// revisedID уже сохранённая новость, содержимое которой изменилось;
+	// элементы до контрольной точки почти всегда сохранены и не менялись,
+	// поэтому их сначала сверяют, а не готовят к вставке
+	revisedID := 0
+	if mode == storeRevise {
+		if revisedID, link = changedStoredNews(ctx, src, link, sourceLink, hash); revisedID == 0 {
+			return newsSkipped
+		}
 	}
 
 	if content == "" {
 		content = description
 	}
+	base, _ := url.Parse(sourceLink)
+	content = sanitizeHTML(content, base)
+	description = sanitizeHTML(description, base)
+
+	availableAt := pubDate.Add(time.Duration(src.EmbargoMinutes) * time.Minute)
+	if src.BypassEmbargo {
+		availableAt = pubDate
+	}
+	geoRestriction := strings.ToUpper(strings.Join(src.GeoRestriction, ","))
+	contentText := htmlToText(content)
+
+	if revisedID != 0 {
+		return applyRevision(ctx, revisedID, link, item, src, title, content, description, contentText, hash)
+	}
+
+	key := linkKey(link)
+	titleFP := titleFingerprint(title)
+	var duplicateOf *int
+	duplicateReason := ""
+	dup, err := findDuplicate(ctx, link, sourceLink, key, titleFP, pubDate)
+	if err != nil {
+		feedLog(src).Error("Ошибка поиска дублей новости", "title", title, "error", err)
+	} else if dup != nil && dup.stored {
+		// новость сохранена под исходной ссылкой: вставка уйдёт в
+		// ON CONFLICT и сверку правок
+		link, key = sourceLink, linkKey(sourceLink)
+	} else if dup != nil {
+		duplicateOf, duplicateReason = &dup.id, dup.reason
+		feedLog(src).Info("Новость — дубль", "title", title, "link", link, "duplicate", dup.String())
+	}
 
 	query := `
-		INSERT INTO news (title, content, description, link, pub_date)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO news (title, content, description, link, pub_date, available_at, geo_restriction, author, content_simhash, source_link, source_id, source_title, image_url, content_text, sanitizer_version,
+			link_key, title_simhash, duplicate_of, duplicate_reason, search_language, language, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 		ON CONFLICT (link) DO NOTHING
+		RETURNING id
+	`
+	if overwrite {
+		query = `
+		INSERT INTO news (title, content, description, link, pub_date, available_at, geo_restriction, author, content_simhash, source_link, source_id, source_title, image_url, content_text, sanitizer_version,
+			link_key, title_simhash, duplicate_of, duplicate_reason, search_language, language, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+		ON CONFLICT (link) DO UPDATE SET
+			title = EXCLUDED.title,
+			content = EXCLUDED.content,
+			description = EXCLUDED.description,
+			pub_date = EXCLUDED.pub_date,
+			available_at = EXCLUDED.available_at,
+			geo_restriction = EXCLUDED.geo_restriction,
+			author = EXCLUDED.author,
+			content_simhash = EXCLUDED.content_simhash,
+			source_link = EXCLUDED.source_link,
+			source_id = EXCLUDED.source_id,
+			source_title = EXCLUDED.source_title,
+			image_url = EXCLUDED.image_url,
+			content_text = EXCLUDED.content_text,
+			sanitizer_version = EXCLUDED.sanitizer_version,
+			link_key = EXCLUDED.link_key,
+			title_simhash = EXCLUDED.title_simhash,
+			search_language = EXCLUDED.search_language,
+			language = EXCLUDED.language,
+			content_hash = EXCLUDED.content_hash
+		RETURNING id
 	`
-	result, err := db.Exec(query, title, content, description, link, pubDate)
+	}
+	var id int
+	err = db.QueryRowContext(ctx, query, title, content, description, link, pubDate, availableAt, geoRestriction, author,
+		contentFingerprint(title, content), sourceLink, src.sourceID(), src.sourceTitle(item), itemImageURL(item, sourceLink),
+		contentText, sanitizerVersion, key, titleFP, duplicateOf, duplicateReason, src.searchLanguage(title, contentText),
+		src.newsLanguage(title, contentText), hash).Scan(&id)
+	if err == sql.ErrNoRows {
+		// новость с такой ссылкой уже сохранена: правка, если изменился хеш
+		if revisedID, link = changedStoredNews(ctx, src, link, sourceLink, hash); revisedID == 0 {
+			return newsSkipped
+		}
+		return applyRevision(ctx, revisedID, link, item, src, title, content, description, contentText, hash)
+	}
 	if err != nil {
-		log.Printf("Ошибка сохранения новости '%s': %v", title, err)
-		return false
+		feedLog(src).Error("Ошибка сохранения новости", "title", title, "error", err)
+		return newsFailed
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	return rowsAffected > 0
+	if err := saveNewsTags(ctx, link, item.Categories, overwrite); err != nil {
+		feedLog(src).Error("Ошибка сохранения рубрик новости", "title", title, "error", err)
+	}
+	// после рубрик: они тоже попадают в документ индекса
+	searchIndex.enqueue(id)
+	// у дубля есть полный текст оригинала, страницу лишний раз не
+	// запрашиваем; при разрешении ссылки — после него, по конечному URL
+	extract := src.ExtractFullContent && duplicateOf == nil
+	if !resolved {
+		resolver.enqueue(resolveTask{id: id, link: sourceLink, extract: extract, feedURL: src.URL})
+	} else if extract {
+		extractor.enqueue(extractTask{link: link, feedURL: src.URL})
+	}
+	// overwrite — повторное сохранение из архива, а не новая новость
+	if !overwrite {
+		events.publish(NewsEvent{
+			ID:          id,
+			Title:       title,
+			Link:        link,
+			SourceID:    src.sourceID(),
+			SourceTitle: src.sourceTitle(item),
+			PubDate:     pubDate,
+			AvailableAt: availableAt,
+			DuplicateOf: duplicateOf,
+			CreatedAt:   time.Now(),
+		})
+	}
+	if !overwrite && duplicateOf == nil {
+		webhooks.notify(id, title+"\n"+contentText, availableAt)
+	}
+	return newsAdded
 }
 
 // latestNewsHandler возвращает последние новости
@@ -296,43 +886,64 @@ func latestNewsHandler(w http.ResponseWriter, r *http.Request) {
 	requestID, _ := r.Context().Value("request_id").(string)
 	log.Printf("Запрос последних новостей, request_id: %s", requestID)
 
-	pageParam := r.URL.Query().Get("page")
-	page := 1
-	if pageParam != "" {
-		var err error
-		page, err = strconv.Atoi(pageParam)
-		if err != nil || page < 1 {
-			page = 1
-		}
+	paging, err := parsePaging(r)
+	if err == nil {
+		err = paging.checkCursorOrder(orderDateDesc)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if paging.Fields, err = parseNewsFields(r.URL.Query().Get("fields")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	page := paging.Page
 
 	searchQuery := r.URL.Query().Get("s")
+	author := r.URL.Query().Get("author")
+	source := strings.TrimSpace(r.URL.Query().Get("source"))
+	langs, err := parseLanguages(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	offset := (page - 1) * PER_PAGE
-
-	news, total, err := getLatestNews(searchQuery, PER_PAGE, offset)
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	news, total, err := getLatestNews(ctx, searchQuery, author, source, langs, paging)
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос новостей отменён, request_id: %s", requestID)
+		return
+	}
+	if isQueryTimeout(err) {
+		log.Printf("Запрос новостей прерван по таймауту (s=%q), request_id: %s", searchQuery, requestID)
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		log.Printf("Ошибка получения новостей: %v", err)
 		http.Error(w, "Failed to get news", http.StatusInternalServerError)
 		return
 	}
 
-	totalPages := int(math.Ceil(float64(total) / float64(PER_PAGE)))
+	totalPages := int(math.Ceil(float64(total) / float64(paging.PerPage)))
 
 	response := NewsListResponse{
 		News: news,
 		Pagination: Pagination{
 			Page:       page,
 			TotalPages: totalPages,
-			PerPage:    PER_PAGE,
+			PerPage:    paging.PerPage,
 			Total:      total,
 		},
+		NextCursor: paging.nextCursor(news, orderDateDesc, totalPages),
 	}
 
 	log.Printf("Возвращено %d новостей, страница %d из %d, request_id: %s", len(news), page, totalPages, requestID)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	encodeNewsList(w, response, paging.Fields)
 }
 
 // filterNewsHandler фильтрует новости по параметрам
@@ -355,50 +966,106 @@ func filterNewsHandler(w http.ResponseWriter, r *http.Request) {
 		query = searchQuery
 	}
 
-	pageParam := r.URL.Query().Get("page")
-	page := 1
-	if pageParam != "" {
-		var err error
-		page, err = strconv.Atoi(pageParam)
-		if err != nil || page < 1 {
-			page = 1
-		}
+	paging, err := parsePaging(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if paging.Fields, err = parseNewsFields(r.URL.Query().Get("fields")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	page := paging.Page
 
-	offset := (page - 1) * PER_PAGE
+	order, err := parseNewsOrder(sortBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if order.relevance && query == "" {
+		// без запроса релевантность не определена — сортировка по дате
+		order = orderDateDesc
+	}
+	if paging.After != nil && !order.keyset {
+		http.Error(w, fmt.Sprintf("cursor is not supported for sort_by=%s, use page", sortBy), http.StatusBadRequest)
+		return
+	}
+	if err := paging.checkCursorOrder(order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	langs, err := parseLanguages(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := parseExcludeTerms(r.URL.Query().Get("exclude"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	facetInterval, err := parseDateFacet(r.URL.Query().Get("facets"), r.URL.Query().Get("facet_interval"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	news, total, err := filterNews(query, dateFrom, dateTo, sortBy, PER_PAGE, offset)
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	filter := newsFilter{
+		Query:     query,
+		Author:    r.URL.Query().Get("author"),
+		Category:  strings.TrimSpace(r.URL.Query().Get("category")),
+		Source:    strings.TrimSpace(r.URL.Query().Get("source")),
+		Languages: langs,
+		Exclude:   exclude,
+		DateFrom:  dateFrom,
+		DateTo:    dateTo,
+		Order:     order,
+	}
+	news, total, err := filterNews(ctx, filter, paging)
+	var facets *NewsFacets
+	if err == nil && facetInterval != "" {
+		facets = &NewsFacets{}
+		facets.Date, err = getDateFacet(ctx, filter, paging, facetInterval)
+	}
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, фильтрация новостей отменена, request_id: %s", requestID)
+		return
+	}
+	if isQueryTimeout(err) {
+		log.Printf("Фильтрация новостей прервана по таймауту, request_id: %s", requestID)
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		log.Printf("Ошибка фильтрации новостей: %v", err)
 		http.Error(w, "Failed to filter news", http.StatusInternalServerError)
 		return
 	}
 
-	totalPages := int(math.Ceil(float64(total) / float64(PER_PAGE)))
+	totalPages := int(math.Ceil(float64(total) / float64(paging.PerPage)))
 
 	response := NewsListResponse{
 		News: news,
 		Pagination: Pagination{
 			Page:       page,
 			TotalPages: totalPages,
-			PerPage:    PER_PAGE,
+			PerPage:    paging.PerPage,
 			Total:      total,
 		},
+		NextCursor: paging.nextCursor(news, order, totalPages),
+		Facets:     facets,
 	}
 
 	log.Printf("Фильтрация: найдено %d новостей, страница %d из %d, request_id: %s", len(news), page, totalPages, requestID)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	encodeNewsList(w, response, paging.Fields)
 }
 
 // newsDetailHandler возвращает детальную информацию о новости
 func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	requestID, _ := r.Context().Value("request_id").(string)
 
 	path := r.URL.Path
@@ -407,16 +1074,39 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	idStr := path[6:]
+	idStr, action, _ := strings.Cut(path[6:], "/")
 	newsID, err := strconv.Atoi(idStr)
 	if err != nil {
 		http.Error(w, "Invalid news ID", http.StatusBadRequest)
 		return
 	}
+	if action == "view" {
+		newsViewHandler(w, r, newsID)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "":
+	case "related":
+		readAlsoHandler(w, r, newsID)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
 
 	log.Printf("Запрос детальной новости ID: %d, request_id: %s", newsID, requestID)
 
-	news, err := getNewsByID(newsID)
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	news, err := getNewsByID(ctx, newsID)
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос новости %d отменён, request_id: %s", newsID, requestID)
+		return
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "News not found", http.StatusNotFound)
@@ -429,6 +1119,17 @@ func newsDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Найдена новость: %s, request_id: %s", news.Title, requestID)
 
+	// gateway сам отвечает 451 по стране клиента; прямому запросу — здесь
+	geo := paging{Country: requestCountry(r)}
+	if !serviceAuthorized(r) && !geo.geoAllows(*news) {
+		http.Error(w, "News is not available in your region", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	if news.Related, err = relatedNews(ctx, news, geo); err != nil {
+		log.Printf("Ошибка получения связанных публикаций новости %d: %v", news.ID, err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(news)
 }
@@ -452,51 +1153,57 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		status["database"] = "connected"
 	}
+	stats := db.Stats()
+	status["db_pool"] = map[string]interface{}{
+		"max_open":      stats.MaxOpenConnections,
+		"open":          stats.OpenConnections,
+		"in_use":        stats.InUse,
+		"idle":          stats.Idle,
+		"wait_count":    stats.WaitCount,
+		"wait_duration": stats.WaitDuration.String(),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
-// getLatestNews получает последние новости из БД с поиском
-func getLatestNews(searchQuery string, limit, offset int) ([]News, int, error) {
-	var countQuery, newsQuery string
-	var args []interface{}
+// newsColumns список колонок, которые читает scanNews
+const newsColumns = "id, title, content, description, link, pub_date, created_at, geo_restriction, author, source_id, source_title, image_url, content_text, duplicate_of, language, revision, updated_at"
 
-	if searchQuery != "" {
-		countQuery = "SELECT COUNT(*) FROM news WHERE title ILIKE $1"
-		newsQuery = `
-			SELECT id, title, content, description, link, pub_date, created_at
-			FROM news
-			WHERE title ILIKE $1
-			ORDER BY pub_date DESC, id DESC
-			LIMIT $2 OFFSET $3
-		`
-		searchPattern := "%" + searchQuery + "%"
-		args = []interface{}{searchPattern, limit, offset}
-	} else {
-		countQuery = "SELECT COUNT(*) FROM news"
-		newsQuery = `
-			SELECT id, title, content, description, link, pub_date, created_at
-			FROM news
-			ORDER BY pub_date DESC, id DESC
-			LIMIT $1 OFFSET $2
-		`
-		args = []interface{}{limit, offset}
-	}
+// rowScanner общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNews читает новость в порядке newsColumns
+func scanNews(row rowScanner) (News, error) {
+	return scanNewsColumns(row, newsFields)
+}
+
+// queryNewsList выполняет подсчёт и выборку страницы новостей
+func queryNewsList(ctx context.Context, whereClause string, order newsOrder, args []interface{}, p paging) ([]News, int, error) {
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM news %s", whereClause)
 	var total int
-	if searchQuery != "" {
-		searchPattern := "%" + searchQuery + "%"
-		err := db.QueryRow(countQuery, searchPattern).Scan(&total)
-		if err != nil {
-			return nil, 0, err
-		}
-	} else {
-		err := db.QueryRow(countQuery).Scan(&total)
-		if err != nil {
-			return nil, 0, err
-		}
+	err := db.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
 	}
-	rows, err := db.Query(newsQuery, args...)
+
+	// условие курсора не влияет на total и добавляется только к выборке страницы
+	if p.After != nil && order.keyset {
+		whereClause += " AND " + order.keysetCondition(p.After, &args)
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		%s
+		%s
+		LIMIT $%d OFFSET $%d
+	`, p.columns(), whereClause, order.clause, len(args)+1, len(args)+2)
+
+	args = append(args, p.PerPage, p.offset())
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -504,111 +1211,165 @@ func getLatestNews(searchQuery string, limit, offset int) ([]News, int, error) {
 
 	var news []News
 	for rows.Next() {
-		var n News
-		err := rows.Scan(&n.ID, &n.Title, &n.Content, &n.Description, &n.Link, &n.PubDate, &n.CreatedAt)
+		n, err := p.scan(rows)
 		if err != nil {
 			return nil, 0, err
 		}
 		news = append(news, n)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
 
 	return news, total, nil
 }
 
-// filterNews фильтрует новости по параметрам
-func filterNews(searchQuery, dateFrom, dateTo, sortBy string, limit, offset int) ([]News, int, error) {
-	var conditions []string
+// getLatestNews получает последние новости из БД с поиском по заголовку,
+// автору, источнику и языку
+func getLatestNews(ctx context.Context, searchQuery, author, source string, langs []string, p paging) ([]News, int, error) {
 	var args []interface{}
-	argIndex := 1
+	conditions := []string{snapshotCondition(p.AsOf, &args), p.duplicateCondition(&args), p.geoCondition(&args)}
 
 	if searchQuery != "" {
-		conditions = append(conditions, fmt.Sprintf("(to_tsvector('russian', title) @@ plainto_tsquery('russian', $%d) OR to_tsvector('russian', content) @@ plainto_tsquery('russian', $%d))", argIndex, argIndex))
-		args = append(args, searchQuery)
-		argIndex++
+		args = append(args, "%"+searchQuery+"%")
+		conditions = append(conditions, fmt.Sprintf("title ILIKE $%d", len(args)))
+	}
+	if author != "" {
+		args = append(args, author)
+		conditions = append(conditions, fmt.Sprintf("LOWER(author) = LOWER($%d)", len(args)))
+	}
+	if source != "" {
+		args = append(args, source)
+		conditions = append(conditions, fmt.Sprintf("source_id = LOWER($%d)", len(args)))
+	}
+	if len(langs) > 0 {
+		args = append(args, pq.Array(langs))
+		conditions = append(conditions, fmt.Sprintf("language = ANY($%d)", len(args)))
 	}
 
-	if dateFrom != "" {
-		if parsedDate, err := time.Parse("2006-01-02", dateFrom); err == nil {
-			conditions = append(conditions, fmt.Sprintf("pub_date >= $%d", argIndex))
-			args = append(args, parsedDate)
-			argIndex++
+	whereClause := "WHERE " + strings.Join(conditions, " AND ")
+	return queryNewsList(ctx, whereClause, orderDateDesc, args, p)
+}
+
+// newsFilter параметры /news/filter
+type newsFilter struct {
+	Query    string
+	Author   string
+	Category string
+	Source   string
+	// Languages коды языков; пустой — все
+	Languages []string
+	// Exclude слова, новости с которыми не попадают в выдачу
+	Exclude  []string
+	DateFrom string
+	DateTo   string
+	Order    newsOrder
+}
+
+// filterNews фильтрует новости по параметрам
+func filterNews(ctx context.Context, f newsFilter, p paging) ([]News, int, error) {
+	if searchEngine != nil && f.Query != "" {
+		news, total, err := searchNewsExternal(ctx, f, p)
+		if err == nil || ctx.Err() != nil {
+			return news, total, err
 		}
+		log.Printf("Ошибка поиска в %s, ищем в PostgreSQL: %v", searchEngine.name(), err)
 	}
 
-	if dateTo != "" {
-		if parsedDate, err := time.Parse("2006-01-02", dateTo); err == nil {
-			conditions = append(conditions, fmt.Sprintf("pub_date <= $%d", argIndex))
-			args = append(args, parsedDate.Add(24*time.Hour-time.Second))
-			argIndex++
+	whereClause, order, args, err := newsFilterWhere(ctx, f, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	return queryNewsList(ctx, whereClause, order, args, p)
+}
+
+// newsFilterWhere условие выборки /news/filter, порядок и аргументы запроса
+func newsFilterWhere(ctx context.Context, f newsFilter, p paging) (string, newsOrder, []interface{}, error) {
+	var args []interface{}
+	conditions := []string{snapshotCondition(p.AsOf, &args), p.duplicateCondition(&args), p.geoCondition(&args)}
+	argIndex := len(args) + 1
+	order := f.Order
+
+	if f.Query != "" {
+		cond, rank, err := searchMatch(ctx, f.Query, &args)
+		if err != nil {
+			return "", order, nil, err
+		}
+		conditions = append(conditions, cond)
+		argIndex = len(args) + 1
+		if order.relevance {
+			order.clause = "ORDER BY " + rank + " DESC, pub_date DESC, id DESC"
 		}
 	}
 
-	whereClause := ""
-	if len(conditions) > 0 {
-		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	if f.Author != "" {
+		conditions = append(conditions, fmt.Sprintf("LOWER(author) = LOWER($%d)", argIndex))
+		args = append(args, f.Author)
+		argIndex++
 	}
 
-	orderClause := "ORDER BY pub_date DESC, id DESC"
-	if sortBy == "title" {
-		orderClause = "ORDER BY title ASC"
-	} else if sortBy == "date_asc" {
-		orderClause = "ORDER BY pub_date ASC, id ASC"
+	if f.Category != "" {
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM news_tags t WHERE t.news_id = news.id AND LOWER(t.tag) = LOWER($%d))", argIndex))
+		args = append(args, f.Category)
+		argIndex++
 	}
 
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM news %s", whereClause)
-	var total int
-	err := db.QueryRow(countQuery, args...).Scan(&total)
-	if err != nil {
-		return nil, 0, err
+	if f.Source != "" {
+		conditions = append(conditions, fmt.Sprintf("source_id = LOWER($%d)", argIndex))
+		args = append(args, f.Source)
+		argIndex++
 	}
 
-	query := fmt.Sprintf(`
-		SELECT id, title, content, description, link, pub_date, created_at
-		FROM news
-		%s
-		%s
-		LIMIT $%d OFFSET $%d
-	`, whereClause, orderClause, argIndex, argIndex+1)
+	if len(f.Languages) > 0 {
+		conditions = append(conditions, fmt.Sprintf("language = ANY($%d)", argIndex))
+		args = append(args, pq.Array(f.Languages))
+		argIndex++
+	}
 
-	args = append(args, limit, offset)
+	if len(f.Exclude) > 0 {
+		cond, err := excludeMatch(ctx, f.Exclude, &args)
+		if err != nil {
+			return "", order, nil, err
+		}
+		conditions = append(conditions, cond)
+		argIndex = len(args) + 1
+	}
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, 0, err
+	if f.DateFrom != "" {
+		if parsedDate, err := time.Parse("2006-01-02", f.DateFrom); err == nil {
+			conditions = append(conditions, fmt.Sprintf("pub_date >= $%d", argIndex))
+			args = append(args, parsedDate)
+			argIndex++
+		}
 	}
-	defer rows.Close()
 
-	var news []News
-	for rows.Next() {
-		var n News
-		err := rows.Scan(&n.ID, &n.Title, &n.Content, &n.Description, &n.Link, &n.PubDate, &n.CreatedAt)
-		if err != nil {
-			return nil, 0, err
+	if f.DateTo != "" {
+		if parsedDate, err := time.Parse("2006-01-02", f.DateTo); err == nil {
+			conditions = append(conditions, fmt.Sprintf("pub_date <= $%d", argIndex))
+			args = append(args, parsedDate.Add(24*time.Hour-time.Second))
+			argIndex++
 		}
-		news = append(news, n)
 	}
 
-	return news, total, nil
+	return "WHERE " + strings.Join(conditions, " AND "), order, args, nil
 }
 
 // getNewsByID получает новость по ID
-func getNewsByID(id int) (*News, error) {
-	query := `
-		SELECT id, title, content, description, link, pub_date, created_at
+func getNewsByID(ctx context.Context, id int) (*News, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM news
-		WHERE id = $1
-	`
+		WHERE id = $1 AND available_at <= NOW()
+	`, newsColumns)
+
+	news, err := scanNews(db.QueryRowContext(ctx, query, id))
+	return &news, err
+}
 
-	news := &News{}
-	err := db.QueryRow(query, id).Scan(
-		&news.ID,
-		&news.Title,
-		&news.Content,
-		&news.Description,
-		&news.Link,
-		&news.PubDate,
-		&news.CreatedAt,
-	)
-
-	return news, err
+// splitGeoRestriction разбирает список стран, хранящийся через запятую
+func splitGeoRestriction(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
 }
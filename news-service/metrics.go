@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики Prometheus. GET /metrics отдаёт в текстовом формате счётчики
+// загрузки лент по источникам (source — source_id), длительность
+// загрузки лент, обработки HTTP-запросов и запросов к БД. Маршрут в
+// метке route — шаблон из ServeMux, поэтому ID в пути не порождают
+// новые ряды. Запросы к БД учитываются обёрткой драйвера по первому
+// слову запроса; для SELECT это время до получения первых строк.
+
+var (
+	httpBuckets  = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	fetchBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+	dbBuckets    = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// serviceMetrics метрики сервиса
+type serviceMetrics struct {
+	itemsFetched  *counterVec
+	itemsInserted *counterVec
+	parseErrors   *counterVec
+	fetchDuration *histogramVec
+	httpDuration  *histogramVec
+	dbDuration    *histogramVec
+}
+
+var metrics = &serviceMetrics{
+	itemsFetched: newCounterVec("news_feed_items_fetched_total",
+		"Элементы в скачанных лентах", "source"),
+	itemsInserted: newCounterVec("news_feed_items_inserted_total",
+		"Сохранённые новые новости", "source"),
+	parseErrors: newCounterVec("news_feed_parse_errors_total",
+		"Скачанные ленты, которые не удалось разобрать", "source"),
+	fetchDuration: newHistogramVec("news_feed_fetch_duration_seconds",
+		"Длительность одной попытки загрузки ленты", fetchBuckets, "source"),
+	httpDuration: newHistogramVec("news_http_request_duration_seconds",
+		"Длительность обработки HTTP-запросов", httpBuckets, "route", "method", "code"),
+	dbDuration: newHistogramVec("news_db_query_duration_seconds",
+		"Длительность запросов к БД", dbBuckets, "operation"),
+}
+
+// counterVec счётчик с набором меток
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = labelValues
+	}
+	c.values[key] += v
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range sortedKeys(c.series) {
+		fmt.Fprintf(b, "%s{%s} %s\n", c.name, labelPairs(c.labels, c.series[k]), formatFloat(c.values[k]))
+	}
+}
+
+// histogramVec гистограмма с набором меток и фиксированными границами корзин
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // по корзинам, не накопительно
+	sum         float64
+	count       uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		pairs := labelPairs(h.labels, s.labelValues)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, pairs, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, pairs, s.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n%s_count{%s} %d\n", h.name, pairs, formatFloat(s.sum), h.name, pairs, s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelPairs(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// metricsMiddleware учитывает длительность запросов по шаблону маршрута
+func metricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.httpDuration.observe(time.Since(start).Seconds(), route, r.Method, strconv.Itoa(rw.statusCode))
+	})
+}
+
+// metricsHandler GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var b strings.Builder
+	for _, c := range []*counterVec{metrics.itemsFetched, metrics.itemsInserted, metrics.parseErrors} {
+		c.write(&b)
+	}
+	for _, h := range []*histogramVec{metrics.fetchDuration, metrics.httpDuration, metrics.dbDuration} {
+		h.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// queryOperation метка operation: первое слово запроса
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch word := strings.ToLower(fields[0]); word {
+	case "select", "insert", "update", "delete", "with":
+		return word
+	}
+	return "other"
+}
+
+// metricsConnector оборачивает соединения драйвера, замеряя запросы
+type metricsConnector struct {
+	driver.Connector
+}
+
+// instrumentedConn возможности соединения pq, которые использует database/sql
+type instrumentedConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+	driver.NamedValueChecker
+}
+
+func (c metricsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ic, ok := conn.(instrumentedConn); ok {
+		return metricsConn{ic}, nil
+	}
+	return conn, nil
+}
+
+type metricsConn struct {
+	instrumentedConn
+}
+
+func (c metricsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.instrumentedConn.QueryContext(ctx, query, args)
+	metrics.dbDuration.observe(time.Since(start).Seconds(), queryOperation(query))
+	return rows, err
+}
+
+func (c metricsConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	res, err := c.instrumentedConn.ExecContext(ctx, query, args)
+	metrics.dbDuration.observe(time.Since(start).Seconds(), queryOperation(query))
+	return res, err
+}
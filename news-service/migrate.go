@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Миграции схемы. Файлы migrations/NNNN_описание.sql встраиваются в
+// бинарник и при запуске применяются по возрастанию номера, каждый в
+// своей транзакции; применённые версии записываются в
+// schema_migrations. Несколько реплик не мигрируют одновременно:
+// на время миграции берётся advisory-блокировка. Применённый файл не
+// меняется — изменение схемы оформляется новым файлом со следующим
+// номером. Данные, которые считает сам сервис, миграция заполняет шагом
+// на Go из migrationSteps в той же транзакции. Запуск с флагом
+// --migrate-only только применяет миграции и завершается (для CI и
+// init-контейнеров).
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID ключ pg_advisory_lock миграций news-service
+const migrationLockID = 8082
+
+// migrationSteps шаги на Go, выполняемые после SQL миграции с тем же
+// номером
+var migrationSteps = map[int]func(context.Context, *sql.Tx) error{
+	10: backfillLinkKeys,
+}
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations встроенные миграции по возрастанию версии
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		name := e.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("имя миграции %s должно начинаться с номера: 0001_описание.sql", name)
+		}
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("миграции %s и %s имеют один номер", prev, name)
+		}
+		seen[version] = name
+		body, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrate применяет недостающие миграции
+func migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// advisory-блокировка принадлежит сессии, поэтому все запросы идут
+	// через одно подключение
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("блокировка миграций: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return err
+	}
+	applied := map[int]bool{}
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return fmt.Errorf("миграция %s: %w", m.name, err)
+		}
+		log.Printf("Применена миграция %s", m.name)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// построение индексов на большой таблице дольше statement_timeout пула
+	if _, err := tx.ExecContext(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	if step := migrationSteps[m.version]; step != nil {
+		if err := step(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тестам миграций и поиска нужна пустая база PostgreSQL, которую можно
+// пересоздавать: MIGRATION_TEST_DSN=postgres://.../news_migrations_test.
+// Схема public этой базы удаляется перед каждым тестом. Без неё тесты
+// пропускаются, а в CI падают, чтобы не пройти незаметно.
+
+// baselineNewsSchema схема init_news_db.sql до введения миграций
+const baselineNewsSchema = `
+CREATE TABLE IF NOT EXISTS news (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(500) NOT NULL,
+    content TEXT,
+    description TEXT,
+    link VARCHAR(1000) UNIQUE,
+    pub_date TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_news_pub_date ON news(pub_date DESC);
+CREATE INDEX IF NOT EXISTS idx_news_title ON news USING gin(to_tsvector('russian', title));
+CREATE INDEX IF NOT EXISTS idx_news_content ON news USING gin(to_tsvector('russian', content));
+`
+
+// migrationTestDB подключается к тестовой базе и очищает её
+func migrationTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("MIGRATION_TEST_DSN")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("MIGRATION_TEST_DSN не задан, а в CI тесты с PostgreSQL обязательны")
+		}
+		t.Skip("MIGRATION_TEST_DSN не задан: тест с PostgreSQL пропущен")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = conn
+	t.Cleanup(func() {
+		conn.Close()
+		db = prev
+	})
+}
+
+// checkMigratedSchema проверяет, что сервис может читать и архивировать
+// новости на мигрированной базе
+func checkMigratedSchema(t *testing.T, ctx context.Context) {
+	t.Helper()
+	n, err := scanNews(db.QueryRowContext(ctx, `SELECT `+newsColumns+` FROM news ORDER BY id LIMIT 1`))
+	if err != nil {
+		t.Fatalf("чтение новости после миграций: %v", err)
+	}
+	if n.Title != "Старая новость" || n.Revision != 1 {
+		t.Fatalf("новость после миграций: %+v", n)
+	}
+	news, err := tableColumns(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := tableColumns(ctx, "news_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sortedCopy(news), sortedCopy(archive)) {
+		t.Fatalf("колонки news и news_archive различаются:\n%v\n%v", news, archive)
+	}
+	if listed := strings.Split(archivedNewsColumns, ", "); !reflect.DeepEqual(sortedCopy(news), sortedCopy(listed)) {
+		t.Fatalf("archivedNewsColumns не совпадает с колонками news:\n%v\n%v", listed, news)
+	}
+	// перенос в архив на базе, где порядок колонок мог измениться
+	if _, err := db.ExecContext(ctx, `UPDATE news SET pub_date = NOW() - INTERVAL '400 days'`); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := retainBatch(ctx, retentionArchive, time.Now().AddDate(0, 0, -365))
+	if err != nil || moved != 1 {
+		t.Fatalf("перенос в архив: %d, %v", moved, err)
+	}
+	var title string
+	if err := db.QueryRowContext(ctx, `SELECT title FROM news_archive`).Scan(&title); err != nil || title != "Старая новость" {
+		t.Fatalf("новость в архиве: %q, %v", title, err)
+	}
+}
+
+func TestMigrationsFromBaselineSchema(t *testing.T) {
+	migrationTestDB(t)
+	ctx := context.Background()
+
+	if _, err := db.Exec(baselineNewsSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO news (title, content, description, link, pub_date)
+		VALUES ('Старая новость', '<p>текст</p>', 'анонс', 'https://example.com/1', NOW())`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx); err != nil {
+		t.Fatalf("миграции на базе init_news_db.sql: %v", err)
+	}
+	var key string
+	if err := db.QueryRow(`SELECT link_key FROM news`).Scan(&key); err != nil || key != "example.com/1" {
+		t.Fatalf("link_key после миграций: %q, %v", key, err)
+	}
+	// повторный запуск ничего не применяет
+	if err := migrate(ctx); err != nil {
+		t.Fatalf("повторный запуск миграций: %v", err)
+	}
+	checkMigratedSchema(t, ctx)
+}
+
+func TestMigrationsFromEmptyDatabase(t *testing.T) {
+	migrationTestDB(t)
+	ctx := context.Background()
+
+	if err := migrate(ctx); err != nil {
+		t.Fatalf("миграции на пустой базе: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO news (title, content, description, link, pub_date)
+		VALUES ('Старая новость', '<p>текст</p>', 'анонс', 'https://example.com/1', NOW())`); err != nil {
+		t.Fatal(err)
+	}
+	checkMigratedSchema(t, ctx)
+}
+
+// tableColumns колонки таблицы в порядке их номеров
+func tableColumns(ctx context.Context, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+func sortedCopy(s []string) []string {
+	out := append([]string(nil), s...)
+	sort.Strings(out)
+	return out
+}
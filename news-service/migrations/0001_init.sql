@@ -10,4 +10,4 @@ CREATE TABLE IF NOT EXISTS news (
 
 CREATE INDEX IF NOT EXISTS idx_news_pub_date ON news(pub_date DESC);
 CREATE INDEX IF NOT EXISTS idx_news_title ON news USING gin(to_tsvector('russian', title));
-CREATE INDEX IF NOT EXISTS idx_news_content ON news USING gin(to_tsvector('russian', content));
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_news_content ON news USING gin(to_tsvector('russian', content));
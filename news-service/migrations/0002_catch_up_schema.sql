@@ -0,0 +1,185 @@
+-- Схема до введения миграций. 0001 — исходная таблица news из
+-- init_news_db.sql; базы, созданные им, получают здесь все колонки,
+-- индексы и таблицы, появившиеся позже. Всё идемпотентно: на базе,
+-- где они уже есть, ничего не меняется.
+ALTER TABLE news
+    ADD COLUMN IF NOT EXISTS available_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS geo_restriction TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS author VARCHAR(255) NOT NULL DEFAULT '',
+    -- simhash содержимого для поиска перепечаток
+    ADD COLUMN IF NOT EXISTS content_simhash BIGINT,
+    -- исходная ссылка из ленты; link — конечный URL после редиректов
+    ADD COLUMN IF NOT EXISTS source_link VARCHAR(1000),
+    -- источник новости: id из config.json (по умолчанию хост ленты) и его название
+    ADD COLUMN IF NOT EXISTS source_id VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS source_title VARCHAR(500) NOT NULL DEFAULT '',
+    -- иллюстрация для превью; пустая — картинки в элементе ленты нет
+    ADD COLUMN IF NOT EXISTS image_url VARCHAR(1000) NOT NULL DEFAULT '',
+    -- содержимое без разметки; content и description хранятся очищенными
+    ADD COLUMN IF NOT EXISTS content_text TEXT NOT NULL DEFAULT '',
+    -- версия правил очистки HTML; строки прежних версий переочищает POST /admin/sanitize
+    ADD COLUMN IF NOT EXISTS sanitizer_version INTEGER NOT NULL DEFAULT 0,
+    -- content заменён текстом, извлечённым со страницы статьи
+    ADD COLUMN IF NOT EXISTS content_extracted BOOLEAN NOT NULL DEFAULT FALSE,
+    -- нормализованная ссылка и simhash заголовка для поиска дублей
+    ADD COLUMN IF NOT EXISTS link_key VARCHAR(1000),
+    ADD COLUMN IF NOT EXISTS title_simhash BIGINT,
+    -- та же история из другой ленты: оригинал и причина (link или title)
+    ADD COLUMN IF NOT EXISTS duplicate_of INTEGER REFERENCES news(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS duplicate_reason VARCHAR(20) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_news_available_at ON news(available_at);
+CREATE INDEX IF NOT EXISTS idx_news_author ON news(LOWER(author));
+CREATE INDEX IF NOT EXISTS idx_news_source_link ON news(source_link);
+CREATE INDEX IF NOT EXISTS idx_news_source_id ON news(source_id, pub_date DESC);
+CREATE INDEX IF NOT EXISTS idx_news_link_key ON news(link_key);
+CREATE INDEX IF NOT EXISTS idx_news_duplicate_of ON news(duplicate_of) WHERE duplicate_of IS NOT NULL;
+
+-- Рубрики новостей из <category> (dc:subject, tags) элементов лент
+CREATE TABLE IF NOT EXISTS news_tags (
+    news_id INTEGER NOT NULL REFERENCES news(id) ON DELETE CASCADE,
+    tag VARCHAR(255) NOT NULL,
+    PRIMARY KEY (news_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_news_tags_tag ON news_tags(LOWER(tag));
+-- Главные новости, закреплённые редакцией; starts_at/ends_at — расписание показа
+CREATE TABLE IF NOT EXISTS top_stories (
+    id SERIAL PRIMARY KEY,
+    news_id INTEGER NOT NULL UNIQUE REFERENCES news(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Источники лент; при первом запуске заполняются списком rss из config.json
+CREATE TABLE IF NOT EXISTS sources (
+    id SERIAL PRIMARY KEY,
+    url VARCHAR(1000) NOT NULL UNIQUE,
+    -- source_id новостей источника; пустой — имя хоста ленты
+    source_key VARCHAR(255) NOT NULL DEFAULT '',
+    title VARCHAR(500) NOT NULL DEFAULT '',
+    embargo_minutes INTEGER NOT NULL DEFAULT 0,
+    geo_restriction TEXT NOT NULL DEFAULT '',
+    priority VARCHAR(20) NOT NULL DEFAULT '',
+    bypass_embargo BOOLEAN NOT NULL DEFAULT FALSE,
+    -- интервал загрузки; 0 — период приоритета
+    fetch_interval_sec INTEGER NOT NULL DEFAULT 0,
+    -- суточный лимит скачанных байт; 0 — без лимита
+    daily_budget_bytes BIGINT NOT NULL DEFAULT 0,
+    -- скачивать полный текст новостей со страниц статей
+    extract_full_content BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- Контрольные точки загрузки RSS-лент
+CREATE TABLE IF NOT EXISTS feed_checkpoints (
+    feed_url VARCHAR(1000) PRIMARY KEY,
+    last_success_at TIMESTAMP,
+    last_item_guid VARCHAR(1000) NOT NULL DEFAULT '',
+    last_item_pub_date TIMESTAMP,
+    -- число элементов в последней загрузке и счётчик загрузок без элементов
+    last_item_count INTEGER NOT NULL DEFAULT 0,
+    empty_fetches INTEGER NOT NULL DEFAULT 0,
+    -- последняя неудачная загрузка и число неудач подряд
+    last_error TEXT NOT NULL DEFAULT '',
+    last_error_at TIMESTAMP,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    -- новостей добавлено последней успешной загрузкой и всего
+    last_items_added INTEGER NOT NULL DEFAULT 0,
+    total_items_added BIGINT NOT NULL DEFAULT 0,
+    last_duration_ms INTEGER NOT NULL DEFAULT 0,
+    -- валидаторы последнего ответа 200 для условных запросов
+    etag VARCHAR(1000) NOT NULL DEFAULT '',
+    last_modified VARCHAR(100) NOT NULL DEFAULT '',
+    not_modified_fetches INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Суточный трафик лент: скачанные байты, время и число загрузок
+CREATE TABLE IF NOT EXISTS feed_bandwidth (
+    feed_url VARCHAR(1000) NOT NULL,
+    day DATE NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    fetch_ms BIGINT NOT NULL DEFAULT 0,
+    fetches INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (feed_url, day)
+);
+
+-- Зеркала изданий: alias (домен или *.домен) сводится к canonical в link_key
+CREATE TABLE IF NOT EXISTS domain_aliases (
+    alias VARCHAR(255) PRIMARY KEY,
+    canonical VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Архив новостей старше retention_days. Колонки те же и в том же
+-- порядке, что у news: миграция, добавляющая колонку в news, добавляет её и сюда
+CREATE TABLE IF NOT EXISTS news_archive (LIKE news INCLUDING DEFAULTS);
+
+CREATE INDEX IF NOT EXISTS idx_news_archive_id ON news_archive(id);
+CREATE INDEX IF NOT EXISTS idx_news_archive_pub_date ON news_archive(pub_date DESC);
+
+CREATE TABLE IF NOT EXISTS news_tags_archive (
+    news_id INTEGER NOT NULL,
+    tag VARCHAR(255) NOT NULL,
+    PRIMARY KEY (news_id, tag)
+);
+
+-- Вебхуки о новых новостях: адрес, секрет подписи, ключевые слова
+-- фильтра (пустой массив — все новости) и статистика доставки
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    url VARCHAR(1000) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    keywords TEXT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_delivery_at TIMESTAMP,
+    last_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0
+);
+
+-- Язык полнотекстового поиска. language источника — конфигурация
+-- PostgreSQL (russian, english, ...), пустой — определяется по тексту
+-- новости. search_vector строится триггером на языке новости.
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS language VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE news
+    ADD COLUMN IF NOT EXISTS search_language VARCHAR(32) NOT NULL DEFAULT 'russian',
+    ADD COLUMN IF NOT EXISTS search_vector tsvector;
+-- news_archive повторяет колонки news в том же порядке
+ALTER TABLE news_archive
+    ADD COLUMN IF NOT EXISTS search_language VARCHAR(32) NOT NULL DEFAULT 'russian',
+    ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION news_search_vector() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_language::regconfig, COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_language::regconfig, COALESCE(NEW.content_text, '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS news_search_vector ON news;
+CREATE TRIGGER news_search_vector
+    BEFORE INSERT OR UPDATE OF title, content_text, search_language ON news
+    FOR EACH ROW EXECUTE FUNCTION news_search_vector();
+
+-- уже сохранённые новости: кириллица в заголовке — russian, иначе english
+UPDATE news SET search_language = CASE WHEN title ~ '[А-Яа-яЁё]' THEN 'russian' ELSE 'english' END;
+UPDATE news_archive SET search_language = CASE WHEN title ~ '[А-Яа-яЁё]' THEN 'russian' ELSE 'english' END;
+UPDATE news_archive SET search_vector =
+    setweight(to_tsvector(search_language::regconfig, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector(search_language::regconfig, COALESCE(content_text, '')), 'B');
+
+CREATE INDEX IF NOT EXISTS idx_news_search_vector ON news USING gin(search_vector);
+DROP INDEX IF EXISTS idx_news_title;
+DROP INDEX IF EXISTS idx_news_content;
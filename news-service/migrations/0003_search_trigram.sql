@@ -0,0 +1,4 @@
+-- Запасной поиск по подстроке (ILIKE) для запросов без лексем и
+-- ранжирование его результатов по similarity
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_news_title_trgm ON news USING gin(title gin_trgm_ops);
@@ -0,0 +1,10 @@
+-- Просмотры новостей по часам: счётчик копится в памяти сервиса и
+-- периодически прибавляется к строке часа
+CREATE TABLE IF NOT EXISTS news_views (
+    news_id INTEGER NOT NULL REFERENCES news(id) ON DELETE CASCADE,
+    bucket TIMESTAMPTZ NOT NULL,
+    views INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (news_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_news_views_bucket ON news_views(bucket);
@@ -0,0 +1,22 @@
+-- Язык новости (ISO 639-1: ru, en, ...), пустой — не определён.
+-- Определяется при сохранении: по language источника, а если он не
+-- задан — по тексту новости.
+ALTER TABLE news ADD COLUMN IF NOT EXISTS language VARCHAR(8) NOT NULL DEFAULT '';
+-- news_archive повторяет колонки news в том же порядке
+ALTER TABLE news_archive ADD COLUMN IF NOT EXISTS language VARCHAR(8) NOT NULL DEFAULT '';
+
+-- уже сохранённые новости: по языку поиска, с которым они проиндексированы
+UPDATE news SET language = CASE search_language
+    WHEN 'russian' THEN 'ru' WHEN 'english' THEN 'en' WHEN 'arabic' THEN 'ar' WHEN 'danish' THEN 'da'
+    WHEN 'dutch' THEN 'nl' WHEN 'finnish' THEN 'fi' WHEN 'french' THEN 'fr' WHEN 'german' THEN 'de'
+    WHEN 'hungarian' THEN 'hu' WHEN 'italian' THEN 'it' WHEN 'norwegian' THEN 'no' WHEN 'portuguese' THEN 'pt'
+    WHEN 'romanian' THEN 'ro' WHEN 'spanish' THEN 'es' WHEN 'swedish' THEN 'sv' WHEN 'turkish' THEN 'tr'
+    ELSE '' END;
+UPDATE news_archive SET language = CASE search_language
+    WHEN 'russian' THEN 'ru' WHEN 'english' THEN 'en' WHEN 'arabic' THEN 'ar' WHEN 'danish' THEN 'da'
+    WHEN 'dutch' THEN 'nl' WHEN 'finnish' THEN 'fi' WHEN 'french' THEN 'fr' WHEN 'german' THEN 'de'
+    WHEN 'hungarian' THEN 'hu' WHEN 'italian' THEN 'it' WHEN 'norwegian' THEN 'no' WHEN 'portuguese' THEN 'pt'
+    WHEN 'romanian' THEN 'ro' WHEN 'spanish' THEN 'es' WHEN 'swedish' THEN 'sv' WHEN 'turkish' THEN 'tr'
+    ELSE '' END;
+
+CREATE INDEX IF NOT EXISTS idx_news_source_language ON news(source_id, language);
@@ -0,0 +1,3 @@
+-- Индексы для sort_by=created_at и sort_by=title/title_desc в /news/filter
+CREATE INDEX IF NOT EXISTS idx_news_created_at ON news(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_news_title_id ON news(title, id);
@@ -0,0 +1,3 @@
+-- Зашифрованный доступ к закрытым лентам (Basic-аутентификация и
+-- заголовки); пустой — лента открытая
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS credentials TEXT NOT NULL DEFAULT '';
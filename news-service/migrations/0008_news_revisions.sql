@@ -0,0 +1,12 @@
+-- Правки новостей после публикации: content_hash — хеш заголовка и
+-- текста элемента ленты, по которому замечаются изменения; revision
+-- растёт с каждой правкой, updated_at — время последней (NULL — новость
+-- не менялась). Хеш уже сохранённых новостей заполняется при следующей
+-- встрече элемента в ленте, без правки.
+ALTER TABLE news ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE news ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE news ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+-- news_archive повторяет колонки news в том же порядке
+ALTER TABLE news_archive ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE news_archive ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE news_archive ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
@@ -0,0 +1,12 @@
+-- Однократные действия при запуске: имя выполненного действия. Метка
+-- sources_seeded — источники из config.json уже перенесены в sources,
+-- и пустая таблица (все источники удалены через /admin/sources) больше
+-- не заполняется заново. Базы, где источники уже есть, получают метку
+-- сразу.
+CREATE TABLE IF NOT EXISTS service_markers (
+    name VARCHAR(100) PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO service_markers (name)
+SELECT 'sources_seeded' WHERE EXISTS (SELECT 1 FROM sources)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,7 @@
+-- link_key для всех новостей. Параметры ref, from и rss больше не
+-- считаются трекинговыми, и ключи ссылок с ними пересчитываются.
+-- Ключи считает сервис (linkKey с учётом зеркал доменов): после этого
+-- файла миграция заполняет link_key всех новостей, где он NULL, —
+-- backfillLinkKeys в dedup.go.
+UPDATE news SET link_key = NULL
+WHERE link_key IS NOT NULL AND link ~* '[?&](ref|from|rss)(=|&|$)';
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Зеркала изданий. Одна статья бывает доступна под разными доменами:
+// мобильная версия (m.example.com), AMP-страница, зеркало для другого
+// региона. Для ключа дедупликации link_key ссылка приводится к
+// основному адресу:
+//   - AMP-версии сводятся всегда: адреса кэша cdn.ampproject.org,
+//     поддомен amp., суффиксы /amp и .amp в пути, префикс /amp/,
+//     параметры amp и outputType=amp;
+//   - домены-зеркала заменяются основным по таблице domain_aliases,
+//     которая правится через /admin/domain-aliases. Правило "*.example.com"
+//     сводит к основному домену все поддомены example.com.
+// После изменения таблицы ключи уже сохранённых новостей с этим доменом
+// пересчитываются в фоне. Сама ссылка link не меняется.
+
+// DomainAlias правило эквивалентности доменов
+type DomainAlias struct {
+	Alias     string    `json:"alias"`
+	Canonical string    `json:"canonical"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type domainAliases struct {
+	mu       sync.RWMutex
+	exact    map[string]string
+	wildcard map[string]string // суффикс без "*." → основной домен
+}
+
+var mirrors = &domainAliases{exact: map[string]string{}, wildcard: map[string]string{}}
+
+var aliasPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9-]+\.)+[a-z0-9-]+$`)
+
+// normalizeDomain домен в нижнем регистре без www. и точки в конце
+func normalizeDomain(d string) string {
+	d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+	if strings.HasPrefix(d, "*.") {
+		return "*." + strings.TrimPrefix(d[2:], "www.")
+	}
+	return strings.TrimPrefix(d, "www.")
+}
+
+// load перечитывает таблицу domain_aliases
+func (m *domainAliases) load(ctx context.Context) error {
+	return m.loadFrom(ctx, db)
+}
+
+// loadFrom перечитывает правила через q — пул или транзакцию миграции
+func (m *domainAliases) loadFrom(ctx context.Context, q querier) error {
+	aliases, err := queryDomainAliases(ctx, q)
+	if err != nil {
+		return err
+	}
+	exact, wildcard := map[string]string{}, map[string]string{}
+	for _, a := range aliases {
+		if strings.HasPrefix(a.Alias, "*.") {
+			wildcard[a.Alias[2:]] = a.Canonical
+		} else {
+			exact[a.Alias] = a.Canonical
+		}
+	}
+	m.mu.Lock()
+	m.exact, m.wildcard = exact, wildcard
+	m.mu.Unlock()
+	return nil
+}
+
+// canonical основной домен для host (без www.); сам домен, если правила нет
+func (m *domainAliases) canonical(host string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if c, ok := m.exact[host]; ok {
+		return c
+	}
+	// самое длинное совпадение суффикса: правило для a.b.example.com
+	// важнее правила для example.com
+	for d := host; ; {
+		if c, ok := m.wildcard[d]; ok && d != host {
+			return c
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			return host
+		}
+		d = d[i+1:]
+	}
+}
+
+// foldAMP адрес основной страницы для AMP-версии
+func foldAMP(u *url.URL) *url.URL {
+	c := *u
+	host := strings.ToLower(c.Hostname())
+
+	// https://example-com.cdn.ampproject.org/c/s/example.com/news/1
+	if strings.HasSuffix(host, ".cdn.ampproject.org") {
+		for _, prefix := range []string{"/c/s/", "/v/s/", "/c/", "/v/"} {
+			if rest := strings.TrimPrefix(c.Path, prefix); rest != c.Path {
+				if inner, err := url.Parse("https://" + rest); err == nil && inner.Host != "" {
+					inner.RawQuery = c.RawQuery
+					c = *inner
+					host = strings.ToLower(c.Hostname())
+				}
+				break
+			}
+		}
+	}
+	if strings.HasPrefix(host, "amp.") {
+		c.Host = strings.TrimPrefix(host, "amp.")
+	}
+
+	path := strings.TrimRight(c.Path, "/")
+	switch {
+	case strings.HasSuffix(path, "/amp"):
+		path = strings.TrimSuffix(path, "/amp")
+	case strings.HasSuffix(path, ".amp"):
+		path = strings.TrimSuffix(path, ".amp")
+	case strings.HasSuffix(path, ".amp.html"):
+		path = strings.TrimSuffix(path, ".amp.html") + ".html"
+	case strings.HasPrefix(path, "/amp/"):
+		path = strings.TrimPrefix(path, "/amp")
+	}
+	if path != strings.TrimRight(c.Path, "/") {
+		c.Path, c.RawPath = path, ""
+	}
+
+	q := c.Query()
+	if _, ok := q["amp"]; ok || strings.EqualFold(q.Get("outputType"), "amp") {
+		q.Del("amp")
+		q.Del("outputType")
+		c.RawQuery = q.Encode()
+	}
+	return &c
+}
+
+// querier *sql.DB или *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func loadDomainAliases(ctx context.Context) ([]DomainAlias, error) {
+	return queryDomainAliases(ctx, db)
+}
+
+func queryDomainAliases(ctx context.Context, q querier) ([]DomainAlias, error) {
+	rows, err := q.QueryContext(ctx, `SELECT alias, canonical, created_at FROM domain_aliases ORDER BY alias`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	aliases := []DomainAlias{}
+	for rows.Next() {
+		var a DomainAlias
+		if err := rows.Scan(&a.Alias, &a.Canonical, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// rekeyDomain пересчитывает link_key новостей, в ссылке которых есть
+// домен. Новости, уже сохранённые отдельно, дублями не помечаются
+func rekeyDomain(ctx context.Context, domain string) {
+	domain = strings.TrimPrefix(domain, "*.")
+	rows, err := db.QueryContext(ctx, `SELECT id, link, COALESCE(link_key, '') FROM news WHERE link ILIKE '%' || $1 || '%'`, domain)
+	if err != nil {
+		log.Printf("Ошибка выборки новостей домена %s: %v", domain, err)
+		return
+	}
+	type pending struct {
+		id  int
+		key string
+	}
+	var changed []pending
+	for rows.Next() {
+		var id int
+		var link, key string
+		if err := rows.Scan(&id, &link, &key); err != nil {
+			rows.Close()
+			log.Printf("Ошибка чтения новости домена %s: %v", domain, err)
+			return
+		}
+		if k := linkKey(link); k != key {
+			changed = append(changed, pending{id, k})
+		}
+	}
+	rows.Close()
+
+	for _, p := range changed {
+		if _, err := db.ExecContext(ctx, "UPDATE news SET link_key = $1 WHERE id = $2", p.key, p.id); err != nil {
+			log.Printf("Ошибка сохранения ключа новости %d: %v", p.id, err)
+			return
+		}
+	}
+	if len(changed) > 0 {
+		log.Printf("Пересчитаны ключи ссылок домена %s: %d новостей", domain, len(changed))
+	}
+}
+
+// reloadMirrors перечитывает правила и пересчитывает ключи домена
+func reloadMirrors(ctx context.Context, domain string) {
+	if err := mirrors.load(ctx); err != nil {
+		log.Printf("Ошибка загрузки зеркал доменов: %v", err)
+		return
+	}
+	go rekeyDomain(serviceCtx, domain)
+}
+
+// domainAliasesHandler GET /admin/domain-aliases — правила зеркал;
+// POST {"alias": "m.example.com", "canonical": "example.com"} — добавить
+// или заменить правило
+func domainAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		aliases, err := loadDomainAliases(ctx)
+		if err != nil {
+			log.Printf("Ошибка получения зеркал доменов: %v", err)
+			http.Error(w, "Failed to get domain aliases", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(aliases)
+
+	case http.MethodPost:
+		var req DomainAlias
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		alias, canonical := normalizeDomain(req.Alias), normalizeDomain(req.Canonical)
+		if !aliasPattern.MatchString(alias) || !aliasPattern.MatchString(canonical) || strings.HasPrefix(canonical, "*.") {
+			http.Error(w, "alias and canonical must be domain names (alias may start with *.)", http.StatusBadRequest)
+			return
+		}
+		if alias == canonical {
+			http.Error(w, "alias must differ from canonical", http.StatusBadRequest)
+			return
+		}
+		var a DomainAlias
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO domain_aliases (alias, canonical) VALUES ($1, $2)
+			ON CONFLICT (alias) DO UPDATE SET canonical = EXCLUDED.canonical
+			RETURNING alias, canonical, created_at
+		`, alias, canonical).Scan(&a.Alias, &a.Canonical, &a.CreatedAt)
+		if err != nil {
+			log.Printf("Ошибка сохранения зеркала %s: %v", alias, err)
+			http.Error(w, "Failed to save domain alias", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Зеркало %s → %s, request_id: %s", alias, canonical, requestID)
+		reloadMirrors(ctx, alias)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// domainAliasHandler DELETE /admin/domain-aliases/{alias}
+func domainAliasHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	alias := normalizeDomain(strings.TrimPrefix(r.URL.Path, "/admin/domain-aliases/"))
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+	var canonical string
+	err := db.QueryRowContext(ctx, `DELETE FROM domain_aliases WHERE alias = $1 RETURNING canonical`, alias).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Domain alias not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка удаления зеркала %s: %v", alias, err)
+		http.Error(w, "Failed to delete domain alias", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Зеркало %s удалено, request_id: %s", alias, requestID)
+	reloadMirrors(ctx, alias)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Импорт и экспорт источников в OPML — формате подписок RSS-читалок.
+// GET /admin/sources/opml отдаёт включённые источники (include_disabled=true
+// — все) как OPML 2.0. POST /admin/sources/opml принимает OPML-файл и
+// добавляет ленты из всех outline с xmlUrl, в том числе вложенных в
+// папки; название берётся из title или text. Лента пропускается, если
+// источник с тем же адресом уже есть или она повторяется в файле (адреса
+// сравниваются без учёта регистра схемы и хоста и завершающего /).
+// С dry_run=true ничего не сохраняется, а в ответе видно, что было бы
+// добавлено.
+
+const maxOPMLBytes = 5 << 20
+
+// Итог импорта ленты
+const (
+	opmlCreated   = "created"
+	opmlWouldAdd  = "would_create"
+	opmlExists    = "exists"
+	opmlDuplicate = "duplicate"
+	opmlInvalid   = "invalid"
+)
+
+type opmlDocument struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Head    opmlHead    `xml:"head"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlEntry struct {
+	Type     string      `xml:"type,attr,omitempty"`
+	Text     string      `xml:"text,attr"`
+	Title    string      `xml:"title,attr,omitempty"`
+	XMLURL   string      `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string      `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlEntry `xml:"outline"`
+}
+
+// OPMLImportItem итог импорта одной ленты
+type OPMLImportItem struct {
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	Status string `json:"status"`
+	// SourceID ID созданного или уже существующего источника
+	SourceID int    `json:"source_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// OPMLImportResult ответ импорта
+type OPMLImportResult struct {
+	DryRun  bool             `json:"dry_run"`
+	Summary map[string]int   `json:"summary"`
+	Items   []OPMLImportItem `json:"items"`
+}
+
+// adminOPMLHandler /admin/sources/opml: GET — экспорт, POST — импорт
+func adminOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		records, err := loadSources(ctx, r.URL.Query().Get("include_disabled") != "true")
+		if err != nil {
+			log.Printf("Ошибка получения источников: %v", err)
+			http.Error(w, "Failed to get sources", http.StatusInternalServerError)
+			return
+		}
+		body, err := xml.MarshalIndent(buildOPML(records), "", "  ")
+		if err != nil {
+			http.Error(w, "Failed to build OPML", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="sources.opml"`)
+		w.Write([]byte(xml.Header))
+		w.Write(body)
+	case http.MethodPost:
+		var doc opmlDocument
+		if err := xml.NewDecoder(io.LimitReader(r.Body, maxOPMLBytes)).Decode(&doc); err != nil {
+			http.Error(w, "Invalid OPML", http.StatusBadRequest)
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		result, err := importOPML(ctx, doc, dryRun)
+		if err != nil {
+			log.Printf("Ошибка импорта OPML: %v", err)
+			http.Error(w, "Failed to import OPML", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Импорт OPML (dry_run=%v): %v, request_id: %s", dryRun, result.Summary, requestID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func buildOPML(records []SourceRecord) opmlDocument {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head:    opmlHead{Title: outFeedTitle, DateCreated: time.Now().UTC().Format(time.RFC1123Z)},
+		Body:    []opmlEntry{},
+	}
+	for _, rec := range records {
+		title := rec.Title
+		if title == "" {
+			title = rec.feed().sourceID()
+		}
+		doc.Body = append(doc.Body, opmlEntry{Type: "rss", Text: title, Title: title, XMLURL: rec.URL})
+	}
+	return doc
+}
+
+// opmlFeeds ленты документа в порядке следования, включая вложенные
+func opmlFeeds(entries []opmlEntry) []opmlEntry {
+	var feeds []opmlEntry
+	for _, e := range entries {
+		if strings.TrimSpace(e.XMLURL) != "" {
+			feeds = append(feeds, e)
+		}
+		feeds = append(feeds, opmlFeeds(e.Outlines)...)
+	}
+	return feeds
+}
+
+// sourceURLKey адрес ленты для сравнения при импорте
+func sourceURLKey(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+func importOPML(ctx context.Context, doc opmlDocument, dryRun bool) (OPMLImportResult, error) {
+	result := OPMLImportResult{DryRun: dryRun, Summary: make(map[string]int), Items: []OPMLImportItem{}}
+	existing, err := loadSources(ctx, false)
+	if err != nil {
+		return result, err
+	}
+	known := make(map[string]int, len(existing))
+	for _, rec := range existing {
+		known[sourceURLKey(rec.URL)] = rec.ID
+	}
+	seen := make(map[string]bool)
+
+	for _, e := range opmlFeeds(doc.Body) {
+		title := strings.TrimSpace(e.Title)
+		if title == "" {
+			title = strings.TrimSpace(e.Text)
+		}
+		item := OPMLImportItem{URL: strings.TrimSpace(e.XMLURL), Title: title}
+		key := sourceURLKey(item.URL)
+		rec := SourceRecord{URL: item.URL, Title: title, Enabled: true}
+		switch {
+		case known[key] != 0:
+			item.Status, item.SourceID = opmlExists, known[key]
+		case seen[key]:
+			item.Status = opmlDuplicate
+		default:
+			if err := validateSource(rec.feed()); err != nil {
+				item.Status, item.Error = opmlInvalid, err.Error()
+				break
+			}
+			seen[key] = true
+			if dryRun {
+				item.Status = opmlWouldAdd
+				break
+			}
+			created, err := insertSource(ctx, rec)
+			if isUniqueViolation(err) {
+				item.Status = opmlExists
+				break
+			}
+			if err != nil {
+				return result, err
+			}
+			item.Status, item.SourceID = opmlCreated, created.ID
+		}
+		result.Summary[item.Status]++
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Исходящая лента агрегатора. GET /feed.rss (RSS 2.0) и GET /feed.atom
+// (Atom 1.0) отдают последние опубликованные новости без дублей — те же,
+// что /news/filter с сортировкой по дате. Параметры q, category, source и
+// author фильтруют ленту так же, как в /news/filter, limit задаёт число
+// элементов. Лента общая для всех читателей и кэшируется публично,
+// поэтому новости с geo_restriction в неё не попадают. Ссылка на саму
+// ленту строится от PUBLIC_BASE_URL, а без него — от Host запроса:
+// X-Forwarded-* присылает клиент, и им не верим. Заголовок ленты —
+// feed_title в config.json.
+
+const (
+	defaultOutFeedLimit = 50
+	maxOutFeedLimit     = 200
+	outFeedMaxAge       = 60 * time.Second
+	// outFeedSummaryRunes длина описания из текста, если у новости нет description
+	outFeedSummaryRunes = 500
+)
+
+var outFeedTitle = "Новости"
+
+var publicBaseURL = strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+
+type outRSS struct {
+	XMLName   xml.Name      `xml:"rss"`
+	Version   string        `xml:"version,attr"`
+	XMLNSAtom string        `xml:"xmlns:atom,attr"`
+	XMLNSDC   string        `xml:"xmlns:dc,attr"`
+	Channel   outRSSChannel `xml:"channel"`
+}
+
+type outRSSChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	SelfLink      outAtomLink  `xml:"atom:link"`
+	LastBuildDate string       `xml:"lastBuildDate"`
+	Items         []outRSSItem `xml:"item"`
+}
+
+type outRSSItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	GUID        outRSSGUID `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Description string     `xml:"description,omitempty"`
+	Creator     string     `xml:"dc:creator,omitempty"`
+	Categories  []string   `xml:"category"`
+}
+
+type outRSSGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type outAtomFeed struct {
+	XMLName xml.Name       `xml:"feed"`
+	XMLNS   string         `xml:"xmlns,attr"`
+	ID      string         `xml:"id"`
+	Title   string         `xml:"title"`
+	Updated string         `xml:"updated"`
+	Links   []outAtomLink  `xml:"link"`
+	Author  outAtomPerson  `xml:"author"`
+	Entries []outAtomEntry `xml:"entry"`
+}
+
+type outAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type outAtomPerson struct {
+	Name string `xml:"name"`
+}
+
+type outAtomEntry struct {
+	ID         string            `xml:"id"`
+	Title      string            `xml:"title"`
+	Link       outAtomLink       `xml:"link"`
+	Published  string            `xml:"published"`
+	Updated    string            `xml:"updated"`
+	Author     *outAtomPerson    `xml:"author,omitempty"`
+	Summary    *outAtomText      `xml:"summary,omitempty"`
+	Categories []outAtomCategory `xml:"category"`
+}
+
+type outAtomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type outAtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// outFeedHandler GET /feed.rss и /feed.atom
+func outFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	q := r.URL.Query()
+	limit := defaultOutFeedLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxOutFeedLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxOutFeedLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	// страна неизвестна: только новости без геоограничений
+	anyCountry := ""
+	news, _, err := filterNews(ctx, newsFilter{
+		Query:    q.Get("q"),
+		Author:   q.Get("author"),
+		Category: strings.TrimSpace(q.Get("category")),
+		Source:   strings.TrimSpace(q.Get("source")),
+		Order:    orderDateDesc,
+	}, paging{Page: 1, PerPage: limit, Country: &anyCountry})
+	var tags map[int][]string
+	if err == nil {
+		tags, err = newsTags(ctx, news)
+	}
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос ленты отменён, request_id: %s", requestID)
+		return
+	}
+	if isQueryTimeout(err) {
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка построения ленты: %v", err)
+		http.Error(w, "Failed to build feed", http.StatusInternalServerError)
+		return
+	}
+
+	self := outFeedBaseURL(r) + r.URL.RequestURI()
+	var doc interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	if r.URL.Path == "/feed.atom" {
+		doc = buildAtomFeed(news, tags, self)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		doc = buildRSSFeed(news, tags, self, outFeedBaseURL(r))
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf("Ошибка построения ленты: %v", err)
+		http.Error(w, "Failed to build feed", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Лента %s: %d новостей, request_id: %s", r.URL.Path, len(news), requestID)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(outFeedMaxAge/time.Second)))
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// outFeedBaseURL внешний адрес сервиса для ссылок ленты
+func outFeedBaseURL(r *http.Request) string {
+	if publicBaseURL != "" {
+		return publicBaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// newsTags рубрики новостей списка
+func newsTags(ctx context.Context, news []News) (map[int][]string, error) {
+	tags := make(map[int][]string)
+	if len(news) == 0 {
+		return tags, nil
+	}
+	ids := make([]int, len(news))
+	for i, n := range news {
+		ids[i] = n.ID
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT news_id, tag FROM news_tags WHERE news_id = ANY($1) ORDER BY news_id, tag
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// outFeedSummary описание новости для ленты: description (HTML) или
+// начало текста без разметки
+func outFeedSummary(n News) (summary string, isHTML bool) {
+	if n.Description != "" {
+		return n.Description, true
+	}
+	text := []rune(strings.TrimSpace(n.ContentText))
+	if len(text) > outFeedSummaryRunes {
+		return string(text[:outFeedSummaryRunes]) + "…", false
+	}
+	return string(text), false
+}
+
+// outFeedUpdated дата самой свежей новости, для пустой ленты — текущая
+func outFeedUpdated(news []News) time.Time {
+	if len(news) == 0 {
+		return time.Now().UTC()
+	}
+	return news[0].PubDate.UTC()
+}
+
+func buildRSSFeed(news []News, tags map[int][]string, self, site string) outRSS {
+	feed := outRSS{
+		Version:   "2.0",
+		XMLNSAtom: atomNamespace,
+		XMLNSDC:   "http://purl.org/dc/elements/1.1/",
+		Channel: outRSSChannel{
+			Title:         outFeedTitle,
+			Link:          site,
+			Description:   outFeedTitle,
+			SelfLink:      outAtomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: outFeedUpdated(news).Format(time.RFC1123Z),
+		},
+	}
+	for _, n := range news {
+		summary, _ := outFeedSummary(n)
+		feed.Channel.Items = append(feed.Channel.Items, outRSSItem{
+			Title:       n.Title,
+			Link:        n.Link,
+			GUID:        outRSSGUID{IsPermaLink: "true", Value: n.Link},
+			PubDate:     n.PubDate.UTC().Format(time.RFC1123Z),
+			Description: summary,
+			Creator:     n.Author,
+			Categories:  tags[n.ID],
+		})
+	}
+	return feed
+}
+
+func buildAtomFeed(news []News, tags map[int][]string, self string) outAtomFeed {
+	feed := outAtomFeed{
+		XMLNS:   atomNamespace,
+		ID:      self,
+		Title:   outFeedTitle,
+		Updated: outFeedUpdated(news).Format(time.RFC3339),
+		Links:   []outAtomLink{{Href: self, Rel: "self", Type: "application/atom+xml"}},
+		Author:  outAtomPerson{Name: outFeedTitle},
+	}
+	for _, n := range news {
+		entry := outAtomEntry{
+			ID:        n.Link,
+			Title:     n.Title,
+			Link:      outAtomLink{Href: n.Link, Rel: "alternate"},
+			Published: n.PubDate.UTC().Format(time.RFC3339),
+			Updated:   n.PubDate.UTC().Format(time.RFC3339),
+		}
+		if n.Author != "" {
+			entry.Author = &outAtomPerson{Name: n.Author}
+		}
+		if summary, isHTML := outFeedSummary(n); summary != "" {
+			entry.Summary = &outAtomText{Type: "text", Value: summary}
+			if isHTML {
+				entry.Summary.Type = "html"
+			}
+		}
+		for _, tag := range tags[n.ID] {
+			entry.Categories = append(entry.Categories, outAtomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
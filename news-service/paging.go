@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Параметры постраничной выдачи списков: page, per_page, as_of и cursor.
+// as_of фиксирует снимок: учитываются только новости, сохранённые и
+// ставшие доступными не позже этого момента, поэтому при листании
+// свежие поступления не сдвигают страницы.
+//
+// cursor — keyset-пагинация по (pub_date, id): следующая страница
+// выбирается условием «после последней новости предыдущей», без
+// OFFSET, поэтому глубокие страницы не замедляются, а новые новости не
+// приводят к повторам. Курсор выдаётся в next_cursor для сортировок по
+// дате; для sort_by=title остаётся только page. Курсор содержит
+// сортировку, для которой выдан, и подписан HMAC (ключ из SERVICE_TOKEN,
+// без него — случайный на время жизни процесса): подделанный курсор или
+// курсор другой сортировки отклоняется.
+
+// cursorKey ключ подписи курсоров
+var cursorKey = func() []byte {
+	if serviceToken != "" {
+		key := sha256.Sum256([]byte("news-cursor\x00" + serviceToken))
+		return key[:]
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// cursorSignatureSize байт HMAC в курсоре
+const cursorSignatureSize = 16
+
+// Размер страницы по умолчанию и верхняя граница per_page; задаются
+// в config.json (default_per_page, max_per_page)
+var (
+	defaultPerPage = 15
+	maxPerPage     = 100
+)
+
+// setPageSizes применяет настройки размера страницы из конфигурации
+func setPageSizes(def, max int) error {
+	if max > 0 {
+		maxPerPage = max
+	}
+	if def > 0 {
+		defaultPerPage = def
+	}
+	if defaultPerPage > maxPerPage {
+		return fmt.Errorf("default_per_page (%d) больше max_per_page (%d)", defaultPerPage, maxPerPage)
+	}
+	return nil
+}
+
+type paging struct {
+	Page    int
+	PerPage int
+	AsOf    *time.Time
+	// After позиция, после которой начинается страница (cursor)
+	After *newsCursor
+	// Fields поля новостей в ответе (fields); nil — все
+	Fields []string
+	// Country страна клиента (country), для которой отбираются новости с
+	// geo_restriction; пустая — страна неизвестна, nil — без отбора
+	Country *string
+}
+
+func (p paging) offset() int {
+	if p.After != nil {
+		return 0
+	}
+	return (p.Page - 1) * p.PerPage
+}
+
+// newsCursor последняя новость предыдущей страницы и номер следующей
+type newsCursor struct {
+	PubDate time.Time `json:"d"`
+	ID      int       `json:"i"`
+	Page    int       `json:"p"`
+	// Order sort_by, для которого выдан курсор
+	Order string `json:"o"`
+}
+
+func cursorSignature(body string) []byte {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)[:cursorSignatureSize]
+}
+
+func encodeNewsCursor(c newsCursor) string {
+	b, _ := json.Marshal(c)
+	body := base64.RawURLEncoding.EncodeToString(b)
+	return body + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(body))
+}
+
+func decodeNewsCursor(s string) (*newsCursor, error) {
+	body, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, fmt.Errorf("unsigned cursor")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cursorSignature(body)) {
+		return nil, fmt.Errorf("invalid cursor signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+	var c newsCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.ID < 1 || c.Page < 2 || c.PubDate.IsZero() {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// newsOrder порядок списка; keyset-пагинация возможна только по (pub_date, id).
+// Каждый порядок заканчивается id, чтобы новости с равными ключами не
+// переставлялись между страницами.
+type newsOrder struct {
+	// name значение sort_by
+	name   string
+	clause string
+	keyset bool
+	desc   bool
+	// relevance порядок по релевантности поиска; clause задаёт filterNews
+	relevance bool
+}
+
+var (
+	orderDateDesc  = newsOrder{name: "date_desc", clause: "ORDER BY pub_date DESC, id DESC", keyset: true, desc: true}
+	orderDateAsc   = newsOrder{name: "date_asc", clause: "ORDER BY pub_date ASC, id ASC", keyset: true}
+	orderCreated   = newsOrder{name: "created_at", clause: "ORDER BY created_at DESC, id DESC"}
+	orderTitle     = newsOrder{name: "title", clause: "ORDER BY title ASC, id ASC"}
+	orderTitleDesc = newsOrder{name: "title_desc", clause: "ORDER BY title DESC, id DESC"}
+	orderSource    = newsOrder{name: "source", clause: "ORDER BY source_id ASC, pub_date DESC, id DESC"}
+	orderRelevance = newsOrder{name: "relevance", relevance: true}
+)
+
+// newsOrders допустимые значения sort_by
+var newsOrders = []newsOrder{orderDateDesc, orderDateAsc, orderCreated, orderTitle, orderTitleDesc, orderSource, orderRelevance}
+
+// parseNewsOrder порядок по sort_by; пустой — по дате, новые первыми
+func parseNewsOrder(sortBy string) (newsOrder, error) {
+	if sortBy == "" {
+		return orderDateDesc, nil
+	}
+	names := make([]string, len(newsOrders))
+	for i, o := range newsOrders {
+		if o.name == sortBy {
+			return o, nil
+		}
+		names[i] = o.name
+	}
+	return newsOrder{}, fmt.Errorf("sort_by must be one of: %s", strings.Join(names, ", "))
+}
+
+// keysetCondition условие «после курсора» для выборки страницы
+func (o newsOrder) keysetCondition(c *newsCursor, args *[]interface{}) string {
+	*args = append(*args, c.PubDate, c.ID)
+	n := len(*args)
+	op := ">"
+	if o.desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(pub_date, id) %s ($%d, $%d)", op, n-1, n)
+}
+
+// nextCursor курсор следующей страницы по последней новости текущей;
+// nil на последней странице и для порядков без keyset
+func (p paging) nextCursor(news []News, order newsOrder, totalPages int) *string {
+	if !order.keyset || len(news) < p.PerPage || p.Page >= totalPages {
+		return nil
+	}
+	last := news[len(news)-1]
+	cursor := encodeNewsCursor(newsCursor{PubDate: last.PubDate, ID: last.ID, Page: p.Page + 1, Order: order.name})
+	return &cursor
+}
+
+// checkCursorOrder курсор выдан для порядка order
+func (p paging) checkCursorOrder(order newsOrder) error {
+	if p.After != nil && p.After.Order != order.name {
+		return fmt.Errorf("cursor was issued for a different sort_by, start from page 1")
+	}
+	return nil
+}
+
+func parsePaging(r *http.Request) (paging, error) {
+	q := r.URL.Query()
+	p := paging{Page: 1, PerPage: defaultPerPage}
+	if v, err := strconv.Atoi(q.Get("page")); err == nil && v > 0 {
+		p.Page = v
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return p, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		p.PerPage = n
+	}
+	if v := q.Get("as_of"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return p, fmt.Errorf("as_of must be RFC 3339 timestamp")
+		}
+		p.AsOf = &t
+	}
+	p.Country = requestCountry(r)
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeNewsCursor(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		p.After = c
+		p.Page = c.Page
+	}
+	return p, nil
+}
+
+// requestCountry страна клиента для отбора по geo_restriction. Параметру
+// country верим только в запросах gateway (X-Service-Token); прочие
+// запросы считаются пришедшими из неизвестной страны и, как в gateway,
+// не видят новостей с ограничениями.
+func requestCountry(r *http.Request) *string {
+	if !serviceAuthorized(r) {
+		unknown := ""
+		return &unknown
+	}
+	return parseCountry(r.URL.Query())
+}
+
+// parseCountry страна клиента из параметра country; nil — параметра нет
+func parseCountry(q url.Values) *string {
+	if !q.Has("country") {
+		return nil
+	}
+	country := strings.ToUpper(strings.TrimSpace(q.Get("country")))
+	return &country
+}
+
+// geoCondition условие доступности новости в стране клиента: без
+// ограничения или страна в списке geo_restriction. TRUE, если отбор по
+// стране не запрошен.
+func (p paging) geoCondition(args *[]interface{}) string {
+	if p.Country == nil {
+		return "TRUE"
+	}
+	*args = append(*args, *p.Country)
+	return fmt.Sprintf("(COALESCE(geo_restriction, '') = '' OR $%d = ANY(string_to_array(geo_restriction, ',')))", len(*args))
+}
+
+// geoAllows новость доступна в стране клиента; как geoCondition, но для
+// уже прочитанной новости
+func (p paging) geoAllows(n News) bool {
+	if p.Country == nil || len(n.GeoRestriction) == 0 {
+		return true
+	}
+	for _, c := range n.GeoRestriction {
+		if strings.EqualFold(c, *p.Country) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotCondition условие доступности новости: сейчас или на момент
+// as_of. as_of из будущего не открывает новости под эмбарго: доступность
+// ограничена текущим моментом.
+func snapshotCondition(asOf *time.Time, args *[]interface{}) string {
+	if asOf == nil {
+		return "available_at <= NOW()"
+	}
+	*args = append(*args, *asOf)
+	n := len(*args)
+	return fmt.Sprintf("available_at <= LEAST($%d, NOW()) AND created_at <= $%d", n, n)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Стране из параметра country верим только в запросах gateway: прямой
+// запрос без неё (или с подставленной) не видит новостей с ограничениями
+func TestRequestCountryTrustsOnlyGateway(t *testing.T) {
+	prev := serviceToken
+	serviceToken = "secret"
+	defer func() { serviceToken = prev }()
+
+	restricted := News{ID: 1, GeoRestriction: []string{"RU"}}
+	tests := []struct {
+		name    string
+		query   string
+		token   string
+		want    string // "-" — без отбора
+		allowed bool
+	}{
+		{"gateway без country", "", "secret", "-", true},
+		{"gateway из RU", "?country=ru", "secret", "RU", true},
+		{"gateway из DE", "?country=DE", "secret", "DE", false},
+		{"прямой запрос", "", "", "", false},
+		{"прямой запрос с country", "?country=RU", "", "", false},
+		{"чужой токен", "?country=RU", "guess", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/news/latest"+tt.query, nil)
+		if tt.token != "" {
+			r.Header.Set("X-Service-Token", tt.token)
+		}
+		p, err := parsePaging(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := "-"
+		if p.Country != nil {
+			got = *p.Country
+		}
+		if got != tt.want {
+			t.Errorf("%s: страна %q, ожидалась %q", tt.name, got, tt.want)
+		}
+		if p.geoAllows(restricted) != tt.allowed {
+			t.Errorf("%s: доступность новости с ограничением %v", tt.name, !tt.allowed)
+		}
+		var args []interface{}
+		if cond := p.geoCondition(&args); (cond == "TRUE") != (tt.want == "-") {
+			t.Errorf("%s: условие %s", tt.name, cond)
+		}
+	}
+}
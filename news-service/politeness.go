@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Вежливость к сайтам-источникам. Все исходящие запросы к сайтам —
+// загрузка лент, страниц статей для полного текста и разрешение ссылок —
+// проходят через общий ограничитель по хосту: к одному хосту идёт не
+// больше host_concurrency запросов одновременно и не больше host_rps
+// запросов в секунду (config.json, по умолчанию 2 и 1). Если хост
+// ответил 429 или 503 с Retry-After, запросы к нему откладываются на
+// указанное время (не больше maxRetryAfter). Запрос, таймаут которого
+// истечёт раньше, сразу завершается ошибкой hostDeferredError и не
+// повторяется.
+// GET /admin/hosts показывает хосты с идущими и отложенными запросами.
+
+const (
+	defaultHostRPS         = 1.0
+	defaultHostConcurrency = 2
+	maxRetryAfter          = time.Hour
+	// maxIdleHosts после стольких хостов забываются те, к которым нет запросов
+	maxIdleHosts = 10000
+)
+
+// hostDeferredError хост попросил подождать дольше, чем может ждать запрос
+type hostDeferredError struct {
+	host  string
+	until time.Time
+}
+
+func (e *hostDeferredError) Error() string {
+	return fmt.Sprintf("хост %s просил не обращаться до %s", e.host, e.until.Format(time.RFC3339))
+}
+
+// hostState очередь запросов к одному хосту
+type hostState struct {
+	slots chan struct{}
+	// next когда можно начать следующий запрос по host_rps,
+	// deferredUntil — по Retry-After
+	next          time.Time
+	deferredUntil time.Time
+	// throttled запросов, ждавших очереди, deferred — ответов с Retry-After
+	throttled int
+	deferred  int
+}
+
+// HostStats состояние запросов к хосту
+type HostStats struct {
+	Host          string     `json:"host"`
+	Active        int        `json:"active"`
+	Throttled     int        `json:"throttled"`
+	Deferred      int        `json:"deferred"`
+	DeferredUntil *time.Time `json:"deferred_until,omitempty"`
+}
+
+// hostLimiter ограничитель исходящих запросов по хосту
+type hostLimiter struct {
+	// rps и concurrency; 0 — без ограничения
+	rps         float64
+	concurrency int
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+var politeness = &hostLimiter{rps: defaultHostRPS, concurrency: defaultHostConcurrency, hosts: make(map[string]*hostState)}
+
+func (l *hostLimiter) state(host string) *hostState {
+	st, ok := l.hosts[host]
+	if !ok {
+		if len(l.hosts) >= maxIdleHosts {
+			now := time.Now()
+			for h, s := range l.hosts {
+				if len(s.slots) == 0 && !s.next.After(now) && !s.deferredUntil.After(now) {
+					delete(l.hosts, h)
+				}
+			}
+		}
+		size := l.concurrency
+		if size <= 0 {
+			size = 1
+		}
+		st = &hostState{slots: make(chan struct{}, size)}
+		l.hosts[host] = st
+	}
+	return st
+}
+
+// acquire ждёт очереди запроса к хосту link; release нужно вызвать после
+// того, как ответ прочитан
+func (l *hostLimiter) acquire(ctx context.Context, link string) (release func(), err error) {
+	host := hostOf(link)
+	l.mu.Lock()
+	st := l.state(host)
+	l.mu.Unlock()
+
+	release = func() {}
+	if l.concurrency > 0 {
+		select {
+		case st.slots <- struct{}{}:
+		default:
+			l.mu.Lock()
+			st.throttled++
+			l.mu.Unlock()
+			select {
+			case st.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		release = func() { <-st.slots }
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if st.deferredUntil.After(start) {
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(st.deferredUntil) {
+			until := st.deferredUntil
+			l.mu.Unlock()
+			release()
+			return nil, &hostDeferredError{host: host, until: until}
+		}
+		start = st.deferredUntil
+	}
+	if l.rps > 0 {
+		if st.next.After(start) {
+			start = st.next
+		}
+		st.next = start.Add(time.Duration(float64(time.Second) / l.rps))
+	}
+	if start.After(now) {
+		st.throttled++
+	}
+	l.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// observe откладывает запросы к хосту, если ответ 429 или 503 содержит
+// Retry-After
+func (l *hostLimiter) observe(link string, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	host := hostOf(link)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.state(host)
+	st.deferred++
+	if until := time.Now().Add(wait); until.After(st.deferredUntil) {
+		st.deferredUntil = until
+	}
+}
+
+// parseRetryAfter разбирает Retry-After: секунды или HTTP-дата
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(sec, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// snapshot хосты с идущими, ожидающими или отложенными запросами
+func (l *hostLimiter) snapshot() []HostStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	out := []HostStats{}
+	for host, st := range l.hosts {
+		s := HostStats{Host: host, Active: len(st.slots), Throttled: st.throttled, Deferred: st.deferred}
+		if st.deferredUntil.After(now) {
+			until := st.deferredUntil
+			s.DeferredUntil = &until
+		}
+		if s.Active == 0 && s.Throttled == 0 && s.Deferred == 0 {
+			continue
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// hostsHandler GET /admin/hosts
+func hostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"host_rps":         politeness.rps,
+		"host_concurrency": politeness.concurrency,
+		"hosts":            politeness.snapshot(),
+	})
+}
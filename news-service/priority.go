@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Приоритеты лент. Источник с "priority": "high" (ленты срочных
+// новостей) загружается отдельным циклом, по умолчанию каждые
+// high_priority_period_sec секунд, независимо от request_period
+// остальных лент. С флагом
+// bypass_embargo новости такого источника публикуются сразу, минуя
+// выдержку embargo_minutes. Счётчики загрузки ведутся отдельно по
+// приоритетам и отдаются в /admin/ingestion/stats.
+
+const (
+	priorityHigh    = "high"
+	priorityRegular = "regular"
+)
+
+// Период загрузки срочных лент по умолчанию
+const defaultHighPriorityPeriod = 60 * time.Second
+
+func (s feedSource) priority() string {
+	if s.Priority == priorityHigh {
+		return priorityHigh
+	}
+	return priorityRegular
+}
+
+// validateSource проверяет адрес ленты, интервал и флаги приоритета
+func validateSource(src feedSource) error {
+	if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("источник %q: url должен быть абсолютным http(s) URL", src.URL)
+	}
+	switch src.Priority {
+	case "", priorityRegular, priorityHigh:
+	default:
+		return fmt.Errorf("источник %s: priority должен быть high или regular", src.URL)
+	}
+	if src.BypassEmbargo && src.priority() != priorityHigh {
+		return fmt.Errorf("источник %s: bypass_embargo допустим только для priority high", src.URL)
+	}
+	if src.EmbargoMinutes < 0 || src.FetchIntervalSec < 0 || src.DailyBudgetBytes < 0 {
+		return fmt.Errorf("источник %s: embargo_minutes, fetch_interval_sec и daily_budget_bytes не могут быть отрицательными", src.URL)
+	}
+	if err := validateSearchLanguage(src.Language); err != nil {
+		return fmt.Errorf("источник %s: %w", src.URL, err)
+	}
+	return nil
+}
+
+// Период, с которым планировщик перечитывает таблицу sources
+const schedulerTick = 15 * time.Second
+
+// Разброс интервала загрузки по умолчанию, процентов, и наибольший допустимый
+const (
+	defaultFetchJitterPercent = 10
+	maxFetchJitterPercent     = 50
+)
+
+var fetchJitterPercent = defaultFetchJitterPercent
+
+// feedScheduler загружает ленты одного приоритета. На каждом тике
+// список источников перечитывается из базы, поэтому добавленные,
+// изменённые и отключённые через /admin/sources ленты учитываются
+// без перезапуска. Лента загружается, когда с прошлой попытки прошёл
+// её fetch_interval_sec (по умолчанию — период её приоритета); после
+// перезапуска отсчёт идёт от последней успешной загрузки. Чтобы ленты
+// с одинаковым интервалом не загружались в один момент, интервал каждой
+// ленты после каждой попытки случайно сдвигается на величину до
+// fetch_jitter_percent процентов в обе стороны.
+type feedScheduler struct {
+	priority        string
+	defaultInterval time.Duration
+	lastAttempt     map[string]time.Time
+	// jitter сдвиг интервала ленты до следующей попытки, от -1 до 1
+	// доли fetch_jitter_percent
+	jitter map[string]float64
+}
+
+func newFeedScheduler(priority string, defaultInterval time.Duration) *feedScheduler {
+	return &feedScheduler{
+		priority:        priority,
+		defaultInterval: defaultInterval,
+		lastAttempt:     make(map[string]time.Time),
+		jitter:          make(map[string]float64),
+	}
+}
+
+func (f *feedScheduler) run(ctx context.Context) {
+	for {
+		f.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(schedulerTick):
+		}
+	}
+}
+
+func (f *feedScheduler) tick(ctx context.Context) {
+	records, err := loadSources(ctx, true)
+	if err != nil {
+		log.Printf("Ошибка чтения источников: %v", err)
+		return
+	}
+	streaks, err := failureStreaks(ctx)
+	if err != nil {
+		// без счётчиков неудач лента загружается с обычным интервалом
+		log.Printf("Ошибка чтения неудач загрузки лент: %v", err)
+	}
+	usage, err := bandwidthToday(ctx)
+	if err != nil {
+		// без учёта трафика суточные лимиты не применяются
+		log.Printf("Ошибка чтения трафика лент: %v", err)
+	}
+	now := time.Now()
+	all := make([]feedSource, 0, len(records))
+	inQuarantine := 0
+	var due []feedSource
+	current := make(map[string]time.Time)
+	jitter := make(map[string]float64)
+	for _, rec := range records {
+		src := rec.feed()
+		all = append(all, src)
+		if src.priority() != f.priority {
+			continue
+		}
+		last, ok := f.lastAttempt[src.URL]
+		if !ok {
+			last = lastSuccess(ctx, src.URL)
+		}
+		shift, ok := f.jitter[src.URL]
+		if !ok {
+			// после перезапуска просроченные ленты тоже расходятся во времени
+			shift = rand.Float64()*2 - 1
+		}
+		interval := f.interval(src)
+		if quarantined(streaks[src.URL]) {
+			inQuarantine++
+			if interval < quarantineInterval {
+				interval = quarantineInterval
+			}
+		}
+		if now.Sub(last) >= jittered(interval, shift) && !overBudget(src, usage[src.URL]) {
+			due = append(due, src)
+			last = now
+			shift = rand.Float64()*2 - 1
+		}
+		current[src.URL] = last
+		jitter[src.URL] = shift
+	}
+	// удалённые и перенесённые в другой приоритет ленты забываются
+	f.lastAttempt = current
+	f.jitter = jitter
+	ingestion.setFeeds(all)
+	ingestion.setQuarantined(f.priority, inQuarantine)
+	if len(due) > 0 {
+		updateNewsFromRSS(ctx, f.priority, due)
+	}
+}
+
+// jittered интервал, сдвинутый на долю shift от fetch_jitter_percent
+func jittered(interval time.Duration, shift float64) time.Duration {
+	return interval + time.Duration(float64(interval)*float64(fetchJitterPercent)/100*shift)
+}
+
+func (f *feedScheduler) interval(src feedSource) time.Duration {
+	if src.FetchIntervalSec > 0 {
+		return time.Duration(src.FetchIntervalSec) * time.Second
+	}
+	return f.defaultInterval
+}
+
+// PriorityStats счётчики загрузки лент одного приоритета
+type PriorityStats struct {
+	Feeds   int `json:"feeds"`
+	Fetches int `json:"fetches"`
+	Errors  int `json:"errors"`
+	Added   int `json:"added"`
+	// Retries повторов после временных ошибок, Quarantined — лент в карантине
+	Retries     int `json:"retries"`
+	Quarantined int `json:"quarantined"`
+	// AvgIngestLagSec среднее время от pub_date до сохранения новости
+	AvgIngestLagSec float64    `json:"avg_ingest_lag_sec"`
+	LastFetchAt     *time.Time `json:"last_fetch_at,omitempty"`
+
+	lagSum time.Duration
+}
+
+// ingestionStats счётчики по приоритетам
+type ingestionStats struct {
+	mu    sync.Mutex
+	stats map[string]*PriorityStats
+}
+
+var ingestion = &ingestionStats{stats: map[string]*PriorityStats{
+	priorityHigh:    {},
+	priorityRegular: {},
+}}
+
+// setFeeds фиксирует число источников каждого приоритета
+func (s *ingestionStats) setFeeds(sources []feedSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.stats {
+		st.Feeds = 0
+	}
+	for _, src := range sources {
+		s.stats[src.priority()].Feeds++
+	}
+}
+
+// fetched учитывает загрузку ленты
+func (s *ingestionStats) fetched(src feedSource, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats[src.priority()]
+	now := time.Now()
+	st.Fetches++
+	st.LastFetchAt = &now
+	if err != nil {
+		st.Errors++
+	}
+}
+
+// retried учитывает повтор загрузки ленты
+func (s *ingestionStats) retried(src feedSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[src.priority()].Retries++
+}
+
+// setQuarantined фиксирует число лент приоритета в карантине
+func (s *ingestionStats) setQuarantined(priority string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[priority].Quarantined = n
+}
+
+// added учитывает сохранённую новость и задержку её появления
+func (s *ingestionStats) added(src feedSource, pubDate time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats[src.priority()]
+	st.Added++
+	// даты из будущего и пустые даты (заменённые временем загрузки) дают нулевую задержку
+	if lag := time.Since(pubDate); lag > 0 {
+		st.lagSum += lag
+	}
+}
+
+func (s *ingestionStats) snapshot() map[string]PriorityStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]PriorityStats, len(s.stats))
+	for name, st := range s.stats {
+		c := *st
+		if c.Added > 0 {
+			c.AvgIngestLagSec = (c.lagSum / time.Duration(c.Added)).Seconds()
+		}
+		out[name] = c
+	}
+	return out
+}
+
+// ingestionStatsHandler возвращает счётчики загрузки по приоритетам
+func ingestionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ingestion.snapshot()); err != nil {
+		log.Printf("Ошибка кодирования статистики загрузки: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Блок "читайте также": GET /news/{id}/related отдаёт limit новостей,
+// похожих на данную. Кандидаты — новости с общими лексемами (до
+// readAlsoTerms самых весомых лексем новости: сначала из заголовка, потом
+// самые частые в тексте) или общими рубриками в пределах readAlsoWindowDays
+// от даты новости. Похожесть — ts_rank по общим лексемам плюс
+// readAlsoTagWeight за каждую общую рубрику. Сама новость, её дубли и
+// оригинал её истории не попадают в блок: они перечислены в related
+// детальной новости. С country в блок попадают только новости, доступные
+// в этой стране.
+
+const (
+	defaultReadAlsoLimit = 5
+	maxReadAlsoLimit     = 20
+	readAlsoTerms        = 32
+	readAlsoWindowDays   = 30
+	readAlsoTagWeight    = 0.5
+)
+
+// ReadAlsoItem похожая новость
+type ReadAlsoItem struct {
+	News
+	Score      float64 `json:"score"`
+	SharedTags int     `json:"shared_tags,omitempty"`
+}
+
+// ReadAlsoResponse ответ /news/{id}/related
+type ReadAlsoResponse struct {
+	News []ReadAlsoItem `json:"news"`
+}
+
+// readAlsoHandler GET /news/{id}/related?limit=5
+func readAlsoHandler(w http.ResponseWriter, r *http.Request, newsID int) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	limit := defaultReadAlsoLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReadAlsoLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxReadAlsoLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	items, err := getReadAlso(ctx, newsID, limit, paging{Country: requestCountry(r)})
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос похожих новостей %d отменён, request_id: %s", newsID, requestID)
+		return
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "News not found", http.StatusNotFound)
+		return
+	}
+	if isQueryTimeout(err) {
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения похожих новостей %d: %v", newsID, err)
+		http.Error(w, "Failed to get related news", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Похожих новостей для %d: %d, request_id: %s", newsID, len(items), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReadAlsoResponse{News: items})
+}
+
+// readAlsoTSQuery собирает из лексем новости $1 запрос "любая из них".
+// Лексемы уже нормализованы на языке новости, поэтому разбираются
+// конфигурацией simple; кавычки и обратная косая черта экранируются.
+const readAlsoTSQuery = `
+	SELECT to_tsquery('simple', string_agg(
+		'''' || replace(replace(lexeme, '\', '\\'), '''', '''''') || '''', ' | '))
+	FROM (
+		SELECT lexeme
+		FROM news, unnest(search_vector)
+		WHERE news.id = $1
+		ORDER BY 'A' = ANY(weights) DESC, cardinality(positions) DESC, lexeme
+		LIMIT %d
+	) terms`
+
+// getReadAlso похожие новости, доступные в стране p.Country;
+// sql.ErrNoRows, если новости нет или она ещё под эмбарго
+func getReadAlso(ctx context.Context, id, limit int, p paging) ([]ReadAlsoItem, error) {
+	var story int
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(duplicate_of, id) FROM news WHERE id = $1 AND available_at <= NOW()
+	`, id).Scan(&story)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{id, story, limit}
+	geo := p.geoCondition(&args)
+	query := fmt.Sprintf(`
+		WITH src AS (
+			SELECT pub_date, (`+strings.TrimSpace(readAlsoTSQuery)+`) AS q
+			FROM news WHERE id = $1
+		), src_tags AS (
+			SELECT DISTINCT LOWER(tag) AS tag FROM news_tags WHERE news_id = $1
+		), candidates AS (
+			SELECT n.id FROM news n, src WHERE n.search_vector @@ src.q
+			UNION
+			SELECT t.news_id FROM news_tags t JOIN src_tags s ON LOWER(t.tag) = s.tag
+		)
+		SELECT n.id, shared.tags,
+			COALESCE(ts_rank(n.search_vector, src.q), 0) + %g * shared.tags AS score
+		FROM candidates c
+		JOIN news n ON n.id = c.id
+		CROSS JOIN src
+		CROSS JOIN LATERAL (
+			SELECT COUNT(DISTINCT LOWER(t.tag)) AS tags
+			FROM news_tags t JOIN src_tags s ON LOWER(t.tag) = s.tag
+			WHERE t.news_id = n.id
+		) shared
+		WHERE n.id <> $1 AND n.id <> $2 AND n.duplicate_of IS NULL
+			AND n.available_at <= NOW() AND %s
+			AND n.pub_date BETWEEN src.pub_date - INTERVAL '%d days' AND src.pub_date + INTERVAL '%d days'
+		ORDER BY score DESC, n.pub_date DESC, n.id DESC
+		LIMIT $3
+	`, readAlsoTerms, readAlsoTagWeight, geo, readAlsoWindowDays, readAlsoWindowDays)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	scores := make(map[int]ReadAlsoItem)
+	for rows.Next() {
+		var item ReadAlsoItem
+		if err := rows.Scan(&item.ID, &item.SharedTags, &item.Score); err != nil {
+			return nil, err
+		}
+		ids = append(ids, item.ID)
+		scores[item.ID] = item
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make([]ReadAlsoItem, 0, len(ids))
+	if len(ids) == 0 {
+		return items, nil
+	}
+	found, err := getNewsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]News, len(found))
+	for _, n := range found {
+		byID[n.ID] = n
+	}
+	for _, id := range ids {
+		if n, ok := byID[id]; ok {
+			item := scores[id]
+			item.News = n
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
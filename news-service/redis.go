@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Минимальный клиент Redis: протокол RESP2 поверх TCP без TLS, команды
+// выполняются по одной на соединение из небольшого пула. Адрес задаётся
+// URL redis://[:password@]host:6379[/db].
+
+const (
+	redisDialTimeout = 500 * time.Millisecond
+	redisIOTimeout   = 500 * time.Millisecond
+	redisPoolSize    = 8
+)
+
+// errRedisNil ответ nil (ключа нет)
+var errRedisNil = errors.New("redis: nil")
+
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRedisClient(raw string) (*redisClient, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("адрес Redis должен иметь вид redis://host:6379/0")
+	}
+	c := &redisClient{addr: u.Host, pool: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if c.db, err = strconv.Atoi(path); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("номер базы Redis должен быть числом: %s", path)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		if _, err := rc.do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do выполняет команду; ответ — строка, число (как строка) или errRedisNil
+func (c *redisClient) do(ctx context.Context, args ...string) (string, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.pool:
+	default:
+		var err error
+		if rc, err = c.dial(ctx); err != nil {
+			return "", err
+		}
+	}
+	reply, err := rc.do(args...)
+	if err != nil && err != errRedisNil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			// соединение в неизвестном состоянии
+			rc.conn.Close()
+			return "", err
+		}
+	}
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// redisError ошибка, которую вернул сервер; соединение остаётся рабочим
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (rc *redisConn) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	rc.conn.SetDeadline(time.Now().Add(redisIOTimeout))
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return "", err
+	}
+	return rc.readReply()
+}
+
+func (rc *redisConn) readReply() (string, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("redis: пустой ответ")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: некорректный ответ %q", line)
+		}
+		if n < 0 {
+			return "", errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("redis: неподдерживаемый ответ %q", line)
+	}
+}
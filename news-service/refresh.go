@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ручной запуск загрузки. POST /admin/refresh сразу загружает все
+// включённые ленты (или выбранные параметрами source и id) вне
+// расписания планировщика и возвращает ID задания; его ход виден в
+// GET /admin/refresh/{id}. Нужно после добавления источника и при
+// восстановлении после сбоя. Хранятся последние maxRefreshJobs заданий.
+
+const maxRefreshJobs = 100
+
+// Статусы задания загрузки
+const (
+	refreshRunning = "running"
+	refreshDone    = "done"
+)
+
+// RefreshFeedError ошибка загрузки ленты в задании
+type RefreshFeedError struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// RefreshJob задание ручной загрузки лент
+type RefreshJob struct {
+	ID         string             `json:"id"`
+	Status     string             `json:"status"`
+	Source     string             `json:"source,omitempty"`
+	SourceDBID int                `json:"source_db_id,omitempty"`
+	Feeds      int                `json:"feeds"`
+	Processed  int                `json:"processed"`
+	Added      int                `json:"added"`
+	Errors     []RefreshFeedError `json:"errors"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+}
+
+type refreshJobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*RefreshJob
+	order []string
+}
+
+var refreshes = &refreshJobs{jobs: make(map[string]*RefreshJob)}
+
+// start регистрирует задание и запускает загрузку лент в фоне
+func (j *refreshJobs) start(job *RefreshJob, sources []feedSource) {
+	j.mu.Lock()
+	j.jobs[job.ID] = job
+	j.order = append(j.order, job.ID)
+	if len(j.order) > maxRefreshJobs {
+		delete(j.jobs, j.order[0])
+		j.order = j.order[1:]
+	}
+	j.mu.Unlock()
+
+	go func() {
+		run := newIngestRun(triggerRefresh, len(sources), "job_id", job.ID)
+		fetchFeeds(serviceCtx, sources, func(src feedSource, added int, err error) {
+			run.report(added, err)
+			j.mu.Lock()
+			job.Processed++
+			job.Added += added
+			if err != nil {
+				job.Errors = append(job.Errors, RefreshFeedError{URL: src.URL, Error: err.Error()})
+			}
+			j.mu.Unlock()
+		})
+		j.mu.Lock()
+		now := time.Now()
+		job.Status = refreshDone
+		job.FinishedAt = &now
+		j.mu.Unlock()
+		run.finish()
+	}()
+}
+
+// get копия задания
+func (j *refreshJobs) get(id string) (RefreshJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return RefreshJob{}, false
+	}
+	c := *job
+	c.Errors = append([]RefreshFeedError{}, job.Errors...)
+	return c, true
+}
+
+// refreshHandler POST /admin/refresh[?source=<source_id>|?id=<id источника>]
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := &RefreshJob{
+		ID:        generateRequestID(),
+		Status:    refreshRunning,
+		Source:    strings.ToLower(strings.TrimSpace(r.URL.Query().Get("source"))),
+		Errors:    []RefreshFeedError{},
+		StartedAt: time.Now(),
+	}
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid source ID", http.StatusBadRequest)
+			return
+		}
+		job.SourceDBID = id
+	}
+
+	records, err := loadSources(r.Context(), true)
+	if err != nil {
+		log.Printf("Ошибка чтения источников: %v", err)
+		http.Error(w, "Failed to get sources", http.StatusInternalServerError)
+		return
+	}
+	var sources []feedSource
+	for _, rec := range records {
+		src := rec.feed()
+		if job.SourceDBID != 0 && rec.ID != job.SourceDBID {
+			continue
+		}
+		if job.Source != "" && src.sourceID() != job.Source {
+			continue
+		}
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		http.Error(w, "No enabled sources match the filter", http.StatusNotFound)
+		return
+	}
+	job.Feeds = len(sources)
+
+	refreshes.start(job, sources)
+	log.Printf("Запущена ручная загрузка %s (лент: %d), request_id: %s", job.ID, job.Feeds, requestID)
+
+	snapshot, _ := refreshes.get(job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/refresh/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// refreshJobHandler GET /admin/refresh/{id} — ход задания
+func refreshJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := refreshes.get(strings.TrimPrefix(r.URL.Path, "/admin/refresh/"))
+	if !ok {
+		http.Error(w, "Refresh job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
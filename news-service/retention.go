@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Срок хранения новостей. Раз в retention_interval_hours новости с
+// pub_date старше retention_days дней удаляются (retention_action
+// "delete") или переносятся вместе с рубриками в таблицы news_archive и
+// news_tags_archive ("archive", по умолчанию). Закреплённые главные
+// новости не трогаются, а дубли уходят вместе с оригиналом, чтобы не
+// вернуться в списки. Без retention_days задание не запускается, но его
+// можно выполнить вручную через POST /admin/retention. Срок короче
+// minRetentionDays не принимается ни в конфигурации, ни вручную, а
+// удаление вручную возможно, только если retention_action — "delete":
+// запрос может лишь заменить удаление архивированием.
+
+const (
+	retentionArchive = "archive"
+	retentionDelete  = "delete"
+
+	defaultRetentionInterval = 24 * time.Hour
+	retentionBatch           = 500
+	// minRetentionDays защита от опечатки, убирающей почти все новости
+	minRetentionDays = 7
+)
+
+// archivedNewsColumns все колонки news, которые переносятся в
+// news_archive. Миграция, добавляющая колонку в news и news_archive,
+// добавляет её и сюда; TestMigrations* сверяет список со схемой.
+const archivedNewsColumns = "id, title, content, description, link, pub_date, created_at, available_at, geo_restriction, " +
+	"author, content_simhash, source_link, source_id, source_title, image_url, content_text, sanitizer_version, " +
+	"content_extracted, link_key, title_simhash, duplicate_of, duplicate_reason, search_language, search_vector, " +
+	"language, content_hash, revision, updated_at"
+
+var (
+	retentionDays     = 0
+	retentionAction   = retentionArchive
+	retentionInterval = defaultRetentionInterval
+)
+
+// RetentionRun результат прогона задания
+type RetentionRun struct {
+	Running    bool       `json:"running"`
+	Action     string     `json:"action"`
+	Cutoff     time.Time  `json:"cutoff"`
+	Processed  int        `json:"processed"`
+	Manual     bool       `json:"manual"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// RetentionStatus ответ GET /admin/retention
+type RetentionStatus struct {
+	Days          int           `json:"retention_days"`
+	Action        string        `json:"action"`
+	IntervalHours int           `json:"interval_hours"`
+	Expired       int           `json:"expired"`
+	Archived      int           `json:"archived"`
+	LastRun       *RetentionRun `json:"last_run,omitempty"`
+}
+
+var retention = struct {
+	mu   sync.Mutex
+	last *RetentionRun
+}{}
+
+// runRetentionLoop запускает задание по расписанию до отмены ctx
+func runRetentionLoop(ctx context.Context) {
+	if retentionDays <= 0 {
+		return
+	}
+	log.Printf("Срок хранения новостей: %d дней (%s), проверка раз в %v", retentionDays, retentionAction, retentionInterval)
+	for {
+		if run, ok := startRetention(retentionDays, retentionAction, false); ok {
+			applyRetention(ctx, run)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retentionInterval):
+		}
+	}
+}
+
+// startRetention регистрирует прогон; false — предыдущий ещё идёт
+func startRetention(days int, action string, manual bool) (*RetentionRun, bool) {
+	retention.mu.Lock()
+	defer retention.mu.Unlock()
+	if retention.last != nil && retention.last.Running {
+		return nil, false
+	}
+	run := &RetentionRun{
+		Running:   true,
+		Action:    action,
+		Cutoff:    time.Now().AddDate(0, 0, -days),
+		Manual:    manual,
+		StartedAt: time.Now(),
+	}
+	retention.last = run
+	return run, true
+}
+
+// applyRetention удаляет или архивирует устаревшие новости порциями
+func applyRetention(ctx context.Context, run *RetentionRun) {
+	var err error
+	for ctx.Err() == nil {
+		var n int
+		n, err = retainBatch(ctx, run.Action, run.Cutoff)
+		if err != nil || n == 0 {
+			break
+		}
+		retention.mu.Lock()
+		run.Processed += n
+		retention.mu.Unlock()
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	retention.mu.Lock()
+	now := time.Now()
+	run.Running = false
+	run.FinishedAt = &now
+	if err != nil {
+		run.Error = err.Error()
+	}
+	retention.mu.Unlock()
+	if err != nil {
+		log.Printf("Ошибка очистки устаревших новостей (%s): %v", run.Action, err)
+	}
+	if run.Processed > 0 {
+		log.Printf("Устаревшие новости до %s: %s %d", run.Cutoff.Format("2006-01-02"), run.Action, run.Processed)
+	}
+}
+
+// expiredCondition новости старше cutoff, кроме закреплённых
+const expiredCondition = `pub_date < $1 AND NOT EXISTS (SELECT 1 FROM top_stories t WHERE t.news_id = news.id)`
+
+// retainBatch обрабатывает одну порцию в транзакции; возвращает число
+// удалённых или перенесённых новостей
+func retainBatch(ctx context.Context, action string, cutoff time.Time) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM news WHERE `+expiredCondition+`
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, cutoff, retentionBatch)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// дубли уходят вместе с оригиналом
+	batch := `SELECT id FROM news WHERE id = ANY($1) OR duplicate_of = ANY($1)`
+	if action == retentionArchive {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO news_tags_archive (news_id, tag)
+			SELECT news_id, tag FROM news_tags WHERE news_id IN (`+batch+`)
+			ON CONFLICT DO NOTHING
+		`, pq.Array(ids)); err != nil {
+			return 0, fmt.Errorf("перенос рубрик: %v", err)
+		}
+	}
+	query := `DELETE FROM news WHERE id IN (` + batch + `)`
+	if action == retentionArchive {
+		// колонки перечислены явно: на базах, обновлённых с прежней схемы,
+		// их порядок в news и news_archive может различаться
+		query = `
+			WITH moved AS (DELETE FROM news WHERE id IN (` + batch + `) RETURNING ` + archivedNewsColumns + `)
+			INSERT INTO news_archive (` + archivedNewsColumns + `) SELECT ` + archivedNewsColumns + ` FROM moved`
+	}
+	result, err := tx.ExecContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// retentionHandler GET /admin/retention — настройки, число устаревших
+// новостей и последний прогон; POST [?days=&action=] — прогон сейчас
+// (202; 409, если прогон уже идёт)
+func retentionHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		ctx, cancel := handlerContext(r, adminTimeout)
+		defer cancel()
+		status := RetentionStatus{
+			Days:          retentionDays,
+			Action:        retentionAction,
+			IntervalHours: int(retentionInterval / time.Hour),
+		}
+		if retentionDays > 0 {
+			cutoff := time.Now().AddDate(0, 0, -retentionDays)
+			if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM news WHERE `+expiredCondition, cutoff).Scan(&status.Expired); err != nil {
+				log.Printf("Ошибка подсчёта устаревших новостей: %v", err)
+				http.Error(w, "Failed to get retention status", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM news_archive`).Scan(&status.Archived); err != nil {
+			log.Printf("Ошибка подсчёта архивных новостей: %v", err)
+			http.Error(w, "Failed to get retention status", http.StatusInternalServerError)
+			return
+		}
+		retention.mu.Lock()
+		if retention.last != nil {
+			last := *retention.last
+			status.LastRun = &last
+		}
+		retention.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case http.MethodPost:
+		days := retentionDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid days", http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+		if days <= 0 {
+			http.Error(w, "days must be positive (retention_days is not configured)", http.StatusBadRequest)
+			return
+		}
+		if days < minRetentionDays {
+			http.Error(w, fmt.Sprintf("days must be at least %d", minRetentionDays), http.StatusBadRequest)
+			return
+		}
+		action := retentionAction
+		if v := r.URL.Query().Get("action"); v != "" {
+			action = v
+		}
+		if action != retentionArchive && action != retentionDelete {
+			http.Error(w, "action must be archive or delete", http.StatusBadRequest)
+			return
+		}
+		if action == retentionDelete && retentionAction != retentionDelete {
+			http.Error(w, "action=delete requires retention_action \"delete\" in config", http.StatusForbidden)
+			return
+		}
+		run, ok := startRetention(days, action, true)
+		if !ok {
+			http.Error(w, "Retention is already running", http.StatusConflict)
+			return
+		}
+		log.Printf("Ручная очистка новостей старше %d дней (%s), request_id: %s", days, action, requestID)
+		go applyRetention(serviceCtx, run)
+
+		retention.mu.Lock()
+		snapshot := *run
+		retention.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(snapshot)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Повторы и карантин лент. Временная ошибка загрузки (сетевая ошибка,
+// таймаут, ответ 5xx, 408 или 429) повторяется до fetch_retries раз с
+// экспоненциальной паузой от retry_backoff_sec. Лента, не загрузившаяся
+// quarantine_after раз подряд, попадает в карантин: она загружается не
+// чаще раза в quarantine_interval_sec, а попадание в карантин пишется в
+// журнал как [ALERT] и учитывается в /admin/ingestion/stats. Первая
+// успешная загрузка обнуляет счётчик неудач и выводит ленту из карантина.
+
+const (
+	defaultFetchRetries       = 2
+	defaultRetryBackoff       = time.Second
+	maxRetryBackoff           = 30 * time.Second
+	defaultQuarantineAfter    = 5
+	defaultQuarantineInterval = time.Hour
+)
+
+var (
+	fetchRetries       = defaultFetchRetries
+	retryBackoff       = defaultRetryBackoff
+	quarantineAfter    = defaultQuarantineAfter
+	quarantineInterval = defaultQuarantineInterval
+)
+
+// feedStatusError ответ ленты с неожиданным статусом
+type feedStatusError struct {
+	code int
+}
+
+func (e *feedStatusError) Error() string {
+	return fmt.Sprintf("HTTP ошибка: %d", e.code)
+}
+
+// isTransientFeedError ошибка, которая может не повториться
+func isTransientFeedError(err error) bool {
+	var statusErr *feedStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusRequestTimeout ||
+			statusErr.code == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetchWithRetry загружает ленту, повторяя временные ошибки; каждую
+// попытку ограничивает feedTimeout
+func fetchWithRetry(ctx context.Context, src feedSource, cond feedValidators) (items []FeedItem, validators feedValidators, notModified bool, err error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		fetchCtx, cancel := context.WithTimeout(ctx, feedTimeout)
+		attemptStart := time.Now()
+		items, validators, notModified, err = fetchRSSFeed(fetchCtx, src.URL, src.auth, cond)
+		cancel()
+		metrics.fetchDuration.observe(time.Since(attemptStart).Seconds(), src.sourceID())
+		var parseErr *feedParseError
+		if errors.As(err, &parseErr) {
+			metrics.parseErrors.inc(src.sourceID())
+		}
+		if err == nil || attempt >= fetchRetries || ctx.Err() != nil || serviceCtx.Err() != nil || !isTransientFeedError(err) {
+			return items, validators, notModified, err
+		}
+		feedLog(src).Warn("Временная ошибка загрузки, повтор", "error", err, "attempt", attempt+1,
+			"attempts", fetchRetries+1, "backoff_ms", backoff.Milliseconds())
+		ingestion.retried(src)
+		select {
+		case <-ctx.Done():
+			return nil, cond, false, err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// quarantined лента в карантине после failures неудач подряд
+func quarantined(failures int) bool {
+	return quarantineAfter > 0 && failures >= quarantineAfter
+}
+
+// reportFailureStreak пишет [ALERT], когда лента попадает в карантин
+func reportFailureStreak(src feedSource, failures int, lastErr error) {
+	if quarantineAfter > 0 && failures == quarantineAfter {
+		feedLog(src).Error("[ALERT] Лента помещена в карантин", "consecutive_failures", failures,
+			"quarantine_interval_sec", int(quarantineInterval.Seconds()), "error", lastErr)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Правки новостей. Издания часто меняют заголовок и текст уже
+// опубликованной новости. Для каждого элемента ленты считается хеш его
+// содержимого (news.content_hash); если элемент с уже сохранённой
+// ссылкой приходит с другим хешем, новость обновляется: revision
+// увеличивается, updated_at ставится в текущее время, рубрики, поисковый
+// индекс и полный текст (для extract_full_content) обновляются заново.
+// Проверяются все элементы ленты, а не только новые после контрольной
+// точки, но элементы старше неё только обновляются: удалённые хранением
+// новости заново не добавляются. Дата публикации, эмбарго и признак
+// дубля при правке не меняются.
+
+// storeMode режим сохранения элемента ленты
+type storeMode int
+
+const (
+	// storeInsert новая новость добавляется, изменённая — обновляется
+	storeInsert storeMode = iota
+	// storeRevise только обновление уже сохранённой изменённой новости
+	storeRevise
+	// storeOverwrite новость перезаписывается целиком (replay архива)
+	storeOverwrite
+)
+
+// saveOutcome итог сохранения элемента ленты
+type saveOutcome int
+
+const (
+	newsSkipped saveOutcome = iota
+	newsAdded
+	newsUpdated
+	// newsFailed элемент не сохранён из-за ошибки, его нужно обработать
+	// при следующей загрузке
+	newsFailed
+)
+
+// itemContentHash хеш содержимого элемента ленты. Считается по данным
+// ленты до очистки разметки, чтобы смена версии очистки или полный текст
+// со страницы статьи не выглядели правкой.
+func itemContentHash(item FeedItem) string {
+	h := sha256.New()
+	for _, part := range []string{item.Title, item.Description, item.Content, item.Author} {
+		h.Write([]byte(strings.TrimSpace(part)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// minRevisionInterval наименьший промежуток между правками одной
+// новости: ленты с нестабильной разметкой иначе правили бы новость при
+// каждой загрузке. Правка, пришедшая раньше, применяется при первой
+// загрузке после интервала.
+const minRevisionInterval = 10 * time.Minute
+
+// storedNews сохранённая новость, с которой сверяется элемент ленты
+type storedNews struct {
+	id       int
+	link     string
+	hash     string
+	sourceID string
+	// recentlyRevised последняя правка была меньше minRevisionInterval назад
+	recentlyRevised bool
+}
+
+// findStoredNews сохранённая новость со ссылкой link или исходной
+// ссылкой sourceLink. Два поиска объединены через UNION ALL, чтобы
+// каждый шёл по своему индексу; совпадение по link предпочтительнее.
+func findStoredNews(ctx context.Context, link, sourceLink string) (storedNews, error) {
+	var n storedNews
+	err := db.QueryRowContext(ctx, `
+		(SELECT id, link, content_hash, source_id, COALESCE(updated_at > NOW() - $3 * INTERVAL '1 second', FALSE)
+			FROM news WHERE link = $1 LIMIT 1)
+		UNION ALL
+		(SELECT id, link, content_hash, source_id, COALESCE(updated_at > NOW() - $3 * INTERVAL '1 second', FALSE)
+			FROM news WHERE source_link = $2 LIMIT 1)
+		LIMIT 1
+	`, link, sourceLink, minRevisionInterval.Seconds()).Scan(&n.id, &n.link, &n.hash, &n.sourceID, &n.recentlyRevised)
+	return n, err
+}
+
+// changedStoredNews ID и ссылка сохранённой новости, содержимое которой
+// отличается от hash; 0 — новости нет или правки нет (хеш совпал или
+// запомнен впервые). Новость правит только источник, который её
+// сохранил: ленты с той же канонической ссылкой, но своим анонсом, не
+// перезаписывают друг друга.
+func changedStoredNews(ctx context.Context, src feedSource, link, sourceLink, hash string) (int, string) {
+	stored, err := findStoredNews(ctx, link, sourceLink)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, ""
+	case err != nil:
+		feedLog(src).Error("Ошибка поиска сохранённой новости", "link", link, "error", err)
+		return 0, ""
+	case stored.sourceID != src.sourceID() || stored.hash == hash:
+		return 0, ""
+	case stored.hash == "":
+		if err := backfillNewsHash(ctx, stored.id, hash); err != nil {
+			feedLog(src).Error("Ошибка сохранения хеша новости", "link", link, "error", err)
+		}
+		return 0, ""
+	case stored.recentlyRevised:
+		return 0, ""
+	}
+	return stored.id, stored.link
+}
+
+// backfillNewsHash запоминает хеш новости, сохранённой до появления
+// content_hash, не считая это правкой
+func backfillNewsHash(ctx context.Context, id int, hash string) error {
+	_, err := db.ExecContext(ctx, `UPDATE news SET content_hash = $2 WHERE id = $1 AND content_hash = ''`, id, hash)
+	return err
+}
+
+// reviseNews записывает новую редакцию новости id и возвращает её номер
+func reviseNews(ctx context.Context, id int, item FeedItem, src feedSource, title, content, description, contentText, hash string) (int, error) {
+	sourceLink := strings.TrimSpace(item.Link)
+	var revision int
+	err := db.QueryRowContext(ctx, `
+		UPDATE news
+		SET title = $2, content = $3, description = $4, author = $5, content_simhash = $6, image_url = $7,
+			content_text = $8, sanitizer_version = $9, title_simhash = $10, search_language = $11, language = $12,
+			content_hash = $13, revision = revision + 1, updated_at = NOW()
+		WHERE id = $1
+		RETURNING revision
+	`, id, title, content, description, item.Author, contentFingerprint(title, content), itemImageURL(item, sourceLink),
+		contentText, sanitizerVersion, titleFingerprint(title), src.searchLanguage(title, contentText),
+		src.newsLanguage(title, contentText), hash).Scan(&revision)
+	return revision, err
+}
+
+// applyRevision обновляет изменённую новость id со ссылкой link вместе с
+// рубриками, поисковым индексом и полным текстом
+func applyRevision(ctx context.Context, id int, link string, item FeedItem, src feedSource, title, content, description, contentText, hash string) saveOutcome {
+	revision, err := reviseNews(ctx, id, item, src, title, content, description, contentText, hash)
+	if err != nil {
+		feedLog(src).Error("Ошибка обновления новости", "title", title, "error", err)
+		return newsFailed
+	}
+	if err := saveNewsTags(ctx, link, item.Categories, true); err != nil {
+		feedLog(src).Error("Ошибка сохранения рубрик новости", "title", title, "error", err)
+	}
+	searchIndex.enqueue(id)
+	if src.ExtractFullContent {
+		extractor.enqueue(extractTask{link: link, feedURL: src.URL})
+	}
+	feedLog(src).Info("Новость изменена в ленте", "id", id, "title", title, "revision", revision)
+	return newsUpdated
+}
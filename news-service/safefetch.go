@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Запросы по адресам, пришедшим извне, — ссылкам элементов лент
+// (разрешение канонических ссылок, страницы статей), адресам вебхуков и
+// сайтов для автообнаружения лент — идут только в публичный интернет.
+// Адрес проверяется в checkDialAddress уже после DNS-резолвинга, при каждом
+// соединении, поэтому ни редирект, ни DNS-rebinding не приведут запрос
+// во внутреннюю сеть: loopback, частные, link-local, CGNAT, multicast и
+// прочие немаршрутизируемые адреса отклоняются. Прокси из окружения не
+// используется — он обошёл бы проверку. ALLOW_PRIVATE_FETCH=true снимает
+// ограничение (стенды, где ленты и вебхуки во внутренней сети).
+
+var errPrivateAddress = errors.New("адрес во внутренней сети запрещён")
+
+var allowPrivateFetch = os.Getenv("ALLOW_PRIVATE_FETCH") == "true"
+
+// blockedNets немаршрутизируемые и служебные сети
+var blockedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "100::/64", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP адрес из публичного интернета
+func publicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress Control для net.Dialer: address уже содержит IP
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if allowPrivateFetch {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// safeTransport транспорт для запросов по внешним адресам
+var safeTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDialAddress,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// checkFetchURL допускает только http и https с хостом
+func checkFetchURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("поддерживаются только адреса http и https: %s", u.Redacted())
+	}
+	return nil
+}
+
+// newSafeClient клиент для запросов по внешним адресам; редиректы
+// проверяются по checkFetchURL, не больше maxRedirects переходов
+func newSafeClient(timeout time.Duration, maxRedirects int) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: safeTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errTooManyRedirects
+			}
+			return checkFetchURL(req.URL)
+		},
+	}
+}
+
+// validatePublicURL проверяет сохраняемый адрес (вебхук): схему и то,
+// что хост сейчас резолвится только в публичные адреса. Это ранний отказ
+// с понятной ошибкой; при каждом запросе адрес всё равно проверяет
+// checkDialAddress.
+func validatePublicURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if err := checkFetchURL(u); err != nil {
+		return err
+	}
+	if allowPrivateFetch {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("хост %s не найден: %v", u.Hostname(), err)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return fmt.Errorf("%w: %s → %s", errPrivateAddress, u.Hostname(), a.IP)
+		}
+	}
+	return nil
+}
+
+// politeGet выполняет запрос, сам проходя по редиректам (не больше
+// maxRedirects): очередь хоста в politeness занимается для каждого
+// перехода отдельно, так что ограничение действует и на хост, куда
+// ведёт редирект. client не должен следовать редиректам сам (см.
+// noRedirectClient). release освобождает очередь последнего хоста;
+// вызывать после чтения тела.
+func politeGet(ctx context.Context, client *http.Client, method, link string, header http.Header, maxRedirects int) (resp *http.Response, release func(), err error) {
+	for hop := 0; ; hop++ {
+		u, err := url.Parse(link)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkFetchURL(u); err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, link, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		release, err := politeness.acquire(ctx, link)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		politeness.observe(link, resp)
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+			return resp, release, nil
+		}
+		resp.Body.Close()
+		release()
+		if hop >= maxRedirects {
+			return nil, nil, errTooManyRedirects
+		}
+		next, err := u.Parse(location)
+		if err != nil {
+			return nil, nil, err
+		}
+		link = next.String()
+	}
+}
+
+// noRedirectClient клиент на safeTransport, возвращающий ответы 3xx как
+// есть, — для politeGet
+func noRedirectClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: safeTransport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
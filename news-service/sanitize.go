@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─── ОЧИСТКА HTML ────────────────────────────────────────────────────────────
+
+// Описания и содержимое элементов лент приходят с произвольной разметкой:
+// скриптами, стилями, iframe, счётчиками-пикселями. При сохранении
+// остаются только теги и атрибуты из белого списка, ссылки — только
+// http(s) и mailto, а рядом сохраняется текстовый вариант содержимого
+// (content_text) для превью, поиска и уведомлений. Новости, сохранённые
+// прежней версией очистки (sanitizer_version), переочищаются заданием
+// POST /admin/sanitize.
+
+// sanitizerVersion увеличивается при изменении правил очистки, чтобы
+// задание переочистило уже сохранённые новости
+const sanitizerVersion = 1
+
+const sanitizeBatch = 500
+
+// allowedTags разрешённые теги и их атрибуты
+var allowedTags = map[string][]string{
+	"a": {"href", "title"}, "img": {"src", "alt", "title", "width", "height"},
+	"p": nil, "br": nil, "hr": nil, "b": nil, "strong": nil, "i": nil, "em": nil, "u": nil, "s": nil,
+	"sub": nil, "sup": nil, "blockquote": nil, "q": nil, "pre": nil, "code": nil,
+	"ul": nil, "ol": nil, "li": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"figure": nil, "figcaption": nil, "table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+}
+
+// voidTags теги без закрывающей пары
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// droppedTags теги, удаляемые вместе с содержимым
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "noscript": true,
+	"template": true, "svg": true, "math": true, "form": true, "select": true, "textarea": true,
+}
+
+// blockTags теги, которые в текстовом варианте разделяют строки
+var blockTags = map[string]bool{
+	"p": true, "br": true, "hr": true, "div": true, "li": true, "tr": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "figure": true, "figcaption": true,
+	"table": true, "ul": true, "ol": true,
+}
+
+var (
+	htmlCommentPattern = regexp.MustCompile(`(?s)<!--.*?-->|<!\[CDATA\[.*?\]\]>|<![^>]*>|<\?[^>]*>`)
+	htmlTagPattern     = regexp.MustCompile(`(?s)<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	htmlAttrPattern    = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	blankPattern       = regexp.MustCompile(`[ \t\r\f\v\x{00a0}]+`)
+)
+
+// htmlToken тег или текст между тегами
+type htmlToken struct {
+	text    string // текст без разметки, не раскодированный
+	name    string // имя тега в нижнем регистре; пусто для текста
+	closing bool
+	attrs   string // исходная строка атрибутов
+}
+
+// tokenizeHTML разбивает HTML на теги и текст; комментарии, CDATA и
+// объявления отбрасываются
+func tokenizeHTML(s string) []htmlToken {
+	s = htmlCommentPattern.ReplaceAllString(s, "")
+	var tokens []htmlToken
+	pos := 0
+	for _, m := range htmlTagPattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > pos {
+			tokens = append(tokens, htmlToken{text: s[pos:m[0]]})
+		}
+		tokens = append(tokens, htmlToken{
+			name:    strings.ToLower(s[m[4]:m[5]]),
+			closing: m[3] > m[2],
+			attrs:   s[m[6]:m[7]],
+		})
+		pos = m[1]
+	}
+	if pos < len(s) {
+		tokens = append(tokens, htmlToken{text: s[pos:]})
+	}
+	return tokens
+}
+
+// sanitizeHTML оставляет разрешённую разметку; относительные ссылки
+// разрешаются от base. Незакрытые теги закрываются, лишние закрывающие
+// отбрасываются
+func sanitizeHTML(s string, base *url.URL) string {
+	var b strings.Builder
+	var open []string
+	skip := ""
+	for _, t := range tokenizeHTML(s) {
+		if skip != "" {
+			if t.closing && t.name == skip {
+				skip = ""
+			}
+			continue
+		}
+		switch {
+		case t.name == "":
+			b.WriteString(html.EscapeString(html.UnescapeString(t.text)))
+		case droppedTags[t.name]:
+			if !t.closing && !strings.HasSuffix(strings.TrimSpace(t.attrs), "/") {
+				skip = t.name
+			}
+		case !hasTag(t.name):
+			// неизвестный тег снимается, содержимое остаётся
+		case t.closing:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != t.name {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		default:
+			attrs, ok := sanitizeAttrs(t, base)
+			if !ok {
+				continue
+			}
+			b.WriteString("<" + t.name + attrs + ">")
+			if !voidTags[t.name] {
+				open = append(open, t.name)
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func hasTag(name string) bool {
+	_, ok := allowedTags[name]
+	return ok
+}
+
+// sanitizeAttrs разрешённые атрибуты тега; false — тег отбрасывается
+// целиком (картинка без адреса или счётчик-пиксель)
+func sanitizeAttrs(t htmlToken, base *url.URL) (string, bool) {
+	if t.name == "img" && pixelAttrRegex.MatchString("<img "+t.attrs+">") {
+		return "", false
+	}
+	allowed := allowedTags[t.name]
+	var b strings.Builder
+	hasSrc := false
+	for _, m := range htmlAttrPattern.FindAllStringSubmatch(t.attrs, -1) {
+		name := strings.ToLower(m[1])
+		if !containsString(allowed, name) {
+			continue
+		}
+		value := html.UnescapeString(m[2] + m[3] + m[4])
+		if name == "href" || name == "src" {
+			if value = safeURL(value, base, name == "href"); value == "" {
+				continue
+			}
+			hasSrc = hasSrc || name == "src"
+		}
+		b.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+	}
+	if t.name == "img" && !hasSrc {
+		return "", false
+	}
+	if t.name == "a" {
+		b.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	return b.String(), true
+}
+
+// safeURL абсолютный адрес со схемой http(s) (для ссылок ещё mailto)
+// или пустая строка
+func safeURL(raw string, base *url.URL, allowMailto bool) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return ""
+		}
+	case "mailto":
+		if !allowMailto {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return u.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlToText текстовый вариант HTML: блоки разделены переводами строк,
+// пробелы схлопнуты, сущности раскодированы
+func htmlToText(s string) string {
+	var b strings.Builder
+	skip := ""
+	for _, t := range tokenizeHTML(s) {
+		switch {
+		case skip != "":
+			if t.closing && t.name == skip {
+				skip = ""
+			}
+		case t.name == "":
+			b.WriteString(strings.ReplaceAll(html.UnescapeString(t.text), "\n", " "))
+		case droppedTags[t.name] && !t.closing:
+			skip = t.name
+		case blockTags[t.name]:
+			b.WriteString("\n")
+		}
+	}
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.TrimSpace(blankPattern.ReplaceAllString(line, " ")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ─── ПЕРЕОЧИСТКА СОХРАНЁННЫХ НОВОСТЕЙ ────────────────────────────────────────
+
+// SanitizeJob состояние задания переочистки
+type SanitizeJob struct {
+	Running    bool       `json:"running"`
+	Version    int        `json:"version"`
+	Remaining  int        `json:"remaining"`
+	Processed  int        `json:"processed"`
+	Changed    int        `json:"changed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+var sanitizer = struct {
+	mu  sync.Mutex
+	job SanitizeJob
+}{job: SanitizeJob{Version: sanitizerVersion}}
+
+// staleSanitized число новостей, очищенных прежней версией
+func staleSanitized(ctx context.Context) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM news WHERE sanitizer_version < $1", sanitizerVersion).Scan(&n)
+	return n, err
+}
+
+// runSanitizeBackfill переочищает новости порциями по возрастанию id
+func runSanitizeBackfill(ctx context.Context) {
+	fail := func(err error) {
+		log.Printf("Ошибка переочистки новостей: %v", err)
+		sanitizer.mu.Lock()
+		now := time.Now()
+		sanitizer.job.Running = false
+		sanitizer.job.Error = err.Error()
+		sanitizer.job.FinishedAt = &now
+		sanitizer.mu.Unlock()
+	}
+
+	lastID := 0
+	for {
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+			return
+		}
+		rows, err := db.QueryContext(ctx, `
+			SELECT id, title, COALESCE(content, ''), COALESCE(description, ''), COALESCE(source_link, link)
+			FROM news
+			WHERE sanitizer_version < $1 AND id > $2
+			ORDER BY id
+			LIMIT $3
+		`, sanitizerVersion, lastID, sanitizeBatch)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		type pending struct {
+			id                                  int
+			title, content, description, source string
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.title, &p.content, &p.description, &p.source); err != nil {
+				rows.Close()
+				fail(err)
+				return
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+
+		changed := 0
+		for _, p := range batch {
+			base, _ := url.Parse(p.source)
+			content := sanitizeHTML(p.content, base)
+			description := sanitizeHTML(p.description, base)
+			if content != p.content || description != p.description {
+				changed++
+			}
+			_, err := db.ExecContext(ctx, `
+				UPDATE news SET content = $1, description = $2, content_text = $3, content_simhash = $4, sanitizer_version = $5
+				WHERE id = $6
+			`, content, description, htmlToText(content), contentFingerprint(p.title, content), sanitizerVersion, p.id)
+			if err != nil {
+				fail(err)
+				return
+			}
+			lastID = p.id
+		}
+
+		sanitizer.mu.Lock()
+		sanitizer.job.Processed += len(batch)
+		sanitizer.job.Changed += changed
+		sanitizer.job.Remaining -= len(batch)
+		if sanitizer.job.Remaining < 0 {
+			sanitizer.job.Remaining = 0
+		}
+		sanitizer.mu.Unlock()
+	}
+
+	sanitizer.mu.Lock()
+	now := time.Now()
+	sanitizer.job.Running = false
+	sanitizer.job.Remaining = 0
+	sanitizer.job.FinishedAt = &now
+	log.Printf("Переочистка новостей завершена: обработано %d, изменено %d", sanitizer.job.Processed, sanitizer.job.Changed)
+	sanitizer.mu.Unlock()
+}
+
+// sanitizeHandler GET /admin/sanitize — состояние задания и число новостей
+// прежней версии очистки; POST — запуск переочистки (409, если уже идёт)
+func sanitizeHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		sanitizer.mu.Lock()
+		job := sanitizer.job
+		sanitizer.mu.Unlock()
+		if !job.Running {
+			remaining, err := staleSanitized(ctx)
+			if err != nil {
+				log.Printf("Ошибка подсчёта новостей для переочистки: %v", err)
+				http.Error(w, "Failed to get sanitize status", http.StatusInternalServerError)
+				return
+			}
+			job.Remaining = remaining
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+
+	case http.MethodPost:
+		remaining, err := staleSanitized(ctx)
+		if err != nil {
+			log.Printf("Ошибка подсчёта новостей для переочистки: %v", err)
+			http.Error(w, "Failed to start sanitize", http.StatusInternalServerError)
+			return
+		}
+		sanitizer.mu.Lock()
+		if sanitizer.job.Running {
+			sanitizer.mu.Unlock()
+			http.Error(w, "Sanitize is already running", http.StatusConflict)
+			return
+		}
+		now := time.Now()
+		sanitizer.job = SanitizeJob{Running: true, Version: sanitizerVersion, Remaining: remaining, StartedAt: &now}
+		job := sanitizer.job
+		sanitizer.mu.Unlock()
+
+		log.Printf("Запуск переочистки новостей (версия %d): %d новостей, request_id: %s", sanitizerVersion, remaining, requestID)
+		go runSanitizeBackfill(serviceCtx)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Язык полнотекстового поиска. Новость индексируется (news.search_vector)
+// конфигурацией PostgreSQL на своём языке: language источника, а если он
+// не задан — языку, определённому по тексту (кириллица — russian,
+// латиница — english). Запрос /news/filter разбирается на каждом языке
+// из search_languages config.json и на simple, поэтому находятся новости
+// всех источников.
+//
+// Если запрос не даёт ни одной лексемы ни на одном языке поиска (только
+// стоп-слова или знаки, например "C++"), новости ищутся по подстроке в
+// заголовке и тексте (ILIKE, индекс pg_trgm по заголовку). sort_by=relevance
+// упорядочивает найденное по ts_rank_cd, а для поиска по подстроке — по
+// триграммному сходству заголовка.
+
+// Конфигурации полнотекстового поиска PostgreSQL, которые можно задать
+// источнику и в search_languages
+var textSearchConfigs = map[string]bool{
+	"simple": true, "arabic": true, "danish": true, "dutch": true, "english": true,
+	"finnish": true, "french": true, "german": true, "hungarian": true, "italian": true,
+	"norwegian": true, "portuguese": true, "romanian": true, "russian": true,
+	"spanish": true, "swedish": true, "turkish": true,
+}
+
+const (
+	searchLanguageRussian = "russian"
+	searchLanguageEnglish = "english"
+	searchLanguageSimple  = "simple"
+)
+
+var searchLanguages = []string{searchLanguageRussian, searchLanguageEnglish}
+
+// setSearchLanguages языки, на которых разбирается поисковый запрос
+func setSearchLanguages(langs []string) error {
+	if len(langs) == 0 {
+		return nil
+	}
+	for _, lang := range langs {
+		if !textSearchConfigs[lang] {
+			return fmt.Errorf("search_languages: неизвестная конфигурация поиска %q", lang)
+		}
+	}
+	searchLanguages = langs
+	return nil
+}
+
+// validateSearchLanguage проверяет language источника
+func validateSearchLanguage(lang string) error {
+	if lang != "" && !textSearchConfigs[lang] {
+		return fmt.Errorf("language: неизвестная конфигурация поиска %q", lang)
+	}
+	return nil
+}
+
+// searchLanguage язык индексации новости источника
+func (s feedSource) searchLanguage(title, text string) string {
+	if s.Language != "" {
+		return s.Language
+	}
+	return detectSearchLanguage(title + " " + text)
+}
+
+// detectSearchLanguage язык текста по преобладающей письменности
+func detectSearchLanguage(text string) string {
+	switch detectLanguage(text) {
+	case languageRussian:
+		return searchLanguageRussian
+	case languageEnglish:
+		return searchLanguageEnglish
+	default:
+		return searchLanguageSimple
+	}
+}
+
+// searchTSQuery tsquery запроса в параметре $arg: объединение разборов на
+// каждом языке поиска и на simple. Имена конфигураций проверены
+// setSearchLanguages.
+func searchTSQuery(arg int) string {
+	langs := append([]string{}, searchLanguages...)
+	if !containsString(langs, searchLanguageSimple) {
+		langs = append(langs, searchLanguageSimple)
+	}
+	return tsqueryUnion(langs, arg)
+}
+
+func tsqueryUnion(langs []string, arg int) string {
+	parts := make([]string, len(langs))
+	for i, lang := range langs {
+		parts[i] = fmt.Sprintf("plainto_tsquery('%s', $%d)", lang, arg)
+	}
+	return "(" + strings.Join(parts, " || ") + ")"
+}
+
+// searchHasLexemes даёт ли запрос лексемы на языках поиска. simple не
+// учитывается: он оставляет стоп-слова, которых нет в индексе новостей
+// на других языках.
+func searchHasLexemes(ctx context.Context, q string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT numnode(`+tsqueryUnion(searchLanguages, 1)+`)`, q).Scan(&n)
+	return n > 0, err
+}
+
+// likePattern шаблон ILIKE для поиска подстроки q
+func likePattern(q string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSpace(q)) + "%"
+}
+
+// searchMatch условие поиска q и выражение его релевантности. В args
+// добавляется только тот параметр, на который ссылаются условие и
+// выражение: сам запрос для tsquery, а без лексем — шаблон ILIKE.
+// Лишний параметр, не упомянутый в SQL, PostgreSQL отклоняет.
+func searchMatch(ctx context.Context, q string, args *[]interface{}) (cond, rank string, err error) {
+	fulltext, err := searchHasLexemes(ctx, q)
+	if err != nil {
+		return "", "", err
+	}
+	if fulltext {
+		*args = append(*args, q)
+		tsq := searchTSQuery(len(*args))
+		return "search_vector @@ " + tsq, "ts_rank_cd(search_vector, " + tsq + ")", nil
+	}
+	*args = append(*args, likePattern(q))
+	n := len(*args)
+	return fmt.Sprintf("(title ILIKE $%d OR content_text ILIKE $%d)", n, n), fmt.Sprintf("similarity(title, $%d)", n), nil
+}
+
+// maxExcludeTerms наибольшее число слов в exclude
+const maxExcludeTerms = 20
+
+// parseExcludeTerms разбирает параметр exclude: слова или фразы через запятую
+func parseExcludeTerms(raw string) ([]string, error) {
+	var terms []string
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || containsString(terms, v) {
+			continue
+		}
+		terms = append(terms, v)
+	}
+	if len(terms) > maxExcludeTerms {
+		return nil, fmt.Errorf("exclude must contain at most %d terms", maxExcludeTerms)
+	}
+	return terms, nil
+}
+
+// excludeMatch условие, отсекающее новости, в которых найдено хотя бы одно
+// из слов terms. Каждое слово ищется так же, как запрос q: по
+// search_vector, а без лексем — по подстроке.
+func excludeMatch(ctx context.Context, terms []string, args *[]interface{}) (string, error) {
+	conds := make([]string, 0, len(terms))
+	for _, term := range terms {
+		cond, _, err := searchMatch(ctx, term, args)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, cond)
+	}
+	// search_vector может быть NULL — такая новость не исключается
+	return "NOT COALESCE(" + strings.Join(conds, " OR ") + ", false)", nil
+}
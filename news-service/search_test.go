@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// Запрос только из стоп-слов не даёт лексем и ищется по подстроке: в SQL
+// не должно остаться параметров, на которые запрос не ссылается, при
+// любой сортировке, с exclude и с гистограммой по датам.
+func TestSearchStopWordsOnlyQuery(t *testing.T) {
+	migrationTestDB(t)
+	ctx := context.Background()
+	if err := migrate(ctx); err != nil {
+		t.Fatalf("миграции: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO news (title, content, content_text, link, pub_date, available_at) VALUES
+		('The C++ guide', '<p>about the language</p>', 'about the language', 'https://example.com/1', NOW() - INTERVAL '2 hours', NOW() - INTERVAL '2 hours'),
+		('Новости дня', '<p>текст</p>', 'текст', 'https://example.com/2', NOW() - INTERVAL '1 hour', NOW() - INTERVAL '1 hour')`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []newsOrder{orderDateDesc, orderRelevance} {
+		f := newsFilter{Query: "the", Order: order}
+		p := paging{Page: 1, PerPage: 10}
+		whereClause, o, args, err := newsFilterWhere(ctx, f, p)
+		if err != nil {
+			t.Fatalf("sort_by=%s: %v", order.name, err)
+		}
+		if o.relevance && o.clause == "" {
+			t.Fatalf("sort_by=%s: нет выражения релевантности", order.name)
+		}
+		news, total, err := queryNewsList(ctx, whereClause, o, args, p)
+		if err != nil {
+			t.Fatalf("sort_by=%s: %v", order.name, err)
+		}
+		if total != 1 || len(news) != 1 || news[0].Title != "The C++ guide" {
+			t.Fatalf("sort_by=%s: найдено %d: %+v", order.name, total, news)
+		}
+		if _, err := getDateFacet(ctx, f, p, facetIntervalDay); err != nil {
+			t.Fatalf("sort_by=%s, facets: %v", order.name, err)
+		}
+	}
+
+	f := newsFilter{Exclude: []string{"the"}, Order: orderDateDesc}
+	p := paging{Page: 1, PerPage: 10}
+	whereClause, o, args, err := newsFilterWhere(ctx, f, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	news, total, err := queryNewsList(ctx, whereClause, o, args, p)
+	if err != nil {
+		t.Fatalf("exclude: %v", err)
+	}
+	if total != 1 || len(news) != 1 || news[0].Title != "Новости дня" {
+		t.Fatalf("exclude: найдено %d: %+v", total, news)
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Внешний поисковый движок. По умолчанию поиск q в /news/filter идёт
+// по search_vector в PostgreSQL. С SEARCH_BACKEND=elasticsearch запрос
+// с q выполняет Elasticsearch или OpenSearch (ELASTICSEARCH_URL):
+// движок возвращает ID новостей страницы, а сами новости читаются из
+// PostgreSQL, поэтому удалённые и помеченные дублями после индексации
+// новости в выдачу не попадают. Сохранённые и изменённые новости
+// индексируются в фоне пакетами; уже существующие переносятся в индекс
+// через POST /admin/search/reindex. Если движок недоступен, поиск
+// выполняется в PostgreSQL. Списки без q движок не использует.
+
+const (
+	searchBackendPostgres = "postgres"
+	searchBackendElastic  = "elasticsearch"
+
+	defaultElasticIndex = "news"
+
+	searchIndexQueueSize = 10000
+	searchIndexBatch     = 500
+	searchIndexInterval  = time.Second
+)
+
+// searchBackend внешний поисковый движок
+type searchBackend interface {
+	name() string
+	// ensureIndex создаёт индекс, если его ещё нет
+	ensureIndex(ctx context.Context) error
+	// indexNews добавляет или заменяет документы новостей
+	indexNews(ctx context.Context, docs []searchDocument) error
+	// searchNews ID новостей страницы в порядке выдачи и число найденных
+	searchNews(ctx context.Context, f newsFilter, p paging) ([]int, int, error)
+}
+
+// searchEngine внешний движок; nil — поиск в PostgreSQL
+var searchEngine searchBackend
+
+func newSearchBackendFromEnv() (searchBackend, error) {
+	switch backend := os.Getenv("SEARCH_BACKEND"); backend {
+	case "", searchBackendPostgres:
+		return nil, nil
+	case searchBackendElastic:
+		raw := strings.TrimRight(os.Getenv("ELASTICSEARCH_URL"), "/")
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("ELASTICSEARCH_URL должен быть http(s) URL, например http://elasticsearch:9200")
+		}
+		index := os.Getenv("ELASTICSEARCH_INDEX")
+		if index == "" {
+			index = defaultElasticIndex
+		}
+		return &elasticBackend{
+			url:      raw,
+			index:    index,
+			username: os.Getenv("ELASTICSEARCH_USERNAME"),
+			password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("SEARCH_BACKEND должен быть %s или %s", searchBackendPostgres, searchBackendElastic)
+	}
+}
+
+// searchDocument новость в индексе поискового движка
+type searchDocument struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	ContentText string    `json:"content_text"`
+	Author      string    `json:"author"`
+	SourceID    string    `json:"source_id"`
+	Language    string    `json:"language"`
+	Tags        []string  `json:"tags"`
+	PubDate     time.Time `json:"pub_date"`
+	AvailableAt time.Time `json:"available_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	Duplicate   bool      `json:"duplicate"`
+}
+
+const searchDocumentQuery = `
+	SELECT n.id, n.title, n.content_text, n.author, n.source_id, n.language, n.pub_date, n.available_at, n.created_at,
+		n.duplicate_of IS NOT NULL,
+		ARRAY(SELECT t.tag FROM news_tags t WHERE t.news_id = n.id ORDER BY t.tag)
+	FROM news n`
+
+func querySearchDocuments(ctx context.Context, query string, args ...interface{}) ([]searchDocument, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []searchDocument
+	for rows.Next() {
+		var d searchDocument
+		if err := rows.Scan(&d.ID, &d.Title, &d.ContentText, &d.Author, &d.SourceID, &d.Language, &d.PubDate, &d.AvailableAt,
+			&d.CreatedAt, &d.Duplicate, pq.Array(&d.Tags)); err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+// searchNewsExternal поиск через движок; новости страницы читаются из БД
+// в порядке, который вернул движок
+func searchNewsExternal(ctx context.Context, f newsFilter, p paging) ([]News, int, error) {
+	ids, total, err := searchEngine.searchNews(ctx, f, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return []News{}, total, nil
+	}
+	var args []interface{}
+	args = append(args, pq.Array(ids))
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		WHERE id = ANY($1) AND %s AND %s AND %s
+	`, p.columns(), snapshotCondition(p.AsOf, &args), p.duplicateCondition(&args), p.geoCondition(&args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	byID := make(map[int]News, len(ids))
+	for rows.Next() {
+		n, err := p.scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		byID[n.ID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	news := make([]News, 0, len(ids))
+	for _, id := range ids {
+		if n, ok := byID[id]; ok {
+			news = append(news, n)
+		}
+	}
+	return news, total, nil
+}
+
+// SearchIndexStats состояние индексации во внешнем движке
+type SearchIndexStats struct {
+	Backend string `json:"backend"`
+	Pending int    `json:"pending"`
+	Indexed int    `json:"indexed"`
+	Failed  int    `json:"failed"`
+	Dropped int    `json:"dropped"`
+	// Reindex ход последней полной переиндексации
+	Reindex *ReindexStatus `json:"reindex,omitempty"`
+}
+
+// ReindexStatus ход переиндексации всех новостей
+type ReindexStatus struct {
+	Running    bool       `json:"running"`
+	Indexed    int        `json:"indexed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// searchIndexer очередь ID новостей на индексацию
+type searchIndexer struct {
+	queue chan int
+
+	mu      sync.Mutex
+	stats   SearchIndexStats
+	reindex *ReindexStatus
+}
+
+var searchIndex = &searchIndexer{queue: make(chan int, searchIndexQueueSize)}
+
+// enqueue ставит новость в очередь, не блокируя сохранение
+func (s *searchIndexer) enqueue(id int) {
+	if searchEngine == nil {
+		return
+	}
+	select {
+	case s.queue <- id:
+	default:
+		s.mu.Lock()
+		s.stats.Dropped++
+		s.mu.Unlock()
+		log.Printf("Очередь поискового индекса переполнена, новость %d не проиндексирована", id)
+	}
+}
+
+// run индексирует очередь пакетами до отмены ctx
+func (s *searchIndexer) run(ctx context.Context) {
+	if err := searchEngine.ensureIndex(ctx); err != nil {
+		log.Printf("Ошибка создания поискового индекса: %v", err)
+	}
+	ticker := time.NewTicker(searchIndexInterval)
+	defer ticker.Stop()
+	var batch []int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			batch = append(batch, id)
+			if len(batch) < searchIndexBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		s.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (s *searchIndexer) flush(ctx context.Context, ids []int) {
+	docs, err := querySearchDocuments(ctx, searchDocumentQuery+` WHERE n.id = ANY($1)`, pq.Array(ids))
+	if err == nil {
+		err = searchEngine.indexNews(ctx, docs)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.stats.Failed += len(ids)
+		log.Printf("Ошибка индексации %d новостей в %s: %v", len(ids), searchEngine.name(), err)
+		return
+	}
+	s.stats.Indexed += len(docs)
+}
+
+// startReindex запускает переиндексацию всех новостей; false — она уже идёт
+func (s *searchIndexer) startReindex() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reindex != nil && s.reindex.Running {
+		return false
+	}
+	s.reindex = &ReindexStatus{Running: true, StartedAt: time.Now()}
+	go s.runReindex(serviceCtx, s.reindex)
+	return true
+}
+
+func (s *searchIndexer) runReindex(ctx context.Context, status *ReindexStatus) {
+	log.Printf("Переиндексация новостей в %s начата", searchEngine.name())
+	err := searchEngine.ensureIndex(ctx)
+	lastID := 0
+	for err == nil {
+		var docs []searchDocument
+		docs, err = querySearchDocuments(ctx, searchDocumentQuery+` WHERE n.id > $1 ORDER BY n.id LIMIT $2`,
+			lastID, searchIndexBatch)
+		if err != nil || len(docs) == 0 {
+			break
+		}
+		if err = searchEngine.indexNews(ctx, docs); err != nil {
+			break
+		}
+		lastID = docs[len(docs)-1].ID
+		s.mu.Lock()
+		status.Indexed += len(docs)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	status.Running = false
+	status.FinishedAt = &now
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Ошибка переиндексации новостей: %v (проиндексировано %d)", err, status.Indexed)
+		return
+	}
+	log.Printf("Переиндексация новостей завершена: %d", status.Indexed)
+}
+
+func (s *searchIndexer) snapshot() SearchIndexStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Backend = searchBackendPostgres
+	if searchEngine != nil {
+		stats.Backend = searchEngine.name()
+	}
+	stats.Pending = len(s.queue)
+	if s.reindex != nil {
+		r := *s.reindex
+		stats.Reindex = &r
+	}
+	return stats
+}
+
+// searchAdminHandler GET /admin/search — состояние индексации
+func searchAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searchIndex.snapshot())
+}
+
+// searchReindexHandler POST /admin/search/reindex — переиндексация всех новостей
+func searchReindexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+	if searchEngine == nil {
+		http.Error(w, "External search backend is not configured", http.StatusConflict)
+		return
+	}
+	if !searchIndex.startReindex() {
+		http.Error(w, "Reindex is already running", http.StatusConflict)
+		return
+	}
+	log.Printf("Запущена переиндексация новостей, request_id: %s", requestID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(searchIndex.snapshot())
+}
+
+// elasticBackend Elasticsearch или OpenSearch через REST API
+type elasticBackend struct {
+	url      string
+	index    string
+	username string
+	password string
+	client   *http.Client
+}
+
+func (e *elasticBackend) name() string {
+	return searchBackendElastic
+}
+
+// elasticIndexSettings анализ и маппинг индекса новостей: текст
+// разбирается стандартным анализатором, поля фильтров сравниваются без
+// учёта регистра
+const elasticIndexSettings = `{
+  "settings": {
+    "analysis": {
+      "normalizer": {
+        "lowercase": {"type": "custom", "filter": ["lowercase"]}
+      }
+    }
+  },
+  "mappings": {
+    "properties": {
+      "id": {"type": "integer"},
+      "title": {"type": "text", "fields": {"raw": {"type": "keyword"}}},
+      "content_text": {"type": "text"},
+      "author": {"type": "keyword", "normalizer": "lowercase"},
+      "source_id": {"type": "keyword", "normalizer": "lowercase"},
+      "language": {"type": "keyword"},
+      "tags": {"type": "keyword", "normalizer": "lowercase"},
+      "pub_date": {"type": "date"},
+      "available_at": {"type": "date"},
+      "created_at": {"type": "date"},
+      "duplicate": {"type": "boolean"}
+    }
+  }
+}`
+
+func (e *elasticBackend) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	return e.client.Do(req)
+}
+
+// elasticError ответ движка с ошибкой
+func elasticError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (e *elasticBackend) ensureIndex(ctx context.Context) error {
+	resp, err := e.do(ctx, http.MethodHead, "/"+e.index, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	resp, err = e.do(ctx, http.MethodPut, "/"+e.index, "application/json", []byte(elasticIndexSettings))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return elasticError(resp)
+	}
+	log.Printf("Создан поисковый индекс %s", e.index)
+	return nil
+}
+
+func (e *elasticBackend) indexNews(ctx context.Context, docs []searchDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, d := range docs {
+		if d.Tags == nil {
+			d.Tags = []string{}
+		}
+		enc.Encode(map[string]interface{}{"index": map[string]string{"_index": e.index, "_id": strconv.Itoa(d.ID)}})
+		enc.Encode(d)
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return elasticError(resp)
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			for _, op := range item {
+				if op.Status >= 300 {
+					return fmt.Errorf("ошибка индексации документа: HTTP %d: %s", op.Status, op.Error)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// elasticSort порядок выдачи, соответствующий newsOrder
+func elasticSort(order newsOrder) []interface{} {
+	byDate := func(dir string) []interface{} {
+		return []interface{}{
+			map[string]string{"pub_date": dir},
+			map[string]string{"id": dir},
+		}
+	}
+	switch order.name {
+	case orderRelevance.name:
+		return append([]interface{}{"_score"}, byDate("desc")...)
+	case orderDateAsc.name:
+		return byDate("asc")
+	case orderCreated.name:
+		return []interface{}{map[string]string{"created_at": "desc"}, map[string]string{"id": "desc"}}
+	case orderTitle.name:
+		return []interface{}{map[string]string{"title.raw": "asc"}, map[string]string{"id": "asc"}}
+	case orderTitleDesc.name:
+		return []interface{}{map[string]string{"title.raw": "desc"}, map[string]string{"id": "desc"}}
+	case orderSource.name:
+		return append([]interface{}{map[string]string{"source_id": "asc"}}, byDate("desc")...)
+	default:
+		return byDate("desc")
+	}
+}
+
+func (e *elasticBackend) searchNews(ctx context.Context, f newsFilter, p paging) ([]int, int, error) {
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]bool{"duplicate": false}},
+	}
+	if p.AsOf == nil {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"available_at": map[string]string{"lte": "now"}}})
+	} else {
+		filters = append(filters,
+			map[string]interface{}{"range": map[string]interface{}{"available_at": map[string]time.Time{"lte": *p.AsOf}}},
+			map[string]interface{}{"range": map[string]interface{}{"created_at": map[string]time.Time{"lte": *p.AsOf}}})
+	}
+	if f.Author != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"author": f.Author}})
+	}
+	if f.Category != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"tags": f.Category}})
+	}
+	if f.Source != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"source_id": f.Source}})
+	}
+	if len(f.Languages) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string][]string{"language": f.Languages}})
+	}
+	pubDate := map[string]time.Time{}
+	if d, err := time.Parse("2006-01-02", f.DateFrom); err == nil {
+		pubDate["gte"] = d
+	}
+	if d, err := time.Parse("2006-01-02", f.DateTo); err == nil {
+		pubDate["lte"] = d.Add(24*time.Hour - time.Second)
+	}
+	if len(pubDate) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"pub_date": pubDate}})
+	}
+
+	mustNot := []interface{}{}
+	for _, term := range f.Exclude {
+		mustNot = append(mustNot, map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":    term,
+				"fields":   []string{"title", "content_text"},
+				"operator": "and",
+			},
+		})
+	}
+
+	body := map[string]interface{}{
+		"size":             p.PerPage,
+		"from":             p.offset(),
+		"track_total_hits": true,
+		"_source":          false,
+		"sort":             elasticSort(f.Order),
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":    f.Query,
+						"fields":   []string{"title^2", "content_text"},
+						"operator": "and",
+					},
+				},
+				"filter":   filters,
+				"must_not": mustNot,
+			},
+		},
+	}
+	if p.After != nil && f.Order.keyset {
+		body["search_after"] = []interface{}{p.After.PubDate.UnixMilli(), p.After.ID}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/"+e.index+"/_search", "application/json", payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, elasticError(resp)
+	}
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	ids := make([]int, 0, len(result.Hits.Hits))
+	for _, h := range result.Hits.Hits {
+		if id, err := strconv.Atoi(h.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, result.Hits.Total.Value, nil
+}
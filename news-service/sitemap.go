@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Карта сайта для публичного фронтенда. Раз в sitemap_interval_min
+// (config.json, по умолчанию 60 минут) сервис строит из опубликованных
+// новостей без дублей страницы карты по sitemapPageSize адресов в
+// порядке ID и хранит их в памяти сжатыми. GET /sitemap.xml отдаёт
+// индекс страниц, GET /sitemaps/{n}.xml — n-ю страницу. Адрес новости
+// строится по шаблону SITEMAP_NEWS_URL
+// (https://example.com/news/{id}); без него карта не строится.
+// Адреса страниц в индексе строятся как ссылки исходящей ленты — от
+// PUBLIC_BASE_URL или адреса запроса.
+
+const (
+	sitemapNamespace       = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapPageSize        = 10000
+	defaultSitemapInterval = time.Hour
+	sitemapBuildTimeout    = 10 * time.Minute
+)
+
+var sitemapInterval = defaultSitemapInterval
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapPage сжатая страница карты
+type sitemapPage struct {
+	gz      []byte
+	lastMod time.Time
+}
+
+// SitemapStats состояние карты сайта
+type SitemapStats struct {
+	Enabled    bool       `json:"enabled"`
+	Pages      int        `json:"pages"`
+	URLs       int        `json:"urls"`
+	Building   bool       `json:"building"`
+	BuiltAt    *time.Time `json:"built_at,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// sitemapBuilder последняя построенная карта
+type sitemapBuilder struct {
+	newsURL string
+
+	mu    sync.Mutex
+	pages []sitemapPage
+	stats SitemapStats
+}
+
+var sitemap *sitemapBuilder
+
+func newSitemapBuilderFromEnv() (*sitemapBuilder, error) {
+	raw := os.Getenv("SITEMAP_NEWS_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(strings.ReplaceAll(raw, "{id}", "1"))
+	if err != nil || !strings.Contains(raw, "{id}") || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("SITEMAP_NEWS_URL должен быть абсолютным адресом с {id}, например https://example.com/news/{id}")
+	}
+	return &sitemapBuilder{newsURL: raw, stats: SitemapStats{Enabled: true}}, nil
+}
+
+func (s *sitemapBuilder) enabled() bool {
+	return s != nil
+}
+
+// run перестраивает карту сразу и затем раз в sitemapInterval до отмены ctx
+func (s *sitemapBuilder) run(ctx context.Context) {
+	for {
+		if err := s.rebuild(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка построения карты сайта: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sitemapInterval):
+		}
+	}
+}
+
+// rebuild строит карту заново; пока она строится, отдаётся предыдущая
+func (s *sitemapBuilder) rebuild(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stats.Building {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stats.Building = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	pages, urls, err := s.build(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Building = false
+	if err != nil {
+		s.stats.LastError = err.Error()
+		return err
+	}
+	now := time.Now()
+	s.pages = pages
+	s.stats.Pages = len(pages)
+	s.stats.URLs = urls
+	s.stats.BuiltAt = &now
+	s.stats.DurationMs = time.Since(start).Milliseconds()
+	s.stats.LastError = ""
+	log.Printf("Карта сайта построена: %d адресов, %d страниц за %v", urls, len(pages), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (s *sitemapBuilder) build(ctx context.Context) ([]sitemapPage, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, sitemapBuildTimeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, pub_date FROM news
+		WHERE available_at <= NOW() AND duplicate_of IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	pages := []sitemapPage{}
+	set := sitemapURLSet{XMLNS: sitemapNamespace}
+	var lastMod time.Time
+	total := 0
+	flush := func() error {
+		if len(set.URLs) == 0 {
+			return nil
+		}
+		gz, err := gzipXML(set)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, sitemapPage{gz: gz, lastMod: lastMod})
+		set.URLs = set.URLs[:0]
+		lastMod = time.Time{}
+		return nil
+	}
+	for rows.Next() {
+		var id int
+		var pubDate time.Time
+		if err := rows.Scan(&id, &pubDate); err != nil {
+			return nil, 0, err
+		}
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     strings.ReplaceAll(s.newsURL, "{id}", strconv.Itoa(id)),
+			LastMod: pubDate.UTC().Format(time.RFC3339),
+		})
+		if pubDate.After(lastMod) {
+			lastMod = pubDate
+		}
+		total++
+		if len(set.URLs) == sitemapPageSize {
+			if err := flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if err := flush(); err != nil {
+		return nil, 0, err
+	}
+	return pages, total, nil
+}
+
+func gzipXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, xml.Header)
+	if err := xml.NewEncoder(zw).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *sitemapBuilder) snapshot() SitemapStats {
+	if !s.enabled() {
+		return SitemapStats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// sitemapIndexHandler GET /sitemap.xml
+func sitemapIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sitemap.enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	sitemap.mu.Lock()
+	pages := sitemap.pages
+	sitemap.mu.Unlock()
+	if pages == nil {
+		http.Error(w, "Sitemap is not built yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	base := outFeedBaseURL(r)
+	index := sitemapIndex{XMLNS: sitemapNamespace}
+	for i, p := range pages {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     fmt.Sprintf("%s/sitemaps/%d.xml", base, i+1),
+			LastMod: p.lastMod.UTC().Format(time.RFC3339),
+		})
+	}
+	body, err := xml.MarshalIndent(index, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to build sitemap", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// sitemapPageHandler GET /sitemaps/{n}.xml
+func sitemapPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sitemap.enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/sitemaps/"), ".xml")
+	n, err := strconv.Atoi(name)
+	sitemap.mu.Lock()
+	pages := sitemap.pages
+	sitemap.mu.Unlock()
+	if !ok || err != nil || n < 1 || n > len(pages) {
+		http.NotFound(w, r)
+		return
+	}
+	page := pages[n-1]
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Last-Modified", page.lastMod.UTC().Format(http.TimeFormat))
+	w.Header().Add("Vary", "Accept-Encoding")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(page.gz)
+		return
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(page.gz))
+	if err != nil {
+		http.Error(w, "Failed to read sitemap", http.StatusInternalServerError)
+		return
+	}
+	io.Copy(w, zr)
+}
+
+// sitemapAdminHandler GET /admin/sitemap — состояние, POST — перестроить
+func sitemapAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sitemap.snapshot())
+	case http.MethodPost:
+		if !sitemap.enabled() {
+			http.Error(w, "Sitemap is disabled, set SITEMAP_NEWS_URL", http.StatusConflict)
+			return
+		}
+		go func() {
+			if err := sitemap.rebuild(serviceCtx); err != nil && serviceCtx.Err() == nil {
+				log.Printf("Ошибка построения карты сайта: %v", err)
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Доступ к закрытым лентам. Источнику можно задать поле auth:
+// логин и пароль для Basic-аутентификации и/или дополнительные
+// заголовки (например, X-Api-Key), которые добавляются к каждому запросу
+// его ленты. В таблице sources они хранятся зашифрованными (AES-256-GCM,
+// колонка credentials) ключом из переменной SOURCE_CREDENTIALS_KEY; без
+// ключа auth задать нельзя. /admin/sources никогда не возвращает пароль
+// и значения заголовков — только логин, признак пароля и имена заголовков.
+// Доступ привязан к хосту ленты: при смене хоста в url он удаляется, если
+// в том же PATCH не задан новый, а на редиректы на другой хост не
+// пересылается.
+
+// Редиректов при загрузке ленты не больше
+const maxFeedRedirects = 10
+
+// credentialsVersion префикс зашифрованных данных
+const credentialsVersion = "v1:"
+
+// credentialsKey ключ шифрования; nil — SOURCE_CREDENTIALS_KEY не задан
+var credentialsKey = loadCredentialsKey()
+
+func loadCredentialsKey() []byte {
+	raw := os.Getenv("SOURCE_CREDENTIALS_KEY")
+	if raw == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte(raw))
+	return key[:]
+}
+
+var headerNameRe = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+// reservedAuthHeaders заголовки, которые задаёт сама загрузка
+var reservedAuthHeaders = map[string]bool{
+	"Host": true, "Content-Length": true, "Transfer-Encoding": true, "Connection": true,
+	"If-None-Match": true, "If-Modified-Since": true, "Accept-Encoding": true,
+}
+
+// SourceAuth доступ к ленте источника
+type SourceAuth struct {
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// SourceAuthInfo доступ к ленте в ответах /admin/sources без секретов
+type SourceAuthInfo struct {
+	Username    string   `json:"username,omitempty"`
+	PasswordSet bool     `json:"password_set"`
+	Headers     []string `json:"headers,omitempty"`
+}
+
+func (a *SourceAuth) empty() bool {
+	return a == nil || (a.Username == "" && a.Password == "" && len(a.Headers) == 0)
+}
+
+// normalize убирает пробелы и приводит имена заголовков к каноническому виду
+func (a *SourceAuth) normalize() {
+	a.Username = strings.TrimSpace(a.Username)
+	headers := make(map[string]string, len(a.Headers))
+	for name, value := range a.Headers {
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	a.Headers = headers
+}
+
+func (a *SourceAuth) validate() error {
+	if a.Password != "" && a.Username == "" {
+		return errors.New("auth.password задаётся вместе с auth.username")
+	}
+	if strings.ContainsAny(a.Username, ":\r\n") || strings.ContainsAny(a.Password, "\r\n") {
+		return errors.New("auth.username не может содержать ':' и переводы строк, auth.password — переводы строк")
+	}
+	for name, value := range a.Headers {
+		if !headerNameRe.MatchString(name) {
+			return fmt.Errorf("auth.headers: некорректное имя заголовка %q", name)
+		}
+		if reservedAuthHeaders[name] || (name == "Authorization" && a.Username != "") {
+			return fmt.Errorf("auth.headers: заголовок %s задавать нельзя", name)
+		}
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("auth.headers: значение заголовка %s пустое или содержит перевод строки", name)
+		}
+	}
+	return nil
+}
+
+// apply добавляет доступ к запросу ленты
+func (a *SourceAuth) apply(req *http.Request) {
+	if a == nil {
+		return
+	}
+	for name, value := range a.Headers {
+		req.Header.Set(name, value)
+	}
+	if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// checkRedirect CheckRedirect клиента ленты: при редиректе на другой
+// хост доступ (Basic и заголовки auth) не пересылается
+func (a *SourceAuth) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxFeedRedirects {
+		return errTooManyRedirects
+	}
+	if a == nil || strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return nil
+	}
+	for name := range a.Headers {
+		req.Header.Del(name)
+	}
+	if a.Username != "" {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// info доступ без секретов
+func (a *SourceAuth) info() *SourceAuthInfo {
+	if a.empty() {
+		return nil
+	}
+	info := &SourceAuthInfo{Username: a.Username, PasswordSet: a.Password != ""}
+	for name := range a.Headers {
+		info.Headers = append(info.Headers, name)
+	}
+	sort.Strings(info.Headers)
+	return info
+}
+
+// sealCredentials шифрует доступ для колонки credentials; пустой доступ —
+// пустая строка
+func sealCredentials(a *SourceAuth) (string, error) {
+	if a.empty() {
+		return "", nil
+	}
+	if credentialsKey == nil {
+		return "", errors.New("SOURCE_CREDENTIALS_KEY не задан")
+	}
+	plain, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := credentialsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return credentialsVersion + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openCredentials расшифровывает колонку credentials
+func openCredentials(sealed string) (*SourceAuth, error) {
+	if sealed == "" {
+		return nil, nil
+	}
+	if credentialsKey == nil {
+		return nil, errors.New("SOURCE_CREDENTIALS_KEY не задан")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, credentialsVersion))
+	if err != nil || !strings.HasPrefix(sealed, credentialsVersion) {
+		return nil, errors.New("неизвестный формат credentials")
+	}
+	gcm, err := credentialsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("неизвестный формат credentials")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("не удалось расшифровать credentials: другой SOURCE_CREDENTIALS_KEY?")
+	}
+	var a SourceAuth
+	if err := json.Unmarshal(plain, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func credentialsCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(credentialsKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAuth проверяет и шифрует новый доступ из запроса; auth целиком
+// заменяет прежний, пустой объект удаляет доступ
+func (p sourcePatch) sealAuth(rec *SourceRecord) error {
+	if p.Auth == nil {
+		return nil
+	}
+	auth := *p.Auth
+	auth.normalize()
+	if err := auth.validate(); err != nil {
+		return err
+	}
+	if auth.empty() {
+		rec.auth, rec.credentials = nil, ""
+		return nil
+	}
+	if credentialsKey == nil {
+		return errors.New("auth можно задать только при заданном SOURCE_CREDENTIALS_KEY")
+	}
+	sealed, err := sealCredentials(&auth)
+	if err != nil {
+		return err
+	}
+	rec.auth, rec.credentials = &auth, sealed
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Источники новостей. Каждая новость хранит source_id и source_title
+// ленты, из которой она получена. source_id задаётся полем source_id
+// источника в таблице sources, по умолчанию — имя хоста ленты без www (несколько лент
+// одного издания образуют один источник). source_title — поле title
+// источника, иначе заголовок самой ленты. По source_id работает фильтр
+// source= в списках и список GET /sources.
+
+// SourceStat источник и число его опубликованных новостей
+type SourceStat struct {
+	SourceID    string     `json:"source_id"`
+	SourceTitle string     `json:"source_title"`
+	Count       int        `json:"count"`
+	LastPubDate *time.Time `json:"last_pub_date,omitempty"`
+}
+
+// Ограничения длины колонок news.source_id и news.source_title
+const (
+	maxSourceIDLength    = 255
+	maxSourceTitleLength = 500
+)
+
+// sourceID идентификатор источника новостей
+func (s feedSource) sourceID() string {
+	id := strings.ToLower(strings.TrimSpace(s.ID))
+	if id == "" {
+		if u, err := url.Parse(s.URL); err == nil {
+			id = strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		}
+	}
+	return truncateRunes(id, maxSourceIDLength)
+}
+
+// sourceTitle название источника для элемента ленты
+func (s feedSource) sourceTitle(item FeedItem) string {
+	title := strings.TrimSpace(s.Title)
+	if title == "" {
+		title = item.FeedTitle
+	}
+	if title == "" {
+		title = s.sourceID()
+	}
+	return truncateRunes(title, maxSourceTitleLength)
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// sourcesHandler возвращает список источников с количеством новостей
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+	log.Printf("Запрос списка источников, request_id: %s", requestID)
+
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	sources, err := getSources(ctx)
+	if err != nil {
+		log.Printf("Ошибка получения источников: %v", err)
+		http.Error(w, "Failed to get sources", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+// getSources получает источники опубликованных новостей по алфавиту;
+// название источника берётся из его самой свежей новости
+func getSources(ctx context.Context) ([]SourceStat, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT source_id,
+			(ARRAY_AGG(source_title ORDER BY pub_date DESC, id DESC))[1],
+			COUNT(*),
+			MAX(pub_date)
+		FROM news
+		WHERE source_id <> '' AND available_at <= NOW()
+		GROUP BY source_id
+		ORDER BY 2 ASC, source_id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []SourceStat{}
+	for rows.Next() {
+		var s SourceStat
+		if err := rows.Scan(&s.SourceID, &s.SourceTitle, &s.Count, &s.LastPubDate); err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Главные новости. Редакция закрепляет новости в упорядоченном списке
+// top_stories (/admin/top-stories) — с необязательным расписанием
+// показа starts_at/ends_at. GET /news/top отдаёт сначала активные
+// закреплённые новости по position, затем дополняет список
+// «трендовыми» — новостями окна window_hours, которые перепечатали
+// больше всего других источников (по simhash-отпечаткам), и, если их
+// не хватает, просто последними новостями. Из каждой группы
+// перепечаток берётся одна новость.
+
+const (
+	defaultTopLimit  = 10
+	maxTopLimit      = 50
+	defaultTopWindow = 24
+	maxTopWindow     = 168
+)
+
+// Происхождение новости в /news/top
+const (
+	pickCurated  = "curated"
+	pickTrending = "trending"
+	pickLatest   = "latest"
+)
+
+// TopStory новость в списке главных
+type TopStory struct {
+	News
+	Pick string `json:"pick"`
+	// Coverage число других источников, перепечатавших новость
+	Coverage int `json:"coverage,omitempty"`
+}
+
+// TopStoriesResponse ответ /news/top
+type TopStoriesResponse struct {
+	News []TopStory `json:"news"`
+}
+
+// TopStoryPick закреплённая новость
+type TopStoryPick struct {
+	ID        int        `json:"id"`
+	NewsID    int        `json:"news_id"`
+	Position  int        `json:"position"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	Active    bool       `json:"active"`
+	Title     string     `json:"title"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// topStoryPatch поля запросов POST и PATCH; отсутствующие поля не меняются
+type topStoryPatch struct {
+	NewsID   *int       `json:"news_id"`
+	Position *int       `json:"position"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	Note     *string    `json:"note"`
+}
+
+func (p topStoryPatch) apply(pick *TopStoryPick) {
+	if p.NewsID != nil {
+		pick.NewsID = *p.NewsID
+	}
+	if p.Position != nil {
+		pick.Position = *p.Position
+	}
+	if p.StartsAt != nil {
+		pick.StartsAt = p.StartsAt
+	}
+	if p.EndsAt != nil {
+		pick.EndsAt = p.EndsAt
+	}
+	if p.Note != nil {
+		pick.Note = strings.TrimSpace(*p.Note)
+	}
+}
+
+func (pick TopStoryPick) validate() error {
+	if pick.NewsID <= 0 {
+		return fmt.Errorf("news_id is required")
+	}
+	if pick.Position < 0 {
+		return fmt.Errorf("position must be non-negative")
+	}
+	if pick.StartsAt != nil && pick.EndsAt != nil && !pick.EndsAt.After(*pick.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+const topStoryColumns = `t.id, t.news_id, t.position, t.starts_at, t.ends_at, t.note,
+	(COALESCE(t.starts_at <= NOW(), TRUE) AND COALESCE(t.ends_at > NOW(), TRUE) AND n.available_at <= NOW()),
+	n.title, t.created_at, t.updated_at`
+
+func scanTopStoryPick(row rowScanner) (TopStoryPick, error) {
+	var pick TopStoryPick
+	err := row.Scan(&pick.ID, &pick.NewsID, &pick.Position, &pick.StartsAt, &pick.EndsAt, &pick.Note,
+		&pick.Active, &pick.Title, &pick.CreatedAt, &pick.UpdatedAt)
+	return pick, err
+}
+
+func getTopStoryPicks(ctx context.Context) ([]TopStoryPick, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+topStoryColumns+`
+		FROM top_stories t JOIN news n ON n.id = t.news_id
+		ORDER BY t.position, t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	picks := []TopStoryPick{}
+	for rows.Next() {
+		pick, err := scanTopStoryPick(rows)
+		if err != nil {
+			return nil, err
+		}
+		picks = append(picks, pick)
+	}
+	return picks, rows.Err()
+}
+
+func getTopStoryPick(ctx context.Context, id int) (*TopStoryPick, error) {
+	pick, err := scanTopStoryPick(db.QueryRowContext(ctx, `SELECT `+topStoryColumns+`
+		FROM top_stories t JOIN news n ON n.id = t.news_id
+		WHERE t.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &pick, nil
+}
+
+// saveTopStoryPick добавляет (pick.ID == 0) или обновляет закреплённую новость
+func saveTopStoryPick(ctx context.Context, pick TopStoryPick) (*TopStoryPick, error) {
+	var id int
+	var err error
+	if pick.ID == 0 {
+		err = db.QueryRowContext(ctx, `
+			INSERT INTO top_stories (news_id, position, starts_at, ends_at, note)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, pick.NewsID, pick.Position, pick.StartsAt, pick.EndsAt, pick.Note).Scan(&id)
+	} else {
+		err = db.QueryRowContext(ctx, `
+			UPDATE top_stories
+			SET news_id = $2, position = $3, starts_at = $4, ends_at = $5, note = $6, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id
+		`, pick.ID, pick.NewsID, pick.Position, pick.StartsAt, pick.EndsAt, pick.Note).Scan(&id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getTopStoryPick(ctx, id)
+}
+
+// topStoryWriteError переводит ошибку записи в ответ клиенту; false —
+// ошибка не из известных
+func topStoryWriteError(w http.ResponseWriter, err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	switch pqErr.Code {
+	case "23505":
+		http.Error(w, "News is already pinned", http.StatusConflict)
+	case "23503":
+		http.Error(w, "News not found", http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
+
+// adminTopStoriesHandler GET — все закреплённые новости, POST — закрепление
+func adminTopStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		picks, err := getTopStoryPicks(ctx)
+		if err != nil {
+			log.Printf("Ошибка получения главных новостей: %v", err)
+			http.Error(w, "Failed to get top stories", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(picks)
+	case http.MethodPost:
+		var patch topStoryPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		var pick TopStoryPick
+		patch.apply(&pick)
+		if err := pick.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := saveTopStoryPick(ctx, pick)
+		if err != nil {
+			if !topStoryWriteError(w, err) {
+				log.Printf("Ошибка закрепления новости %d: %v", pick.NewsID, err)
+				http.Error(w, "Failed to pin news", http.StatusInternalServerError)
+			}
+			return
+		}
+		log.Printf("Новость %d закреплена в главных (позиция %d), request_id: %s", created.NewsID, created.Position, requestID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminTopStoryHandler /admin/top-stories/{id}: GET, PATCH, DELETE
+func adminTopStoryHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/top-stories/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid top story ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch:
+		pick, err := getTopStoryPick(ctx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Top story not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка получения главной новости %d: %v", id, err)
+			http.Error(w, "Failed to get top story", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			var patch topStoryPatch
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			patch.apply(pick)
+			if err := pick.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pick, err = saveTopStoryPick(ctx, *pick)
+			if err != nil {
+				if !topStoryWriteError(w, err) {
+					log.Printf("Ошибка обновления главной новости %d: %v", id, err)
+					http.Error(w, "Failed to update top story", http.StatusInternalServerError)
+				}
+				return
+			}
+			log.Printf("Главная новость %d изменена (новость %d, позиция %d), request_id: %s", id, pick.NewsID, pick.Position, requestID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pick)
+	case http.MethodDelete:
+		res, err := db.ExecContext(ctx, `DELETE FROM top_stories WHERE id = $1`, id)
+		if err != nil {
+			log.Printf("Ошибка удаления главной новости %d: %v", id, err)
+			http.Error(w, "Failed to delete top story", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Top story not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Главная новость %d снята, request_id: %s", id, requestID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getCuratedStories активные закреплённые новости по position
+func getCuratedStories(ctx context.Context, limit int) ([]News, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		JOIN top_stories t ON t.news_id = news.id
+		WHERE COALESCE(t.starts_at <= NOW(), TRUE) AND COALESCE(t.ends_at > NOW(), TRUE)
+			AND news.available_at <= NOW()
+		ORDER BY t.position, t.id
+		LIMIT $1
+	`, qualifiedNewsColumns()), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	news := []News{}
+	for rows.Next() {
+		n, err := scanNews(rows)
+		if err != nil {
+			return nil, err
+		}
+		news = append(news, n)
+	}
+	return news, rows.Err()
+}
+
+// qualifiedNewsColumns newsColumns с префиксом таблицы news
+func qualifiedNewsColumns() string {
+	cols := strings.Split(newsColumns, ", ")
+	for i, c := range cols {
+		cols[i] = "news." + c
+	}
+	return strings.Join(cols, ", ")
+}
+
+// trendingCandidates ID новостей окна, упорядоченные по числу других
+// источников, перепечатавших их, и их охват. Из группы перепечаток
+// берётся одна новость; новости exclude и их перепечатки пропускаются.
+func trendingCandidates(ctx context.Context, since time.Time, exclude map[int]bool) ([]int, map[int]int, error) {
+	items, err := loadFingerprints(ctx, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	pairs := findSimilarPairs(items, defaultMaxDistance, func(a, b fingerprintedItem) bool {
+		return a.Source != b.Source
+	})
+
+	neighbors := make(map[int][]int)
+	sources := make(map[int]map[string]bool)
+	addCoverage := func(id int, source string) {
+		if sources[id] == nil {
+			sources[id] = make(map[string]bool)
+		}
+		sources[id][source] = true
+	}
+	for _, p := range pairs {
+		neighbors[p.A.ID] = append(neighbors[p.A.ID], p.B.ID)
+		neighbors[p.B.ID] = append(neighbors[p.B.ID], p.A.ID)
+		addCoverage(p.A.ID, p.B.Source)
+		addCoverage(p.B.ID, p.A.Source)
+	}
+
+	var ranked []fingerprintedItem
+	for _, it := range items {
+		if len(sources[it.ID]) > 0 {
+			ranked = append(ranked, it)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ci, cj := len(sources[ranked[i].ID]), len(sources[ranked[j].ID])
+		if ci != cj {
+			return ci > cj
+		}
+		return ranked[i].PubDate.After(ranked[j].PubDate)
+	})
+
+	used := make(map[int]bool)
+	for id := range exclude {
+		used[id] = true
+		for _, n := range neighbors[id] {
+			used[n] = true
+		}
+	}
+	var ids []int
+	coverage := make(map[int]int)
+	for _, it := range ranked {
+		if used[it.ID] {
+			continue
+		}
+		used[it.ID] = true
+		for _, n := range neighbors[it.ID] {
+			used[n] = true
+		}
+		ids = append(ids, it.ID)
+		coverage[it.ID] = len(sources[it.ID])
+	}
+	return ids, coverage, nil
+}
+
+// getLatestExcept последние опубликованные новости, кроме exclude
+func getLatestExcept(ctx context.Context, exclude []int, limit int) ([]News, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM news
+		WHERE available_at <= NOW() AND duplicate_of IS NULL AND NOT (id = ANY($1))
+		ORDER BY pub_date DESC, id DESC
+		LIMIT $2
+	`, newsColumns), pq.Array(exclude), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	news := []News{}
+	for rows.Next() {
+		n, err := scanNews(rows)
+		if err != nil {
+			return nil, err
+		}
+		news = append(news, n)
+	}
+	return news, rows.Err()
+}
+
+// getTopStories закреплённые, затем трендовые, затем последние новости
+func getTopStories(ctx context.Context, limit int, window time.Duration) ([]TopStory, error) {
+	curated, err := getCuratedStories(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	stories := make([]TopStory, 0, limit)
+	seen := make(map[int]bool)
+	var seenIDs []int
+	add := func(n News, pick string, coverage int) {
+		stories = append(stories, TopStory{News: n, Pick: pick, Coverage: coverage})
+		seen[n.ID] = true
+		seenIDs = append(seenIDs, n.ID)
+	}
+	for _, n := range curated {
+		add(n, pickCurated, 0)
+	}
+
+	if len(stories) < limit {
+		ids, coverage, err := trendingCandidates(ctx, time.Now().Add(-window), seen)
+		if err != nil {
+			return nil, err
+		}
+		// часть кандидатов может быть ещё под эмбарго — берём с запасом
+		if len(ids) > 2*limit {
+			ids = ids[:2*limit]
+		}
+		if len(ids) > 0 {
+			found, err := getNewsByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[int]News, len(found))
+			for _, n := range found {
+				byID[n.ID] = n
+			}
+			for _, id := range ids {
+				if n, ok := byID[id]; ok && len(stories) < limit {
+					add(n, pickTrending, coverage[id])
+				}
+			}
+		}
+	}
+
+	if len(stories) < limit {
+		latest, err := getLatestExcept(ctx, seenIDs, limit-len(stories))
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range latest {
+			add(n, pickLatest, 0)
+		}
+	}
+	return stories, nil
+}
+
+// topStoriesHandler GET /news/top?limit=10&window_hours=24
+func topStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	q := r.URL.Query()
+	limit := defaultTopLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTopLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	windowHours := defaultTopWindow
+	if v := q.Get("window_hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopWindow {
+			http.Error(w, fmt.Sprintf("window_hours must be between 1 and %d", maxTopWindow), http.StatusBadRequest)
+			return
+		}
+		windowHours = n
+	}
+
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	stories, err := getTopStories(ctx, limit, time.Duration(windowHours)*time.Hour)
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос главных новостей отменён, request_id: %s", requestID)
+		return
+	}
+	if isQueryTimeout(err) {
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения главных новостей: %v", err)
+		http.Error(w, "Failed to get top stories", http.StatusInternalServerError)
+		return
+	}
+	geo := paging{Country: requestCountry(r)}
+	visible := stories[:0]
+	for _, s := range stories {
+		if geo.geoAllows(s.News) {
+			visible = append(visible, s)
+		}
+	}
+	stories = visible
+	log.Printf("Запрос главных новостей: %d, request_id: %s", len(stories), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TopStoriesResponse{News: stories})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Обсуждаемые новости: GET /news/trending отдаёт новости, опубликованные
+// за окно window_hours, по числу комментариев за то же окно с поправкой
+// на возраст: score = comments / (age_hours + 2)^1.5. Число комментариев
+// берётся из comments-service (/comments/activity, COMMENTS_SERVICE_URL)
+// и кэшируется в памяти на trendingCacheTTL. Окно по умолчанию —
+// trending_window_hours в config.json (24 часа). Дубли в список не
+// попадают, с country — и новости, недоступные в этой стране.
+
+const (
+	defaultTrendingLimit    = 10
+	maxTrendingLimit        = 50
+	maxTrendingWindow       = 168
+	trendingActivityLimit   = 500
+	trendingGravity         = 1.5
+	trendingCacheTTL        = 30 * time.Second
+	commentsActivityTimeout = 5 * time.Second
+)
+
+var trendingWindow = 24 * time.Hour
+
+var errCommentsUnavailable = errors.New("comments-service недоступен")
+
+// TrendingItem обсуждаемая новость
+type TrendingItem struct {
+	News
+	Comments      int       `json:"comments"`
+	LastCommentAt time.Time `json:"last_comment_at"`
+	Score         float64   `json:"score"`
+}
+
+// TrendingResponse ответ /news/trending
+type TrendingResponse struct {
+	WindowHours int            `json:"window_hours"`
+	News        []TrendingItem `json:"news"`
+}
+
+// commentActivity число комментариев новости из comments-service
+type commentActivity struct {
+	NewsID        int       `json:"news_id"`
+	Comments      int       `json:"comments"`
+	LastCommentAt time.Time `json:"last_comment_at"`
+}
+
+// commentsClient запросы к comments-service с кэшем активности по окнам
+type commentsClient struct {
+	baseURL string
+	client  *http.Client
+
+	mu    sync.Mutex
+	cache map[time.Duration]cachedActivity
+}
+
+type cachedActivity struct {
+	at   time.Time
+	news []commentActivity
+}
+
+var commentsService = newCommentsClientFromEnv()
+
+func newCommentsClientFromEnv() *commentsClient {
+	baseURL := os.Getenv("COMMENTS_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://comments-service:8081"
+	}
+	return &commentsClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: commentsActivityTimeout},
+		cache:   make(map[time.Duration]cachedActivity),
+	}
+}
+
+// activity новости с комментариями за последние window
+func (c *commentsClient) activity(ctx context.Context, window time.Duration) ([]commentActivity, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[window]
+	c.mu.Unlock()
+	if ok && time.Since(cached.at) < trendingCacheTTL {
+		return cached.news, nil
+	}
+
+	q := url.Values{}
+	q.Set("since", time.Now().Add(-window).UTC().Format(time.RFC3339))
+	q.Set("limit", strconv.Itoa(trendingActivityLimit))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/comments/activity?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("comments-service ответил %s", resp.Status)
+	}
+	var body struct {
+		News []commentActivity `json:"news"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("некорректный ответ comments-service: %v", err)
+	}
+
+	c.mu.Lock()
+	c.cache[window] = cachedActivity{at: time.Now(), news: body.News}
+	c.mu.Unlock()
+	return body.News, nil
+}
+
+// trendingScore число комментариев с поправкой на возраст новости
+func trendingScore(comments int, pubDate, now time.Time) float64 {
+	age := math.Max(now.Sub(pubDate).Hours(), 0)
+	return float64(comments) / math.Pow(age+2, trendingGravity)
+}
+
+// getTrendingNews обсуждаемые новости окна window, доступные в стране
+// p.Country; недоступные отсеиваются до обрезки по limit
+func getTrendingNews(ctx context.Context, limit int, window time.Duration, p paging) ([]TrendingItem, error) {
+	activity, err := commentsService.activity(ctx, window)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCommentsUnavailable, err)
+	}
+	items := []TrendingItem{}
+	if len(activity) == 0 {
+		return items, nil
+	}
+	ids := make([]int, len(activity))
+	for i, a := range activity {
+		ids[i] = a.NewsID
+	}
+	found, err := getNewsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]News, len(found))
+	for _, n := range found {
+		byID[n.ID] = n
+	}
+
+	now := time.Now()
+	since := now.Add(-window)
+	for _, a := range activity {
+		n, ok := byID[a.NewsID]
+		if !ok || n.DuplicateOf != nil || n.PubDate.Before(since) || !p.geoAllows(n) {
+			continue
+		}
+		items = append(items, TrendingItem{
+			News:          n,
+			Comments:      a.Comments,
+			LastCommentAt: a.LastCommentAt,
+			Score:         trendingScore(a.Comments, n.PubDate, now),
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].PubDate.After(items[j].PubDate)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// trendingNewsHandler GET /news/trending?limit=10&window_hours=24
+func trendingNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	q := r.URL.Query()
+	limit := defaultTrendingLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTrendingLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTrendingLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	window := trendingWindow
+	if v := q.Get("window_hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTrendingWindow {
+			http.Error(w, fmt.Sprintf("window_hours must be between 1 and %d", maxTrendingWindow), http.StatusBadRequest)
+			return
+		}
+		window = time.Duration(n) * time.Hour
+	}
+
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	items, err := getTrendingNews(ctx, limit, window, paging{Country: requestCountry(r)})
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос обсуждаемых новостей отменён, request_id: %s", requestID)
+		return
+	}
+	if errors.Is(err, errCommentsUnavailable) {
+		log.Printf("Ошибка получения обсуждаемых новостей: %v, request_id: %s", err, requestID)
+		http.Error(w, "Comments service unavailable", http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения обсуждаемых новостей: %v, request_id: %s", err, requestID)
+		http.Error(w, "Failed to get trending news", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Запрос обсуждаемых новостей: %d, request_id: %s", len(items), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TrendingResponse{WindowHours: int(window / time.Hour), News: items})
+}
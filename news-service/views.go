@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Просмотры новостей. Фронтенд отмечает просмотр запросом
+// POST /news/{id}/view; просмотры копятся в памяти по часам и раз в
+// viewsFlushInterval прибавляются к news_views, а при остановке сервиса
+// записываются сразу. Просмотр учитывается только у опубликованной
+// новости и не чаще раза в viewDedupWindow от одного зрителя. Зритель —
+// заголовок X-Viewer от gateway (только с X-Service-Token), иначе IP
+// подключения. GET /news/popular?window=24h отдаёт новости с наибольшим
+// числом просмотров за окно (от часа до 7 дней, с точностью до часа),
+// с country — только доступные в этой стране.
+// Строки старше наибольшего окна удаляются.
+
+const (
+	viewsFlushInterval   = 10 * time.Second
+	viewsFlushTimeout    = 5 * time.Second
+	viewsCleanupInterval = time.Hour
+	maxPendingViews      = 100000
+	viewDedupWindow      = 30 * time.Minute
+	// maxRecentViewers сколько пар «новость, зритель» помнится для
+	// отсева повторов; сверх этого просмотры не учитываются
+	maxRecentViewers     = 200000
+	defaultPopularWindow = 24 * time.Hour
+	maxPopularWindow     = 7 * 24 * time.Hour
+	defaultPopularLimit  = 10
+	maxPopularLimit      = 50
+)
+
+// viewKey просмотры новости за час
+type viewKey struct {
+	newsID int
+	bucket time.Time
+}
+
+// viewerKey зритель новости
+type viewerKey struct {
+	newsID int
+	viewer string
+}
+
+// viewCounter просмотры, ещё не записанные в БД
+type viewCounter struct {
+	mu      sync.Mutex
+	pending map[viewKey]int
+	// recent время учтённого просмотра по зрителю
+	recent  map[viewerKey]time.Time
+	dropped int
+}
+
+var views = &viewCounter{pending: make(map[viewKey]int), recent: make(map[viewerKey]time.Time)}
+
+// add учитывает просмотр новости зрителем viewer; повтор в пределах
+// viewDedupWindow не учитывается, при переполнении буферов просмотр
+// теряется
+func (c *viewCounter) add(newsID int, viewer string) {
+	now := time.Now()
+	key := viewKey{newsID: newsID, bucket: now.UTC().Truncate(time.Hour)}
+	vk := viewerKey{newsID: newsID, viewer: viewer}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen, ok := c.recent[vk]
+	if ok && now.Sub(seen) < viewDedupWindow {
+		return
+	}
+	if !ok && len(c.recent) >= maxRecentViewers {
+		c.dropped++
+		return
+	}
+	if _, ok := c.pending[key]; !ok && len(c.pending) >= maxPendingViews {
+		c.dropped++
+		return
+	}
+	c.recent[vk] = now
+	c.pending[key]++
+}
+
+// forgetViewers забывает зрителей, чьё окно повтора прошло
+func (c *viewCounter) forgetViewers(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for vk, seen := range c.recent {
+		if now.Sub(seen) >= viewDedupWindow {
+			delete(c.recent, vk)
+		}
+	}
+}
+
+// flush прибавляет накопленные просмотры к news_views. При ошибке
+// просмотры возвращаются в буфер до следующей попытки.
+func (c *viewCounter) flush(ctx context.Context) error {
+	c.mu.Lock()
+	batch := c.pending
+	dropped := c.dropped
+	c.pending = make(map[viewKey]int)
+	c.dropped = 0
+	c.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("[WARN] Буфер просмотров переполнен, потеряно просмотров: %d", dropped)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(batch))
+	buckets := make([]string, 0, len(batch))
+	counts := make([]int64, 0, len(batch))
+	for k, n := range batch {
+		ids = append(ids, int64(k.newsID))
+		buckets = append(buckets, k.bucket.Format(time.RFC3339))
+		counts = append(counts, int64(n))
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO news_views (news_id, bucket, views)
+		SELECT v.news_id, v.bucket, v.views
+		FROM unnest($1::int[], $2::timestamptz[], $3::int[]) AS v(news_id, bucket, views)
+		JOIN news n ON n.id = v.news_id
+		ON CONFLICT (news_id, bucket) DO UPDATE SET views = news_views.views + EXCLUDED.views
+	`, pq.Array(ids), pq.Array(buckets), pq.Array(counts))
+	if err != nil {
+		c.mu.Lock()
+		for k, n := range batch {
+			c.pending[k] += n
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// run записывает просмотры по таймеру и удаляет устаревшие строки до
+// отмены ctx; последнюю запись делает остановка сервиса
+func (c *viewCounter) run(ctx context.Context) {
+	flush := time.NewTicker(viewsFlushInterval)
+	defer flush.Stop()
+	cleanup := time.NewTicker(viewsCleanupInterval)
+	defer cleanup.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flush.C:
+			if err := c.flush(ctx); err != nil {
+				log.Printf("Ошибка записи просмотров: %v", err)
+			}
+			c.forgetViewers(time.Now())
+		case <-cleanup.C:
+			res, err := db.ExecContext(ctx, `DELETE FROM news_views WHERE bucket < $1`,
+				time.Now().Add(-maxPopularWindow-time.Hour))
+			if err != nil {
+				log.Printf("Ошибка удаления старых просмотров: %v", err)
+			} else if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("Удалено строк просмотров старше %v: %d", maxPopularWindow, n)
+			}
+		}
+	}
+}
+
+// newsViewHandler POST /news/{id}/view
+func newsViewHandler(w http.ResponseWriter, r *http.Request, newsID int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if newsID <= 0 {
+		http.Error(w, "Invalid news ID", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := handlerContext(r, lookupTimeout)
+	defer cancel()
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM news WHERE id = $1 AND available_at <= NOW())`, newsID).Scan(&exists)
+	if err != nil {
+		log.Printf("Ошибка проверки новости %d для просмотра: %v", newsID, err)
+		http.Error(w, "Failed to record view", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "News not found", http.StatusNotFound)
+		return
+	}
+	views.add(newsID, viewerOf(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// viewerOf зритель запроса: X-Viewer от gateway или IP подключения
+func viewerOf(r *http.Request) string {
+	if v := r.Header.Get("X-Viewer"); v != "" && serviceAuthorized(r) {
+		return v
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// PopularItem новость с числом просмотров за окно
+type PopularItem struct {
+	News
+	Views int `json:"views"`
+}
+
+// PopularResponse ответ /news/popular
+type PopularResponse struct {
+	Window string        `json:"window"`
+	News   []PopularItem `json:"news"`
+}
+
+// getPopularNews новости с наибольшим числом просмотров с since,
+// доступные в стране p.Country
+func getPopularNews(ctx context.Context, since time.Time, limit int, p paging) ([]PopularItem, error) {
+	args := []interface{}{since, limit}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT v.news_id, SUM(v.views)
+		FROM news_views v
+		JOIN news n ON n.id = v.news_id
+		WHERE v.bucket >= $1 AND n.duplicate_of IS NULL AND n.available_at <= NOW() AND %s
+		GROUP BY v.news_id
+		ORDER BY SUM(v.views) DESC, v.news_id DESC
+		LIMIT $2
+	`, p.geoCondition(&args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	counts := make(map[int]int)
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		counts[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make([]PopularItem, 0, len(ids))
+	if len(ids) == 0 {
+		return items, nil
+	}
+	found, err := getNewsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]News, len(found))
+	for _, n := range found {
+		byID[n.ID] = n
+	}
+	for _, id := range ids {
+		if n, ok := byID[id]; ok {
+			items = append(items, PopularItem{News: n, Views: counts[id]})
+		}
+	}
+	return items, nil
+}
+
+// popularNewsHandler GET /news/popular?window=24h&limit=10
+func popularNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	q := r.URL.Query()
+	window := defaultPopularWindow
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Hour || d > maxPopularWindow {
+			http.Error(w, "window must be a duration between 1h and 168h", http.StatusBadRequest)
+			return
+		}
+		window = d.Truncate(time.Hour)
+	}
+	limit := defaultPopularLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPopularLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPopularLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// текущий час входит в окно целиком
+	since := time.Now().UTC().Truncate(time.Hour).Add(-window + time.Hour)
+
+	ctx, cancel := handlerContext(r, searchTimeout)
+	defer cancel()
+	items, err := getPopularNews(ctx, since, limit, paging{Country: requestCountry(r)})
+	if r.Context().Err() != nil {
+		log.Printf("Клиент отключился, запрос популярных новостей отменён, request_id: %s", requestID)
+		return
+	}
+	if isQueryTimeout(err) {
+		http.Error(w, "Query timed out, narrow the search", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения популярных новостей: %v", err)
+		http.Error(w, "Failed to get popular news", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Запрос популярных новостей за %v: %d, request_id: %s", window, len(items), requestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PopularResponse{Window: fmt.Sprintf("%dh", int(window/time.Hour)), News: items})
+}
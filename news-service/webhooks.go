@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Вебхуки о новых новостях. Внешние системы (боты, индексаторы)
+// регистрируют URL через /admin/webhooks и получают POST на каждую новую
+// новость, в заголовке или тексте которой есть одно из ключевых слов
+// вебхука (без слов — на все новости). Тело подписывается HMAC-SHA256 с
+// секретом вебхука. Временная ошибка (сетевая, 5xx, 408, 429) повторяется
+// до webhook_retries раз с удваивающейся паузой. Дубли и повторное
+// сохранение при воспроизведении архива не рассылаются, а новость под
+// эмбарго отправляется, когда становится доступна. Очередь доставок
+// хранится в памяти и при перезапуске теряется.
+
+const (
+	defaultWebhookRetries    = 5
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookQueueSize  = 1000
+	webhookRetryBackoff      = 10 * time.Second
+	maxWebhookRetryBackoff   = 10 * time.Minute
+	webhookWorkers           = 4
+	webhookSignatureHeader   = "X-Webhook-Signature"
+	maxWebhookErrorBodyBytes = 512
+)
+
+var (
+	webhookRetries   = defaultWebhookRetries
+	webhookTimeout   = defaultWebhookTimeout
+	webhookQueueSize = defaultWebhookQueueSize
+)
+
+// Webhook подписка на новые новости
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Secret ключ подписи; возвращается только при создании и смене
+	Secret string `json:"secret,omitempty"`
+	// Keywords слова в нижнем регистре; пустой список — все новости
+	Keywords       []string   `json:"keywords"`
+	Enabled        bool       `json:"enabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	LastStatus     int        `json:"last_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Delivered      int64      `json:"delivered"`
+	Failed         int64      `json:"failed"`
+}
+
+// webhookPatch поля запросов POST и PATCH; отсутствующие поля не меняются
+type webhookPatch struct {
+	URL      *string   `json:"url"`
+	Secret   *string   `json:"secret"`
+	Keywords *[]string `json:"keywords"`
+	Enabled  *bool     `json:"enabled"`
+}
+
+func (p webhookPatch) apply(h *Webhook) {
+	if p.URL != nil {
+		h.URL = strings.TrimSpace(*p.URL)
+	}
+	if p.Secret != nil {
+		h.Secret = *p.Secret
+	}
+	if p.Keywords != nil {
+		h.Keywords = normalizeKeywords(*p.Keywords)
+	}
+	if p.Enabled != nil {
+		h.Enabled = *p.Enabled
+	}
+}
+
+// normalizeKeywords слова в нижнем регистре без пустых и повторов
+func normalizeKeywords(words []string) []string {
+	seen := map[string]bool{}
+	keywords := []string{}
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" && !seen[w] {
+			seen[w] = true
+			keywords = append(keywords, w)
+		}
+	}
+	return keywords
+}
+
+// validateWebhook проверяет адрес вебхука; адреса во внутренней сети
+// запрещены (доставка идёт через safeTransport, а здесь — ранний отказ)
+func validateWebhook(ctx context.Context, h Webhook) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	if err := validatePublicURL(ctx, h.URL); err != nil {
+		log.Printf("Адрес вебхука %s отклонён: %v", u.Redacted(), err)
+		return errors.New("url must resolve to a public address")
+	}
+	return nil
+}
+
+// matches есть ли в тексте новости (в нижнем регистре) слово вебхука
+func (h Webhook) matches(text string) bool {
+	if len(h.Keywords) == 0 {
+		return true
+	}
+	for _, kw := range h.Keywords {
+		if strings.Contains(text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload тело запроса вебхука
+type WebhookPayload struct {
+	Event      string    `json:"event"`
+	DeliveryID string    `json:"delivery_id"`
+	WebhookID  int       `json:"webhook_id"`
+	Attempt    int       `json:"attempt"`
+	SentAt     time.Time `json:"sent_at"`
+	News       News      `json:"news"`
+}
+
+// WebhookStats счётчики доставки
+type WebhookStats struct {
+	Pending   int `json:"pending"`
+	Delivered int `json:"delivered"`
+	Retried   int `json:"retried"`
+	Failed    int `json:"failed"`
+	Dropped   int `json:"dropped"`
+}
+
+// webhookDelivery отправка одной новости одному вебхуку
+type webhookDelivery struct {
+	id      string
+	hookID  int
+	newsID  int
+	attempt int
+	due     time.Time
+}
+
+type webhookDispatcher struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	hooks   []Webhook // включённые вебхуки
+	pending []webhookDelivery
+	stats   WebhookStats
+	wake    chan struct{}
+}
+
+var webhooks = &webhookDispatcher{
+	// редирект на POST-запрос считается ошибкой адреса, а не успехом
+	client: noRedirectClient(0),
+	wake:   make(chan struct{}, 1),
+}
+
+// load перечитывает включённые вебхуки
+func (d *webhookDispatcher) load(ctx context.Context) error {
+	all, err := loadWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	var enabled []Webhook
+	for _, h := range all {
+		if h.Enabled {
+			enabled = append(enabled, h)
+		}
+	}
+	d.mu.Lock()
+	d.hooks = enabled
+	d.mu.Unlock()
+	return nil
+}
+
+// reloadWebhooks перечитывает вебхуки после изменения через API;
+// изменение уже записано, поэтому отключение клиента перечитывание не отменяет
+func reloadWebhooks(ctx context.Context) {
+	if err := webhooks.load(context.WithoutCancel(ctx)); err != nil {
+		log.Printf("Ошибка загрузки вебхуков: %v", err)
+	}
+}
+
+// hook включённый вебхук по ID
+func (d *webhookDispatcher) hook(id int) (Webhook, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range d.hooks {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// notify ставит в очередь отправку новой новости подходящим вебхукам;
+// text — заголовок и текст новости, availableAt — конец эмбарго
+func (d *webhookDispatcher) notify(newsID int, text string, availableAt time.Time) {
+	text = strings.ToLower(text)
+	d.mu.Lock()
+	added := false
+	for _, h := range d.hooks {
+		if !h.matches(text) {
+			continue
+		}
+		if len(d.pending) >= webhookQueueSize {
+			d.stats.Dropped++
+			log.Printf("[WARN] Очередь вебхуков переполнена, новость %d не отправлена вебхуку %d", newsID, h.ID)
+			continue
+		}
+		d.pending = append(d.pending, webhookDelivery{id: generateRequestID(), hookID: h.ID, newsID: newsID, due: availableAt})
+		added = true
+	}
+	d.mu.Unlock()
+	if added {
+		d.signal()
+	}
+}
+
+func (d *webhookDispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next первая доставка, время которой пришло, или пауза до ближайшей
+func (d *webhookDispatcher) next(now time.Time) (webhookDelivery, bool, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wait := time.Duration(-1)
+	for i, del := range d.pending {
+		if del.due.After(now) {
+			if w := del.due.Sub(now); wait < 0 || w < wait {
+				wait = w
+			}
+			continue
+		}
+		d.pending = append(d.pending[:i], d.pending[i+1:]...)
+		return del, true, 0
+	}
+	return webhookDelivery{}, false, wait
+}
+
+// run рассылает очередь до отмены ctx; одновременно выполняется не
+// больше webhookWorkers запросов, чтобы медленный получатель не
+// задерживал остальных
+func (d *webhookDispatcher) run(ctx context.Context) {
+	sem := make(chan struct{}, webhookWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		del, ok, wait := d.next(time.Now())
+		if ok {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				d.process(ctx, del)
+			}()
+			continue
+		}
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer:
+		}
+	}
+}
+
+func (d *webhookDispatcher) process(ctx context.Context, del webhookDelivery) {
+	hook, ok := d.hook(del.hookID)
+	if !ok {
+		// вебхук удалён или отключён, пока доставка ждала
+		return
+	}
+	news, err := scanNews(db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM news WHERE id = $1`, newsColumns), del.newsID))
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения новости %d для вебхука %d: %v", del.newsID, hook.ID, err)
+		return
+	}
+	if news.DuplicateOf != nil {
+		// после разрешения ссылки новость оказалась дублем уже отправленной
+		return
+	}
+
+	status, err := d.send(ctx, hook, del, news)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		d.mu.Lock()
+		d.stats.Delivered++
+		d.mu.Unlock()
+		recordWebhookDelivery(ctx, hook.ID, status, "")
+		return
+	}
+
+	if del.attempt < webhookRetries && isTransientWebhookError(status, err) {
+		backoff := webhookRetryBackoff << del.attempt
+		if backoff > maxWebhookRetryBackoff || backoff <= 0 {
+			backoff = maxWebhookRetryBackoff
+		}
+		log.Printf("Ошибка доставки новости %d вебхуку %d (попытка %d из %d): %v, повтор через %v",
+			news.ID, hook.ID, del.attempt+1, webhookRetries+1, err, backoff)
+		del.attempt++
+		del.due = time.Now().Add(backoff)
+		d.mu.Lock()
+		d.stats.Retried++
+		d.pending = append(d.pending, del)
+		d.mu.Unlock()
+		d.signal()
+		return
+	}
+
+	log.Printf("Новость %d не доставлена вебхуку %d (%s): %v", news.ID, hook.ID, hook.URL, err)
+	d.mu.Lock()
+	d.stats.Failed++
+	d.mu.Unlock()
+	recordWebhookDelivery(ctx, hook.ID, status, err.Error())
+}
+
+// send отправляет новость; status — код ответа или 0 без ответа
+func (d *webhookDispatcher) send(ctx context.Context, hook Webhook, del webhookDelivery, news News) (int, error) {
+	body, err := json.Marshal(WebhookPayload{
+		Event:      eventNewsCreated,
+		DeliveryID: del.id,
+		WebhookID:  hook.ID,
+		Attempt:    del.attempt + 1,
+		SentAt:     time.Now().UTC(),
+		News:       news,
+	})
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "news-service/1.0 (+webhooks)")
+	req.Header.Set("X-Webhook-Event", eventNewsCreated)
+	req.Header.Set("X-Webhook-Delivery", del.id)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBodyBytes))
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// signWebhook HMAC-SHA256 тела запроса в hex
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isTransientWebhookError ошибка, которая может не повториться
+func isTransientWebhookError(status int, err error) bool {
+	if status == 0 {
+		return err != nil
+	}
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// newWebhookSecret случайный секрет для вебхука без заданного
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// recordWebhookDelivery сохраняет итог доставки в статистике вебхука
+func recordWebhookDelivery(ctx context.Context, id, status int, errText string) {
+	delivered, failed := 1, 0
+	if errText != "" {
+		delivered, failed = 0, 1
+	}
+	_, err := db.ExecContext(ctx, `
+		UPDATE webhooks
+		SET last_delivery_at = NOW(), last_status = $2, last_error = $3,
+			delivered = delivered + $4, failed = failed + $5
+		WHERE id = $1
+	`, id, status, errText, delivered, failed)
+	if err != nil {
+		log.Printf("Ошибка сохранения статистики вебхука %d: %v", id, err)
+	}
+}
+
+const webhookColumns = "id, url, secret, keywords, enabled, created_at, last_delivery_at, last_status, last_error, delivered, failed"
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var h Webhook
+	err := row.Scan(&h.ID, &h.URL, &h.Secret, pq.Array(&h.Keywords), &h.Enabled, &h.CreatedAt,
+		&h.LastDeliveryAt, &h.LastStatus, &h.LastError, &h.Delivered, &h.Failed)
+	if h.Keywords == nil {
+		h.Keywords = []string{}
+	}
+	return h, err
+}
+
+func loadWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM webhooks ORDER BY id`, webhookColumns))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func getWebhook(ctx context.Context, id int) (Webhook, error) {
+	return scanWebhook(db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM webhooks WHERE id = $1`, webhookColumns), id))
+}
+
+// WebhooksStatus ответ GET /admin/webhooks
+type WebhooksStatus struct {
+	Stats    WebhookStats `json:"stats"`
+	Webhooks []Webhook    `json:"webhooks"`
+}
+
+// adminWebhooksHandler GET — вебхуки и счётчики доставки, POST — регистрация
+func adminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := loadWebhooks(ctx)
+		if err != nil {
+			log.Printf("Ошибка получения вебхуков: %v", err)
+			http.Error(w, "Failed to get webhooks", http.StatusInternalServerError)
+			return
+		}
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		webhooks.mu.Lock()
+		stats := webhooks.stats
+		stats.Pending = len(webhooks.pending)
+		webhooks.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WebhooksStatus{Stats: stats, Webhooks: hooks})
+	case http.MethodPost:
+		var patch webhookPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		hook := Webhook{Enabled: true, Keywords: []string{}}
+		patch.apply(&hook)
+		if err := validateWebhook(ctx, hook); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if hook.Secret == "" {
+			secret, err := newWebhookSecret()
+			if err != nil {
+				log.Printf("Ошибка генерации секрета вебхука: %v", err)
+				http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+				return
+			}
+			hook.Secret = secret
+		}
+		created, err := scanWebhook(db.QueryRowContext(ctx, fmt.Sprintf(`
+			INSERT INTO webhooks (url, secret, keywords, enabled) VALUES ($1, $2, $3, $4)
+			RETURNING %s
+		`, webhookColumns), hook.URL, hook.Secret, pq.Array(hook.Keywords), hook.Enabled))
+		if err != nil {
+			log.Printf("Ошибка добавления вебхука %s: %v", hook.URL, err)
+			http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Добавлен вебхук %d (%s), request_id: %s", created.ID, created.URL, requestID)
+		reloadWebhooks(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminWebhookHandler /admin/webhooks/{id}: GET, PATCH, DELETE
+func adminWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/webhooks/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := handlerContext(r, adminTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch:
+		hook, err := getWebhook(ctx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка получения вебхука %d: %v", id, err)
+			http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+			return
+		}
+		// секрет показывается только в ответе на его смену
+		secretChanged := false
+		if r.Method == http.MethodPatch {
+			var patch webhookPatch
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if patch.Secret != nil && *patch.Secret == "" {
+				http.Error(w, "secret must not be empty", http.StatusBadRequest)
+				return
+			}
+			secretChanged = patch.Secret != nil
+			patch.apply(&hook)
+			if err := validateWebhook(ctx, hook); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hook, err = scanWebhook(db.QueryRowContext(ctx, fmt.Sprintf(`
+				UPDATE webhooks SET url = $2, secret = $3, keywords = $4, enabled = $5
+				WHERE id = $1
+				RETURNING %s
+			`, webhookColumns), id, hook.URL, hook.Secret, pq.Array(hook.Keywords), hook.Enabled))
+			if err == sql.ErrNoRows {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Ошибка обновления вебхука %d: %v", id, err)
+				http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Вебхук %d изменён (%s, enabled=%t), request_id: %s", id, hook.URL, hook.Enabled, requestID)
+			reloadWebhooks(ctx)
+		}
+		if !secretChanged {
+			hook.Secret = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hook)
+	case http.MethodDelete:
+		res, err := db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+		if err != nil {
+			log.Printf("Ошибка удаления вебхука %d: %v", id, err)
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Вебхук %d удалён, request_id: %s", id, requestID)
+		reloadWebhooks(ctx)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}